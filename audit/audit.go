@@ -0,0 +1,204 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// genesisHash the previous hash of the first entry
+var genesisHash = strings.Repeat("0", sha256.Size*2)
+
+// Entry a record of an issued key or address, the seed is never recorded
+type Entry struct {
+	Seq       uint64    `json:"seq"`
+	Timestamp time.Time `json:"timestamp"`
+	Endpoint  string    `json:"endpoint"`
+	WalletID  string    `json:"walletId"`
+	Path      string    `json:"path"`
+	Address   string    `json:"address"`
+	PublicKey string    `json:"publicKey"`
+	Requester string    `json:"requester"`
+	PrevHash  string    `json:"prevHash"`
+	Hash      string    `json:"hash"`
+}
+
+// Log the append-only audit log file, one json entry per line. Each entry carries the hash of the previous entry, so
+// modifying or removing an entry breaks the chain of all the following entries.
+type Log struct {
+	mu       sync.Mutex
+	file     *os.File
+	seq      uint64
+	lastHash string
+}
+
+// Open Open the audit log file for appending, the existing chain is verified first
+func Open(file string) (*Log, error) {
+	entries, err := Verify(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{file: f, lastHash: genesisHash}
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		l.seq = last.Seq
+		l.lastHash = last.Hash
+	}
+	return l, nil
+}
+
+// Append Chain the entry to the log and write it to the disk before returning. The nil log records nothing.
+func (l *Log) Append(e Entry) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.Seq = l.seq + 1
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	e.Timestamp = e.Timestamp.UTC()
+	e.PrevHash = l.lastHash
+	e.Hash = e.computeHash()
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+
+	// The written entry is in the file even if the sync fails, the next entry must chain to it
+	l.seq = e.Seq
+	l.lastHash = e.Hash
+	return l.file.Sync()
+}
+
+// Close Close the log file
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Verify Read the audit log file and verify the hash chain, the error tells the first broken entry
+func Verify(file string) ([]Entry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	prevHash := genesisHash
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var e Entry
+		err = json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			return entries, fmt.Errorf("line %d: %v", line, err)
+		}
+		if e.Seq != uint64(line) {
+			return entries, fmt.Errorf("line %d: unexpected sequence %d", line, e.Seq)
+		}
+		if e.PrevHash != prevHash {
+			return entries, fmt.Errorf("line %d: previous hash doesn't match, the log has been modified", line)
+		}
+		if e.computeHash() != e.Hash {
+			return entries, fmt.Errorf("line %d: entry hash doesn't match, the entry has been modified", line)
+		}
+		prevHash = e.Hash
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return entries, err
+	}
+
+	return entries, nil
+}
+
+// ExportJSON Write the entries as a json array
+func ExportJSON(w io.Writer, entries []Entry) error {
+	if entries == nil {
+		entries = []Entry{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+// ExportCSV Write the entries as csv with a header row
+func ExportCSV(w io.Writer, entries []Entry) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"seq", "timestamp", "endpoint", "walletId", "path", "address", "publicKey", "requester", "prevHash", "hash"})
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		err = cw.Write([]string{
+			strconv.FormatUint(e.Seq, 10),
+			e.Timestamp.Format(time.RFC3339Nano),
+			e.Endpoint,
+			e.WalletID,
+			e.Path,
+			e.Address,
+			e.PublicKey,
+			e.Requester,
+			e.PrevHash,
+			e.Hash,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// computeHash the sha256 of the length prefixed fields, so the field boundaries can't be shifted
+func (e *Entry) computeHash() string {
+	fields := []string{
+		strconv.FormatUint(e.Seq, 10),
+		e.Timestamp.UTC().Format(time.RFC3339Nano),
+		e.Endpoint,
+		e.WalletID,
+		e.Path,
+		e.Address,
+		e.PublicKey,
+		e.Requester,
+		e.PrevHash,
+	}
+
+	h := sha256.New()
+	for _, field := range fields {
+		fmt.Fprintf(h, "%d:%s", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogAppendVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "audit.log")
+	l, err := Open(file)
+	if err != nil {
+		t.Fatal("Open audit log error:", err)
+	}
+
+	err = l.Append(Entry{Endpoint: "/v1/genPublicKeyAndSegWitAddress", WalletID: "8e1c3b7a", Path: "m/0'/0/0", Address: "bc1q8c87x4v0m3dfrxksv724rtwpxy5ghpw8gwf8da"})
+	if err != nil {
+		t.Fatal("Append error:", err)
+	}
+	l.Close()
+
+	// The reopened log continues the chain
+	l, err = Open(file)
+	if err != nil {
+		t.Fatal("Reopen audit log error:", err)
+	}
+	err = l.Append(Entry{Endpoint: "/v1/genMultiSigP2SHAddress", Path: "2-of-3", Address: "3QJmV3qfvL9SuYo34YihAf3sRCW3qSinyC"})
+	if err != nil {
+		t.Fatal("Append error:", err)
+	}
+	l.Close()
+
+	entries, err := Verify(file)
+	if err != nil {
+		t.Fatal("Verify error:", err)
+	}
+	if len(entries) != 2 || entries[0].PrevHash != genesisHash || entries[1].PrevHash != entries[0].Hash || entries[1].Seq != 2 {
+		t.Fatal("Unexpected entries:", entries)
+	}
+
+	var buf bytes.Buffer
+	err = ExportCSV(&buf, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil || len(records) != 3 || records[2][5] != "3QJmV3qfvL9SuYo34YihAf3sRCW3qSinyC" {
+		t.Error("Unexpected csv export:", records, err)
+	}
+
+	buf.Reset()
+	err = ExportJSON(&buf, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exported []Entry
+	err = json.Unmarshal(buf.Bytes(), &exported)
+	if err != nil || len(exported) != 2 || exported[1].Hash != entries[1].Hash {
+		t.Error("Unexpected json export:", exported, err)
+	}
+
+	// Modifying an entry breaks the chain and the log can't be reopened
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := strings.Replace(string(data), "m/0'/0/0", "m/0'/0/1", 1)
+	err = ioutil.WriteFile(file, []byte(tampered), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Verify(file)
+	if err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Error("The modified entry should be detected:", err)
+	}
+	_, err = Open(file)
+	if err == nil {
+		t.Error("The broken log should not be opened")
+	}
+
+	// Removing an entry breaks the chain as well
+	lines := strings.SplitN(string(data), "\n", 2)
+	err = ioutil.WriteFile(file, []byte(lines[1]), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Verify(file)
+	if err == nil {
+		t.Error("The removed entry should be detected")
+	}
+}
@@ -0,0 +1,219 @@
+package bitcoind
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mockNode the stand-in of the bitcoind RPC server with the wallets and the imported descriptors
+type mockNode struct {
+	user     string
+	password string
+	wallets  map[string]bool
+	imported map[string]string
+	unspent  map[string]bool
+}
+
+func (m *mockNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, password, ok := r.BasicAuth()
+	if !ok || user != m.user || password != m.password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ID     uint64            `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var result interface{}
+	var rpcErr *Error
+	wallet := strings.TrimPrefix(r.URL.Path, "/wallet/")
+	switch req.Method {
+	case "loadwallet", "createwallet":
+		var name string
+		_ = json.Unmarshal(req.Params[0], &name)
+		loaded, exists := m.wallets[name]
+		switch {
+		case req.Method == "loadwallet" && !exists:
+			rpcErr = &Error{ErrCodeWalletNotFound, "Wallet file not found"}
+		case req.Method == "loadwallet" && loaded:
+			rpcErr = &Error{ErrCodeWalletAlreadyLoaded, "Wallet is already loaded"}
+		case req.Method == "createwallet" && exists:
+			rpcErr = &Error{-4, "Wallet already exists"}
+		default:
+			m.wallets[name] = true
+			result = map[string]string{"name": name}
+		}
+	case "importdescriptors":
+		var requests []ImportRequest
+		_ = json.Unmarshal(req.Params[0], &requests)
+		var results []ImportResult
+		for _, request := range requests {
+			i := strings.IndexByte(request.Desc, '#')
+			if checksum, _ := DescriptorChecksum(request.Desc[:i]); i < 0 || checksum != request.Desc[i+1:] {
+				results = append(results, ImportResult{Error: &Error{-5, "Invalid checksum"}})
+				continue
+			}
+			m.imported[request.Desc[:i]] = wallet
+			results = append(results, ImportResult{Success: true})
+		}
+		result = results
+	case "getreceivedbyaddress":
+		var address string
+		var minConf int
+		_ = json.Unmarshal(req.Params[0], &address)
+		_ = json.Unmarshal(req.Params[1], &minConf)
+		if m.imported[AddrDescriptor(address)] != wallet {
+			rpcErr = &Error{-4, "Address not found in wallet"}
+			break
+		}
+		result = 0.0015
+		if minConf == 0 {
+			result = 0.0025
+		}
+	case "listunspent":
+		result = []map[string]interface{}{
+			{"txid": "5e2383defe7efcbdc9fdd6dba55da148b206617bbb49e6bb93fce7bfbb459d44", "vout": 1, "amount": 0.0015, "confirmations": 3, "scriptPubKey": "0014"},
+			{"txid": "4e2383defe7efcbdc9fdd6dba55da148b206617bbb49e6bb93fce7bfbb459d44", "vout": 0, "amount": 0.001, "confirmations": 0, "scriptPubKey": "0014"},
+		}
+	case "scantxoutset":
+		var descs []ScanObject
+		_ = json.Unmarshal(req.Params[1], &descs)
+		var unspents []map[string]string
+		for _, desc := range descs {
+			script := strings.TrimSuffix(strings.TrimPrefix(desc.Desc, "raw("), ")")
+			if m.unspent[script] {
+				unspents = append(unspents, map[string]string{"scriptPubKey": script})
+			}
+		}
+		result = map[string]interface{}{"success": true, "unspents": unspents}
+	default:
+		rpcErr = &Error{-32601, "Method not found"}
+	}
+
+	if rpcErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": result, "error": rpcErr, "id": req.ID})
+}
+
+func TestDescriptorChecksum(t *testing.T) {
+	// The test vector of BIP380
+	desc, err := AddChecksum("raw(deadbeef)")
+	if err != nil || desc != "raw(deadbeef)#89f8spxm" {
+		t.Error("Unexpected descriptor checksum:", desc, err)
+	}
+	if _, err = AddChecksum("raw(deadbeef)#89f8spxn"); err == nil {
+		t.Error("The invalid checksum should be rejected")
+	}
+	if _, err = AddChecksum("raw(deadbeefé)"); err == nil {
+		t.Error("The invalid character should be rejected")
+	}
+
+	pubKey := []byte{0x02, 0x79, 0xbe}
+	if desc := WPKHDescriptor("d34db33f", "m/0'/1/5", pubKey); desc != "wpkh([d34db33f/0'/1/5]0279be)" {
+		t.Error("Unexpected wpkh descriptor:", desc)
+	}
+	if desc := WPKHDescriptor("", "", pubKey); desc != "wpkh(0279be)" {
+		t.Error("Unexpected wpkh descriptor without the origin:", desc)
+	}
+}
+
+func TestClient(t *testing.T) {
+	node := &mockNode{user: "__cookie__", password: "secret", wallets: map[string]bool{}, imported: map[string]string{}}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "bitcoind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cookieFile := filepath.Join(dir, ".cookie")
+	if err = ioutil.WriteFile(cookieFile, []byte("__cookie__:secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = New(server.URL, "", "watch"); err == nil {
+		t.Error("The client without the credentials should be rejected")
+	}
+	if _, err = New("ftp://localhost", cookieFile, "watch"); err == nil {
+		t.Error("The invalid url should be rejected")
+	}
+
+	client, err := New(server.URL, cookieFile, "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The wallet is created at the first load, and it's already loaded at the second
+	if err = client.LoadWallet(); err != nil || !node.wallets["watch"] {
+		t.Fatal("Create the wallet failed:", err)
+	}
+	if err = client.LoadWallet(); err != nil {
+		t.Error("Load the loaded wallet failed:", err)
+	}
+
+	address := "bc1q8c87x4v0m3dfrxksv724rtwpxy5ghpw8gwf8da"
+	if _, err = client.AddressStatus(address); err == nil || !strings.Contains(err.Error(), "-4") {
+		t.Error("The address not imported should be rejected:", err)
+	}
+	if err = client.ImportWatchOnly(AddrDescriptor(address), "test"); err != nil {
+		t.Fatal(err)
+	}
+	if node.imported[AddrDescriptor(address)] != "watch" {
+		t.Error("The descriptor should be imported to the wallet:", node.imported)
+	}
+
+	status, err := client.AddressStatus(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Received != 150000 || status.Unconfirmed != 100000 || status.Balance != 250000 || len(status.UTXOs) != 2 ||
+		status.UTXOs[0].Value != 150000 || status.UTXOs[1].Confirmations != 0 {
+		t.Error("Unexpected address status:", status)
+	}
+
+	node.unspent = map[string]bool{"00143e0fe6ab8fdc5a919ad06795aadc1331288b85c7": true}
+	scripts, err := client.ScanTxOutSet([]ScanObject{{Desc: "raw(00143e0fe6ab8fdc5a919ad06795aadc1331288b85c7)"}, {Desc: "raw(0014)"}})
+	if err != nil || len(scripts) != 1 || scripts[0] != "00143e0fe6ab8fdc5a919ad06795aadc1331288b85c7" {
+		t.Error("Unexpected scanned scripts:", scripts, err)
+	}
+
+	if err = client.Call("unknown", nil); err == nil || err.(*Error).Code != -32601 {
+		t.Error("The RPC error should be returned:", err)
+	}
+
+	// The node rewrites the cookie at the restart
+	if err = ioutil.WriteFile(cookieFile, []byte("__cookie__:changed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.LoadWallet(); err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Error("The old cookie should be rejected:", err)
+	}
+	node.password = "changed"
+	if err = client.LoadWallet(); err != nil {
+		t.Error("The new cookie should be read:", err)
+	}
+
+	// The user and password of the url
+	userClient, err := New(strings.Replace(server.URL, "http://", "http://__cookie__:changed@", 1), "", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = userClient.LoadWallet(); err != nil {
+		t.Error("The url credentials should be accepted:", err)
+	}
+}
@@ -0,0 +1,323 @@
+package bitcoind
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcutil"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The bitcoind RPC error codes handled by the client
+const (
+	ErrCodeWalletNotFound      = -18
+	ErrCodeWalletAlreadyLoaded = -35
+)
+
+// DefaultTimeout the timeout of a RPC request
+const DefaultTimeout = 30 * time.Second
+
+// Error the error object of the RPC response
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("bitcoind error %d: %s", e.Code, e.Message)
+}
+
+// Client the JSON-RPC client of the bitcoind node. The wallet RPCs are sent to the named wallet, it's a descriptor
+// wallet without the private keys which only watches the imported descriptors.
+type Client struct {
+	url        string
+	user       string
+	password   string
+	cookieFile string
+	wallet     string
+	httpClient *http.Client
+	id         uint64
+}
+
+// New Create the client of the RPC url (http://host:port). The credentials are the user and password of the url, or
+// the cookie file of the node which is read at each request since the node rewrites it at every start.
+func New(rpcURL string, cookieFile string, wallet string) (*Client, error) {
+	u, err := url.Parse(rpcURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid RPC url %s", u.Redacted())
+	}
+	if wallet == "" {
+		return nil, errors.New("the wallet name is missing")
+	}
+
+	c := &Client{cookieFile: cookieFile, wallet: wallet, httpClient: &http.Client{Timeout: DefaultTimeout}}
+	if u.User != nil {
+		c.user = u.User.Username()
+		c.password, _ = u.User.Password()
+		u.User = nil
+	} else if cookieFile == "" {
+		return nil, errors.New("the RPC credentials are missing, set the user and password of the url or the cookie file")
+	}
+	c.url = strings.TrimSuffix(u.String(), "/")
+	return c, nil
+}
+
+// Wallet Return the name of the wallet
+func (c *Client) Wallet() string {
+	return c.wallet
+}
+
+// Call Send the RPC request to the node and unmarshal the result, the result is ignored if it's nil
+func (c *Client) Call(method string, result interface{}, params ...interface{}) error {
+	return c.call(c.url, method, result, params)
+}
+
+// WalletCall Send the RPC request to the wallet of the client
+func (c *Client) WalletCall(method string, result interface{}, params ...interface{}) error {
+	return c.call(c.url+"/wallet/"+url.PathEscape(c.wallet), method, result, params)
+}
+
+func (c *Client) call(endpoint string, method string, result interface{}, params []interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "1.0",
+		"id":      atomic.AddUint64(&c.id, 1),
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	user, password, err := c.credentials()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(user, password)
+	req.Header.Set("Content-Type", "application/json")
+
+	rsp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	data, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return err
+	}
+	if rsp.StatusCode == http.StatusUnauthorized || rsp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("%s: the RPC credentials are rejected, status %d", method, rsp.StatusCode)
+	}
+
+	// The node replies the RPC error with the status 404 or 500 and the error object
+	var rpcRsp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *Error          `json:"error"`
+	}
+	if err = json.Unmarshal(data, &rpcRsp); err != nil {
+		return fmt.Errorf("%s: unexpected response, status %d", method, rsp.StatusCode)
+	}
+	if rpcRsp.Error != nil {
+		return rpcRsp.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(rpcRsp.Result, result)
+}
+
+// credentials Return the user and password of the url or the cookie file
+func (c *Client) credentials() (string, string, error) {
+	if c.user != "" || c.cookieFile == "" {
+		return c.user, c.password, nil
+	}
+	cookie, err := ioutil.ReadFile(c.cookieFile)
+	if err != nil {
+		return "", "", err
+	}
+	parts := strings.SplitN(strings.TrimSpace(string(cookie)), ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid cookie file %s", c.cookieFile)
+	}
+	return parts[0], parts[1], nil
+}
+
+// LoadWallet Load the wallet of the client, the watch-only descriptor wallet is created if it doesn't exist
+func (c *Client) LoadWallet() error {
+	err := c.Call("loadwallet", nil, c.wallet)
+	var rpcErr *Error
+	if err == nil || (errors.As(err, &rpcErr) && rpcErr.Code == ErrCodeWalletAlreadyLoaded) {
+		return nil
+	}
+	if !errors.As(err, &rpcErr) || rpcErr.Code != ErrCodeWalletNotFound {
+		return err
+	}
+
+	// createwallet name disable_private_keys blank passphrase avoid_reuse descriptors
+	return c.Call("createwallet", nil, c.wallet, true, true, "", false, true)
+}
+
+// ImportRequest a descriptor to import, the timestamp is "now" or the unix time of the earliest output to rescan
+type ImportRequest struct {
+	Desc      string      `json:"desc"`
+	Timestamp interface{} `json:"timestamp"`
+	Label     string      `json:"label,omitempty"`
+}
+
+// ImportResult the result of an imported descriptor
+type ImportResult struct {
+	Success  bool     `json:"success"`
+	Warnings []string `json:"warnings,omitempty"`
+	Error    *Error   `json:"error,omitempty"`
+}
+
+// ImportDescriptors Import the descriptors to the wallet, return the result of each descriptor
+func (c *Client) ImportDescriptors(requests []ImportRequest) ([]ImportResult, error) {
+	for i := range requests {
+		desc, err := AddChecksum(requests[i].Desc)
+		if err != nil {
+			return nil, err
+		}
+		requests[i].Desc = desc
+	}
+
+	var results []ImportResult
+	err := c.WalletCall("importdescriptors", &results, requests)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != len(requests) {
+		return nil, fmt.Errorf("importdescriptors: %d results of %d descriptors", len(results), len(requests))
+	}
+	return results, nil
+}
+
+// ImportWatchOnly Import the descriptor of the newly generated address, the node doesn't rescan the blocks since the
+// address has no history
+func (c *Client) ImportWatchOnly(desc string, label string) error {
+	results, err := c.ImportDescriptors([]ImportRequest{{Desc: desc, Timestamp: "now", Label: label}})
+	if err != nil {
+		return err
+	}
+	if !results[0].Success {
+		if results[0].Error != nil {
+			return results[0].Error
+		}
+		return errors.New("importdescriptors failed")
+	}
+	return nil
+}
+
+// UTXO an unspent output of the address in the wallet, the value is in satoshi
+type UTXO struct {
+	TxID          string `json:"txid"`
+	Vout          uint32 `json:"vout"`
+	Value         int64  `json:"value"`
+	Confirmations int64  `json:"confirmations"`
+	ScriptPubKey  string `json:"scriptPubKey"`
+}
+
+// AddressStatus the amounts in satoshi received by the address with at least one confirmation and in the mempool, and
+// the unspent outputs of the address
+type AddressStatus struct {
+	Address     string `json:"address"`
+	Received    int64  `json:"received"`
+	Unconfirmed int64  `json:"unconfirmed"`
+	Balance     int64  `json:"balance"`
+	UTXOs       []UTXO `json:"utxos"`
+}
+
+// AddressStatus Return the received amount and the unspent outputs of the address, the address must be imported to
+// the wallet
+func (c *Client) AddressStatus(address string) (*AddressStatus, error) {
+	status := &AddressStatus{Address: address, UTXOs: []UTXO{}}
+
+	var received, receivedAll float64
+	err := c.WalletCall("getreceivedbyaddress", &received, address, 1)
+	if err != nil {
+		return nil, err
+	}
+	err = c.WalletCall("getreceivedbyaddress", &receivedAll, address, 0)
+	if err != nil {
+		return nil, err
+	}
+	confirmed, err := btcutil.NewAmount(received)
+	if err != nil {
+		return nil, err
+	}
+	all, err := btcutil.NewAmount(receivedAll)
+	if err != nil {
+		return nil, err
+	}
+	status.Received = int64(confirmed)
+	status.Unconfirmed = int64(all - confirmed)
+
+	// listunspent minconf maxconf addresses include_unsafe
+	var unspent []struct {
+		TxID          string  `json:"txid"`
+		Vout          uint32  `json:"vout"`
+		Amount        float64 `json:"amount"`
+		Confirmations int64   `json:"confirmations"`
+		ScriptPubKey  string  `json:"scriptPubKey"`
+	}
+	err = c.WalletCall("listunspent", &unspent, 0, 9999999, []string{address}, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range unspent {
+		value, err := btcutil.NewAmount(u.Amount)
+		if err != nil {
+			return nil, err
+		}
+		status.UTXOs = append(status.UTXOs, UTXO{u.TxID, u.Vout, int64(value), u.Confirmations, u.ScriptPubKey})
+		status.Balance += int64(value)
+	}
+	return status, nil
+}
+
+// ScanObject a scantxoutset descriptor, the ranged descriptor (ending with /*) is expanded to the indices from Range[0]
+// to Range[1] (inclusive)
+type ScanObject struct {
+	Desc  string   `json:"desc"`
+	Range []uint32 `json:"range,omitempty"`
+}
+
+// ScanTxOutSet Scan the utxo set of the node for the descriptors, and return the hex output scripts of the unspent
+// outputs. The node scans the whole utxo set, it takes minutes on the mainnet, so the descriptors of a scan should be
+// given in a single call.
+func (c *Client) ScanTxOutSet(descs []ScanObject) ([]string, error) {
+	var result struct {
+		Success  bool `json:"success"`
+		Unspents []struct {
+			ScriptPubKey string `json:"scriptPubKey"`
+		} `json:"unspents"`
+	}
+	err := c.Call("scantxoutset", &result, "start", descs)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Success {
+		return nil, errors.New("scantxoutset is aborted")
+	}
+
+	scripts := make([]string, len(result.Unspents))
+	for i, unspent := range result.Unspents {
+		scripts[i] = unspent.ScriptPubKey
+	}
+	return scripts, nil
+}
@@ -0,0 +1,97 @@
+package bitcoind
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// The character sets of the BIP380 descriptor checksum
+const (
+	descriptorInputCharset    = "0123456789()[],'/*abcdefgh@:$%{}IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+	descriptorChecksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+)
+
+var descriptorGenerator = [5]uint64{0xf5dee51989, 0xa9fdca3312, 0x1bab10e32d, 0x3706b1677a, 0x644d626ffd}
+
+// DescriptorChecksum Return the BIP380 checksum of the descriptor without the checksum
+func DescriptorChecksum(desc string) (string, error) {
+	var symbols []uint64
+	var groups []uint64
+	for i, c := range desc {
+		v := strings.IndexRune(descriptorInputCharset, c)
+		if v < 0 {
+			return "", fmt.Errorf("invalid descriptor character %q at %d", c, i)
+		}
+		symbols = append(symbols, uint64(v&31))
+		groups = append(groups, uint64(v>>5))
+		if len(groups) == 3 {
+			symbols = append(symbols, groups[0]*9+groups[1]*3+groups[2])
+			groups = groups[:0]
+		}
+	}
+	switch len(groups) {
+	case 1:
+		symbols = append(symbols, groups[0])
+	case 2:
+		symbols = append(symbols, groups[0]*3+groups[1])
+	}
+	symbols = append(symbols, make([]uint64, 8)...)
+
+	chk := uint64(1)
+	for _, value := range symbols {
+		top := chk >> 35
+		chk = (chk&0x7ffffffff)<<5 ^ value
+		for i := uint(0); i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= descriptorGenerator[i]
+			}
+		}
+	}
+	chk ^= 1
+
+	checksum := make([]byte, 8)
+	for i := range checksum {
+		checksum[i] = descriptorChecksumCharset[(chk>>(5*(7-uint(i))))&31]
+	}
+	return string(checksum), nil
+}
+
+// AddChecksum Return the descriptor with its checksum, the existing checksum is verified
+func AddChecksum(desc string) (string, error) {
+	if i := strings.IndexByte(desc, '#'); i >= 0 {
+		checksum, err := DescriptorChecksum(desc[:i])
+		if err != nil {
+			return "", err
+		}
+		if desc[i+1:] != checksum {
+			return "", fmt.Errorf("invalid descriptor checksum %s, expected %s", desc[i+1:], checksum)
+		}
+		return desc, nil
+	}
+
+	checksum, err := DescriptorChecksum(desc)
+	if err != nil {
+		return "", err
+	}
+	return desc + "#" + checksum, nil
+}
+
+// WPKHDescriptor Return the descriptor of the P2WPKH output of the public key with the key origin, the fingerprint of
+// the master key and the path m/... of the key. The origin is omitted without the fingerprint.
+func WPKHDescriptor(fingerprint string, path string, pubKey []byte) string {
+	if fingerprint == "" {
+		return "wpkh(" + hex.EncodeToString(pubKey) + ")"
+	}
+	return "wpkh([" + fingerprint + strings.TrimPrefix(path, "m") + "]" + hex.EncodeToString(pubKey) + ")"
+}
+
+// AddrDescriptor Return the descriptor of the address, the node watches the output script without the keys
+func AddrDescriptor(address string) string {
+	return "addr(" + address + ")"
+}
+
+// RawDescriptor Return the descriptor of the output script
+func RawDescriptor(pkScript []byte) string {
+	return "raw(" + hex.EncodeToString(pkScript) + ")"
+}
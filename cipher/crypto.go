@@ -7,13 +7,14 @@ import (
 	"errors"
 	"fmt"
 	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcutil"
-	"github.com/btcsuite/btcutil/base58"
 	"log"
 	"strings"
 )
 
 // MessageEncrypt encrypts data for the target public key using AES-256-CBC.
+// It is the legacy envelope (version 1), see MessageEncryptVersion for the versioned envelope.
 func MessageEncrypt(pubKey *btcec.PublicKey, plainText *[]byte) (*[]byte, error) {
 	ciphertext, err := btcec.Encrypt(pubKey, *plainText)
 	if err != nil {
@@ -33,16 +34,26 @@ func MessageDecrypt(privKey *btcec.PrivateKey, ciphertext *[]byte) (*[]byte, err
 	return &plainText, nil
 }
 
+// MultiSigSizeBound the bound of the m*73+n*66 multisig size estimate, the standard signature script of Bitcoin v0.9.x and
+// earlier is at most 500 bytes
+const MultiSigSizeBound = 496
+
+// MultiSigSizeEstimate Return the m*73+n*66 signature script size estimate of the m-of-n multisig, a signature push is at
+// most 73 bytes and an uncompressed public key push 66 bytes
+func MultiSigSizeEstimate(m int, n int) int {
+	return m*73 + n*66
+}
+
 // Duplicate the multisig functions due to the project package issues
 // Refrence: github.com/soroushjp/go-bitcoin-multisig/multisig
-// OutputAddress formats and prints relevant outputs to the user.
-func OutputAddress(flagM int, flagN int, flagPublicKeys string) (string, string, error) {
-	P2SHAddress, redeemScriptHex, err := generateAddress(flagM, flagN, flagPublicKeys)
+// OutputAddress formats and prints relevant outputs to the user, the P2SH address is encoded for the network.
+func OutputAddress(flagM int, flagN int, flagPublicKeys string, net *chaincfg.Params) (string, string, error) {
+	P2SHAddress, redeemScriptHex, err := generateAddress(flagM, flagN, flagPublicKeys, net)
 	if err != nil {
 		return "", "", err
 	}
 
-	if flagM*73+flagN*66 > 496 {
+	if MultiSigSizeEstimate(flagM, flagN) > MultiSigSizeBound {
 		err := errors.New(fmt.Sprintf(`
 -----------------------------------------------------------------------------------------------------------------------------------
 WARNING: 
@@ -66,7 +77,7 @@ See http://bitcoin.stackexchange.com/questions/23893/what-are-the-limits-of-m-an
 // GenerateAddress is the high-level logic for creating P2SH multisig addresses with the 'go-bitcoin-multisig address' subcommand.
 // Takes flagM (number of keys required to spend), flagN (total number of keys)
 // and flagPublicKeys (comma separated list of N public keys) as arguments.
-func generateAddress(flagM int, flagN int, flagPublicKeys string) (string, string, error) {
+func generateAddress(flagM int, flagN int, flagPublicKeys string, net *chaincfg.Params) (string, string, error) {
 	//Convert public keys argument into slice of public key bytes with necessary tidying
 	flagPublicKeys = strings.Replace(flagPublicKeys, "'", "\"", -1) //Replace single quotes with double since csv package only recognizes double quotes
 	publicKeyStrings, err := csv.NewReader(strings.NewReader(flagPublicKeys)).Read()
@@ -89,10 +100,12 @@ func generateAddress(flagM int, flagN int, flagPublicKeys string) (string, strin
 		log.Fatal(err)
 		return "", "", err
 	}
-	redeemScriptHash := btcutil.Hash160(redeemScript)
-
-	//Get P2SH address by base58 encoding with P2SH prefix 0x05
-	P2SHAddress := base58.CheckEncode(redeemScriptHash, 5)
+	//Get P2SH address by base58 encoding with the P2SH prefix of the network
+	scriptHashAddress, err := btcutil.NewAddressScriptHash(redeemScript, net)
+	if err != nil {
+		return "", "", err
+	}
+	P2SHAddress := scriptHashAddress.EncodeAddress()
 
 	//Get redeemScript in Hex
 	redeemScriptHex := hex.EncodeToString(redeemScript)
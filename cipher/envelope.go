@@ -0,0 +1,212 @@
+package cipher
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The envelope versions, the legacy version is the btcec ECIES (AES-256-CBC + HMAC-SHA256) without any header
+const (
+	EnvelopeLegacy = 1
+	EnvelopeV2     = 2
+)
+
+// The envelope v2 layout: version(1) | key id(4) | ephemeral public key(33) | nonce(12) | ChaCha20-Poly1305 ciphertext
+const (
+	envelopeKeyIDLen  = 4
+	envelopeHeaderLen = 1 + envelopeKeyIDLen + btcec.PubKeyBytesLenCompressed
+	envelopeInfo      = "bitcoinAddressGenerator envelope v2"
+)
+
+// supportedEnvelopeVersions the envelope versions this build can encrypt and decrypt
+var supportedEnvelopeVersions = []int{EnvelopeLegacy, EnvelopeV2}
+
+// EnvelopeVersions Return the envelope versions accepted by the server, the legacy version is only accepted in the compatibility mode
+func EnvelopeVersions(legacy bool) []int {
+	if legacy {
+		return []int{EnvelopeLegacy, EnvelopeV2}
+	}
+	return []int{EnvelopeV2}
+}
+
+// FormatEnvelopeVersions Format the versions as a comma separated list, e.g. "1,2"
+func FormatEnvelopeVersions(versions []int) string {
+	s := make([]string, len(versions))
+	for i, v := range versions {
+		s[i] = strconv.Itoa(v)
+	}
+	return strings.Join(s, ",")
+}
+
+// ParseEnvelopeVersions Parse the comma separated versions, the empty string means the peer only knows the legacy version
+func ParseEnvelopeVersions(s string) ([]int, error) {
+	if s == "" {
+		return []int{EnvelopeLegacy}, nil
+	}
+
+	var versions []int
+	for _, field := range strings.Split(s, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// NegotiateEnvelopeVersion Return the highest envelope version supported by both this build and the peer
+func NegotiateEnvelopeVersion(peerVersions []int) (int, error) {
+	versions := append([]int(nil), peerVersions...)
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+	for _, v := range versions {
+		if containsVersion(supportedEnvelopeVersions, v) {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("no shared envelope version with the peer versions %v", peerVersions)
+}
+
+// AcceptEnvelopeVersion Return an error if the version isn't in the accepted versions
+func AcceptEnvelopeVersion(accepted []int, version int) error {
+	if !containsVersion(accepted, version) {
+		return fmt.Errorf("envelope version %d is not accepted", version)
+	}
+	return nil
+}
+
+// MessageEncryptVersion encrypts data for the target public key using the envelope version.
+func MessageEncryptVersion(version int, pubKey *btcec.PublicKey, plainText *[]byte) (*[]byte, error) {
+	switch version {
+	case EnvelopeLegacy:
+		return MessageEncrypt(pubKey, plainText)
+	case EnvelopeV2:
+		envelope, err := SealEnvelope(pubKey, *plainText)
+		if err != nil {
+			return nil, err
+		}
+		return &envelope, nil
+	}
+	return nil, fmt.Errorf("unsupported envelope version %d", version)
+}
+
+// MessageDecryptVersion decrypts data that was encrypted using the MessageEncryptVersion function.
+func MessageDecryptVersion(version int, privKey *btcec.PrivateKey, ciphertext *[]byte) (*[]byte, error) {
+	switch version {
+	case EnvelopeLegacy:
+		return MessageDecrypt(privKey, ciphertext)
+	case EnvelopeV2:
+		plainText, err := OpenEnvelope(privKey, *ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		return &plainText, nil
+	}
+	return nil, fmt.Errorf("unsupported envelope version %d", version)
+}
+
+// SealEnvelope Encrypt the data for the target public key into a v2 envelope. The ChaCha20-Poly1305 key is derived by
+// HKDF-SHA256 from the ECDH secret of an ephemeral key, the header is authenticated as the additional data.
+func SealEnvelope(pubKey *btcec.PublicKey, plainText []byte) ([]byte, error) {
+	ephemeral, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, err := hex.DecodeString(KeyID(pubKey))
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, envelopeHeaderLen)
+	header = append(header, EnvelopeV2)
+	header = append(header, keyID...)
+	header = append(header, ephemeral.PubKey().SerializeCompressed()...)
+
+	aead, err := newEnvelopeAEAD(ephemeral, pubKey, ephemeral.PubKey(), pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := append(header, nonce...)
+	return aead.Seal(envelope, nonce, plainText, header), nil
+}
+
+// OpenEnvelope Decrypt the v2 envelope by the private key of the key id in the envelope
+func OpenEnvelope(privKey *btcec.PrivateKey, envelope []byte) ([]byte, error) {
+	if len(envelope) < envelopeHeaderLen+chacha20poly1305.NonceSize+chacha20poly1305.Overhead {
+		return nil, errors.New("envelope too short")
+	}
+	if envelope[0] != EnvelopeV2 {
+		return nil, fmt.Errorf("unsupported envelope version %d", envelope[0])
+	}
+	if hex.EncodeToString(envelope[1:1+envelopeKeyIDLen]) != KeyID(privKey.PubKey()) {
+		return nil, ErrUnknownKeyID
+	}
+
+	ephemeralPubKey, err := btcec.ParsePubKey(envelope[1+envelopeKeyIDLen:envelopeHeaderLen], btcec.S256())
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newEnvelopeAEAD(privKey, ephemeralPubKey, ephemeralPubKey, privKey.PubKey())
+	if err != nil {
+		return nil, err
+	}
+
+	header := envelope[:envelopeHeaderLen]
+	nonce := envelope[envelopeHeaderLen : envelopeHeaderLen+aead.NonceSize()]
+	plainText, err := aead.Open(nil, nonce, envelope[envelopeHeaderLen+aead.NonceSize():], header)
+	if err != nil {
+		return nil, errors.New("envelope authentication failed")
+	}
+
+	return plainText, nil
+}
+
+// newEnvelopeAEAD Derive the ChaCha20-Poly1305 cipher from the ECDH secret, both public keys are bound as the HKDF salt
+func newEnvelopeAEAD(privKey *btcec.PrivateKey, peerPubKey *btcec.PublicKey, ephemeralPubKey *btcec.PublicKey, recipientPubKey *btcec.PublicKey) (cipher.AEAD, error) {
+	secret := btcec.GenerateSharedSecret(privKey, peerPubKey)
+	salt := append(ephemeralPubKey.SerializeCompressed(), recipientPubKey.SerializeCompressed()...)
+
+	key := make([]byte, chacha20poly1305.KeySize)
+	_, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(envelopeInfo)), key)
+	for i := range secret {
+		secret[i] = 0
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.New(key)
+	for i := range key {
+		key[i] = 0
+	}
+	return aead, err
+}
+
+func containsVersion(versions []int, version int) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
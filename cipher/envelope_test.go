@@ -0,0 +1,79 @@
+package cipher
+
+import (
+	"github.com/btcsuite/btcd/btcec"
+	"reflect"
+	"testing"
+)
+
+func TestSealOpenEnvelope(t *testing.T) {
+	privateKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal("Generate key error:", err)
+	}
+
+	msg := []byte("test message")
+	envelope, err := SealEnvelope(privateKey.PubKey(), msg)
+	if err != nil {
+		t.Fatal("SealEnvelope error:", err)
+	}
+
+	if envelope[0] != EnvelopeV2 || len(envelope) != envelopeHeaderLen+12+len(msg)+16 {
+		t.Error("Unexpected envelope layout:", len(envelope))
+	}
+
+	plaintext, err := OpenEnvelope(privateKey, envelope)
+	if err != nil {
+		t.Fatal("OpenEnvelope error:", err)
+	}
+
+	if !reflect.DeepEqual(msg, plaintext) {
+		t.Error("OpenEnvelope failed:", string(msg), string(plaintext))
+	}
+
+	// The tampered header or ciphertext must be rejected
+	for _, i := range []int{0, 1, envelopeHeaderLen - 1, len(envelope) - 1} {
+		tampered := append([]byte(nil), envelope...)
+		tampered[i] ^= 0x01
+		_, err = OpenEnvelope(privateKey, tampered)
+		if err == nil {
+			t.Error("The tampered envelope should be rejected, byte:", i)
+		}
+	}
+
+	otherKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenEnvelope(otherKey, envelope)
+	if err != ErrUnknownKeyID {
+		t.Error("The envelope for another key id should be rejected, error:", err)
+	}
+}
+
+func TestNegotiateEnvelopeVersion(t *testing.T) {
+	versions, err := ParseEnvelopeVersions(FormatEnvelopeVersions(EnvelopeVersions(true)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := NegotiateEnvelopeVersion(versions)
+	if err != nil || version != EnvelopeV2 {
+		t.Error("The highest shared version should be negotiated:", version, err)
+	}
+
+	// The server without the versions field only knows the legacy envelope
+	versions, err = ParseEnvelopeVersions("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err = NegotiateEnvelopeVersion(versions)
+	if err != nil || version != EnvelopeLegacy {
+		t.Error("The legacy version should be negotiated:", version, err)
+	}
+
+	_, err = NegotiateEnvelopeVersion([]int{3})
+	if err == nil {
+		t.Error("The unknown version should not be negotiated")
+	}
+}
@@ -0,0 +1,134 @@
+package cipher
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The domain separation of the channel key announcement, the identity key signature can't be replayed for other messages
+const channelKeyMagic = "bitcoinAddressGenerator channel key:\n"
+
+// LoadOrCreateIdentityKey Load the long-term server identity key from the keystore file, generate and save a new one if
+// the file doesn't exist. The identity key signs the published channel keys and is never rotated.
+func LoadOrCreateIdentityKey(file string, passphrase []byte) (*btcec.PrivateKey, error) {
+	kr, err := LoadOrCreateKeyRing(file, passphrase, 0)
+	if err != nil {
+		return nil, err
+	}
+	return kr.Current().PrivKey, nil
+}
+
+// Fingerprint Return the fingerprint of the server identity key distributed out of band, the sha256 of the compressed public key
+func Fingerprint(pubKey *btcec.PublicKey) string {
+	hash := sha256.Sum256(pubKey.SerializeCompressed())
+	return "SHA256:" + hex.EncodeToString(hash[:])
+}
+
+// ChannelKeyAnnouncement the channel key fields published by the V1/serverPublicKeys API and signed by the server identity key
+type ChannelKeyAnnouncement struct {
+	PublicKey        string
+	KeyID            string
+	ExpiresAt        string
+	EnvelopeVersions string
+}
+
+// SignChannelKey Sign the channel key announcement by the server identity key. The accepted envelope versions are signed
+// too, so a man in the middle can't downgrade the client to the legacy envelope.
+func SignChannelKey(identityKey *btcec.PrivateKey, a *ChannelKeyAnnouncement) (string, error) {
+	sig, err := identityKey.Sign(a.hash())
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sig.Serialize()), nil
+}
+
+// VerifyChannelKey Verify the channel key announcement is signed by the server identity key
+func VerifyChannelKey(identityPubKey *btcec.PublicKey, a *ChannelKeyAnnouncement, signature string) error {
+	sigBytes, err := hex.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+
+	sig, err := btcec.ParseDERSignature(sigBytes, btcec.S256())
+	if err != nil {
+		return err
+	}
+
+	if !sig.Verify(a.hash(), identityPubKey) {
+		return errors.New("invalid channel key signature")
+	}
+
+	pubKeyBytes, err := hex.DecodeString(a.PublicKey)
+	if err != nil {
+		return err
+	}
+	pubKey, err := btcec.ParsePubKey(pubKeyBytes, btcec.S256())
+	if err != nil {
+		return err
+	}
+	if KeyID(pubKey) != a.KeyID {
+		return errors.New("channel key id doesn't match the public key")
+	}
+
+	return nil
+}
+
+// CheckKnownHost Verify the server identity against the known hosts file, each line is "host fingerprint".
+// The identity of a host not in the file is appended to the file (trust on first use) and true is returned.
+func CheckKnownHost(file string, host string, identityPubKey *btcec.PublicKey) (bool, error) {
+	fingerprint := Fingerprint(identityPubKey)
+
+	f, err := os.Open(file)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 2 || fields[0] != host {
+				continue
+			}
+			f.Close()
+			if fields[1] != fingerprint {
+				return false, fmt.Errorf("the identity of %s has changed to %s, expected %s, it might be a man in the middle attack", host, fingerprint, fields[1])
+			}
+			return false, nil
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return false, err
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(file), 0700)
+	if err != nil {
+		return false, err
+	}
+
+	f, err = os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "%s %s\n", host, fingerprint)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (a *ChannelKeyAnnouncement) hash() []byte {
+	msg := channelKeyMagic + a.PublicKey + "\n" + a.KeyID + "\n" + a.ExpiresAt + "\n" + a.EnvelopeVersions
+	return chainhash.DoubleHashB([]byte(msg))
+}
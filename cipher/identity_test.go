@@ -0,0 +1,93 @@
+package cipher
+
+import (
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSignVerifyChannelKey(t *testing.T) {
+	identityKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	channelKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey := hex.EncodeToString(channelKey.PubKey().SerializeCompressed())
+	keyID := KeyID(channelKey.PubKey())
+	announcement := &ChannelKeyAnnouncement{PublicKey: publicKey, KeyID: keyID, EnvelopeVersions: "2"}
+	signature, err := SignChannelKey(identityKey, announcement)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = VerifyChannelKey(identityKey.PubKey(), announcement, signature)
+	if err != nil {
+		t.Error("VerifyChannelKey error:", err)
+	}
+
+	// A swapped channel key or expiry must be rejected
+	attackerKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	attackerPublicKey := hex.EncodeToString(attackerKey.PubKey().SerializeCompressed())
+	swapped := &ChannelKeyAnnouncement{PublicKey: attackerPublicKey, KeyID: KeyID(attackerKey.PubKey()), EnvelopeVersions: "2"}
+	err = VerifyChannelKey(identityKey.PubKey(), swapped, signature)
+	if err == nil {
+		t.Error("The swapped channel key should be rejected")
+	}
+
+	modified := *announcement
+	modified.ExpiresAt = "2030-01-01T00:00:00Z"
+	err = VerifyChannelKey(identityKey.PubKey(), &modified, signature)
+	if err == nil {
+		t.Error("The modified expiry should be rejected")
+	}
+
+	downgraded := *announcement
+	downgraded.EnvelopeVersions = "1"
+	err = VerifyChannelKey(identityKey.PubKey(), &downgraded, signature)
+	if err == nil {
+		t.Error("The downgraded envelope versions should be rejected")
+	}
+}
+
+func TestCheckKnownHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knownhosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "known_hosts")
+	identityKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pinned, err := CheckKnownHost(file, "localhost:8080", identityKey.PubKey())
+	if err != nil || !pinned {
+		t.Fatal("The identity should be pinned at the first use:", err)
+	}
+
+	pinned, err = CheckKnownHost(file, "localhost:8080", identityKey.PubKey())
+	if err != nil || pinned {
+		t.Error("The pinned identity should be accepted:", err)
+	}
+
+	otherKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CheckKnownHost(file, "localhost:8080", otherKey.PubKey())
+	if err == nil {
+		t.Error("The changed identity should be rejected")
+	}
+}
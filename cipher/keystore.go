@@ -0,0 +1,390 @@
+package cipher
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcutil"
+	"golang.org/x/crypto/scrypt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The scrypt parameters recommended for interactive logins (2017), the keystore only be decrypted once at startup
+const (
+	keyStoreVersion = 2
+	scryptN         = 1 << 15
+	scryptR         = 8
+	scryptP         = 1
+	scryptKeyLen    = 32
+	saltLen         = 32
+)
+
+// ScryptParams the scrypt parameters stored with the data encrypted by a passphrase
+type ScryptParams struct {
+	N int `json:"n"`
+	R int `json:"r"`
+	P int `json:"p"`
+}
+
+// DefaultScryptParams the scrypt parameters of the newly encrypted data
+var DefaultScryptParams = ScryptParams{scryptN, scryptR, scryptP}
+
+// ErrUnknownKeyID the request is encrypted by a key the server doesn't hold or the key has expired
+var ErrUnknownKeyID = errors.New("unknown or expired server key id")
+
+// KeyStore the json file format of the server channel keys encrypted at rest by a passphrase
+type KeyStore struct {
+	Version int             `json:"version"`
+	Keys    []KeyStoreEntry `json:"keys"`
+}
+
+// KeyStoreEntry a channel key in the keystore, the key without expiry is the current key
+type KeyStoreEntry struct {
+	KeyID      string     `json:"keyId"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	KDF        string     `json:"kdf"`
+	N          int        `json:"n"`
+	R          int        `json:"r"`
+	P          int        `json:"p"`
+	Salt       string     `json:"salt"`
+	Nonce      string     `json:"nonce"`
+	CipherText string     `json:"cipherText"`
+}
+
+// ChannelKey a decrypted server channel key. The key of the key ring is never modified, it's shared by the concurrent
+// requests without the lock, the rotation replaces the current key by a deprecated copy with the expiry.
+type ChannelKey struct {
+	ID        string
+	PrivKey   *btcec.PrivateKey
+	CreatedAt time.Time
+	ExpiresAt *time.Time
+}
+
+// KeyRing holds the current server channel key and the rotated keys still accepted until their expiry
+type KeyRing struct {
+	mu         sync.RWMutex
+	keys       []*ChannelKey
+	file       string
+	passphrase []byte
+	grace      time.Duration
+}
+
+// KeyID Return the identifier of the channel key, the first 4 bytes of the hash160 of the compressed public key (same as the BIP032 fingerprint)
+func KeyID(pubKey *btcec.PublicKey) string {
+	return hex.EncodeToString(btcutil.Hash160(pubKey.SerializeCompressed())[:4])
+}
+
+// NewKeyRing Create a key ring only kept in memory with the given current key
+func NewKeyRing(privKey *btcec.PrivateKey, grace time.Duration) *KeyRing {
+	key := &ChannelKey{ID: KeyID(privKey.PubKey()), PrivKey: privKey, CreatedAt: time.Now().UTC()}
+	return &KeyRing{keys: []*ChannelKey{key}, grace: grace}
+}
+
+// LoadOrCreateKeyRing Load the channel keys from the keystore file, generate and save a new key if the file doesn't exist.
+// The rotated keys are accepted for the grace period after the rotation.
+func LoadOrCreateKeyRing(file string, passphrase []byte, grace time.Duration) (*KeyRing, error) {
+	kr := &KeyRing{file: file, passphrase: passphrase, grace: grace}
+
+	_, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		privKey, err := btcec.NewPrivateKey(btcec.S256())
+		if err != nil {
+			return nil, err
+		}
+
+		kr.keys = []*ChannelKey{{ID: KeyID(privKey.PubKey()), PrivKey: privKey, CreatedAt: time.Now().UTC()}}
+		err = kr.save()
+		if err != nil {
+			return nil, err
+		}
+		return kr, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var ks KeyStore
+	err = json.Unmarshal(data, &ks)
+	if err != nil {
+		return nil, err
+	}
+
+	// The version 1 keystore is a single entry object
+	if ks.Version == 1 {
+		var entry KeyStoreEntry
+		err = json.Unmarshal(data, &entry)
+		if err != nil {
+			return nil, err
+		}
+		ks.Keys = []KeyStoreEntry{entry}
+	} else if ks.Version != keyStoreVersion {
+		return nil, fmt.Errorf("unsupported keystore version %d", ks.Version)
+	}
+
+	for i := range ks.Keys {
+		key, err := decryptEntry(&ks.Keys[i], passphrase)
+		if err != nil {
+			return nil, err
+		}
+		kr.keys = append(kr.keys, key)
+	}
+
+	if kr.current() == nil {
+		return nil, errors.New("keystore has no current key")
+	}
+
+	return kr, nil
+}
+
+// Current Return the key advertised to the clients
+func (kr *KeyRing) Current() *ChannelKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.current()
+}
+
+// Lookup Return the key of the key id, the rotated key is returned until it expires
+func (kr *KeyRing) Lookup(keyID string) (*ChannelKey, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	now := time.Now()
+	for _, key := range kr.keys {
+		if key.ID != keyID {
+			continue
+		}
+		if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+			return nil, ErrUnknownKeyID
+		}
+		return key, nil
+	}
+
+	return nil, ErrUnknownKeyID
+}
+
+// Rotate Generate a new current key, the previous keys expire after the grace period and the expired keys are dropped.
+// Return the new current key and the deprecated previous key.
+func (kr *KeyRing) Rotate() (*ChannelKey, *ChannelKey, error) {
+	privKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(kr.grace)
+	keys := []*ChannelKey{{ID: KeyID(privKey.PubKey()), PrivKey: privKey, CreatedAt: now}}
+	var previous *ChannelKey
+	for _, key := range kr.keys {
+		if key.ExpiresAt == nil {
+			deprecated := *key
+			deprecated.ExpiresAt = &expiresAt
+			key = &deprecated
+			previous = key
+		}
+		if now.Before(*key.ExpiresAt) {
+			keys = append(keys, key)
+		}
+	}
+
+	// The keys of the failed rotation are dropped, the key ring still holds the unmodified keys
+	rotated := kr.keys
+	kr.keys = keys
+	err = kr.save()
+	if err != nil {
+		kr.keys = rotated
+		return nil, nil, err
+	}
+
+	return keys[0], previous, nil
+}
+
+// Deprecated Return true if the key has been rotated and will expire
+func (key *ChannelKey) Deprecated() bool {
+	return key.ExpiresAt != nil
+}
+
+func (kr *KeyRing) current() *ChannelKey {
+	for _, key := range kr.keys {
+		if key.ExpiresAt == nil {
+			return key
+		}
+	}
+	return nil
+}
+
+// save Write the keys to the keystore file, the key ring created by NewKeyRing isn't persisted
+func (kr *KeyRing) save() error {
+	if kr.file == "" {
+		return nil
+	}
+
+	ks := KeyStore{Version: keyStoreVersion}
+	for _, key := range kr.keys {
+		entry, err := encryptEntry(key, kr.passphrase)
+		if err != nil {
+			return err
+		}
+		ks.Keys = append(ks.Keys, *entry)
+	}
+
+	data, err := json.MarshalIndent(ks, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// A crash during the writing doesn't lose the keys
+	return WriteFileAtomic(kr.file, data, 0600)
+}
+
+// WriteFileAtomic Write the data to a temporary file synced to the disk and rename it to the file, then sync the
+// directory so the rename is durable. A crash at any point leaves either the old or the new file, never a truncated one.
+func WriteFileAtomic(file string, data []byte, perm os.FileMode) error {
+	tmp := file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	err = os.Rename(tmp, file)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(file))
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	if closeErr := dir.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// encryptEntry Encrypt the channel key by the passphrase
+func encryptEntry(key *ChannelKey, passphrase []byte) (*KeyStoreEntry, error) {
+	salt := make([]byte, saltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := NewPassphraseAEAD(passphrase, salt, DefaultScryptParams)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	plainText := key.PrivKey.Serialize()
+	cipherText := aead.Seal(nil, nonce, plainText, []byte(key.ID))
+	for i := range plainText {
+		plainText[i] = 0
+	}
+
+	return &KeyStoreEntry{
+		KeyID:      key.ID,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		KDF:        "scrypt",
+		N:          scryptN,
+		R:          scryptR,
+		P:          scryptP,
+		Salt:       hex.EncodeToString(salt),
+		Nonce:      hex.EncodeToString(nonce),
+		CipherText: hex.EncodeToString(cipherText),
+	}, nil
+}
+
+// decryptEntry Decrypt the channel key stored in the keystore entry by the passphrase
+func decryptEntry(entry *KeyStoreEntry, passphrase []byte) (*ChannelKey, error) {
+	if entry.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported keystore kdf %s", entry.KDF)
+	}
+
+	salt, err := hex.DecodeString(entry.Salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(entry.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	cipherText, err := hex.DecodeString(entry.CipherText)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := NewPassphraseAEAD(passphrase, salt, ScryptParams{entry.N, entry.R, entry.P})
+	if err != nil {
+		return nil, err
+	}
+
+	// The key id is authenticated as the additional data, so it can't be swapped in the file
+	plainText, err := aead.Open(nil, nonce, cipherText, []byte(entry.KeyID))
+	if err != nil {
+		return nil, errors.New("keystore decryption failed, wrong passphrase or corrupted file")
+	}
+
+	privKey, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), plainText)
+	for i := range plainText {
+		plainText[i] = 0
+	}
+
+	if KeyID(pubKey) != entry.KeyID {
+		return nil, errors.New("keystore key id doesn't match the stored key")
+	}
+
+	return &ChannelKey{ID: entry.KeyID, PrivKey: privKey, CreatedAt: entry.CreatedAt, ExpiresAt: entry.ExpiresAt}, nil
+}
+
+// NewPassphraseAEAD Derive the AES-256-GCM cipher from the passphrase by scrypt
+func NewPassphraseAEAD(passphrase []byte, salt []byte, params ScryptParams) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, params.N, params.R, params.P, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	for i := range key {
+		key[i] = 0
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
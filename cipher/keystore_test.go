@@ -0,0 +1,186 @@
+package cipher
+
+import (
+	"github.com/btcsuite/btcd/btcec"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadOrCreateKeyRing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "serverKey.json")
+	passphrase := []byte("test passphrase")
+
+	kr, err := LoadOrCreateKeyRing(file, passphrase, time.Hour)
+	if err != nil {
+		t.Fatal("Create channel key error:", err)
+	}
+	created := kr.Current().PrivKey
+
+	kr, err = LoadOrCreateKeyRing(file, passphrase, time.Hour)
+	if err != nil {
+		t.Fatal("Load channel key error:", err)
+	}
+	loaded := kr.Current().PrivKey
+
+	if !reflect.DeepEqual(created.Serialize(), loaded.Serialize()) {
+		t.Error("The loaded key is different from the created key")
+	}
+
+	if KeyID(created.PubKey()) != KeyID(loaded.PubKey()) || len(KeyID(loaded.PubKey())) != 8 {
+		t.Error("Unmatched key id:", KeyID(created.PubKey()), KeyID(loaded.PubKey()))
+	}
+
+	_, err = LoadOrCreateKeyRing(file, []byte("wrong passphrase"), time.Hour)
+	if err == nil {
+		t.Error("The keystore should not be decrypted by a wrong passphrase")
+	}
+}
+
+func TestKeyRingRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "serverKey.json")
+	passphrase := []byte("test passphrase")
+
+	kr, err := LoadOrCreateKeyRing(file, passphrase, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := kr.Current()
+
+	current, deprecated, err := kr.Rotate()
+	if err != nil {
+		t.Fatal("Rotate error:", err)
+	}
+
+	if current.ID == previous.ID || kr.Current().ID != current.ID {
+		t.Error("The rotated key should be the current key")
+	}
+	if deprecated.ID != previous.ID || !deprecated.Deprecated() || previous.Deprecated() {
+		t.Error("The rotation should deprecate a copy of the previous key")
+	}
+
+	// The previous key survives the restart and is accepted until it expires
+	loaded, err := LoadOrCreateKeyRing(file, passphrase, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.Current().ID != current.ID {
+		t.Error("Unmatched current key after reload:", loaded.Current().ID, current.ID)
+	}
+
+	key, err := loaded.Lookup(previous.ID)
+	if err != nil {
+		t.Fatal("The previous key should be accepted during the grace period:", err)
+	}
+	if !key.Deprecated() {
+		t.Error("The previous key should be deprecated")
+	}
+
+	// Without the grace period the previous key expires immediately
+	kr = NewKeyRing(current.PrivKey, 0)
+	_, _, err = kr.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = kr.Lookup(current.ID)
+	if err != ErrUnknownKeyID {
+		t.Error("The expired key should be rejected, error:", err)
+	}
+}
+
+func TestKeyRingRotateConcurrent(t *testing.T) {
+	privKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr := NewKeyRing(privKey, time.Hour)
+	previous := kr.Current()
+
+	// The keys are read by the requests while the key is rotated, run with -race
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key, err := kr.Lookup(previous.ID)
+				if err != nil || key.ID != previous.ID {
+					t.Error("Unexpected previous key:", err)
+					return
+				}
+				_ = kr.Current().Deprecated()
+			}
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		if _, _, err = kr.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	// The failed rotation keeps the key ring unmodified
+	current := kr.Current()
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kr.file = filepath.Join(dir, "missing", "serverKey.json")
+	if _, _, err = kr.Rotate(); err == nil {
+		t.Fatal("The rotation should fail to save the keystore")
+	}
+	if kr.Current() != current || current.Deprecated() {
+		t.Error("The failed rotation should keep the current key")
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "vault.json")
+	for _, data := range []string{"first", "second"} {
+		if err = WriteFileAtomic(file, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		written, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(written) != data {
+			t.Error("Unexpected file content:", string(written))
+		}
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Error("Unexpected file mode:", info.Mode())
+	}
+	if _, err = os.Stat(file + ".tmp"); !os.IsNotExist(err) {
+		t.Error("The temporary file should be renamed:", err)
+	}
+}
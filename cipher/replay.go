@@ -0,0 +1,103 @@
+package cipher
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+)
+
+// MaxFutureSkew the max time the request timestamp may be ahead of the server time, the nonce of the future request
+// is kept in the cache until its timestamp leaves the window, so the skew is much smaller than the window
+const MaxFutureSkew = 5 * time.Second
+
+// The errors of the request rejected by the ReplayGuard
+var (
+	ErrStaleRequest    = errors.New("request timestamp is out of the freshness window")
+	ErrReplayedRequest = errors.New("request nonce has been used")
+	ErrReplayCacheFull = errors.New("replay cache is full of unexpired nonces")
+)
+
+// ReplayGuard rejects the encrypted request replayed by an attacker. A request is fresh if its timestamp is in the
+// window before the server time (or at most MaxFutureSkew after it) and its nonce hasn't been seen. The seen nonces are
+// kept in a bounded cache until their timestamp leaves the window, an unexpired nonce is never evicted: when the cache
+// is full the new request is rejected with ErrReplayCacheFull until the oldest nonce expires.
+type ReplayGuard struct {
+	mu       sync.Mutex
+	window   time.Duration
+	capacity int
+	seen     map[string]int64
+	order    replayHeap
+	now      func() time.Time
+}
+
+type replayEntry struct {
+	nonce     string
+	timestamp int64
+}
+
+// replayHeap the seen nonces ordered by their timestamp, the oldest first
+type replayHeap []replayEntry
+
+func (h replayHeap) Len() int            { return len(h) }
+func (h replayHeap) Less(i, j int) bool  { return h[i].timestamp < h[j].timestamp }
+func (h replayHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *replayHeap) Push(x interface{}) { *h = append(*h, x.(replayEntry)) }
+func (h *replayHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// NewReplayGuard Create a replay guard accepting the timestamp within the window and remembering up to capacity nonces
+func NewReplayGuard(window time.Duration, capacity int) *ReplayGuard {
+	return &ReplayGuard{
+		window:   window,
+		capacity: capacity,
+		seen:     make(map[string]int64),
+		now:      time.Now,
+	}
+}
+
+// Check Record the nonce of the request, return ErrStaleRequest, ErrReplayedRequest or ErrReplayCacheFull if the
+// request has to be rejected
+func (rg *ReplayGuard) Check(timestamp int64, nonce string) error {
+	if nonce == "" {
+		return ErrReplayedRequest
+	}
+
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	now := rg.now()
+	skew := MaxFutureSkew
+	if skew > rg.window {
+		skew = rg.window
+	}
+	if timestamp < now.Add(-rg.window).Unix() || timestamp > now.Add(skew).Unix() {
+		return ErrStaleRequest
+	}
+
+	if _, ok := rg.seen[nonce]; ok {
+		return ErrReplayedRequest
+	}
+
+	rg.expire(now)
+	if len(rg.order) >= rg.capacity {
+		return ErrReplayCacheFull
+	}
+
+	rg.seen[nonce] = timestamp
+	heap.Push(&rg.order, replayEntry{nonce, timestamp})
+	return nil
+}
+
+// expire Drop the nonces of which the timestamp has left the window, they are rejected as stale anyway
+func (rg *ReplayGuard) expire(now time.Time) {
+	start := now.Add(-rg.window).Unix()
+	for len(rg.order) > 0 && rg.order[0].timestamp < start {
+		e := heap.Pop(&rg.order).(replayEntry)
+		delete(rg.seen, e.nonce)
+	}
+}
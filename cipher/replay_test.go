@@ -0,0 +1,64 @@
+package cipher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplayGuard(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	rg := NewReplayGuard(time.Minute, 3)
+	rg.now = func() time.Time { return now }
+
+	ts := now.Unix()
+	if err := rg.Check(ts, "a"); err != nil {
+		t.Error("The fresh request should be accepted:", err)
+	}
+	if err := rg.Check(ts, "a"); err != ErrReplayedRequest {
+		t.Error("The replayed request should be rejected:", err)
+	}
+	if err := rg.Check(ts-120, "b"); err != ErrStaleRequest {
+		t.Error("The old request should be rejected:", err)
+	}
+	if err := rg.Check(ts+120, "c"); err != ErrStaleRequest {
+		t.Error("The future request should be rejected:", err)
+	}
+	if err := rg.Check(ts+int64(MaxFutureSkew/time.Second)+1, "c"); err != ErrStaleRequest {
+		t.Error("The request over the future skew should be rejected:", err)
+	}
+
+	// The cache full of unexpired nonces rejects the new request instead of evicting a nonce
+	if err := rg.Check(ts+int64(MaxFutureSkew/time.Second), "0"); err != nil {
+		t.Error("The fresh request should be accepted:", err)
+	}
+	if err := rg.Check(ts-30, "1"); err != nil {
+		t.Error("The fresh request should be accepted:", err)
+	}
+	if err := rg.Check(ts, "e"); err != ErrReplayCacheFull {
+		t.Error("The request should be rejected by the full cache:", err)
+	}
+	if err := rg.Check(ts, "a"); err != ErrReplayedRequest {
+		t.Error("The nonce should not be replayed:", err)
+	}
+
+	// The oldest nonce expires first although it was inserted last
+	now = now.Add(40 * time.Second)
+	if err := rg.Check(now.Unix(), "e"); err != nil {
+		t.Error("The fresh request should be accepted after the oldest nonce expires:", err)
+	}
+	if _, ok := rg.seen["1"]; ok {
+		t.Error("The expired nonce should be dropped")
+	}
+	if err := rg.Check(ts, "a"); err != ErrReplayedRequest {
+		t.Error("The unexpired nonce should not be replayed:", err)
+	}
+
+	// The nonces leave the cache after the window
+	now = now.Add(2 * time.Minute)
+	if err := rg.Check(now.Unix(), "d"); err != nil {
+		t.Error("The fresh request should be accepted:", err)
+	}
+	if len(rg.seen) != 1 {
+		t.Error("The expired nonces should be dropped, cache size:", len(rg.seen))
+	}
+}
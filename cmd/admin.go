@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/jayt106/bitcoinAddressGenerator/cipher"
+	"log"
+	"net/http"
+	"time"
+)
+
+// RotateKeyHandler the handler uses for passing this struct into the ServerHTTP function
+type RotateKeyHandler struct {
+	keyRing    *cipher.KeyRing
+	adminToken string
+}
+
+// ServeHTTP handle the V1/admin/rotateServerKey API request. Generate a new server channel key and advertise it by the
+// V1/serverPublicKeys API, the previous key is still accepted until the grace period expires.
+// The request must carry the admin token as the bearer token.
+func (rh *RotateKeyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handle API /v1/admin/rotateServerKey")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := []byte("Bearer " + rh.adminToken)
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), token) != 1 {
+		log.Println("Rotate server key error:", errors.New("invalid admin token"))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	key, previous, err := rh.keyRing.Rotate()
+	if err != nil {
+		ServerErrorHandle(w, err, "Rotate server key error:")
+		return
+	}
+	log.Println("The server channel key is rotated from", previous.ID, "to", key.ID)
+
+	resp := make(map[string]string)
+	resp["publicKey"] = hex.EncodeToString(key.PrivKey.PubKey().SerializeCompressed())
+	resp["keyId"] = key.ID
+	resp["previousKeyId"] = previous.ID
+	if previous.ExpiresAt != nil {
+		resp["previousKeyExpiresAt"] = previous.ExpiresAt.Format(time.RFC3339)
+	}
+
+	marshalledData, err := json.Marshal(resp)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json Marshal error:")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, err = w.Write(marshalledData)
+	if err != nil {
+		log.Println("ServeHTTP write error:", err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/jayt106/bitcoinAddressGenerator/cipher"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPServerRotateServerKey(t *testing.T) {
+	rotatePrivKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr := cipher.NewKeyRing(rotatePrivKey, time.Hour)
+	rotateh := &RotateKeyHandler{kr, "secret"}
+
+	req, err := http.NewRequest("POST", "/v1/admin/rotateServerKey", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer wrong")
+	rr := httptest.NewRecorder()
+	rotateh.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Error("The request with a wrong admin token should be rejected, status:", rr.Code)
+	}
+
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	rotateh.ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		t.Fatal("Rotate server key failed, status:", rr.Code)
+	}
+
+	body, err := ioutil.ReadAll(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rsp map[string]string
+	err = json.Unmarshal(body, &rsp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rsp["previousKeyId"] != cipher.KeyID(rotatePrivKey.PubKey()) || rsp["keyId"] != kr.Current().ID {
+		t.Error("Unmatched key ids:", rsp)
+	}
+
+	// The previous key is still accepted during the grace period and reported as deprecated
+	previous, err := kr.Lookup(rsp["previousKeyId"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !previous.Deprecated() || rsp["previousKeyExpiresAt"] == "" {
+		t.Error("The previous key should be deprecated")
+	}
+}
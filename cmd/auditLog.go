@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jayt106/bitcoinAddressGenerator/audit"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+
+	l := len(os.Args)
+
+	if l >= 2 && strings.ToLower(os.Args[1]) == "help" {
+		help()
+		return
+	} else if l == 3 && os.Args[1] == "verify" {
+		entries, err := audit.Verify(os.Args[2])
+		if err != nil {
+			log.Fatalln("The audit log verification failed after", len(entries), "valid entries:", err)
+			return
+		}
+		fmt.Println("The audit log is intact,", len(entries), "entries")
+	} else if l == 4 && os.Args[1] == "export" {
+		// Export only the verified log, a broken chain can't be trusted
+		entries, err := audit.Verify(os.Args[2])
+		if err != nil {
+			log.Fatalln("The audit log verification failed after", len(entries), "valid entries:", err)
+			return
+		}
+
+		switch strings.ToLower(os.Args[3]) {
+		case "csv":
+			err = audit.ExportCSV(os.Stdout, entries)
+		case "json":
+			err = audit.ExportJSON(os.Stdout, entries)
+		default:
+			fmt.Println("Invalid export format, please check your input")
+			help()
+			return
+		}
+		if err != nil {
+			log.Fatalln(err)
+			return
+		}
+	} else {
+		fmt.Println("Invalid arguments, please check your input")
+		help()
+		return
+	}
+}
+
+func help() {
+	fmt.Println("usage: ./auditLog verify [audit log file]")
+	fmt.Println()
+	fmt.Println("Verify the hash chain and print the entries to the stdout in the csv or json format")
+	fmt.Println("usage: ./auditLog export [audit log file] [csv|json]")
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/jayt106/bitcoinAddressGenerator/cipher"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+// SecureChannel the middleware of the encrypted APIs. The http client sends {"keyId", "version", "data"}, the data is the
+// compressed public key of the client (for the return message encryption) followed by the json request of the API,
+// encrypted by the server channel key of the key id (See V1/serverPublicKeys API) in the envelope version.
+// The middleware decrypts the request, checks the timestamp and nonce of the request against the replay protection and
+// passes the json request to the API handler. The response of the API handler is encrypted by the client's public key
+// in the same envelope version. The error response is passed as is.
+type SecureChannel struct {
+	keyRing          *cipher.KeyRing
+	envelopeVersions []int
+	replayGuard      *cipher.ReplayGuard
+}
+
+// clientKeyIDKey the request context key of the client channel key id of the decrypted request
+type clientKeyIDKey struct{}
+
+// sessionKey the request context key of the channelSession
+type sessionKey struct{}
+
+// channelSession the client public key and the envelope version of the encrypted request, the records of the streaming
+// response are encrypted by them
+type channelSession struct {
+	clientKey *btcec.PublicKey
+	version   int
+}
+
+// Requester Return the requester recorded in the audit log, the remote address of the request. The client channel key
+// isn't recorded, the client generates a fresh key for each request so its key id identifies nobody.
+func Requester(r *http.Request) string {
+	return r.RemoteAddr
+}
+
+// Encrypted Return true if the request is decrypted by the secure channel, false for the plaintext request
+func Encrypted(r *http.Request) bool {
+	_, ok := r.Context().Value(clientKeyIDKey{}).(string)
+	return ok
+}
+
+// channelResponseWriter buffers the response of the API handler for the encryption, unless the handler streams the
+// encrypted records to the client by the RecordWriter
+type channelResponseWriter struct {
+	header    http.Header
+	status    int
+	body      bytes.Buffer
+	w         http.ResponseWriter
+	streaming bool
+}
+
+func (cw *channelResponseWriter) Header() http.Header {
+	return cw.header
+}
+
+func (cw *channelResponseWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = 200
+	}
+	return cw.body.Write(b)
+}
+
+func (cw *channelResponseWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+// stream Return the response writer of the client, the records encrypted by the RecordWriter are written to it as is
+func (cw *channelResponseWriter) stream() http.ResponseWriter {
+	cw.streaming = true
+	return cw.w
+}
+
+// Handler Wrap the API handler by the secure channel. If allowPlaintext is true, the request without the data field is
+// passed to the API handler as is for the clients not using the encryption.
+func (sc *SecureChannel) Handler(next http.Handler, allowPlaintext bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sc.serve(w, r, next, allowPlaintext)
+	})
+}
+
+func (sc *SecureChannel) serve(w http.ResponseWriter, r *http.Request, next http.Handler, allowPlaintext bool) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Read body error:")
+		return
+	}
+
+	var msgParam map[string]interface{}
+	err = json.Unmarshal(body, &msgParam)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json unmarshal error:")
+		return
+	}
+
+	if _, ok := msgParam["data"]; !ok && allowPlaintext {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	keyID, _ := msgParam["keyId"].(string)
+	data, _ := msgParam["data"].(string)
+	versionParam, _ := msgParam["version"].(string)
+
+	// Reject the request encrypted by a key the server doesn't hold, the client needs to fetch the current key
+	channelKey, err := sc.keyRing.Lookup(keyID)
+	if err != nil {
+		ServerErrorHandle(w, err, "Server key id "+keyID+" error:")
+		return
+	}
+
+	cipherBytes, err := hex.DecodeString(data)
+	if err != nil {
+		ServerErrorHandle(w, err, "Hex decode string error:")
+		return
+	}
+
+	versions, err := cipher.ParseEnvelopeVersions(versionParam)
+	if err == nil && len(versions) != 1 {
+		err = errors.New("the request must carry exactly one envelope version")
+	}
+	if err != nil {
+		ServerErrorHandle(w, err, "Envelope version "+versionParam+" error:")
+		return
+	}
+	version := versions[0]
+
+	err = cipher.AcceptEnvelopeVersion(sc.envelopeVersions, version)
+	if err != nil {
+		ServerErrorHandle(w, err, "Envelope version error:")
+		return
+	}
+
+	plainBytes, err := cipher.MessageDecryptVersion(version, channelKey.PrivKey, &cipherBytes)
+	if err != nil {
+		ServerErrorHandle(w, err, "Decrypt data error:")
+		return
+	}
+
+	slice := *plainBytes
+	defer func() {
+		for i := range slice {
+			slice[i] = 0
+		}
+	}()
+	Clear(plainBytes)
+
+	if len(slice) < btcec.PubKeyBytesLenCompressed {
+		ServerErrorHandle(w, errors.New("missing client public key"), "Decrypt data error:")
+		return
+	}
+
+	clientCipherPublicKey, err := btcec.ParsePubKey(slice[:btcec.PubKeyBytesLenCompressed], btcec.S256())
+	if err != nil {
+		ServerErrorHandle(w, err, "ParsePubKey error:")
+		return
+	}
+	payload := slice[btcec.PubKeyBytesLenCompressed:]
+
+	var replayParam REPLAYPARAM
+	err = json.Unmarshal(payload, &replayParam)
+	if err != nil {
+		ServerErrorHandle(w, err, "Unmarshal data error:")
+		return
+	}
+
+	err = sc.replayGuard.Check(replayParam.TIMESTAMP, replayParam.NONCE)
+	if err != nil {
+		ReplayErrorHandle(w, err)
+		return
+	}
+
+	cw := &channelResponseWriter{header: make(http.Header), w: w}
+	ctx := context.WithValue(r.Context(), clientKeyIDKey{}, cipher.KeyID(clientCipherPublicKey))
+	ctx = context.WithValue(ctx, sessionKey{}, &channelSession{clientCipherPublicKey, version})
+	r = r.WithContext(ctx)
+	r.Body = ioutil.NopCloser(bytes.NewReader(payload))
+	next.ServeHTTP(cw, r)
+	if cw.streaming {
+		return
+	}
+
+	if cw.status != 200 {
+		for k, v := range cw.header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(cw.status)
+		_, err = w.Write(cw.body.Bytes())
+		if err != nil {
+			log.Println("ServeHTTP write error:", err)
+		}
+		return
+	}
+
+	marshalledData := cw.body.Bytes()
+	if channelKey.Deprecated() {
+		marshalledData, err = addKeyDeprecation(marshalledData, channelKey)
+		if err != nil {
+			ServerErrorHandle(w, err, "Json Marshal error:")
+			return
+		}
+	}
+
+	cipherText, err := cipher.MessageEncryptVersion(version, clientCipherPublicKey, &marshalledData)
+	if err != nil {
+		ServerErrorHandle(w, err, "MessageEncrypt error:")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, err = w.Write(*cipherText)
+	if err != nil {
+		log.Println("ServeHTTP write error:", err)
+	}
+}
+
+// addKeyDeprecation Add the deprecation of the channel key to the json response, the client should fetch the new server key
+func addKeyDeprecation(data []byte, channelKey *cipher.ChannelKey) ([]byte, error) {
+	var resp map[string]json.RawMessage
+	err := json.Unmarshal(data, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	resp["keyDeprecated"] = json.RawMessage(`"true"`)
+	resp["keyExpiresAt"] = json.RawMessage(`"` + channelKey.ExpiresAt.Format(time.RFC3339) + `"`)
+	return json.Marshal(resp)
+}
+
+// RecordWriter writes the newline-delimited json records of the streaming response as they are produced, so the large
+// response isn't buffered by the server. The records of the encrypted request are encrypted one by one by the client
+// public key of the secure channel and written as {"data"} lines, the records of the plaintext request over TLS are
+// written as is. The response status 200 is sent with the first record. If the write timeout is set, the write deadline
+// of the response is extended by it for each record, so the server write timeout doesn't cut the long stream.
+type RecordWriter struct {
+	w            http.ResponseWriter
+	session      *channelSession
+	writeTimeout time.Duration
+	started      bool
+}
+
+// NewRecordWriter Create the record writer of the response of the request, the plaintext request must be over TLS
+func NewRecordWriter(w http.ResponseWriter, r *http.Request, writeTimeout time.Duration) (*RecordWriter, error) {
+	session, _ := r.Context().Value(sessionKey{}).(*channelSession)
+	if session == nil && r.TLS == nil {
+		return nil, errors.New("the streaming response must be encrypted or over TLS")
+	}
+	return &RecordWriter{w: w, session: session, writeTimeout: writeTimeout}, nil
+}
+
+// Started Return true if the first record is written, the later errors can't change the response status
+func (rw *RecordWriter) Started() bool {
+	return rw.started
+}
+
+// Write Marshal the record and write it to the client as a line, the line is flushed at once
+func (rw *RecordWriter) Write(record interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if rw.session != nil {
+		cipherText, err := cipher.MessageEncryptVersion(rw.session.version, rw.session.clientKey, &data)
+		if err != nil {
+			return err
+		}
+		data, err = json.Marshal(map[string]string{"data": hex.EncodeToString(*cipherText)})
+		if err != nil {
+			return err
+		}
+	}
+
+	if !rw.started {
+		if cw, ok := rw.w.(*channelResponseWriter); ok {
+			rw.w = cw.stream()
+		}
+		rw.w.Header().Set("Content-Type", "application/x-ndjson")
+		rw.w.WriteHeader(200)
+		rw.started = true
+	}
+	if rw.writeTimeout > 0 {
+		err = http.NewResponseController(rw.w).SetWriteDeadline(time.Now().Add(rw.writeTimeout))
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+	}
+	_, err = rw.w.Write(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	if flusher, ok := rw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
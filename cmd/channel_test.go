@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/jayt106/bitcoinAddressGenerator/cipher"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// requestSecureChannel Send the payload encrypted by the server channel key to the handler wrapped by the SecureChannel
+// middleware and return the decrypted response
+func requestSecureChannel(t *testing.T, handler http.Handler, payload interface{}) (map[string]string, int) {
+	return requestSecureChannelPath(t, handler, "/", payload)
+}
+
+// requestSecureChannelPath Send the encrypted payload to the url path
+func requestSecureChannelPath(t *testing.T, handler http.Handler, path string, payload interface{}) (map[string]string, int) {
+	rr, channelPrivKeyClient := serveSecureChannel(t, handler, path, payload)
+	if rr.Code != 200 {
+		return nil, rr.Code
+	}
+
+	body, err := ioutil.ReadAll(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, err := cipher.MessageDecryptVersion(cipher.EnvelopeV2, channelPrivKeyClient, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rsp map[string]string
+	err = json.Unmarshal(*plaintext, &rsp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rsp, rr.Code
+}
+
+// serveSecureChannel Send the encrypted payload to the url path and return the response recorder and the client channel
+// key decrypting the response
+func serveSecureChannel(t *testing.T, handler http.Handler, path string, payload interface{}) (*httptest.ResponseRecorder, *btcec.PrivateKey) {
+	marshalledData, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	channelPrivKeyClient, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slice := append(channelPrivKeyClient.PubKey().SerializeCompressed(), marshalledData...)
+	ciphertext, err := cipher.MessageEncryptVersion(cipher.EnvelopeV2, privKey.PubKey(), &slice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make(map[string]string)
+	data["keyId"] = keyID
+	data["version"] = strconv.Itoa(cipher.EnvelopeV2)
+	data["data"] = hex.EncodeToString(*ciphertext)
+	bytesData, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", path, bytes.NewReader(bytesData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "192.0.2.1:1234"
+
+	channel := &SecureChannel{keyRing, cipher.EnvelopeVersions(false), replayGuard}
+	rr := httptest.NewRecorder()
+	channel.Handler(handler, true).ServeHTTP(rr, req)
+	return rr, channelPrivKeyClient
+}
+
+func TestHTTPServerEncryptedGenMultiSigP2SHAddress(t *testing.T) {
+	replayParam, err := NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	param := MULTISIGPARAM{
+		N:           "2",
+		M:           "3",
+		PUBLICKEYS:  "04a882d414e478039cd5b52a92ffb13dd5e6bd4515497439dffd691a0f12af9575fa349b5694ed3155b136f09e63975a1700c9f4d4df849323dac06cf3bd6458cd,046ce31db9bdd543e72fe3039a1f1c047dab87037c36a669ff90e28da1848f640de68c2fe913d363a51154a0c62d7adea1b822d05035077418267b1a1379790187,0411ffd36c70776538d079fbae117dc38effafb33304af83ce4894589747aee1ef992f63280567f52f5ba870678b4ab4ff6c8ea600bd217870a8b4f1f09f3a8e83",
+		REPLAYPARAM: *replayParam,
+	}
+
+	rsp, code := requestSecureChannel(t, &MultiSigHandler{net: &chaincfg.MainNetParams}, param)
+	if code != 200 {
+		t.Fatal("Unexpected status:", code)
+	}
+
+	if rsp["ps2hAddress"] != "347N1Thc213QqfYCz3PZkjoJpNv5b14kBd" {
+		t.Error("Generated P2SH address different from expected address.", rsp["ps2hAddress"])
+	}
+
+	// The same encrypted request can't be replayed
+	_, code = requestSecureChannel(t, &MultiSigHandler{net: &chaincfg.MainNetParams}, param)
+	if code != http.StatusConflict {
+		t.Error("The replayed request should be rejected, status:", code)
+	}
+}
+
+func TestSecureChannelEnvelopeVersions(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	// The request offering several envelope versions is rejected with the reason
+	bytesData, err := json.Marshal(map[string]string{"keyId": keyID, "version": "1,2", "data": "00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", "/", bytes.NewReader(bytesData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel := &SecureChannel{keyRing, cipher.EnvelopeVersions(false), replayGuard}
+	rr := httptest.NewRecorder()
+	channel.Handler(&MultiSigHandler{net: &chaincfg.MainNetParams}, true).ServeHTTP(rr, req)
+	if rr.Code != 500 || !strings.Contains(logs.String(), "the request must carry exactly one envelope version") {
+		t.Error("Unexpected envelope versions error:", rr.Code, logs.String())
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/jayt106/bitcoinAddressGenerator/psbt"
+	"github.com/jayt106/bitcoinAddressGenerator/txdecode"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+// DecodeTransactionHandler the handler uses for passing this struct into the ServerHTTP function
+type DecodeTransactionHandler struct {
+	net *chaincfg.Params
+}
+
+// ServeHTTP handle the V1/decodeTransaction API request to decode the raw transaction (hex) on the server instead of
+// the third-party websites. Return the inputs, the outputs with the addresses of the configured network, the weight
+// and the vsize, the fee if the previous transactions are given, and the inputs and outputs of the xpub or the redeem
+// scripts. The request is plaintext json or encrypted by the SecureChannel middleware.
+func (dh *DecodeTransactionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handle API /v1/decodeTransaction")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Read body error:")
+		return
+	}
+
+	var decodeParam DECODETXPARAM
+	err = json.Unmarshal(body, &decodeParam)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json unmarshal error:")
+		return
+	}
+
+	tx, err := decodeRawTx(decodeParam.TX)
+	if err != nil {
+		ServerErrorHandle(w, err, "Decode transaction error:")
+		return
+	}
+
+	prevTxs := make([]*wire.MsgTx, len(decodeParam.PREVTXS))
+	for i, prevTx := range decodeParam.PREVTXS {
+		prevTxs[i], err = decodeRawTx(prevTx)
+		if err != nil {
+			ServerErrorHandle(w, fmt.Errorf("previous transaction %d: %v", i, err), "Decode transaction error:")
+			return
+		}
+	}
+
+	matcher, err := decodeMatcher(&decodeParam.DECODEMATCHPARAM, dh.net)
+	if err != nil {
+		ServerErrorHandle(w, err, "Decode match error:")
+		return
+	}
+
+	writeDecoded(w, txdecode.DecodeTx(tx, prevTxs, dh.net, matcher))
+}
+
+// DecodePSBTHandler the handler uses for passing this struct into the ServerHTTP function
+type DecodePSBTHandler struct {
+	net *chaincfg.Params
+}
+
+// ServeHTTP handle the V1/decodePSBT API request to decode the base64 psbt. Return the inputs with the values and the
+// signing state, the outputs with the addresses of the configured network, the weight (estimated for the inputs not
+// finalized yet), the fee, and the inputs and outputs of the xpub or the redeem scripts. The request is plaintext json
+// or encrypted by the SecureChannel middleware.
+func (dh *DecodePSBTHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handle API /v1/decodePSBT")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Read body error:")
+		return
+	}
+
+	var decodeParam DECODEPSBTPARAM
+	err = json.Unmarshal(body, &decodeParam)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json unmarshal error:")
+		return
+	}
+
+	packet, err := psbt.NewFromBase64(decodeParam.PSBT)
+	if err != nil {
+		ServerErrorHandle(w, err, "Parse PSBT failed:")
+		return
+	}
+
+	matcher, err := decodeMatcher(&decodeParam.DECODEMATCHPARAM, dh.net)
+	if err != nil {
+		ServerErrorHandle(w, err, "Decode match error:")
+		return
+	}
+
+	writeDecoded(w, txdecode.DecodePSBT(packet, dh.net, matcher))
+}
+
+// decodeRawTx Deserialize the hex transaction
+func decodeRawTx(s string) (*wire.MsgTx, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	tx := wire.NewMsgTx(wire.TxVersion)
+	err = tx.Deserialize(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// decodeMatcher Return the matcher of the xpub and the redeem scripts of the request, nil if there is none
+func decodeMatcher(p *DECODEMATCHPARAM, net *chaincfg.Params) (*txdecode.Matcher, error) {
+	if p.XPUB == "" && len(p.REDEEMSCRIPTS) == 0 {
+		return nil, nil
+	}
+
+	matcher := txdecode.NewMatcher()
+	if p.XPUB != "" {
+		lookahead := p.LOOKAHEAD
+		if lookahead == 0 {
+			lookahead = txdecode.DefaultLookahead
+		}
+		err := matcher.AddXPub(p.XPUB, lookahead, net)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, s := range p.REDEEMSCRIPTS {
+		script, err := hex.DecodeString(s)
+		if err != nil || len(script) == 0 {
+			return nil, fmt.Errorf("invalid redeem script %d", i)
+		}
+		matcher.AddRedeemScript(script, fmt.Sprintf("redeem script %d", i))
+	}
+	return matcher, nil
+}
+
+// writeDecoded Write the decoded transaction or psbt as the json response
+func writeDecoded(w http.ResponseWriter, decoded interface{}) {
+	marshalledData, err := json.Marshal(decoded)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json Marshal error:")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, err = w.Write(marshalledData)
+	if err != nil {
+		log.Println("ServeHTTP write error:", err)
+	}
+}
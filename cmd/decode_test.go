@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"github.com/jayt106/bitcoinAddressGenerator/psbt"
+	"github.com/jayt106/bitcoinAddressGenerator/txdecode"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHTTPServerDecode(t *testing.T) {
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filePath = workingDir + "/../test/test.json"
+	keyParam, err := ReadSeedFromJsonFile(&filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The account xpub m/0' of the test seed
+	seed, err := hex.DecodeString(keyParam.SEED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	masterKey, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accountKey, err := masterKey.Derive(hdkeychain.HardenedKeyStart + keyParam.PATH.ACCOUNT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xpub, err := accountKey.Neuter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The transaction spends the address of m/0'/0/0 to the multisig P2SH address and the P2WPKH address
+	pkScript, err := message.AddressScript("bc1q8c87x4v0m3dfrxksv724rtwpxy5ghpw8gwf8da", &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	multiSigScript, err := message.AddressScript("347N1Thc213QqfYCz3PZkjoJpNv5b14kBd", &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevTx := wire.NewMsgTx(wire.TxVersion)
+	prevTx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{1}}})
+	prevTx.AddTxOut(wire.NewTxOut(50000, pkScript))
+
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: prevTx.TxHash()}, Sequence: psbt.DefaultSequence})
+	tx.AddTxOut(wire.NewTxOut(30000, multiSigScript))
+	tx.AddTxOut(wire.NewTxOut(19000, pkScript))
+
+	var buf bytes.Buffer
+	if err = tx.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var prevBuf bytes.Buffer
+	if err = prevTx.Serialize(&prevBuf); err != nil {
+		t.Fatal(err)
+	}
+
+	matchParam := DECODEMATCHPARAM{XPUB: xpub.String(), REDEEMSCRIPTS: []string{testRedeemScriptHex}}
+	decodeParam := DECODETXPARAM{TX: hex.EncodeToString(buf.Bytes()), PREVTXS: []string{hex.EncodeToString(prevBuf.Bytes())}, DECODEMATCHPARAM: matchParam}
+	var decoded txdecode.Transaction
+	if code := requestPlaintext(t, &DecodeTransactionHandler{&chaincfg.MainNetParams}, decodeParam, &decoded); code != 200 {
+		t.Fatal("Decode transaction failed, status:", code)
+	}
+	if decoded.TxID != tx.TxHash().String() || decoded.Fee != 1000 || decoded.Inputs[0].Match != "xpub 0/0" {
+		t.Error("Unexpected decoded transaction:", decoded.TxID, decoded.Fee, decoded.Inputs)
+	}
+	if decoded.Outputs[0].Address != "347N1Thc213QqfYCz3PZkjoJpNv5b14kBd" || decoded.Outputs[0].Match != "redeem script 0 p2sh" ||
+		decoded.Outputs[1].Match != "xpub 0/0" {
+		t.Error("Unexpected decoded outputs:", decoded.Outputs)
+	}
+
+	// The addresses of the testnet server
+	decoded = txdecode.Transaction{}
+	if code := requestPlaintext(t, &DecodeTransactionHandler{&chaincfg.TestNet3Params}, DECODETXPARAM{TX: decodeParam.TX}, &decoded); code != 200 {
+		t.Fatal("Decode transaction failed, status:", code)
+	}
+	if !strings.HasPrefix(decoded.Outputs[1].Address, "tb1q") || decoded.Fee != 0 {
+		t.Error("Unexpected testnet transaction:", decoded.Outputs[1].Address, decoded.Fee)
+	}
+
+	// The mainnet xpub isn't accepted by the testnet server
+	if code := requestPlaintext(t, &DecodeTransactionHandler{&chaincfg.TestNet3Params}, decodeParam, &decoded); code != 500 {
+		t.Error("The mainnet xpub should be rejected, status:", code)
+	}
+
+	utxo := &psbt.UTXO{OutPoint: tx.TxIn[0].PreviousOutPoint, Value: 50000, PkScript: pkScript}
+	packet, summary, err := psbt.Create([]*psbt.UTXO{utxo}, []*psbt.TxOutput{{TxOut: tx.TxOut[0]}}, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := packet.B64Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decodedPSBT txdecode.PSBT
+	if code := requestPlaintext(t, &DecodePSBTHandler{&chaincfg.MainNetParams}, DECODEPSBTPARAM{PSBT: encoded, DECODEMATCHPARAM: matchParam}, &decodedPSBT); code != 200 {
+		t.Fatal("Decode PSBT failed, status:", code)
+	}
+	if decodedPSBT.Complete || !decodedPSBT.EstimatedWeight || decodedPSBT.Weight != summary.Weight || decodedPSBT.Fee != summary.Fee ||
+		decodedPSBT.Inputs[0].Match != "xpub 0/0" {
+		t.Error("Unexpected decoded psbt:", decodedPSBT)
+	}
+
+	if code := requestPlaintext(t, &DecodePSBTHandler{&chaincfg.MainNetParams}, DECODEPSBTPARAM{PSBT: "invalid"}, &decodedPSBT); code != 500 {
+		t.Error("The invalid psbt should be rejected, status:", code)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/jayt106/bitcoinAddressGenerator/audit"
+	"github.com/jayt106/bitcoinAddressGenerator/derivation"
+	"github.com/jayt106/bitcoinAddressGenerator/keycache"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"github.com/jayt106/bitcoinAddressGenerator/vault"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+// DerivedKey the derived key of the range, the addresses are keyed by the address type
+type DerivedKey struct {
+	Index     uint32            `json:"index"`
+	Path      string            `json:"path"`
+	PublicKey string            `json:"publicKey"`
+	Addresses map[string]string `json:"addresses"`
+}
+
+// DeriveStreamEnd the last record of the streaming derivation, Next is the index to resume the derivation from
+type DeriveStreamEnd struct {
+	Done  bool   `json:"done"`
+	Count uint32 `json:"count"`
+	Next  uint32 `json:"next"`
+	Error string `json:"error,omitempty"`
+}
+
+// DeriveRangeHandler the handler uses for passing this struct into the ServerHTTP function, the keys are streamed as
+// the newline-delimited json records if stream is true
+type DeriveRangeHandler struct {
+	vault        *vault.Vault
+	auditLog     *audit.Log
+	workers      int
+	maxCount     uint32
+	stream       bool
+	writeTimeout time.Duration
+	net          *chaincfg.Params
+	keyCache     *keycache.Cache
+}
+
+// ServeHTTP handle the V1/deriveRange API request behind the SecureChannel middleware to derive the COUNT addresses of
+// the chain of the seed (or the wallet id of the seed registered in the vault) from the START index, for example to
+// prepare the deposit addresses of a batch job. The indices are derived by the worker pool of the derivation engine in
+// the index order, and the derivation stops when the client disconnects. The derived range is recorded in the audit
+// log as one entry (See auditRange). Return the keys with the paths, the public keys and the addresses of the address
+// types (p2wpkh by default). The V1/deriveRange/stream API streams the keys instead (See serveStream).
+func (dh *DeriveRangeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if dh.stream {
+		log.Println("Handle API /v1/deriveRange/stream")
+	} else {
+		log.Println("Handle API /v1/deriveRange")
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Read body error:")
+		return
+	}
+
+	var deriveParam DERIVERANGEPARAM
+	err = json.Unmarshal(body, &deriveParam)
+	Clear(&body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json unmarshal error:")
+		return
+	}
+	keyParam := BIP32PARAM{SEED: deriveParam.SEED, WALLETID: deriveParam.WALLETID, WALLETTOKEN: deriveParam.WALLETTOKEN, PATH: KEYPATH{ACCOUNT: deriveParam.ACCOUNT, CHAIN: deriveParam.CHAIN}}
+	Clear(&deriveParam.SEED)
+	defer Clear(&keyParam)
+
+	if deriveParam.COUNT == 0 || deriveParam.COUNT > dh.maxCount {
+		ServerErrorHandle(w, fmt.Errorf("the count must be between 1 and %d", dh.maxCount), "Derive range error:")
+		return
+	}
+	addressTypes := deriveParam.ADDRESSTYPES
+	if len(addressTypes) == 0 {
+		addressTypes = []string{message.AddressP2WPKH}
+	}
+
+	err = ResolveSeed(dh.vault, &keyParam)
+	if err != nil {
+		ServerErrorHandle(w, err, "Resolve wallet seed error:")
+		return
+	}
+	walletID, err := WalletFingerprint(&keyParam)
+	if err != nil {
+		ServerErrorHandle(w, err, "Wallet fingerprint error:")
+		return
+	}
+	chainKey, err := GenerateChainPublicKey(&keyParam, dh.keyCache)
+	if err != nil {
+		ServerErrorHandle(w, err, "Generate HD public key failed:")
+		return
+	}
+	chainKey.SetNet(dh.net)
+
+	opts := derivation.Options{Workers: dh.workers, AddressTypes: addressTypes, Net: dh.net}
+	opts.Progress = deriveProgress(keyParam.PATH)
+	if dh.stream {
+		dh.serveStream(w, r, walletID, chainKey, keyParam.PATH, deriveParam.START, deriveParam.COUNT, opts)
+		return
+	}
+	keys := make([]DerivedKey, 0, deriveParam.COUNT)
+	err = derivation.Stream(r.Context(), chainKey, deriveParam.START, deriveParam.COUNT, opts, func(key *derivation.Key) error {
+		keys = append(keys, newDerivedKey(keyParam.PATH, key, addressTypes))
+		return nil
+	})
+	if err != nil {
+		ServerErrorHandle(w, err, "Derive range error:")
+		return
+	}
+	err = dh.auditRange(r, walletID, chainKey, keyParam.PATH, deriveParam.START, deriveParam.COUNT)
+	if err != nil {
+		ServerErrorHandle(w, err, "Audit log error:")
+		return
+	}
+
+	marshalledData, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		ServerErrorHandle(w, err, "Json Marshal error:")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, err = w.Write(marshalledData)
+	if err != nil {
+		log.Println("ServeHTTP write error:", err)
+	}
+}
+
+// serveStream Write each derived key as a record of the newline-delimited json response as soon as it's derived, the
+// records are encrypted one by one by the secure channel (the plaintext request must be over TLS). The last record is
+// DeriveStreamEnd with the number of the keys and the next index, the client resumes the interrupted derivation from
+// the index after its last received key. The error before the first record is returned as the 500 response, the later
+// error is the last record. The range of the written records is recorded in the audit log before the last record, also
+// when the stream is interrupted.
+func (dh *DeriveRangeHandler) serveStream(w http.ResponseWriter, r *http.Request, walletID string, chainKey *hdkeychain.ExtendedKey,
+	chainPath KEYPATH, start uint32, count uint32, opts derivation.Options) {
+	rw, err := NewRecordWriter(w, r, dh.writeTimeout)
+	if err != nil {
+		ServerErrorHandle(w, err, "Derive range stream error:")
+		return
+	}
+
+	end := DeriveStreamEnd{Next: start}
+	err = derivation.Stream(r.Context(), chainKey, start, count, opts, func(key *derivation.Key) error {
+		err := rw.Write(newDerivedKey(chainPath, key, opts.AddressTypes))
+		if err != nil {
+			return err
+		}
+		end.Count++
+		end.Next = key.Index + 1
+		return nil
+	})
+	if err != nil && !rw.Started() {
+		ServerErrorHandle(w, err, "Derive range stream error:")
+		return
+	}
+	if err != nil {
+		log.Println("Derive range stream error:", err)
+		end.Error = err.Error()
+	} else {
+		end.Done = true
+		end.Next = start + count
+	}
+	if end.Count > 0 {
+		err = dh.auditRange(r, walletID, chainKey, chainPath, start, end.Count)
+		if err != nil {
+			log.Println("Audit log error:", err)
+			end.Done = false
+			end.Error = "audit log error: " + err.Error()
+		}
+	}
+	err = rw.Write(end)
+	if err != nil {
+		log.Println("ServeHTTP write error:", err)
+	}
+}
+
+// auditRange Record the derived range of the chain in the audit log as one entry, the path is the range of the indices
+// m/account'/chain/first-last and the public key is the chain xpub, so the addresses of the range can be derived again
+func (dh *DeriveRangeHandler) auditRange(r *http.Request, walletID string, chainKey *hdkeychain.ExtendedKey, chainPath KEYPATH,
+	start uint32, count uint32) error {
+	endpoint := "/v1/deriveRange"
+	if dh.stream {
+		endpoint = "/v1/deriveRange/stream"
+	}
+	return dh.auditLog.Append(audit.Entry{
+		Endpoint:  endpoint,
+		WalletID:  walletID,
+		Path:      fmt.Sprintf("m/%d'/%d/%d-%d", chainPath.ACCOUNT, chainPath.CHAIN, start, start+count-1),
+		PublicKey: chainKey.String(),
+		Requester: Requester(r),
+	})
+}
+
+// newDerivedKey Return the derived key of the chain path
+func newDerivedKey(chainPath KEYPATH, key *derivation.Key, addressTypes []string) DerivedKey {
+	path := chainPath
+	path.ADDRESS = key.Index
+	derived := DerivedKey{
+		Index:     key.Index,
+		Path:      path.String(),
+		PublicKey: hex.EncodeToString(key.PubKey.SerializeCompressed()),
+		Addresses: make(map[string]string, len(addressTypes)),
+	}
+	for i, addressType := range addressTypes {
+		derived.Addresses[addressType] = key.Addresses[i]
+	}
+	return derived
+}
+
+// deriveProgress Return the progress report logging every 10% of the derivation of the chain
+func deriveProgress(chainPath KEYPATH) func(done uint32, total uint32) {
+	logged := uint64(0)
+	return func(done uint32, total uint32) {
+		if step := uint64(done) * 10 / uint64(total); step > logged {
+			logged = step
+			log.Printf("Derive m/%d'/%d: %d of %d keys", chainPath.ACCOUNT, chainPath.CHAIN, done, total)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/jayt106/bitcoinAddressGenerator/audit"
+	"github.com/jayt106/bitcoinAddressGenerator/cipher"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPServerDeriveRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	auditLog, err := audit.Open(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filePath = workingDir + "/../test/test.json"
+	keyParam, err := ReadSeedFromJsonFile(&filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	param := DERIVERANGEPARAM{SEED: keyParam.SEED, CHAIN: 1, START: 3, COUNT: 50, ADDRESSTYPES: []string{message.AddressP2WPKH, message.AddressP2TR}}
+	var rsp struct {
+		Keys []DerivedKey
+	}
+	if code := requestPlaintext(t, &DeriveRangeHandler{nil, auditLog, 2, 100, false, 0, &chaincfg.MainNetParams, nil}, param, &rsp); code != 200 {
+		t.Fatal("Derive range failed, status:", code)
+	}
+	if len(rsp.Keys) != 50 {
+		t.Fatal("Unexpected keys:", len(rsp.Keys))
+	}
+
+	// The keys are the same as the keys of the serial path
+	for i, key := range rsp.Keys {
+		keyParam.PATH = KEYPATH{ACCOUNT: 0, CHAIN: 1, ADDRESS: uint32(i) + 3}
+		hdPubKey, err := GenerateHDPublicKey(keyParam, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubKey, err := hdPubKey.ECPubKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		address, err := message.Address(pubKey, message.AddressP2TR, &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.Index != keyParam.PATH.ADDRESS || key.Path != keyParam.PATH.String() ||
+			key.PublicKey != hex.EncodeToString(pubKey.SerializeCompressed()) || key.Addresses[message.AddressP2TR] != address {
+			t.Fatal("Unexpected derived key:", i, key)
+		}
+	}
+
+	// The address of m/0'/0/0 by default
+	param = DERIVERANGEPARAM{SEED: keyParam.SEED, COUNT: 1}
+	if code := requestPlaintext(t, &DeriveRangeHandler{nil, auditLog, 2, 100, false, 0, &chaincfg.MainNetParams, nil}, param, &rsp); code != 200 {
+		t.Fatal("Derive range failed, status:", code)
+	}
+	if len(rsp.Keys) != 1 || rsp.Keys[0].Addresses[message.AddressP2WPKH] != "bc1q8c87x4v0m3dfrxksv724rtwpxy5ghpw8gwf8da" {
+		t.Error("Unexpected derived keys:", rsp.Keys)
+	}
+
+	for _, count := range []uint32{0, 101} {
+		param.COUNT = count
+		if code := requestPlaintext(t, &DeriveRangeHandler{nil, auditLog, 2, 100, false, 0, &chaincfg.MainNetParams, nil}, param, &rsp); code != 500 {
+			t.Error("The count out of range should be rejected:", count, code)
+		}
+	}
+
+	// Each derived range is one audit entry with the chain xpub
+	walletID, err := WalletFingerprint(keyParam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := audit.Verify(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatal("Unexpected audit log entries:", len(entries))
+	}
+	if entries[0].Endpoint != "/v1/deriveRange" || entries[0].WalletID != walletID || entries[0].Path != "m/0'/1/3-52" ||
+		!strings.HasPrefix(entries[0].PublicKey, "xpub") || entries[1].Path != "m/0'/0/0-0" {
+		t.Error("Unexpected audit log entries:", entries)
+	}
+}
+
+// deriveStreamRecord the record of the V1/deriveRange/stream API, a derived key or the last record
+type deriveStreamRecord struct {
+	DerivedKey
+	DeriveStreamEnd
+}
+
+// readStreamRecords Return the newline-delimited json records of the body, decrypted by the client channel key if given
+func readStreamRecords(t *testing.T, body []byte, decrypt func(data []byte) []byte) []deriveStreamRecord {
+	var records []deriveStreamRecord
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if decrypt != nil {
+			line = decrypt(line)
+		}
+		var record deriveStreamRecord
+		err := json.Unmarshal(line, &record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// failingWriter the response writer of the client disconnecting after the given number of writes
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	writes int
+}
+
+func (fw *failingWriter) Write(b []byte) (int, error) {
+	if fw.writes == 0 {
+		return 0, errors.New("connection reset")
+	}
+	fw.writes--
+	return fw.ResponseRecorder.Write(b)
+}
+
+func TestHTTPServerDeriveRangeStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	auditLog, err := audit.Open(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filePath = workingDir + "/../test/test.json"
+	keyParam, err := ReadSeedFromJsonFile(&filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &DeriveRangeHandler{nil, auditLog, 2, 100, true, time.Minute, &chaincfg.MainNetParams, nil}
+
+	// The records of the encrypted request are encrypted one by one
+	replayParam, err := NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	param := DERIVERANGEPARAM{SEED: keyParam.SEED, COUNT: 30, REPLAYPARAM: *replayParam}
+	rr, channelPrivKeyClient := serveSecureChannel(t, handler, "/v1/deriveRange/stream", param)
+	if rr.Code != 200 || rr.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatal("Derive range stream failed, status:", rr.Code, rr.Body.String())
+	}
+	records := readStreamRecords(t, rr.Body.Bytes(), func(line []byte) []byte {
+		var data map[string]string
+		err := json.Unmarshal(line, &data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cipherBytes, err := hex.DecodeString(data["data"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		plaintext, err := cipher.MessageDecryptVersion(cipher.EnvelopeV2, channelPrivKeyClient, &cipherBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *plaintext
+	})
+	if len(records) != 31 || records[0].Addresses[message.AddressP2WPKH] != "bc1q8c87x4v0m3dfrxksv724rtwpxy5ghpw8gwf8da" {
+		t.Fatal("Unexpected records:", len(records))
+	}
+	for i, record := range records[:30] {
+		if record.Index != uint32(i) || record.Done {
+			t.Error("The keys aren't in the index order:", i, record.Index)
+		}
+	}
+	if end := records[30].DeriveStreamEnd; !end.Done || end.Count != 30 || end.Next != 30 || end.Error != "" {
+		t.Error("Unexpected last record:", end)
+	}
+
+	// The derivation is resumed from the next index over TLS
+	param = DERIVERANGEPARAM{SEED: keyParam.SEED, START: 20, COUNT: 10}
+	bytesData, err := json.Marshal(param)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", "/v1/deriveRange/stream", bytes.NewReader(bytesData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.TLS = &tls.ConnectionState{}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		t.Fatal("Derive range stream failed, status:", rr.Code, rr.Body.String())
+	}
+	resumed := readStreamRecords(t, rr.Body.Bytes(), nil)
+	if len(resumed) != 11 || resumed[0].Index != 20 || resumed[0].PublicKey != records[20].PublicKey || !resumed[10].Done || resumed[10].Next != 30 {
+		t.Error("Unexpected resumed records:", resumed)
+	}
+
+	// The plaintext records aren't streamed without TLS
+	var rsp map[string]string
+	if code := requestPlaintext(t, handler, param, &rsp); code != 500 {
+		t.Error("The plaintext stream should be rejected:", code)
+	}
+	// The error before the first record is the 500 response
+	replayParam, err = NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	param = DERIVERANGEPARAM{SEED: keyParam.SEED, START: 0x7fffffff, COUNT: 2, REPLAYPARAM: *replayParam}
+	if rr, _ = serveSecureChannel(t, handler, "/v1/deriveRange/stream", param); rr.Code != 500 {
+		t.Error("The hardened indices should be rejected:", rr.Code)
+	}
+
+	// The interrupted stream records the range of the written keys only
+	param = DERIVERANGEPARAM{SEED: keyParam.SEED, START: 40, COUNT: 50}
+	bytesData, err = json.Marshal(param)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err = http.NewRequest("POST", "/v1/deriveRange/stream", bytes.NewReader(bytesData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.TLS = &tls.ConnectionState{}
+	fw := &failingWriter{httptest.NewRecorder(), 5}
+	handler.ServeHTTP(fw, req)
+	if interrupted := readStreamRecords(t, fw.Body.Bytes(), nil); len(interrupted) != 5 || interrupted[4].Index != 44 {
+		t.Error("Unexpected interrupted records:", interrupted)
+	}
+
+	walletID, err := WalletFingerprint(keyParam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := audit.Verify(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, entry := range entries {
+		if entry.Endpoint != "/v1/deriveRange/stream" || entry.WalletID != walletID {
+			t.Error("Unexpected audit log entry:", entry)
+		}
+		paths = append(paths, entry.Path)
+	}
+	if strings.Join(paths, ",") != "m/0'/0/0-29,m/0'/0/20-29,m/0'/0/40-44" {
+		t.Error("Unexpected audited ranges:", paths)
+	}
+}
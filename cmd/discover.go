@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/jayt106/bitcoinAddressGenerator/audit"
+	"github.com/jayt106/bitcoinAddressGenerator/bitcoind"
+	"github.com/jayt106/bitcoinAddressGenerator/discovery"
+	"github.com/jayt106/bitcoinAddressGenerator/utxoscan"
+	"github.com/jayt106/bitcoinAddressGenerator/vault"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+// The history oracles of the account discovery
+const (
+	OracleAddresses = "addresses"
+	OracleSnapshot  = "snapshot"
+	OracleRPC       = "rpc"
+)
+
+// DiscoverAccountsHandler the handler uses for passing this struct into the ServerHTTP function
+type DiscoverAccountsHandler struct {
+	vault    *vault.Vault
+	auditLog *audit.Log
+	node     *bitcoind.Client
+	snapshot utxoscan.Reader
+	net      *chaincfg.Params
+}
+
+// ServeHTTP handle the V1/discoverAccounts API request behind the SecureChannel middleware to recover the accounts of
+// the seed (or the wallet id of the seed registered in the vault). The accounts and chains are walked by the gap limit
+// scanner of the utxoScan tool, the history of the addresses is given by the oracle, the list of the used addresses of
+// the request, the utxo snapshot of the server or the utxo set of the bitcoind node. Return the used accounts with their
+// xpubs and the last used index of the chains, each returned xpub is recorded in the audit log.
+func (dh *DiscoverAccountsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handle API /v1/discoverAccounts")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Read body error:")
+		return
+	}
+
+	var discoverParam DISCOVERPARAM
+	err = json.Unmarshal(body, &discoverParam)
+	Clear(&body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json unmarshal error:")
+		return
+	}
+	keyParam := BIP32PARAM{SEED: discoverParam.SEED, WALLETID: discoverParam.WALLETID, WALLETTOKEN: discoverParam.WALLETTOKEN}
+	Clear(&discoverParam.SEED)
+	defer Clear(&keyParam)
+
+	oracle, err := dh.oracle(&discoverParam)
+	if err != nil {
+		ServerErrorHandle(w, err, "History oracle error:")
+		return
+	}
+
+	err = ResolveSeed(dh.vault, &keyParam)
+	if err != nil {
+		ServerErrorHandle(w, err, "Resolve wallet seed error:")
+		return
+	}
+
+	walletID, err := WalletFingerprint(&keyParam)
+	if err != nil {
+		ServerErrorHandle(w, err, "Wallet fingerprint error:")
+		return
+	}
+
+	gapLimit := discoverParam.GAPLIMIT
+	if gapLimit == 0 {
+		gapLimit = utxoscan.DefaultGapLimit
+	}
+	seed, err := hex.DecodeString(keyParam.SEED)
+	if err != nil {
+		ServerErrorHandle(w, err, "Decode seed error:")
+		return
+	}
+	scanner, err := utxoscan.NewSeedScanner(seed, gapLimit, dh.net)
+	Clear(&seed)
+	if err != nil {
+		ServerErrorHandle(w, err, "Discover accounts error:")
+		return
+	}
+	defer scanner.Zero()
+	if len(discoverParam.ADDRESSTYPES) != 0 {
+		err = scanner.SetAddressTypes(discoverParam.ADDRESSTYPES)
+		if err != nil {
+			ServerErrorHandle(w, err, "Discover accounts error:")
+			return
+		}
+	}
+	report, err := scanner.Scan(oracle)
+	if err != nil {
+		ServerErrorHandle(w, err, "Discover accounts error:")
+		return
+	}
+	accounts := discovery.Accounts(report)
+	for _, account := range accounts {
+		err = dh.auditLog.Append(audit.Entry{
+			Endpoint:  "/v1/discoverAccounts",
+			WalletID:  walletID,
+			Path:      fmt.Sprintf("m/%d'", account.Account),
+			PublicKey: account.XPub,
+			Requester: Requester(r),
+		})
+		if err != nil {
+			ServerErrorHandle(w, err, "Audit log error:")
+			return
+		}
+	}
+
+	marshalledData, err := json.Marshal(map[string]interface{}{"accounts": accounts})
+	if err != nil {
+		ServerErrorHandle(w, err, "Json Marshal error:")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, err = w.Write(marshalledData)
+	if err != nil {
+		log.Println("ServeHTTP write error:", err)
+	}
+}
+
+// oracle Return the history oracle of the request, the used addresses of the request by default
+func (dh *DiscoverAccountsHandler) oracle(p *DISCOVERPARAM) (utxoscan.Oracle, error) {
+	switch p.ORACLE {
+	case "", OracleAddresses:
+		return discovery.NewAddressSet(p.USEDADDRESSES, dh.net)
+	case OracleSnapshot:
+		if dh.snapshot == nil {
+			return nil, errors.New("the server has no utxo snapshot, please launch it with -utxoSnapshot")
+		}
+		return dh.snapshot, nil
+	case OracleRPC:
+		if dh.node == nil {
+			return nil, errors.New("the server has no bitcoind RPC, please launch it with -rpcURL")
+		}
+		return &discovery.NodeOracle{Node: dh.node}, nil
+	}
+	return nil, fmt.Errorf("unknown history oracle %s", p.ORACLE)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/jayt106/bitcoinAddressGenerator/audit"
+	"github.com/jayt106/bitcoinAddressGenerator/discovery"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHTTPServerDiscoverAccounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	auditLogFile := filepath.Join(dir, "audit.log")
+	auditLog, err := audit.Open(auditLogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filePath = workingDir + "/../test/test.json"
+	keyParam, err := ReadSeedFromJsonFile(&filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The address of m/0'/0/0 is used
+	param := DISCOVERPARAM{SEED: keyParam.SEED, USEDADDRESSES: []string{"bc1q8c87x4v0m3dfrxksv724rtwpxy5ghpw8gwf8da"}, GAPLIMIT: 5}
+	var rsp struct {
+		Accounts []discovery.Account
+	}
+	handler := &DiscoverAccountsHandler{auditLog: auditLog, net: &chaincfg.MainNetParams}
+	if code := requestPlaintext(t, handler, param, &rsp); code != 200 {
+		t.Fatal("Discover accounts failed, status:", code)
+	}
+	accPubKey, err := GenerateAccountPublicKey(&BIP32PARAM{SEED: keyParam.SEED}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.Accounts) != 1 || rsp.Accounts[0].XPub != accPubKey.String() || rsp.Accounts[0].LastUsed != [2]int64{0, -1} {
+		t.Error("Unexpected discovered accounts:", rsp.Accounts)
+	}
+
+	// The returned account xpub is audited
+	walletID, err := WalletFingerprint(keyParam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := audit.Verify(auditLogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Endpoint != "/v1/discoverAccounts" || entries[0].WalletID != walletID ||
+		entries[0].Path != "m/0'" || entries[0].PublicKey != accPubKey.String() {
+		t.Error("Unexpected audit log entries:", entries)
+	}
+
+	// The oracles the server isn't launched with
+	for _, oracle := range []string{OracleSnapshot, OracleRPC, "unknown"} {
+		param.ORACLE = oracle
+		if code := requestPlaintext(t, handler, param, &rsp); code != 500 {
+			t.Error("The unavailable oracle should be rejected:", oracle, code)
+		}
+	}
+}
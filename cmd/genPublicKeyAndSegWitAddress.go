@@ -1,46 +1,58 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/jayt106/bitcoinAddressGenerator/cipher"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 )
 
 func main() {
 
 	l := len(os.Args)
+	if l >= 2 && strings.ToLower(os.Args[1]) == "range" {
+		deriveRange(os.Args[2:])
+		return
+	}
 
 	var ip string
 	var port string
-	var serverPublicKey string
 	var relativePath string
 	if l >= 2 && strings.ToLower(os.Args[1]) == "help" {
 		help()
 		return
-	} else if l == 3 {
-		serverPublicKey = os.Args[1]
-		relativePath = os.Args[2]
+	} else if l == 2 {
+		relativePath = os.Args[1]
 		ip = "localhost"
 		port = "8080"
-	} else if l == 5 {
+	} else if l == 4 {
 		ip = os.Args[1]
 		port = os.Args[2]
-		serverPublicKey = os.Args[3]
-		relativePath = os.Args[4]
+		relativePath = os.Args[3]
 	} else {
 		fmt.Println("Invalid arguments, please check your input")
 		help()
 		return
 	}
 
+	// Fetch and authenticate the server public key before encrypting any seed
+	pubKey, serverKeyID, serverVersions, err := fetchServerPublicKey(ip, port)
+	if err != nil {
+		log.Fatalln(err)
+		return
+	}
+
 	workingDir, err := os.Getwd()
 	if err != nil {
 		log.Fatalln(err)
@@ -54,92 +66,388 @@ func main() {
 		return
 	}
 
-	marshalledData, err := json.Marshal(keyParam)
+	// The timestamp and the nonce prevent the request being replayed
+	replayParam, err := NewReplayParam()
 	if err != nil {
 		log.Fatalln(err)
 		return
 	}
+	keyParam.REPLAYPARAM = *replayParam
 
-	channelPrivKeyClient, err := btcec.NewPrivateKey(btcec.S256())
+	api := "http://" + ip + ":" + port + "/v1/genPublicKeyAndSegWitAddress"
+	req, channelPrivKeyClient, version, err := newEncryptedRequest(api, keyParam, pubKey, serverKeyID, serverVersions)
 	if err != nil {
 		log.Fatalln(err)
 		return
 	}
 
-	var slice []byte
-	slice = append(channelPrivKeyClient.PubKey().SerializeCompressed(), marshalledData...)
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatalln(err)
+		return
+	}
 
-	bs, err := hex.DecodeString(serverPublicKey)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatalln(err)
 		return
 	}
 
-	// Verifying the receiving data is a ecdsa publicKey
-	pubKey, err := btcec.ParsePubKey(bs, btcec.S256())
+	if resp.StatusCode != 200 {
+		log.Fatalln("The server rejected the request:", resp.Status, string(body))
+		return
+	}
+
+	plaintext, err := cipher.MessageDecryptVersion(version, channelPrivKeyClient, &body)
 	if err != nil {
 		log.Fatalln(err)
 		return
 	}
 
-	ciphertext, err := cipher.MessageEncrypt(pubKey, &slice)
+	var rsp map[string]string
+	err = json.Unmarshal(*plaintext, &rsp)
 	if err != nil {
 		log.Fatalln(err)
 		return
 	}
+	publicKey := rsp["publicKey"]
+	segwitAddress := rsp["segwitAddress"]
 
-	data := make(map[string]string)
-	data["data"] = hex.EncodeToString(*ciphertext)
-	bytesData, err := json.Marshal(data)
+	fmt.Println("publicKey:", publicKey)
+	fmt.Println("segwitAddress:", segwitAddress)
+
+	if rsp["keyDeprecated"] == "true" {
+		fmt.Println("WARNING: the server key", serverKeyID, "is deprecated and expires at", rsp["keyExpiresAt"]+", please fetch the new server public key")
+	}
+}
+
+// deriveRange Stream the addresses [0, count) of the chain of the seed file by the V1/deriveRange/stream API to the
+// output file, a record per line. The existing output file of the chain is resumed from the index after its last key.
+func deriveRange(args []string) {
+	var ip = "localhost"
+	var port = "8080"
+	if len(args) == 7 {
+		ip = args[0]
+		port = args[1]
+		args = args[2:]
+	} else if len(args) != 5 {
+		fmt.Println("Invalid arguments, please check your input")
+		help()
+		return
+	}
+	var values []uint32
+	for _, arg := range args[1:4] {
+		value, err := strconv.ParseUint(arg, 10, 32)
+		if err != nil {
+			log.Fatalln("Invalid account, chain or count:", arg)
+			return
+		}
+		values = append(values, uint32(value))
+	}
+	account, chain, count := values[0], values[1], values[2]
+
+	pubKey, serverKeyID, serverVersions, err := fetchServerPublicKey(ip, port)
 	if err != nil {
 		log.Fatalln(err)
 		return
 	}
 
-	api := "http://" + ip + ":" + port + "/v1/genPublicKeyAndSegWitAddress"
+	workingDir, err := os.Getwd()
+	if err != nil {
+		log.Fatalln(err)
+		return
+	}
 
-	req, err := http.NewRequest("POST", api, bytes.NewReader(bytesData))
+	var filePath = workingDir + "/" + args[0]
+	keyParam, err := ReadSeedFromJsonFile(&filePath)
 	if err != nil {
 		log.Fatalln(err)
 		return
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	file, err := os.OpenFile(args[4], os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
 		log.Fatalln(err)
 		return
 	}
+	defer file.Close()
 
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	start, err := resumeIndex(file, account, chain)
 	if err != nil {
 		log.Fatalln(err)
 		return
 	}
+	if start >= count {
+		fmt.Println("The", count, "addresses are already in", args[4])
+		return
+	}
+	if start != 0 {
+		fmt.Println("Resume the derivation from the index", start)
+	}
 
-	plaintext, err := cipher.MessageDecrypt(channelPrivKeyClient, &body)
+	replayParam, err := NewReplayParam()
 	if err != nil {
 		log.Fatalln(err)
 		return
 	}
+	param := DERIVERANGEPARAM{SEED: keyParam.SEED, ACCOUNT: account, CHAIN: chain, START: start, COUNT: count - start, REPLAYPARAM: *replayParam}
+	Clear(keyParam)
 
-	var rsp map[string]string
-	err = json.Unmarshal(*plaintext, &rsp)
+	api := "http://" + ip + ":" + port + "/v1/deriveRange/stream"
+	req, channelPrivKeyClient, version, err := newEncryptedRequest(api, param, pubKey, serverKeyID, serverVersions)
+	Clear(&param)
 	if err != nil {
 		log.Fatalln(err)
 		return
 	}
-	publicKey := rsp["publicKey"]
-	segwitAddress := rsp["segwitAddress"]
 
-	fmt.Println("publicKey:", publicKey)
-	fmt.Println("segwitAddress:", segwitAddress)
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatalln(err)
+		return
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		log.Fatalln("The server rejected the request:", resp.Status, string(body))
+		return
+	}
+
+	// Each line is written to the output file as soon as it's received and decrypted
+	written := uint32(0)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var line map[string]string
+		err = json.Unmarshal(scanner.Bytes(), &line)
+		if err != nil {
+			log.Fatalln(err)
+			return
+		}
+		cipherBytes, err := hex.DecodeString(line["data"])
+		if err != nil {
+			log.Fatalln(err)
+			return
+		}
+		plaintext, err := cipher.MessageDecryptVersion(version, channelPrivKeyClient, &cipherBytes)
+		if err != nil {
+			log.Fatalln(err)
+			return
+		}
+
+		var record streamRecord
+		err = json.Unmarshal(*plaintext, &record)
+		if err != nil {
+			log.Fatalln(err)
+			return
+		}
+		if record.Index == nil {
+			if record.Error != "" {
+				log.Fatalln("The derivation stopped at the index", record.Next, "by the server error:", record.Error+", please run again to resume")
+				return
+			}
+			fmt.Println(written, "addresses are written to", args[4]+", the next index is", record.Next)
+			return
+		}
+
+		_, err = file.Write(append(*plaintext, '\n'))
+		if err != nil {
+			log.Fatalln(err)
+			return
+		}
+		written++
+		if written%10000 == 0 {
+			fmt.Println(written, "addresses are written, the last index is", *record.Index)
+		}
+	}
+	log.Fatalln("The stream is interrupted after", written, "addresses:", scanner.Err(), "please run again to resume")
+}
+
+// streamRecord the record of the V1/deriveRange/stream API, a derived key with the index or the last record
+type streamRecord struct {
+	Index *uint32 `json:"index"`
+	Path  string  `json:"path"`
+	Done  bool    `json:"done"`
+	Next  uint32  `json:"next"`
+	Error string  `json:"error"`
+}
+
+// resumeIndex Return the index after the last key of the output file, the file must be the keys of the chain of the
+// account. The incomplete last line written by the interrupted derivation is truncated.
+func resumeIndex(file *os.File, account uint32, chain uint32) (uint32, error) {
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return 0, err
+	}
+	end := bytes.LastIndexByte(data, '\n') + 1
+	if end != len(data) {
+		err = file.Truncate(int64(end))
+		if err != nil {
+			return 0, err
+		}
+	}
+	_, err = file.Seek(int64(end), io.SeekStart)
+	if err != nil || end == 0 {
+		return 0, err
+	}
+
+	last := data[bytes.LastIndexByte(data[:end-1], '\n')+1 : end-1]
+	var record streamRecord
+	err = json.Unmarshal(last, &record)
+	if err != nil {
+		return 0, err
+	}
+	if record.Index == nil || record.Path != (KEYPATH{ACCOUNT: account, CHAIN: chain, ADDRESS: *record.Index}).String() {
+		return 0, fmt.Errorf("the output file isn't the addresses of the chain m/%d'/%d", account, chain)
+	}
+	return *record.Index + 1, nil
+}
+
+// newEncryptedRequest Create the request of the api encrypted by the server channel key in the highest envelope version
+// both sides support, the data is the public key of the returned client channel key followed by the json payload. The
+// response is encrypted by the client channel key in the returned version.
+func newEncryptedRequest(api string, payload interface{}, pubKey *btcec.PublicKey, serverKeyID string, serverVersions []int) (*http.Request, *btcec.PrivateKey, int, error) {
+	marshalledData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	channelPrivKeyClient, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	var slice []byte
+	slice = append(channelPrivKeyClient.PubKey().SerializeCompressed(), marshalledData...)
+	Clear(&marshalledData)
+
+	// Encrypt by the highest envelope version both sides support
+	version, err := cipher.NegotiateEnvelopeVersion(serverVersions)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	ciphertext, err := cipher.MessageEncryptVersion(version, pubKey, &slice)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	data := make(map[string]string)
+	data["keyId"] = serverKeyID
+	data["version"] = strconv.Itoa(version)
+	data["data"] = hex.EncodeToString(*ciphertext)
+	bytesData, err := json.Marshal(data)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	req, err := http.NewRequest("POST", api, bytes.NewReader(bytesData))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, channelPrivKeyClient, version, nil
+}
+
+// fetchServerPublicKey Fetch the server channel key by the V1/serverPublicKeys API and verify it is signed by the server
+// identity key. The identity is checked against the SERVER_IDENTITY fingerprint if it is given, otherwise it is pinned in
+// the known hosts file at the first connection (trust on first use) and checked at the later connections.
+func fetchServerPublicKey(ip string, port string) (*btcec.PublicKey, string, []int, error) {
+	resp, err := http.Get("http://" + ip + ":" + port + "/v1/serverPublicKeys")
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var rsp map[string]string
+	err = json.Unmarshal(body, &rsp)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	identityBytes, err := hex.DecodeString(rsp["identityKey"])
+	if err != nil {
+		return nil, "", nil, err
+	}
+	identityPubKey, err := btcec.ParsePubKey(identityBytes, btcec.S256())
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	fingerprint := cipher.Fingerprint(identityPubKey)
+	expected := os.Getenv("SERVER_IDENTITY")
+	if expected != "" {
+		if expected != fingerprint {
+			return nil, "", nil, fmt.Errorf("the server identity %s doesn't match the expected identity %s", fingerprint, expected)
+		}
+	} else {
+		knownHosts := os.Getenv("KNOWN_HOSTS")
+		if knownHosts == "" {
+			homeDir, err := os.UserHomeDir()
+			if err != nil {
+				return nil, "", nil, err
+			}
+			knownHosts = filepath.Join(homeDir, ".bitcoinAddressGenerator", "known_hosts")
+		}
+
+		pinned, err := cipher.CheckKnownHost(knownHosts, ip+":"+port, identityPubKey)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if pinned {
+			fmt.Println("WARNING: the server identity", fingerprint, "is added to", knownHosts+", please verify it with the server operator")
+		}
+	}
+
+	announcement := &cipher.ChannelKeyAnnouncement{
+		PublicKey:        rsp["publicKey"],
+		KeyID:            rsp["keyId"],
+		ExpiresAt:        rsp["expiresAt"],
+		EnvelopeVersions: rsp["envelopeVersions"],
+	}
+	err = cipher.VerifyChannelKey(identityPubKey, announcement, rsp["signature"])
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	bs, err := hex.DecodeString(rsp["publicKey"])
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	// Verifying the receiving data is a ecdsa publicKey
+	pubKey, err := btcec.ParsePubKey(bs, btcec.S256())
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	versions, err := cipher.ParseEnvelopeVersions(rsp["envelopeVersions"])
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return pubKey, rsp["keyId"], versions, nil
 }
 
 func help() {
-	fmt.Println("usage: ./genPublicKeyAndSegWitAddress [ip] [port] [server public key] [seed file path]")
+	fmt.Println("usage: ./genPublicKeyAndSegWitAddress [ip] [port] [seed file path]")
 	fmt.Println()
 	fmt.Println("For connecting with the default server: localhost:8080")
-	fmt.Println("usage: ./genPublicKeyAndSegWitAddress [server public key] [seed file path]")
-}
\ No newline at end of file
+	fmt.Println("usage: ./genPublicKeyAndSegWitAddress [seed file path]")
+	fmt.Println()
+	fmt.Println("For streaming the P2WPKH addresses [0, count) of the chain m/account'/chain to the output file, one json record per line.")
+	fmt.Println("The existing output file is resumed from the index after its last address:")
+	fmt.Println("usage: ./genPublicKeyAndSegWitAddress range [ip] [port] [seed file path] [account] [chain] [count] [output file]")
+	fmt.Println("usage: ./genPublicKeyAndSegWitAddress range [seed file path] [account] [chain] [count] [output file]")
+	fmt.Println()
+	fmt.Println("The server identity is pinned in ~/.bitcoinAddressGenerator/known_hosts at the first connection (set KNOWN_HOSTS to change the file),")
+	fmt.Println("or set SERVER_IDENTITY to the identity fingerprint given by the server operator")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/jayt106/bitcoinAddressGenerator/audit"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"github.com/jayt106/bitcoinAddressGenerator/vault"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// SignMessageHandler the handler uses for passing this struct into the ServerHTTP function
+type SignMessageHandler struct {
+	vault    *vault.Vault
+	auditLog *audit.Log
+	net      *chaincfg.Params
+}
+
+// ServeHTTP handle the V1/signMessage API request behind the SecureChannel middleware. Derive the private key of the
+// seed (or the vault seed of the wallet id and the wallet token) and the path like V1/genPublicKeyAndSegWitAddress and
+// sign the message to prove the control of the address. Return the address and the BIP137 compact signature, or the
+// BIP322 simple signature for the P2TR address or when the bip322 format is requested.
+func (sh *SignMessageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handle API /v1/signMessage")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Read body error:")
+		return
+	}
+
+	var signParam SIGNMESSAGEPARAM
+	err = json.Unmarshal(body, &signParam)
+	Clear(&body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Unmarshal data error:")
+		return
+	}
+
+	addressType := signParam.ADDRESSTYPE
+	if addressType == "" {
+		addressType = message.AddressP2WPKH
+	}
+
+	err = ResolveSeed(sh.vault, &signParam.BIP32PARAM)
+	if err != nil {
+		Clear(&signParam)
+		ServerErrorHandle(w, err, "Resolve wallet seed error:")
+		return
+	}
+
+	walletID, err := WalletFingerprint(&signParam.BIP32PARAM)
+	if err != nil {
+		Clear(&signParam)
+		ServerErrorHandle(w, err, "Wallet fingerprint error:")
+		return
+	}
+	path := signParam.PATH.String()
+
+	clientHDKey, err := GenerateHDKey(&signParam.BIP32PARAM)
+	Clear(&signParam.BIP32PARAM)
+	if err != nil {
+		ServerErrorHandle(w, err, "Generate HD key failed:")
+		return
+	}
+
+	privKey, err := clientHDKey.ECPrivKey()
+	Clear(&clientHDKey)
+	if err != nil {
+		ServerErrorHandle(w, err, "Generate HD key failed:")
+		return
+	}
+
+	address, signature, err := message.Sign(privKey, addressType, signParam.FORMAT, signParam.MESSAGE, sh.net)
+	publicKey := hex.EncodeToString(privKey.PubKey().SerializeCompressed())
+	privKey.D.SetInt64(0)
+	if err != nil {
+		ServerErrorHandle(w, err, "Sign message failed:")
+		return
+	}
+
+	err = sh.auditLog.Append(audit.Entry{
+		Endpoint:  "/v1/signMessage",
+		WalletID:  walletID,
+		Path:      path,
+		Address:   address,
+		PublicKey: publicKey,
+		Requester: Requester(r),
+	})
+	if err != nil {
+		ServerErrorHandle(w, err, "Audit log error:")
+		return
+	}
+
+	resp := make(map[string]string)
+	resp["address"] = address
+	resp["publicKey"] = publicKey
+	resp["signature"] = signature
+
+	marshalledData, err := json.Marshal(resp)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json Marshal error:")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, err = w.Write(marshalledData)
+	if err != nil {
+		log.Println("ServeHTTP write error:", err)
+	}
+}
+
+// VerifyMessageHandler the handler uses for passing this struct into the ServerHTTP function
+type VerifyMessageHandler struct {
+	net *chaincfg.Params
+}
+
+// ServeHTTP handle the V1/verifyMessage API request to verify the BIP137 or BIP322 simple signature of the message by
+// the address of the network, the request needs no secret. The invalid signature is reported by the valid field with
+// the error.
+func (vh *VerifyMessageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handle API /v1/verifyMessage")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Read body error:")
+		return
+	}
+
+	var verifyParam VERIFYMESSAGEPARAM
+	err = json.Unmarshal(body, &verifyParam)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json unmarshal error:")
+		return
+	}
+
+	format, err := message.Verify(verifyParam.ADDRESS, verifyParam.MESSAGE, verifyParam.SIGNATURE, vh.net)
+
+	resp := make(map[string]string)
+	resp["address"] = verifyParam.ADDRESS
+	resp["format"] = format
+	resp["valid"] = strconv.FormatBool(err == nil)
+	if err != nil {
+		resp["error"] = err.Error()
+	}
+
+	marshalledData, err := json.Marshal(resp)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json Marshal error:")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, err = w.Write(marshalledData)
+	if err != nil {
+		log.Println("ServeHTTP write error:", err)
+	}
+}
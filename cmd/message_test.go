@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/jayt106/bitcoinAddressGenerator/vault"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// requestVerifyMessage Send the plaintext verification request and return the response
+func requestVerifyMessage(t *testing.T, param VERIFYMESSAGEPARAM) map[string]string {
+	bytesData, err := json.Marshal(param)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", "/v1/verifyMessage", bytes.NewReader(bytesData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	(&VerifyMessageHandler{&chaincfg.MainNetParams}).ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		t.Fatal("Unexpected status:", rr.Code)
+	}
+
+	body, err := ioutil.ReadAll(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rsp map[string]string
+	err = json.Unmarshal(body, &rsp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rsp
+}
+
+func TestHTTPServerSignVerifyMessage(t *testing.T) {
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filePath = workingDir + "/../test/test.json"
+	keyParam, err := ReadSeedFromJsonFile(&filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addressType, format, expectedFormat string
+	}{
+		{"p2wpkh", "", "bip137"},
+		{"p2wpkh", "bip322", "bip322"},
+		{"p2sh-p2wpkh", "", "bip137"},
+		{"p2pkh", "", "bip137"},
+		{"p2tr", "", "bip322"},
+	}
+
+	for _, test := range tests {
+		replayParam, err := NewReplayParam()
+		if err != nil {
+			t.Fatal(err)
+		}
+		param := SIGNMESSAGEPARAM{
+			BIP32PARAM:  BIP32PARAM{SEED: keyParam.SEED, PATH: keyParam.PATH, REPLAYPARAM: *replayParam},
+			ADDRESSTYPE: test.addressType,
+			FORMAT:      test.format,
+			MESSAGE:     "deposit address ownership",
+		}
+
+		rsp, code := requestSecureChannel(t, &SignMessageHandler{net: &chaincfg.MainNetParams}, param)
+		if code != 200 {
+			t.Fatal("Sign message failed:", test.addressType, code)
+		}
+		if test.addressType == "p2wpkh" && rsp["address"] != "bc1q8c87x4v0m3dfrxksv724rtwpxy5ghpw8gwf8da" {
+			t.Error("Unmatched address:", rsp["address"])
+		}
+
+		verified := requestVerifyMessage(t, VERIFYMESSAGEPARAM{rsp["address"], param.MESSAGE, rsp["signature"]})
+		if verified["valid"] != "true" || verified["format"] != test.expectedFormat {
+			t.Error("The signature should be valid:", test.addressType, verified)
+		}
+
+		verified = requestVerifyMessage(t, VERIFYMESSAGEPARAM{rsp["address"], "another message", rsp["signature"]})
+		if verified["valid"] != "false" {
+			t.Error("The signature of another message should be invalid:", test.addressType, verified)
+		}
+	}
+}
+
+func TestHTTPServerSignMessageWalletToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	walletVault, err := vault.Open(filepath.Join(dir, "vault.json"), []byte("test passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filePath = workingDir + "/../test/test.json"
+	keyParam, err := ReadSeedFromJsonFile(&filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, err := hex.DecodeString(keyParam.SEED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	walletID, walletToken, err := walletVault.Register(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint, err := vault.Fingerprint(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The public wallet id or fingerprint can't prove the control of the wallet addresses
+	for _, key := range []BIP32PARAM{
+		{WALLETID: walletID},
+		{WALLETID: walletID, WALLETTOKEN: strings.Repeat("0", 64)},
+		{WALLETID: fingerprint, WALLETTOKEN: walletToken},
+	} {
+		replayParam, err := NewReplayParam()
+		if err != nil {
+			t.Fatal(err)
+		}
+		key.PATH, key.REPLAYPARAM = keyParam.PATH, *replayParam
+		param := SIGNMESSAGEPARAM{BIP32PARAM: key, MESSAGE: "deposit address ownership"}
+		if _, code := requestSecureChannel(t, &SignMessageHandler{walletVault, nil, &chaincfg.MainNetParams}, param); code != 500 {
+			t.Error("The signing without the wallet token should be rejected:", key.WALLETID, code)
+		}
+	}
+
+	replayParam, err := NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	param := SIGNMESSAGEPARAM{
+		BIP32PARAM: BIP32PARAM{WALLETID: walletID, WALLETTOKEN: walletToken, PATH: keyParam.PATH, REPLAYPARAM: *replayParam},
+		MESSAGE:    "deposit address ownership",
+	}
+	rsp, code := requestSecureChannel(t, &SignMessageHandler{walletVault, nil, &chaincfg.MainNetParams}, param)
+	if code != 200 || rsp["address"] != "bc1q8c87x4v0m3dfrxksv724rtwpxy5ghpw8gwf8da" {
+		t.Fatal("Sign message by the vault wallet failed:", code, rsp)
+	}
+	verified := requestVerifyMessage(t, VERIFYMESSAGEPARAM{rsp["address"], param.MESSAGE, rsp["signature"]})
+	if verified["valid"] != "true" {
+		t.Error("The signature should be valid:", verified)
+	}
+}
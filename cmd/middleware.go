@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"runtime/debug"
+)
+
+// Middleware the common protections of every API in front of the SecureChannel middleware. The request body is read
+// up to the max body size, the request of another method than the API method is rejected, the POST request with a
+// body must be json, and the panic of the API handler is recovered as the INTERNAL_ERROR (500) response instead of
+// breaking the connection.
+type Middleware struct {
+	maxBodySize int64
+}
+
+// middlewareWriter records whether the response status is sent, the panic after it can't change the response
+type middlewareWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (mw *middlewareWriter) WriteHeader(status int) {
+	mw.wroteHeader = true
+	mw.ResponseWriter.WriteHeader(status)
+}
+
+func (mw *middlewareWriter) Write(b []byte) (int, error) {
+	mw.wroteHeader = true
+	return mw.ResponseWriter.Write(b)
+}
+
+// Flush Flush the streaming response, See RecordWriter
+func (mw *middlewareWriter) Flush() {
+	if flusher, ok := mw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap Return the response writer of the server, the http.ResponseController of the RecordWriter sets the write
+// deadline through it
+func (mw *middlewareWriter) Unwrap() http.ResponseWriter {
+	return mw.ResponseWriter
+}
+
+// Handler Wrap the API handler of the method by the middleware
+func (m *Middleware) Handler(method string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.serve(w, r, method, next)
+	})
+}
+
+func (m *Middleware) serve(w http.ResponseWriter, r *http.Request, method string, next http.Handler) {
+	mw := &middlewareWriter{ResponseWriter: w}
+	defer func() {
+		p := recover()
+		if p == nil {
+			return
+		}
+		if p == http.ErrAbortHandler {
+			panic(p)
+		}
+		log.Printf("Panic recovered in %s: %v\n%s", r.URL.Path, p, debug.Stack())
+		if !mw.wroteHeader {
+			ErrorCodeHandle(mw, http.StatusInternalServerError, "INTERNAL_ERROR", errors.New("internal server error"))
+		}
+	}()
+
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		ErrorCodeHandle(mw, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", fmt.Errorf("the method %s isn't allowed, use %s", r.Method, method))
+		return
+	}
+
+	// Read one more byte than the limit to tell the body is too large, the declared length is checked first
+	if r.ContentLength > m.maxBodySize {
+		ErrorCodeHandle(mw, http.StatusRequestEntityTooLarge, "BODY_TOO_LARGE", fmt.Errorf("the request body is larger than %d bytes", m.maxBodySize))
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, m.maxBodySize+1))
+	if err != nil {
+		ServerErrorHandle(mw, err, "Read body error:")
+		return
+	}
+	if int64(len(body)) > m.maxBodySize {
+		ErrorCodeHandle(mw, http.StatusRequestEntityTooLarge, "BODY_TOO_LARGE", fmt.Errorf("the request body is larger than %d bytes", m.maxBodySize))
+		return
+	}
+
+	if len(body) != 0 {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			ErrorCodeHandle(mw, http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE", errors.New("the request body must be application/json"))
+			return
+		}
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	next.ServeHTTP(mw, r)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// requestMiddleware Send the body to the handler wrapped by the middleware and return the response recorder and the
+// json error response
+func requestMiddleware(t *testing.T, handler http.Handler, method string, contentType string, body string) (*httptest.ResponseRecorder, map[string]string) {
+	req, err := http.NewRequest(method, "/", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	mw := &Middleware{64}
+	rr := httptest.NewRecorder()
+	mw.Handler(http.MethodPost, handler).ServeHTTP(rr, req)
+
+	var rsp map[string]string
+	if rr.Code != 200 {
+		err = json.Unmarshal(rr.Body.Bytes(), &rsp)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rr, rsp
+}
+
+func TestMiddleware(t *testing.T) {
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.WriteHeader(200)
+		_, _ = w.Write(body)
+	})
+
+	rr, _ := requestMiddleware(t, echo, "POST", "application/json; charset=utf-8", `{"N":"2"}`)
+	if rr.Code != 200 || rr.Body.String() != `{"N":"2"}` {
+		t.Error("The request should be passed to the handler:", rr.Code, rr.Body.String())
+	}
+	rr, _ = requestMiddleware(t, echo, "POST", "", "")
+	if rr.Code != 200 {
+		t.Error("The request without a body needs no content type:", rr.Code)
+	}
+
+	rr, rsp := requestMiddleware(t, echo, "GET", "", "")
+	if rr.Code != http.StatusMethodNotAllowed || rsp["errorCode"] != "METHOD_NOT_ALLOWED" || rr.Header().Get("Allow") != "POST" {
+		t.Error("The other method should be rejected:", rr.Code, rsp)
+	}
+	rr, rsp = requestMiddleware(t, echo, "POST", "text/plain", `{"N":"2"}`)
+	if rr.Code != http.StatusUnsupportedMediaType || rsp["errorCode"] != "UNSUPPORTED_MEDIA_TYPE" {
+		t.Error("The body other than json should be rejected:", rr.Code, rsp)
+	}
+	rr, rsp = requestMiddleware(t, echo, "POST", "application/json", `{"N":"`+strings.Repeat("2", 64)+`"}`)
+	if rr.Code != http.StatusRequestEntityTooLarge || rsp["errorCode"] != "BODY_TOO_LARGE" {
+		t.Error("The large body should be rejected:", rr.Code, rsp)
+	}
+
+	// The body of an unknown length is limited while it's read
+	req, err := http.NewRequest("POST", "/", ioutil.NopCloser(bytes.NewReader(make([]byte, 65))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if req.ContentLength != 0 {
+		t.Fatal("Unexpected content length:", req.ContentLength)
+	}
+	rr = httptest.NewRecorder()
+	(&Middleware{64}).Handler(http.MethodPost, echo).ServeHTTP(rr, req)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Error("The large body of an unknown length should be rejected:", rr.Code)
+	}
+
+	// The panic of the handler is the typed 500 response, the panic message isn't leaked to the client
+	var slice []byte
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = slice[32]
+	})
+	rr, rsp = requestMiddleware(t, panicking, "POST", "application/json", `{}`)
+	if rr.Code != 500 || rsp["errorCode"] != "INTERNAL_ERROR" || rsp["error"] != "internal server error" {
+		t.Error("The panic should be recovered:", rr.Code, rsp)
+	}
+
+	// The panic after the response status is sent can't change the response
+	streaming := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		_, _ = w.Write([]byte("{}\n"))
+		w.(http.Flusher).Flush()
+		_ = slice[32]
+	})
+	rr, _ = requestMiddleware(t, streaming, "POST", "", "")
+	if rr.Code != 200 || rr.Body.String() != "{}\n" || !rr.Flushed {
+		t.Error("The response after the status should be kept:", rr.Code, rr.Body.String())
+	}
+}
+
+func TestRecordWriterDeadline(t *testing.T) {
+	for _, http2 := range []bool{false, true} {
+		for _, writeTimeout := range []time.Duration{0, 200 * time.Millisecond} {
+			// The records are written slowly for longer than the write timeout of the server
+			stream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				rw, err := NewRecordWriter(w, r, writeTimeout)
+				if err != nil {
+					ServerErrorHandle(w, err, "Record writer error:")
+					return
+				}
+				for i := 0; i < 6; i++ {
+					if err = rw.Write(DeriveStreamEnd{Count: uint32(i)}); err != nil {
+						return
+					}
+					time.Sleep(80 * time.Millisecond)
+				}
+			})
+
+			server := httptest.NewUnstartedServer((&Middleware{64}).Handler(http.MethodPost, stream))
+			server.EnableHTTP2 = http2
+			server.Config.WriteTimeout = 200 * time.Millisecond
+			server.StartTLS()
+
+			records := 0
+			rsp, err := server.Client().Post(server.URL, "", nil)
+			if err == nil {
+				body, _ := ioutil.ReadAll(rsp.Body)
+				rsp.Body.Close()
+				records = strings.Count(string(body), "\n")
+			}
+			server.Close()
+
+			if writeTimeout == 0 && records == 6 {
+				t.Error("The stream should be cut by the server write timeout, http2:", http2)
+			}
+			if writeTimeout != 0 && records != 6 {
+				t.Error("The write deadline should be extended for each record, http2:", http2, records, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/jayt106/bitcoinAddressGenerator/bitcoind"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+// AddressStatusHandler the handler uses for passing this struct into the ServerHTTP function
+type AddressStatusHandler struct {
+	node *bitcoind.Client
+	net  *chaincfg.Params
+}
+
+// ServeHTTP handle the V1/addressStatus API request, the API is only enabled with the bitcoind RPC. Return the amount
+// received by the address, the unconfirmed amount and the unspent outputs in the watch-only wallet of the node. The
+// address must be imported to the wallet, the generated addresses are imported when they are issued. The request is
+// plaintext json or encrypted by the SecureChannel middleware.
+func (ah *AddressStatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handle API /v1/addressStatus")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Read body error:")
+		return
+	}
+
+	var statusParam ADDRESSSTATUSPARAM
+	err = json.Unmarshal(body, &statusParam)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json unmarshal error:")
+		return
+	}
+	if _, err = message.AddressScript(statusParam.ADDRESS, ah.net); err != nil {
+		ServerErrorHandle(w, err, "Invalid address:")
+		return
+	}
+
+	status, err := ah.node.AddressStatus(statusParam.ADDRESS)
+	if err != nil {
+		ServerErrorHandle(w, err, "Bitcoind RPC error:")
+		return
+	}
+
+	marshalledData, err := json.Marshal(status)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json Marshal error:")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, err = w.Write(marshalledData)
+	if err != nil {
+		log.Println("ServeHTTP write error:", err)
+	}
+}
+
+// WatchAddress Import the descriptor of the issued address as watch-only to the wallet of the node, and return the
+// import state of the response, "imported" or the error. The address is issued even if the node is unavailable, the
+// empty state is returned without the bitcoind RPC.
+func WatchAddress(node *bitcoind.Client, desc string, label string) string {
+	if node == nil {
+		return ""
+	}
+	err := node.ImportWatchOnly(desc, label)
+	if err != nil {
+		log.Println("Import descriptor error:", err)
+		return "import failed: " + err.Error()
+	}
+	return "imported"
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/jayt106/bitcoinAddressGenerator/audit"
+	"github.com/jayt106/bitcoinAddressGenerator/bitcoind"
+	"github.com/jayt106/bitcoinAddressGenerator/vault"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHTTPServerAddressStatus(t *testing.T) {
+	// The stand-in of the bitcoind RPC server records the imported descriptors
+	imported := make(map[string]string)
+	rpcServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if user, password, _ := r.BasicAuth(); user != "rpc" || password != "secret" || json.NewDecoder(r.Body).Decode(&req) != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var result interface{}
+		switch req.Method {
+		case "importdescriptors":
+			var requests []bitcoind.ImportRequest
+			_ = json.Unmarshal(req.Params[0], &requests)
+			for _, request := range requests {
+				imported[strings.Split(request.Desc, "#")[0]] = request.Label
+			}
+			result = []bitcoind.ImportResult{{Success: true}}
+		case "getreceivedbyaddress":
+			result = 0.001
+		case "listunspent":
+			result = []map[string]interface{}{{"txid": "5e2383defe7efcbdc9fdd6dba55da148b206617bbb49e6bb93fce7bfbb459d44", "vout": 0, "amount": 0.001, "confirmations": 6}}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": result, "error": nil})
+	}))
+	defer rpcServer.Close()
+
+	node, err := bitcoind.New(strings.Replace(rpcServer.URL, "http://", "http://rpc:secret@", 1), "", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	walletVault, err := vault.Open(filepath.Join(dir, "vault.json"), []byte("test passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLog, err := audit.Open(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filePath = workingDir + "/../test/test.json"
+	keyParam, err := ReadSeedFromJsonFile(&filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, err := hex.DecodeString(keyParam.SEED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	walletID, walletToken, err := walletVault.Register(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint, err := vault.Fingerprint(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The issued address is imported with the key origin and the label
+	replayParam, err := NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := NEXTADDRESSPARAM{WALLETTOKEN: walletToken, LABEL: "invoice-1", REPLAYPARAM: *replayParam}
+	rsp, code := requestSecureChannelPath(t, &WalletsHandler{walletVault, auditLog, node, &chaincfg.MainNetParams, nil}, "/v1/wallets/"+walletID+"/nextAddress", req)
+	if code != 200 {
+		t.Fatal("Next address failed, status:", code)
+	}
+	pubKey, err := hex.DecodeString(rsp["publicKey"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := bitcoind.WPKHDescriptor(fingerprint, "m/0'/0/0", pubKey)
+	if rsp["watchOnly"] != "imported" || imported[desc] != "invoice-1" {
+		t.Error("The issued address should be imported:", rsp["watchOnly"], imported)
+	}
+
+	var status bitcoind.AddressStatus
+	if code = requestPlaintext(t, &AddressStatusHandler{node, &chaincfg.MainNetParams}, ADDRESSSTATUSPARAM{ADDRESS: rsp["segwitAddress"]}, &status); code != 200 {
+		t.Fatal("Address status failed, status:", code)
+	}
+	if status.Address != rsp["segwitAddress"] || status.Received != 100000 || status.Balance != 100000 || len(status.UTXOs) != 1 {
+		t.Error("Unexpected address status:", status)
+	}
+
+	if code = requestPlaintext(t, &AddressStatusHandler{node, &chaincfg.MainNetParams}, ADDRESSSTATUSPARAM{ADDRESS: "invalid"}, &status); code != 500 {
+		t.Error("The invalid address should be rejected, status:", code)
+	}
+
+	// The address is still issued when the node rejects the import
+	unavailable, err := bitcoind.New(rpcServer.URL, filepath.Join(dir, ".cookie"), "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayParam, err = NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = NEXTADDRESSPARAM{WALLETTOKEN: walletToken, REPLAYPARAM: *replayParam}
+	rsp, code = requestSecureChannelPath(t, &WalletsHandler{walletVault, auditLog, unavailable, &chaincfg.MainNetParams, nil}, "/v1/wallets/"+walletID+"/nextAddress", req)
+	if code != 200 || !strings.HasPrefix(rsp["watchOnly"], "import failed") {
+		t.Error("Unexpected import failure:", code, rsp["watchOnly"])
+	}
+}
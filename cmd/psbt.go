@@ -0,0 +1,634 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/jayt106/bitcoinAddressGenerator/audit"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"github.com/jayt106/bitcoinAddressGenerator/psbt"
+	"github.com/jayt106/bitcoinAddressGenerator/taproot"
+	"github.com/jayt106/bitcoinAddressGenerator/vault"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PSBTCreateHandler the handler uses for passing this struct into the ServerHTTP function
+type PSBTCreateHandler struct {
+	vault    *vault.Vault
+	auditLog *audit.Log
+	net      *chaincfg.Params
+}
+
+// ServeHTTP handle the V1/psbt/create API request to create the BIP174 psbt spending the utxos given by the client, like
+// the outputs of the V1/genMultiSigP2SHAddress address. The redeem scripts, the witness scripts and the BIP032
+// derivations are filled in the psbt for the signers, nothing is fetched from the network. The request is plaintext json
+// or encrypted by the SecureChannel middleware, the derivation without the public key is resolved by the vault wallet of
+// the fingerprint and requires the encrypted request. Each public key derived from the vault is recorded in the audit log.
+func (ph *PSBTCreateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handle API /v1/psbt/create")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Read body error:")
+		return
+	}
+
+	var createParam PSBTCREATEPARAM
+	err = json.Unmarshal(body, &createParam)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json unmarshal error:")
+		return
+	}
+
+	var vaultKeys *psbtVault
+	if Encrypted(r) {
+		vaultKeys = &psbtVault{ph.vault, ph.auditLog, r, "/v1/psbt/create"}
+	}
+
+	utxos := make([]*psbt.UTXO, len(createParam.UTXOS))
+	for i := range createParam.UTXOS {
+		utxos[i], err = psbtUTXO(vaultKeys, &createParam.UTXOS[i], ph.net)
+		if err != nil {
+			ServerErrorHandle(w, fmt.Errorf("utxo %d: %v", i, err), "PSBT utxo error:")
+			return
+		}
+	}
+
+	outputs := make([]*psbt.TxOutput, len(createParam.OUTPUTS))
+	for i := range createParam.OUTPUTS {
+		outputs[i], err = psbtOutput(vaultKeys, &createParam.OUTPUTS[i], ph.net)
+		if err != nil {
+			ServerErrorHandle(w, fmt.Errorf("output %d: %v", i, err), "PSBT output error:")
+			return
+		}
+	}
+
+	var change *psbt.TxOutput
+	if createParam.CHANGE != nil {
+		change, err = psbtOutput(vaultKeys, createParam.CHANGE, ph.net)
+		if err != nil {
+			ServerErrorHandle(w, err, "PSBT change output error:")
+			return
+		}
+	}
+
+	packet, summary, err := psbt.Create(utxos, outputs, change, createParam.FEERATE)
+	if err != nil {
+		ServerErrorHandle(w, err, "Create PSBT failed:")
+		return
+	}
+
+	encoded, err := packet.B64Encode()
+	if err != nil {
+		ServerErrorHandle(w, err, "Serialize PSBT failed:")
+		return
+	}
+
+	resp := make(map[string]string)
+	resp["psbt"] = encoded
+	resp["fee"] = strconv.FormatInt(summary.Fee, 10)
+	resp["feeRate"] = strconv.FormatFloat(summary.FeeRate, 'f', 2, 64)
+	resp["vsize"] = strconv.FormatInt(summary.VSize, 10)
+	resp["weight"] = strconv.FormatInt(summary.Weight, 10)
+	resp["changeIndex"] = strconv.Itoa(summary.ChangeIndex)
+	if len(summary.Warnings) != 0 {
+		resp["warning"] = strings.Join(summary.Warnings, "; ")
+	}
+
+	marshalledData, err := json.Marshal(resp)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json Marshal error:")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, err = w.Write(marshalledData)
+	if err != nil {
+		log.Println("ServeHTTP write error:", err)
+	}
+}
+
+// SignPSBTHandler the handler uses for passing this struct into the ServerHTTP function
+type SignPSBTHandler struct {
+	vault *vault.Vault
+	net   *chaincfg.Params
+}
+
+// ServeHTTP handle the V1/psbt/sign API request behind the SecureChannel middleware, the server acts as a cosigner. Sign
+// every input of the psbt of which the BIP032 derivation fingerprint matches the master key of the seed (or of the vault
+// seed of the wallet id and the wallet token), the P2WPKH, P2SH-P2WPKH, P2SH/P2WSH multisig and P2TR key path inputs. Return the updated psbt and the indexes of
+// the signed inputs, the response is encrypted by the client channel key.
+func (sh *SignPSBTHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handle API /v1/psbt/sign")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Read body error:")
+		return
+	}
+
+	var signParam PSBTSIGNPARAM
+	err = json.Unmarshal(body, &signParam)
+	Clear(&body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Unmarshal data error:")
+		return
+	}
+
+	packet, err := psbt.NewFromBase64(signParam.PSBT)
+	if err != nil {
+		Clear(&signParam)
+		ServerErrorHandle(w, err, "Parse PSBT failed:")
+		return
+	}
+
+	keyParam := BIP32PARAM{SEED: signParam.SEED, WALLETID: signParam.WALLETID, WALLETTOKEN: signParam.WALLETTOKEN}
+	Clear(&signParam)
+	err = ResolveSeed(sh.vault, &keyParam)
+	if err != nil {
+		Clear(&keyParam)
+		ServerErrorHandle(w, err, "Resolve wallet seed error:")
+		return
+	}
+
+	seed, err := hex.DecodeString(keyParam.SEED)
+	Clear(&keyParam)
+	if err != nil {
+		ServerErrorHandle(w, err, "Decode seed error:")
+		return
+	}
+
+	masterKey, err := hdkeychain.NewMaster(seed, sh.net)
+	for i := range seed {
+		seed[i] = 0
+	}
+	if err != nil {
+		ServerErrorHandle(w, err, "Generate HD key failed:")
+		return
+	}
+
+	signed, err := psbt.Sign(packet, masterKey)
+	masterKey.Zero()
+	if err != nil {
+		ServerErrorHandle(w, err, "Sign PSBT failed:")
+		return
+	}
+
+	encoded, err := packet.B64Encode()
+	if err != nil {
+		ServerErrorHandle(w, err, "Serialize PSBT failed:")
+		return
+	}
+
+	signedInputs := make([]string, len(signed))
+	for i, idx := range signed {
+		signedInputs[i] = strconv.Itoa(idx)
+	}
+
+	resp := make(map[string]string)
+	resp["psbt"] = encoded
+	resp["signedInputs"] = strings.Join(signedInputs, ",")
+
+	marshalledData, err := json.Marshal(resp)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json Marshal error:")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, err = w.Write(marshalledData)
+	if err != nil {
+		log.Println("ServeHTTP write error:", err)
+	}
+}
+
+// CombinePSBT a handle function to merge the psbts signed by the cosigners, the request needs no secret. The request is
+// plaintext json or encrypted by the SecureChannel middleware.
+func CombinePSBT(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handle API /v1/psbt/combine")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Read body error:")
+		return
+	}
+
+	var combineParam PSBTCOMBINEPARAM
+	err = json.Unmarshal(body, &combineParam)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json unmarshal error:")
+		return
+	}
+
+	packets := make([]*psbt.Packet, len(combineParam.PSBTS))
+	for i, encoded := range combineParam.PSBTS {
+		packets[i], err = psbt.NewFromBase64(encoded)
+		if err != nil {
+			ServerErrorHandle(w, fmt.Errorf("psbt %d: %v", i, err), "Parse PSBT failed:")
+			return
+		}
+	}
+
+	combined, err := psbt.Combine(packets)
+	if err != nil {
+		ServerErrorHandle(w, err, "Combine PSBT failed:")
+		return
+	}
+
+	encoded, err := combined.B64Encode()
+	if err != nil {
+		ServerErrorHandle(w, err, "Serialize PSBT failed:")
+		return
+	}
+
+	resp := make(map[string]string)
+	resp["psbt"] = encoded
+
+	marshalledData, err := json.Marshal(resp)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json Marshal error:")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, err = w.Write(marshalledData)
+	if err != nil {
+		log.Println("ServeHTTP write error:", err)
+	}
+}
+
+// FinalizePSBT a handle function to finalize the inputs of the psbt having enough valid signatures against the redeem
+// script or the witness script. The response has the psbt, the diagnostics of every input explaining why it can't be
+// finalized yet, and the raw transaction (hex) when all the inputs are finalized. The request is plaintext json or
+// encrypted by the SecureChannel middleware.
+func FinalizePSBT(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handle API /v1/psbt/finalize")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Read body error:")
+		return
+	}
+
+	var finalizeParam PSBTFINALIZEPARAM
+	err = json.Unmarshal(body, &finalizeParam)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json unmarshal error:")
+		return
+	}
+
+	packet, err := psbt.NewFromBase64(finalizeParam.PSBT)
+	if err != nil {
+		ServerErrorHandle(w, err, "Parse PSBT failed:")
+		return
+	}
+
+	statuses := psbt.Finalize(packet)
+	encoded, err := packet.B64Encode()
+	if err != nil {
+		ServerErrorHandle(w, err, "Serialize PSBT failed:")
+		return
+	}
+
+	resp := make(map[string]interface{})
+	resp["psbt"] = encoded
+	resp["complete"] = psbt.IsComplete(statuses)
+	resp["inputs"] = statuses
+	if psbt.IsComplete(statuses) {
+		tx, err := psbt.Extract(packet)
+		if err != nil {
+			ServerErrorHandle(w, err, "Extract transaction failed:")
+			return
+		}
+
+		var buf bytes.Buffer
+		err = tx.Serialize(&buf)
+		if err != nil {
+			ServerErrorHandle(w, err, "Serialize transaction failed:")
+			return
+		}
+		resp["tx"] = hex.EncodeToString(buf.Bytes())
+		resp["txid"] = tx.TxHash().String()
+	}
+
+	marshalledData, err := json.Marshal(resp)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json Marshal error:")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, err = w.Write(marshalledData)
+	if err != nil {
+		log.Println("ServeHTTP write error:", err)
+	}
+}
+
+// VerifyRedeemScript a handle function to prove the redeem script (or witness script) is spendable before funding it,
+// the request is encrypted by the SecureChannel middleware since it has the test private keys (WIF or hex). A dummy
+// output of the script is spent, signed by the keys and executed by the btcd script engine. The response has the result,
+// the executed opcode trace and the actual signature script and witness sizes compared with the fee estimate.
+func VerifyRedeemScript(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handle API /v1/verifyRedeemScript")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Read body error:")
+		return
+	}
+
+	var verifyParam VERIFYSCRIPTPARAM
+	err = json.Unmarshal(body, &verifyParam)
+	Clear(&body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json unmarshal error:")
+		return
+	}
+	defer Clear(&verifyParam)
+
+	script, err := decodeScript(verifyParam.REDEEMSCRIPT)
+	if err != nil {
+		ServerErrorHandle(w, err, "Decode redeem script error:")
+		return
+	}
+
+	var privKeys []*btcec.PrivateKey
+	for _, key := range verifyParam.PRIVATEKEYS {
+		privKey, err := parsePrivateKey(key)
+		if err != nil {
+			ServerErrorHandle(w, err, "Parse private key error:")
+			return
+		}
+		privKeys = append(privKeys, privKey)
+	}
+	defer func() {
+		for _, privKey := range privKeys {
+			privKey.D.SetInt64(0)
+		}
+	}()
+
+	inputType := verifyParam.TYPE
+	if inputType == "" {
+		inputType = psbt.InputP2SH
+	}
+	check, err := psbt.CheckScript(script, inputType, privKeys)
+	if err != nil {
+		ServerErrorHandle(w, err, "Check script error:")
+		return
+	}
+
+	marshalledData, err := json.Marshal(check)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json Marshal error:")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, err = w.Write(marshalledData)
+	if err != nil {
+		log.Println("ServeHTTP write error:", err)
+	}
+}
+
+// psbtVault the vault wallets of the derivations of the psbt request, the public key derived from the vault seed is
+// recorded in the audit log as issued by the endpoint to the requester
+type psbtVault struct {
+	vault    *vault.Vault
+	auditLog *audit.Log
+	r        *http.Request
+	endpoint string
+}
+
+// psbtUTXO Convert the utxo of the request, the output script is taken from the address of the network or the scripts
+func psbtUTXO(v *psbtVault, p *PSBTUTXOPARAM, net *chaincfg.Params) (*psbt.UTXO, error) {
+	hash, err := chainhash.NewHashFromStr(p.TXID)
+	if err != nil {
+		return nil, err
+	}
+
+	utxo := &psbt.UTXO{OutPoint: wire.OutPoint{Hash: *hash, Index: p.VOUT}, Value: p.VALUE}
+	if p.ADDRESS != "" {
+		utxo.PkScript, err = message.AddressScript(p.ADDRESS, net)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	utxo.RedeemScript, err = decodeScript(p.REDEEMSCRIPT)
+	if err != nil {
+		return nil, err
+	}
+	utxo.WitnessScript, err = decodeScript(p.WITNESSSCRIPT)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.PREVTX != "" {
+		data, err := hex.DecodeString(p.PREVTX)
+		if err != nil {
+			return nil, err
+		}
+		utxo.PrevTx = wire.NewMsgTx(wire.TxVersion)
+		err = utxo.PrevTx.Deserialize(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	derivations, err := psbtDerivations(v, p.DERIVATIONS, net)
+	if err != nil {
+		return nil, err
+	}
+	if taproot.IsPkScript(utxo.PkScript) {
+		utxo.TaprootInternalKey, utxo.TaprootBip32Derivation, err = taprootDerivations(utxo.PkScript, derivations)
+		return utxo, err
+	}
+	utxo.Bip32Derivation = derivations
+	return utxo, nil
+}
+
+// psbtOutput Convert the output of the request to the address of the network with the scripts and derivations
+// identifying the change output
+func psbtOutput(v *psbtVault, p *PSBTOUTPUTPARAM, net *chaincfg.Params) (*psbt.TxOutput, error) {
+	pkScript, err := message.AddressScript(p.ADDRESS, net)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &psbt.TxOutput{TxOut: wire.NewTxOut(p.VALUE, pkScript)}
+	output.Output.RedeemScript, err = decodeScript(p.REDEEMSCRIPT)
+	if err != nil {
+		return nil, err
+	}
+	output.Output.WitnessScript, err = decodeScript(p.WITNESSSCRIPT)
+	if err != nil {
+		return nil, err
+	}
+
+	derivations, err := psbtDerivations(v, p.DERIVATIONS, net)
+	if err != nil {
+		return nil, err
+	}
+	if taproot.IsPkScript(pkScript) {
+		output.Output.TaprootInternalKey, output.Output.TaprootBip32Derivation, err = taprootDerivations(pkScript, derivations)
+		return output, err
+	}
+	output.Output.Bip32Derivation = derivations
+	return output, nil
+}
+
+// psbtDerivations Convert the derivations of the request, the missing public key is derived from the vault seed of the
+// wallet id with the wallet token. The vault is nil for the plaintext request.
+func psbtDerivations(v *psbtVault, params []PSBTDERIVATIONPARAM, net *chaincfg.Params) ([]*psbt.Bip32Derivation, error) {
+	var derivations []*psbt.Bip32Derivation
+	for _, p := range params {
+		path, err := psbt.ParseDerivationPath(p.PATH)
+		if err != nil {
+			return nil, err
+		}
+
+		var pubKey []byte
+		walletFingerprint := p.FINGERPRINT
+		if p.PUBLICKEY != "" {
+			pubKey, err = hex.DecodeString(p.PUBLICKEY)
+			if err == nil {
+				_, err = btcec.ParsePubKey(pubKey, btcec.S256())
+			}
+		} else {
+			pubKey, walletFingerprint, err = v.publicKey(p.WALLETID, p.WALLETTOKEN, path, net)
+			if err == nil && p.FINGERPRINT != "" && p.FINGERPRINT != walletFingerprint {
+				err = fmt.Errorf("the fingerprint %s isn't the fingerprint of the wallet %s", p.FINGERPRINT, p.WALLETID)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		fingerprint, err := hex.DecodeString(walletFingerprint)
+		if err != nil || len(fingerprint) != 4 {
+			return nil, fmt.Errorf("invalid fingerprint %s", walletFingerprint)
+		}
+
+		derivation := &psbt.Bip32Derivation{PubKey: pubKey, Path: path}
+		copy(derivation.Fingerprint[:], fingerprint)
+		derivations = append(derivations, derivation)
+	}
+	return derivations, nil
+}
+
+// publicKey Derive the compressed public key of the path from the vault seed of the wallet id with the wallet token,
+// record it in the audit log and return it with the master key fingerprint of the seed
+func (v *psbtVault) publicKey(walletID string, token string, path []uint32, net *chaincfg.Params) ([]byte, string, error) {
+	if v == nil {
+		return nil, "", errors.New("the public key is missing, the vault wallet is only used by the encrypted request")
+	}
+
+	seed, err := v.vault.Seed(walletID, token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	fingerprint, err := vault.Fingerprint(seed)
+	if err != nil {
+		for i := range seed {
+			seed[i] = 0
+		}
+		return nil, "", err
+	}
+	masterKey, err := hdkeychain.NewMaster(seed, net)
+	for i := range seed {
+		seed[i] = 0
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	key, err := psbt.DeriveKey(masterKey, path)
+	masterKey.Zero()
+	if err != nil {
+		return nil, "", err
+	}
+
+	pubKey, err := key.ECPubKey()
+	key.Zero()
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = v.auditLog.Append(audit.Entry{
+		Endpoint:  v.endpoint,
+		WalletID:  fingerprint,
+		Path:      psbt.FormatDerivationPath(path),
+		PublicKey: hex.EncodeToString(pubKey.SerializeCompressed()),
+		Requester: Requester(v.r),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return pubKey.SerializeCompressed(), fingerprint, nil
+}
+
+// taprootDerivations Convert the derivations of the P2TR output to the BIP371 taproot derivations. The key path only
+// output has a single key, the internal key of the output key.
+func taprootDerivations(pkScript []byte, derivations []*psbt.Bip32Derivation) ([]byte, []*psbt.TaprootBip32Derivation, error) {
+	if len(derivations) == 0 {
+		return nil, nil, nil
+	}
+	if len(derivations) != 1 {
+		return nil, nil, errors.New("only the key path of the p2tr output is supported")
+	}
+
+	pubKey, err := btcec.ParsePubKey(derivations[0].PubKey, btcec.S256())
+	if err != nil {
+		return nil, nil, err
+	}
+	outputKey, err := taproot.OutputKey(pubKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(taproot.PkScript(outputKey), pkScript) {
+		return nil, nil, errors.New("the public key isn't the internal key of the p2tr output")
+	}
+
+	internalKey := taproot.XOnlyPubKey(pubKey)
+	return internalKey, []*psbt.TaprootBip32Derivation{{
+		XOnlyPubKey: internalKey,
+		Fingerprint: derivations[0].Fingerprint,
+		Path:        derivations[0].Path,
+	}}, nil
+}
+
+// decodeScript Decode the hex script, the empty string is no script
+func decodeScript(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return hex.DecodeString(s)
+}
+
+// parsePrivateKey Parse the WIF or hex private key
+func parsePrivateKey(key string) (*btcec.PrivateKey, error) {
+	wif, err := btcutil.DecodeWIF(key)
+	if err == nil {
+		return wif.PrivKey, nil
+	}
+
+	data, err := hex.DecodeString(key)
+	if err != nil || len(data) != btcec.PrivKeyBytesLen {
+		return nil, errors.New("the private key is neither WIF nor 32 bytes hex")
+	}
+	privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), data)
+	Clear(&data)
+	return privKey, nil
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/jayt106/bitcoinAddressGenerator/audit"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"github.com/jayt106/bitcoinAddressGenerator/psbt"
+	"github.com/jayt106/bitcoinAddressGenerator/taproot"
+	"github.com/jayt106/bitcoinAddressGenerator/vault"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testRedeemScriptHex = "524104a882d414e478039cd5b52a92ffb13dd5e6bd4515497439dffd691a0f12af9575fa349b5694ed3155b136f09e63975a1700c9f4d4df849323dac06cf3bd6458cd41046ce31db9bdd543e72fe3039a1f1c047dab87037c36a669ff90e28da1848f640de68c2fe913d363a51154a0c62d7adea1b822d05035077418267b1a1379790187410411ffd36c70776538d079fbae117dc38effafb33304af83ce4894589747aee1ef992f63280567f52f5ba870678b4ab4ff6c8ea600bd217870a8b4f1f09f3a8e8353ae"
+
+func TestHTTPServerCreatePSBT(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	walletVault, err := vault.Open(filepath.Join(dir, "vault.json"), []byte("test passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filePath = workingDir + "/../test/test.json"
+	keyParam, err := ReadSeedFromJsonFile(&filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayParam, err := NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp, code := requestSecureChannel(t, &RegisterWalletHandler{walletVault}, WALLETPARAM{keyParam.SEED, *replayParam})
+	if code != 200 {
+		t.Fatal("Register wallet failed, status:", code)
+	}
+	walletID, walletToken, walletFingerprint := rsp["walletId"], rsp["walletToken"], rsp["fingerprint"]
+
+	hdPubKey, err := GenerateHDPublicKey(keyParam, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := ConvertPublicKey(hdPubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The public key of the change derivation is derived from the vault wallet
+	derivation := PSBTDERIVATIONPARAM{WALLETID: walletID, WALLETTOKEN: walletToken, PATH: "m/0'/0/0"}
+	replayParam, err = NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	param := PSBTCREATEPARAM{
+		UTXOS: []PSBTUTXOPARAM{
+			{TXID: "5e2383defe7efcbdc9fdd6dba55da148b206617bbb49e6bb93fce7bfbb459d44", VOUT: 1, VALUE: 200000,
+				REDEEMSCRIPT: testRedeemScriptHex},
+			{TXID: "81b4c832d70cb56ff957589752eb4125a4cab78a25a8fc52d6a09e5bd4404d48", VOUT: 0, VALUE: 50000,
+				ADDRESS: "bc1q8c87x4v0m3dfrxksv724rtwpxy5ghpw8gwf8da", DERIVATIONS: []PSBTDERIVATIONPARAM{derivation}},
+		},
+		OUTPUTS:     []PSBTOUTPUTPARAM{{ADDRESS: "347N1Thc213QqfYCz3PZkjoJpNv5b14kBd", VALUE: 150000}},
+		CHANGE:      &PSBTOUTPUTPARAM{ADDRESS: "bc1q8c87x4v0m3dfrxksv724rtwpxy5ghpw8gwf8da", DERIVATIONS: []PSBTDERIVATIONPARAM{derivation}},
+		FEERATE:     5,
+		REPLAYPARAM: *replayParam,
+	}
+
+	auditLogFile := filepath.Join(dir, "audit.log")
+	auditLog, err := audit.Open(auditLogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+	rsp, code = requestSecureChannel(t, &PSBTCreateHandler{walletVault, auditLog, &chaincfg.MainNetParams}, param)
+	if code != 200 {
+		t.Fatal("Create PSBT failed, status:", code)
+	}
+	if rsp["changeIndex"] != "1" || rsp["warning"] == "" {
+		t.Error("Unexpected response:", rsp)
+	}
+
+	packet, err := psbt.NewFromBase64(rsp["psbt"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet.Inputs[0].RedeemScript == nil || packet.Inputs[1].WitnessUtxo == nil {
+		t.Error("Unexpected inputs of the psbt")
+	}
+	for _, derivations := range [][]*psbt.Bip32Derivation{packet.Inputs[1].Bip32Derivation, packet.Outputs[1].Bip32Derivation} {
+		if len(derivations) != 1 || !bytes.Equal(derivations[0].PubKey, *pubKey) ||
+			psbt.FormatDerivationPath(derivations[0].Path) != "m/0'/0/0" ||
+			hex.EncodeToString(derivations[0].Fingerprint[:]) != walletFingerprint {
+			t.Error("Unexpected derivation of the psbt")
+		}
+	}
+
+	// The public keys derived from the vault for the utxo and the change are audited
+	entries, err := audit.Verify(auditLogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatal("Unexpected audit log entries:", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Endpoint != "/v1/psbt/create" || entry.WalletID != walletFingerprint || entry.Path != "m/0'/0/0" ||
+			entry.PublicKey != hex.EncodeToString(*pubKey) || entry.Requester != "192.0.2.1:1234" {
+			t.Error("Unexpected audit log entry:", entry)
+		}
+	}
+
+	// The plaintext request can't derive the public key from the vault
+	bytesData, err := json.Marshal(param)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", "/v1/psbt/create", bytes.NewReader(bytesData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	(&PSBTCreateHandler{walletVault, auditLog, &chaincfg.MainNetParams}).ServeHTTP(rr, req)
+	if rr.Code != 500 {
+		t.Error("The plaintext request should not use the vault, status:", rr.Code)
+	}
+}
+
+func TestHTTPServerSignPSBT(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	walletVault, err := vault.Open(filepath.Join(dir, "vault.json"), []byte("test passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filePath = workingDir + "/../test/test.json"
+	keyParam, err := ReadSeedFromJsonFile(&filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walletID, err := WalletFingerprint(keyParam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdPubKey, err := GenerateHDPublicKey(keyParam, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := hdPubKey.ECPubKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2trAddress, err := message.Address(pubKey, message.AddressP2TR, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	derivation := PSBTDERIVATIONPARAM{PUBLICKEY: hex.EncodeToString(pubKey.SerializeCompressed()), FINGERPRINT: walletID, PATH: "m/0'/0/0"}
+	replayParam, err := NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	createParam := PSBTCREATEPARAM{
+		UTXOS: []PSBTUTXOPARAM{
+			{TXID: "5e2383defe7efcbdc9fdd6dba55da148b206617bbb49e6bb93fce7bfbb459d44", VOUT: 0, VALUE: 30000,
+				ADDRESS: "bc1q8c87x4v0m3dfrxksv724rtwpxy5ghpw8gwf8da", DERIVATIONS: []PSBTDERIVATIONPARAM{derivation}},
+			{TXID: "81b4c832d70cb56ff957589752eb4125a4cab78a25a8fc52d6a09e5bd4404d48", VOUT: 1, VALUE: 40000,
+				ADDRESS: p2trAddress, DERIVATIONS: []PSBTDERIVATIONPARAM{derivation}},
+		},
+		OUTPUTS:     []PSBTOUTPUTPARAM{{ADDRESS: "347N1Thc213QqfYCz3PZkjoJpNv5b14kBd", VALUE: 60000}},
+		FEERATE:     2,
+		REPLAYPARAM: *replayParam,
+	}
+	rsp, code := requestSecureChannel(t, &PSBTCreateHandler{net: &chaincfg.MainNetParams}, createParam)
+	if code != 200 {
+		t.Fatal("Create PSBT failed, status:", code)
+	}
+	unsigned := rsp["psbt"]
+
+	// The wallet id is not enough to sign by the vault seed, the fingerprint of the psbt is public
+	seed, err := hex.DecodeString(keyParam.SEED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vaultWalletID, walletToken, err := walletVault.Register(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, signParam := range []PSBTSIGNPARAM{
+		{WALLETID: vaultWalletID},
+		{WALLETID: vaultWalletID, WALLETTOKEN: strings.Repeat("0", 64)},
+		{WALLETID: walletID, WALLETTOKEN: walletToken},
+	} {
+		replayParam, err = NewReplayParam()
+		if err != nil {
+			t.Fatal(err)
+		}
+		signParam.PSBT, signParam.REPLAYPARAM = unsigned, *replayParam
+		if _, code = requestSecureChannel(t, &SignPSBTHandler{walletVault, &chaincfg.MainNetParams}, signParam); code != 500 {
+			t.Error("The signing without the wallet token should be rejected:", signParam.WALLETID, code)
+		}
+	}
+
+	replayParam, err = NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signParam := PSBTSIGNPARAM{WALLETID: vaultWalletID, WALLETTOKEN: walletToken, PSBT: unsigned, REPLAYPARAM: *replayParam}
+	if rsp, code = requestSecureChannel(t, &SignPSBTHandler{walletVault, &chaincfg.MainNetParams}, signParam); code != 200 || rsp["signedInputs"] != "0,1" {
+		t.Fatal("Sign PSBT by the vault wallet failed:", code, rsp["signedInputs"])
+	}
+
+	replayParam, err = NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp, code = requestSecureChannel(t, &SignPSBTHandler{net: &chaincfg.MainNetParams}, PSBTSIGNPARAM{SEED: keyParam.SEED, PSBT: unsigned, REPLAYPARAM: *replayParam})
+	if code != 200 {
+		t.Fatal("Sign PSBT failed, status:", code)
+	}
+	if rsp["signedInputs"] != "0,1" {
+		t.Fatal("Unexpected signed inputs:", rsp["signedInputs"])
+	}
+
+	packet, err := psbt.NewFromBase64(rsp["psbt"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevOuts, err := packet.PrevOuts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The P2WPKH signature is valid for the script engine
+	partialSigs := packet.Inputs[0].PartialSigs
+	if len(partialSigs) != 1 {
+		t.Fatal("Unexpected partial signatures:", len(partialSigs))
+	}
+	tx := packet.UnsignedTx.Copy()
+	tx.TxIn[0].Witness = wire.TxWitness{partialSigs[0].Signature, partialSigs[0].PubKey}
+	engine, err := txscript.NewEngine(prevOuts[0].PkScript, tx, 0, txscript.StandardVerifyFlags, nil,
+		txscript.NewTxSigHashes(tx), prevOuts[0].Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = engine.Execute(); err != nil {
+		t.Error("Invalid P2WPKH signature:", err)
+	}
+
+	if err = taproot.VerifyKeyPath(packet.UnsignedTx, 1, prevOuts, packet.Inputs[1].TaprootKeySpendSig); err != nil {
+		t.Error("Invalid P2TR signature:", err)
+	}
+
+	// The seed of another wallet signs nothing
+	replayParam, err = NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSeed := hex.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	rsp, code = requestSecureChannel(t, &SignPSBTHandler{net: &chaincfg.MainNetParams}, PSBTSIGNPARAM{SEED: otherSeed, PSBT: rsp["psbt"], REPLAYPARAM: *replayParam})
+	if code != 200 || rsp["signedInputs"] != "" {
+		t.Error("Unexpected signing of another wallet:", code, rsp["signedInputs"])
+	}
+}
+
+// requestPlaintext Send the plaintext json request to the handler and decode the response
+func requestPlaintext(t *testing.T, handler http.Handler, payload interface{}, rsp interface{}) int {
+	bytesData, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", "/", bytes.NewReader(bytesData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code == 200 {
+		err = json.Unmarshal(rr.Body.Bytes(), rsp)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rr.Code
+}
+
+// finalizeResponse the response of the V1/psbt/finalize API
+type finalizeResponse struct {
+	PSBT     string             `json:"psbt"`
+	Complete bool               `json:"complete"`
+	Inputs   []psbt.InputStatus `json:"inputs"`
+	Tx       string             `json:"tx"`
+	TxID     string             `json:"txid"`
+}
+
+func TestHTTPServerCombineFinalizePSBT(t *testing.T) {
+	seeds := []string{"a966eb6058f8ec9f47074a2faadd3dab42e2c60ed05bc34d39d6c0e1d32b8bdf", hex.EncodeToString(bytes.Repeat([]byte{1}, 32))}
+	var addrs []*btcutil.AddressPubKey
+	var derivations []PSBTDERIVATIONPARAM
+	for _, seed := range seeds {
+		keyParam := &BIP32PARAM{SEED: seed}
+		walletID, err := WalletFingerprint(keyParam)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdPubKey, err := GenerateHDPublicKey(keyParam, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubKey, err := ConvertPublicKey(hdPubKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr, err := btcutil.NewAddressPubKey(*pubKey, &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, addr)
+		derivations = append(derivations, PSBTDERIVATIONPARAM{PUBLICKEY: hex.EncodeToString(*pubKey), FINGERPRINT: walletID, PATH: "m/0'/0/0"})
+	}
+	witnessScript, err := txscript.MultiSigScript(addrs, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createParam := PSBTCREATEPARAM{
+		UTXOS: []PSBTUTXOPARAM{{TXID: "5e2383defe7efcbdc9fdd6dba55da148b206617bbb49e6bb93fce7bfbb459d44", VOUT: 0, VALUE: 30000,
+			WITNESSSCRIPT: hex.EncodeToString(witnessScript), DERIVATIONS: derivations}},
+		OUTPUTS: []PSBTOUTPUTPARAM{{ADDRESS: "347N1Thc213QqfYCz3PZkjoJpNv5b14kBd", VALUE: 25000}},
+		FEERATE: 2,
+	}
+	createRsp := make(map[string]string)
+	if code := requestPlaintext(t, &PSBTCreateHandler{net: &chaincfg.MainNetParams}, createParam, &createRsp); code != 200 {
+		t.Fatal("Create PSBT failed, status:", code)
+	}
+
+	var signed []string
+	for _, seed := range seeds {
+		replayParam, err := NewReplayParam()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp, code := requestSecureChannel(t, &SignPSBTHandler{net: &chaincfg.MainNetParams}, PSBTSIGNPARAM{SEED: seed, PSBT: createRsp["psbt"], REPLAYPARAM: *replayParam})
+		if code != 200 || rsp["signedInputs"] != "0" {
+			t.Fatal("Sign PSBT failed, status:", code, rsp["signedInputs"])
+		}
+		signed = append(signed, rsp["psbt"])
+	}
+
+	// A single cosigner signature isn't enough
+	var finalized finalizeResponse
+	if code := requestPlaintext(t, http.HandlerFunc(FinalizePSBT), PSBTFINALIZEPARAM{PSBT: signed[0]}, &finalized); code != 200 {
+		t.Fatal("Finalize PSBT failed, status:", code)
+	}
+	if finalized.Complete || finalized.Tx != "" || finalized.Inputs[0].Signatures != 1 ||
+		!strings.Contains(finalized.Inputs[0].Reason, derivations[1].PUBLICKEY) {
+		t.Error("Unexpected finalization of the single signature:", finalized.Inputs)
+	}
+
+	combineRsp := make(map[string]string)
+	if code := requestPlaintext(t, http.HandlerFunc(CombinePSBT), PSBTCOMBINEPARAM{PSBTS: signed}, &combineRsp); code != 200 {
+		t.Fatal("Combine PSBT failed, status:", code)
+	}
+
+	finalized = finalizeResponse{}
+	if code := requestPlaintext(t, http.HandlerFunc(FinalizePSBT), PSBTFINALIZEPARAM{PSBT: combineRsp["psbt"]}, &finalized); code != 200 {
+		t.Fatal("Finalize PSBT failed, status:", code)
+	}
+	if !finalized.Complete || finalized.TxID == "" {
+		t.Fatal("The combined psbt should be complete:", finalized.Inputs)
+	}
+
+	// The extracted transaction is valid for the script engine
+	data, err := hex.DecodeString(finalized.Tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := wire.NewMsgTx(wire.TxVersion)
+	if err = tx.Deserialize(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	pkScript := psbt.P2WSHScript(witnessScript)
+	engine, err := txscript.NewEngine(pkScript, tx, 0, txscript.StandardVerifyFlags, nil, txscript.NewTxSigHashes(tx), 30000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = engine.Execute(); err != nil {
+		t.Error("The multisig spend fails:", err)
+	}
+}
+
+func TestHTTPServerVerifyRedeemScript(t *testing.T) {
+	var privKeys []string
+	var addrs []*btcutil.AddressPubKey
+	for i := byte(1); i <= 3; i++ {
+		privKey, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), bytes.Repeat([]byte{i}, 32))
+		wif, err := btcutil.NewWIF(privKey, &chaincfg.MainNetParams, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		privKeys = append(privKeys, wif.String())
+		addr, err := btcutil.NewAddressPubKey(pubKey.SerializeCompressed(), &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, addr)
+	}
+	redeemScript, err := txscript.MultiSigScript(addrs, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The hex private key is accepted too
+	privKeys[2] = hex.EncodeToString(bytes.Repeat([]byte{3}, 32))
+	verifyParam := VERIFYSCRIPTPARAM{REDEEMSCRIPT: hex.EncodeToString(redeemScript), PRIVATEKEYS: privKeys[1:]}
+	var check psbt.ScriptCheck
+	if code := requestPlaintext(t, http.HandlerFunc(VerifyRedeemScript), verifyParam, &check); code != 200 {
+		t.Fatal("Verify redeem script failed, status:", code)
+	}
+	if !check.Passed || check.Type != psbt.InputP2SH || check.ScriptSigSize == 0 || check.Weight > check.EstimatedWeight {
+		t.Error("Unexpected check of the redeem script:", check)
+	}
+
+	verifyParam.TYPE = psbt.InputP2WSH
+	verifyParam.PRIVATEKEYS = privKeys[:1]
+	check = psbt.ScriptCheck{}
+	if code := requestPlaintext(t, http.HandlerFunc(VerifyRedeemScript), verifyParam, &check); code != 200 {
+		t.Fatal("Verify redeem script failed, status:", code)
+	}
+	if check.Passed || check.Signatures != 1 || check.Required != 2 {
+		t.Error("A single signature should fail:", check)
+	}
+
+	verifyParam.PRIVATEKEYS = []string{"not a key"}
+	if code := requestPlaintext(t, http.HandlerFunc(VerifyRedeemScript), verifyParam, &check); code != 500 {
+		t.Error("The invalid private key should be rejected, status:", code)
+	}
+}
@@ -3,62 +3,231 @@ package main
 import (
 	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/jayt106/bitcoinAddressGenerator/audit"
+	"github.com/jayt106/bitcoinAddressGenerator/bitcoind"
 	"github.com/jayt106/bitcoinAddressGenerator/cipher"
+	"github.com/jayt106/bitcoinAddressGenerator/keycache"
+	"github.com/jayt106/bitcoinAddressGenerator/utxoscan"
+	"github.com/jayt106/bitcoinAddressGenerator/vault"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 func main() {
 	// the ctr + c signal event handle
 	handleCtrlC()
 
-	log.Println("The server is running...")
+	keyStoreFile := flag.String("keystore", "serverKey.json", "the passphrase encrypted keystore file of the server channel keys")
+	identityKeyStoreFile := flag.String("identityKeystore", "serverIdentity.json", "the passphrase encrypted keystore file of the long-term server identity key")
+	keyGracePeriod := flag.Duration("keyGracePeriod", 72*time.Hour, "how long the previous server channel key is accepted after a key rotation")
+	legacyEnvelope := flag.Bool("legacyEnvelope", false, "accept the legacy btcec ECIES envelope (version 1) for the old clients")
+	replayWindow := flag.Duration("replayWindow", 2*time.Minute, "the accepted clock difference between the request timestamp and the server")
+	replayCacheSize := flag.Int("replayCacheSize", 100000, "the max number of the request nonces remembered for the replay protection")
+	vaultFile := flag.String("vault", "vault.json", "the vault file of the registered seeds")
+	auditLogFile := flag.String("auditLog", "audit.log", "the hash-chained audit log file of the issued keys and addresses")
+	network := flag.String("network", "mainnet", "the network of the generated, parsed and decoded addresses, mainnet, testnet3, regtest or signet")
+	rpcURL := flag.String("rpcURL", "", "the optional bitcoind RPC url (http://[user:password@]host:port) to import the issued addresses as watch-only")
+	rpcCookieFile := flag.String("rpcCookieFile", "", "the cookie file of the bitcoind RPC if the url has no user and password")
+	rpcWallet := flag.String("rpcWallet", "addressGenerator", "the watch-only descriptor wallet of the node, it's created if it doesn't exist")
+	utxoSnapshot := flag.String("utxoSnapshot", "", "the optional dumptxoutset snapshot or csv utxo export (.csv) of the account discovery")
+	vanityWorkers := flag.Int("vanityWorkers", runtime.NumCPU(), "the max goroutine workers of a vanity address search")
+	vanityTimeBudget := flag.Duration("vanityTimeBudget", 2*time.Minute, "the max time budget of a vanity address search")
+	keyCacheSize := flag.Int("keyCacheSize", 1000, "the max number of the cached account and chain public keys, 0 disables the key cache")
+	keyCacheTTL := flag.Duration("keyCacheTTL", 10*time.Minute, "how long a public key is kept in the key cache")
+	deriveWorkers := flag.Int("deriveWorkers", runtime.NumCPU(), "the goroutine workers of the range derivation")
+	deriveMaxCount := flag.Uint("deriveMaxCount", 10000, "the max number of the addresses of a range derivation request")
+	deriveStreamMaxCount := flag.Uint("deriveStreamMaxCount", 1000000, "the max number of the addresses of a streaming range derivation request")
+	tlsCert := flag.String("tlsCert", "", "the optional TLS certificate file, the server is served over TLS with -tlsKey")
+	tlsKey := flag.String("tlsKey", "", "the private key file of the TLS certificate")
+	maxBodySize := flag.Int64("maxBodySize", 1<<20, "the max size in bytes of a request body")
+	readTimeout := flag.Duration("readTimeout", 30*time.Second, "the max duration of reading a request, including the body")
+	writeTimeout := flag.Duration("writeTimeout", 5*time.Minute, "the max duration of handling a request and writing the response, it must be longer than -vanityTimeBudget")
+	idleTimeout := flag.Duration("idleTimeout", 2*time.Minute, "how long an idle keep-alive connection is kept")
+	flag.Parse()
+	if *writeTimeout <= *vanityTimeBudget {
+		log.Fatalln("The write timeout must be longer than the vanity time budget")
+	}
+
+	netParams, err := NetworkParams(*network)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// The passphrase is passed by the environment variable to avoid leaking it to the process list
+	passphrase := os.Getenv("KEYSTORE_PASSPHRASE")
+	if passphrase == "" {
+		log.Fatalln("Please set the keystore passphrase by the KEYSTORE_PASSPHRASE environment variable")
+	}
 
-	// Generate the key for the data encrypt/decrypt during the message passing
-	privKey, err := btcec.NewPrivateKey(btcec.S256())
+	// Load the keys for the data encrypt/decrypt during the message passing, the key is created at the first run
+	keyRing, err := cipher.LoadOrCreateKeyRing(*keyStoreFile, []byte(passphrase), *keyGracePeriod)
 	if err != nil {
-		panic(err)
+		log.Fatalln("Load the server channel key error:", err)
+	}
+
+	// Load the identity key signing the published channel keys, the clients pin its fingerprint
+	identityKey, err := cipher.LoadOrCreateIdentityKey(*identityKeyStoreFile, []byte(passphrase))
+	if err != nil {
+		log.Fatalln("Load the server identity key error:", err)
+	}
+
+	// Open the vault of the registered seeds, the seeds are encrypted by the master key derived from the passphrase
+	walletVault, err := vault.Open(*vaultFile, []byte(passphrase))
+	if err != nil {
+		log.Fatalln("Open the vault error:", err)
+	}
+
+	// Open the audit log, the server refuses to start if the existing hash chain is broken
+	auditLog, err := audit.Open(*auditLogFile)
+	if err != nil {
+		log.Fatalln("Open the audit log error:", err)
+	}
+
+	// Connect the node, the issued addresses are imported to its watch-only wallet
+	var node *bitcoind.Client
+	if *rpcURL != "" {
+		node, err = bitcoind.New(*rpcURL, *rpcCookieFile, *rpcWallet)
+		if err != nil {
+			log.Fatalln("Bitcoind RPC config error:", err)
+		}
+		err = node.LoadWallet()
+		if err != nil {
+			log.Fatalln("Load the bitcoind wallet error:", err)
+		}
+		log.Println("The issued addresses are imported to the bitcoind wallet", node.Wallet())
 	}
 
+	// Cache the account and chain public keys, the address keys of the same chain skip the master key derivation
+	var keyCache *keycache.Cache
+	if *keyCacheSize > 0 {
+		keyCache, err = keycache.New(*keyCacheSize, *keyCacheTTL)
+		if err != nil {
+			log.Fatalln("Key cache config error:", err)
+		}
+	}
+
+	log.Println("The server identity key fingerprint is", cipher.Fingerprint(identityKey.PubKey()))
+	log.Println("The server is running with the channel key", keyRing.Current().ID)
+
 	//Create the default mux
 	mux := http.NewServeMux()
 
+	//The middleware of every API, the body size limit, the method and content type checks and the panic recovery
+	mw := &Middleware{*maxBodySize}
+
 	//Handling the /v1/serverPublicKeys.
-	pubkh := &PubKeyHandler{privKey.PubKey()}
-	mux.Handle("/v1/serverPublicKeys", pubkh)
+	envelopeVersions := cipher.EnvelopeVersions(*legacyEnvelope)
+	pubkh := &PubKeyHandler{keyRing, identityKey, envelopeVersions}
+	mux.Handle("/v1/serverPublicKeys", mw.Handler(http.MethodGet, pubkh))
+
+	//The middleware of the encrypted APIs
+	replayGuard := cipher.NewReplayGuard(*replayWindow, *replayCacheSize)
+	channel := &SecureChannel{keyRing, envelopeVersions, replayGuard}
 
 	//Handling the /v1/genPublicKeyAndSegWitAddress.
-	privkh := &PrivKeyHandler{privKey}
-	mux.Handle("/v1/genPublicKeyAndSegWitAddress", privkh)
+	mux.Handle("/v1/genPublicKeyAndSegWitAddress", mw.Handler(http.MethodPost, channel.Handler(&HDKeyHandler{walletVault, auditLog, node, netParams, keyCache}, false)))
+
+	//Handling the /v1/wallets/register.
+	mux.Handle("/v1/wallets/register", mw.Handler(http.MethodPost, channel.Handler(&RegisterWalletHandler{walletVault}, false)))
+
+	//Handling the /v1/wallets/{id}/nextAddress.
+	mux.Handle("/v1/wallets/", mw.Handler(http.MethodPost, channel.Handler(&WalletsHandler{walletVault, auditLog, node, netParams, keyCache}, false)))
+
+	//Handling the /v1/signMessage.
+	mux.Handle("/v1/signMessage", mw.Handler(http.MethodPost, channel.Handler(&SignMessageHandler{walletVault, auditLog, netParams}, false)))
+
+	//Handling the /v1/verifyMessage, the request has no secret and is plaintext
+	mux.Handle("/v1/verifyMessage", mw.Handler(http.MethodPost, &VerifyMessageHandler{netParams}))
+
+	//Handling the /v1/admin/rotateServerKey, it is only enabled when the admin token is set
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken != "" {
+		mux.Handle("/v1/admin/rotateServerKey", mw.Handler(http.MethodPost, &RotateKeyHandler{keyRing, adminToken}))
+	}
+
+	//Handling the /v1/genMultiSigP2SH address, the plaintext request is still accepted
+	mux.Handle("/v1/genMultiSigP2SHAddress", mw.Handler(http.MethodPost, channel.Handler(&MultiSigHandler{auditLog, node, netParams}, true)))
+
+	//Handling the /v1/psbt/create, the plaintext request is accepted when every public key is given
+	mux.Handle("/v1/psbt/create", mw.Handler(http.MethodPost, channel.Handler(&PSBTCreateHandler{walletVault, auditLog, netParams}, true)))
+
+	//Handling the /v1/psbt/sign.
+	mux.Handle("/v1/psbt/sign", mw.Handler(http.MethodPost, channel.Handler(&SignPSBTHandler{walletVault, netParams}, false)))
+
+	//Handling the /v1/psbt/combine and /v1/psbt/finalize, the request has no secret and the plaintext request is accepted
+	mux.Handle("/v1/psbt/combine", mw.Handler(http.MethodPost, channel.Handler(http.HandlerFunc(CombinePSBT), true)))
+	mux.Handle("/v1/psbt/finalize", mw.Handler(http.MethodPost, channel.Handler(http.HandlerFunc(FinalizePSBT), true)))
+
+	//Handling the /v1/buildTransaction, the change address is allocated from the vault wallet
+	mux.Handle("/v1/buildTransaction", mw.Handler(http.MethodPost, channel.Handler(&BuildTransactionHandler{walletVault, auditLog, netParams, keyCache}, false)))
+
+	//Handling the /v1/sweep, the request has the WIF private keys and must be encrypted
+	mux.Handle("/v1/sweep", mw.Handler(http.MethodPost, channel.Handler(&SweepHandler{walletVault, auditLog, netParams, keyCache}, false)))
 
-	//Handling the /v1/genMultiSigP2SH address
-	mux.HandleFunc("/v1/genMultiSigP2SHAddress", GenMultiSigP2SHAddress)
+	//Handling the /v1/verifyRedeemScript, the request has the private keys and must be encrypted
+	mux.Handle("/v1/verifyRedeemScript", mw.Handler(http.MethodPost, channel.Handler(http.HandlerFunc(VerifyRedeemScript), false)))
+
+	//Handling the /v1/decodeTransaction and /v1/decodePSBT, the plaintext request is accepted
+	mux.Handle("/v1/decodeTransaction", mw.Handler(http.MethodPost, channel.Handler(&DecodeTransactionHandler{netParams}, true)))
+	mux.Handle("/v1/decodePSBT", mw.Handler(http.MethodPost, channel.Handler(&DecodePSBTHandler{netParams}, true)))
+
+	//Handling the /v1/discoverAccounts, the request has the seed and must be encrypted
+	var snapshot utxoscan.Reader
+	if *utxoSnapshot != "" {
+		snapshot = utxoscan.FileReader(*utxoSnapshot, netParams)
+	}
+	mux.Handle("/v1/discoverAccounts", mw.Handler(http.MethodPost, channel.Handler(&DiscoverAccountsHandler{walletVault, auditLog, node, snapshot, netParams}, false)))
+
+	//Handling the /v1/deriveRange, the request has the seed and must be encrypted
+	mux.Handle("/v1/deriveRange", mw.Handler(http.MethodPost, channel.Handler(&DeriveRangeHandler{walletVault, auditLog, *deriveWorkers, uint32(*deriveMaxCount), false, 0, netParams, keyCache}, false)))
+
+	//Handling the /v1/deriveRange/stream, the request must be encrypted or over TLS
+	mux.Handle("/v1/deriveRange/stream", mw.Handler(http.MethodPost, channel.Handler(&DeriveRangeHandler{walletVault, auditLog, *deriveWorkers, uint32(*deriveStreamMaxCount), true, *writeTimeout, netParams, keyCache}, true)))
+
+	//Handling the /v1/vanity, the request has the seed and the response may have the private key, it must be encrypted
+	mux.Handle("/v1/vanity", mw.Handler(http.MethodPost, channel.Handler(NewVanityHandler(walletVault, auditLog, *vanityWorkers, *vanityTimeBudget, netParams, keyCache), false)))
+
+	//Handling the /v1/addressStatus, it is only enabled with the bitcoind RPC and the plaintext request is accepted
+	if node != nil {
+		mux.Handle("/v1/addressStatus", mw.Handler(http.MethodPost, channel.Handler(&AddressStatusHandler{node, netParams}, true)))
+	}
 
 	//Create the http server.
 	s := &http.Server{
-		Addr:    ":8080",
-		Handler: mux,
+		Addr:              ":8080",
+		Handler:           mux,
+		ReadHeaderTimeout: *readTimeout,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
 	}
 
 	// Start the server
-	err = s.ListenAndServe()
+	if *tlsCert != "" || *tlsKey != "" {
+		err = s.ListenAndServeTLS(*tlsCert, *tlsKey)
+	} else {
+		err = s.ListenAndServe()
+	}
 	if err != nil {
 		log.Println(err)
 	}
 }
 
 func handleCtrlC() {
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
@@ -74,14 +243,40 @@ func shutdown() {
 
 // PubKeyHandler the handler uses for passing this struct into the ServerHTTP function
 type PubKeyHandler struct {
-	pubKey *btcec.PublicKey
+	keyRing          *cipher.KeyRing
+	identityKey      *btcec.PrivateKey
+	envelopeVersions []int
 }
 
-// ServeHTTP handle the V1/serverPublicKeys API request. Return the server public key for encrypting the client data
+// ServeHTTP handle the V1/serverPublicKeys API request. Return the current server public key for encrypting the client data.
+// The key, key id and expiry are signed by the server identity key, the client verifies the signature by the identity
+// key fingerprint distributed out of band to prevent the key being swapped by a man in the middle.
 func (ph *PubKeyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Println("Handle API /v1/serverPublicKeys")
+	key := ph.keyRing.Current()
 	resp := make(map[string]string)
-	resp["publicKey"] = hex.EncodeToString(ph.pubKey.SerializeCompressed())
+	resp["publicKey"] = hex.EncodeToString(key.PrivKey.PubKey().SerializeCompressed())
+	resp["keyId"] = key.ID
+	resp["expiresAt"] = ""
+	if key.ExpiresAt != nil {
+		resp["expiresAt"] = key.ExpiresAt.Format(time.RFC3339)
+	}
+	resp["envelopeVersions"] = cipher.FormatEnvelopeVersions(ph.envelopeVersions)
+	resp["identityKey"] = hex.EncodeToString(ph.identityKey.PubKey().SerializeCompressed())
+
+	announcement := &cipher.ChannelKeyAnnouncement{
+		PublicKey:        resp["publicKey"],
+		KeyID:            resp["keyId"],
+		ExpiresAt:        resp["expiresAt"],
+		EnvelopeVersions: resp["envelopeVersions"],
+	}
+	signature, err := cipher.SignChannelKey(ph.identityKey, announcement)
+	if err != nil {
+		ServerErrorHandle(w, err, "Sign channel key error:")
+		return
+	}
+	resp["signature"] = signature
+
 	jsonResp, err := json.Marshal(resp)
 	if err != nil {
 		log.Println("Json Marshal error:", err)
@@ -97,15 +292,20 @@ func (ph *PubKeyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-type PrivKeyHandler struct {
-	privKey *btcec.PrivateKey
+// HDKeyHandler the handler uses for passing this struct into the ServerHTTP function
+type HDKeyHandler struct {
+	vault    *vault.Vault
+	auditLog *audit.Log
+	node     *bitcoind.Client
+	net      *chaincfg.Params
+	keyCache *keycache.Cache
 }
 
-// ServeHTTP handle the V1/genPublicKeyAndSegWitAddress API request.
-// The http client send the seed, path and the public key(for the return message encryption) encrypted by the server's public key
-// (See V1/serverPublicKeys API). This function decrypted the message by the server's private key, generate the HD key base on the
-// seed and the path and return the public key and the SegWit address encrypted by the client's public key.
-func (ph *PrivKeyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+// ServeHTTP handle the V1/genPublicKeyAndSegWitAddress API request behind the SecureChannel middleware.
+// The http client send the seed (or the wallet id of the seed registered in the vault) and the path encrypted by the server's
+// public key, generate the HD key base on the seed and the path and return the public key and the SegWit address encrypted
+// by the client's public key. The issued address is recorded in the audit log before the response.
+func (hh *HDKeyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Println("Handle API /v1/genPublicKeyAndSegWitAddress")
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -113,41 +313,31 @@ func (ph *PrivKeyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	msgParam := make(map[string]string)
-	err = json.Unmarshal(body, &msgParam)
-	if err != nil {
-		ServerErrorHandle(w, err, "Json unmarshal error:")
-		return
-	}
-
-	cipherBytes, err := hex.DecodeString(msgParam["data"])
+	var keyParam BIP32PARAM
+	err = json.Unmarshal(body, &keyParam)
+	Clear(&body)
 	if err != nil {
-		ServerErrorHandle(w, err, "Hex decode string error:")
+		ServerErrorHandle(w, err, "Unmarshal data error:")
 		return
 	}
 
-	plainBytes, err := cipher.MessageDecrypt(ph.privKey, &cipherBytes)
+	err = ResolveSeed(hh.vault, &keyParam)
 	if err != nil {
-		ServerErrorHandle(w, err, "Decrypt data error:")
+		Clear(&keyParam)
+		ServerErrorHandle(w, err, "Resolve wallet seed error:")
 		return
 	}
 
-	slice := *plainBytes
-	clientCipherPublicKey := slice[:btcec.PubKeyBytesLenCompressed]
-	keyPath := slice[btcec.PubKeyBytesLenCompressed:]
-	Clear(plainBytes)
-	Clear(&slice)
-
-	var keyParam BIP32PARAM
-	err = json.Unmarshal(keyPath, &keyParam)
-	Clear(&keyPath)
+	walletID, err := WalletFingerprint(&keyParam)
 	if err != nil {
-		ServerErrorHandle(w, err, "Unmarshal data error:")
+		Clear(&keyParam)
+		ServerErrorHandle(w, err, "Wallet fingerprint error:")
 		return
 	}
+	path := keyParam.PATH.String()
 
 	// Generate a HD key chain (Bitcoin mainnet) using the seed.
-	clientHDPubKey, err := GenerateHDPublicKey(&keyParam)
+	clientHDPubKey, err := GenerateHDPublicKey(&keyParam, hh.keyCache)
 	Clear(&keyParam)
 	if err != nil {
 		ServerErrorHandle(w, err, "Generate HD public key failed:")
@@ -160,7 +350,7 @@ func (ph *PrivKeyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	segwitAddress, err := GenerateSegwitAddress(compressedPubKey)
+	segwitAddress, err := GenerateSegwitAddress(compressedPubKey, hh.net)
 	if err != nil {
 		ServerErrorHandle(w, err, "Generate segwit address failed:")
 		return
@@ -170,27 +360,32 @@ func (ph *PrivKeyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	resp["publicKey"] = hex.EncodeToString(*compressedPubKey)
 	resp["segwitAddress"] = *segwitAddress
 
-	marshalledData, err := json.Marshal(resp)
+	err = hh.auditLog.Append(audit.Entry{
+		Endpoint:  "/v1/genPublicKeyAndSegWitAddress",
+		WalletID:  walletID,
+		Path:      path,
+		Address:   *segwitAddress,
+		PublicKey: resp["publicKey"],
+		Requester: Requester(r),
+	})
 	if err != nil {
-		ServerErrorHandle(w, err, "Json Marshal error:")
+		ServerErrorHandle(w, err, "Audit log error:")
 		return
 	}
 
-	pubKey, err := btcec.ParsePubKey(clientCipherPublicKey, btcec.S256())
-	if err != nil {
-		ServerErrorHandle(w, err, "ParsePubKey error:")
-		return
+	if state := WatchAddress(hh.node, bitcoind.WPKHDescriptor(walletID, path, *compressedPubKey), ""); state != "" {
+		resp["watchOnly"] = state
 	}
 
-	cipherText, err := cipher.MessageEncrypt(pubKey, &marshalledData)
+	marshalledData, err := json.Marshal(resp)
 	if err != nil {
-		ServerErrorHandle(w, err, "MessageEncrypt error:")
+		ServerErrorHandle(w, err, "Json Marshal error:")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
-	_, err = w.Write(*cipherText)
+	_, err = w.Write(marshalledData)
 	if err != nil {
 		log.Println("ServeHTTP write error:", err)
 	}
@@ -203,21 +398,144 @@ func ServerErrorHandle(w http.ResponseWriter, e error, s string) {
 	w.WriteHeader(500)
 }
 
-// GenerateHDPublicKey Generate a bitcoin mainnet HD public key given the seed and path following by BIP032
-func GenerateHDPublicKey(p *BIP32PARAM) (*hdkeychain.ExtendedKey, error){
-	seed, err := hex.DecodeString(p.SEED)
+// ReplayErrorHandle Handle the response message when the request is rejected by the replay protection, the error code
+// REPLAYED_REQUEST (409) tells the client the nonce has been used, STALE_REQUEST (400) the timestamp is out of the window
+// and REPLAY_CACHE_FULL (503) the request can be retried when the oldest nonces expire
+func ReplayErrorHandle(w http.ResponseWriter, e error) {
+	log.Println("Replay protection error:", e)
+	if e == cipher.ErrReplayedRequest {
+		ErrorCodeHandle(w, http.StatusConflict, "REPLAYED_REQUEST", e)
+		return
+	}
+	if e == cipher.ErrReplayCacheFull {
+		ErrorCodeHandle(w, http.StatusServiceUnavailable, "REPLAY_CACHE_FULL", e)
+		return
+	}
+	ErrorCodeHandle(w, http.StatusBadRequest, "STALE_REQUEST", e)
+}
+
+// ErrorCodeHandle Handle the response message of the rejected request, the json response has the error code and the
+// error for the client
+func ErrorCodeHandle(w http.ResponseWriter, status int, errorCode string, e error) {
+	resp := map[string]string{"errorCode": errorCode, "error": e.Error()}
+	jsonResp, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Json Marshal error:", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(jsonResp)
+	if err != nil {
+		log.Println("ServeHTTP write error:", err)
+	}
+}
+
+// GenerateHDPublicKey Generate a bitcoin mainnet HD public key given the seed and path following by BIP032, the address
+// key is derived from the neutered chain key of the key cache, the nil cache derives it from the seed
+func GenerateHDPublicKey(p *BIP32PARAM, cache *keycache.Cache) (*hdkeychain.ExtendedKey, error){
+	var clientHDPubKey *hdkeychain.ExtendedKey
+	err := usePublicKey(p, cache, true, func(chainPubKey *hdkeychain.ExtendedKey) error {
+		var err error
+		clientHDPubKey, err = chainPubKey.Derive(p.PATH.ADDRESS)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	clientMasterKey, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
-	Clear(&seed)
+	return clientHDPubKey, nil
+}
+
+// GenerateAccountPublicKey Generate the bitcoin mainnet HD public key of the account given the seed and the account of
+// the path, m/account', the key is taken from the key cache if any
+func GenerateAccountPublicKey(p *BIP32PARAM, cache *keycache.Cache) (*hdkeychain.ExtendedKey, error) {
+	var accPubKey *hdkeychain.ExtendedKey
+	err := usePublicKey(p, cache, false, func(key *hdkeychain.ExtendedKey) error {
+		var err error
+		accPubKey, err = hdkeychain.NewKeyFromString(key.String())
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	accKey, err := clientMasterKey.Derive(hdkeychain.HardenedKeyStart + p.PATH.ACCOUNT)
-	Clear(&clientMasterKey)
+	return accPubKey, nil
+}
+
+// GenerateChainPublicKey Generate the bitcoin mainnet HD public key of the chain given the seed and the account and
+// chain of the path, m/account'/chain, the key is taken from the key cache if any
+func GenerateChainPublicKey(p *BIP32PARAM, cache *keycache.Cache) (*hdkeychain.ExtendedKey, error) {
+	var chainPubKey *hdkeychain.ExtendedKey
+	err := usePublicKey(p, cache, true, func(key *hdkeychain.ExtendedKey) error {
+		var err error
+		chainPubKey, err = hdkeychain.NewKeyFromString(key.String())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return chainPubKey, nil
+}
+
+// usePublicKey Run fn with the neutered account key m/account' of the seed, or the chain key m/account'/chain. The key
+// is taken from the key cache, or derived from the seed and added to the cache (with the account key of the chain key)
+// after fn. The nil cache always derives the key from the seed. The key is only valid within fn.
+func usePublicKey(p *BIP32PARAM, cache *keycache.Cache, chain bool, fn func(*hdkeychain.ExtendedKey) error) error {
+	accountPath := fmt.Sprintf("m/%d'", p.PATH.ACCOUNT)
+	path := accountPath
+	if chain {
+		path = fmt.Sprintf("%s/%d", accountPath, p.PATH.CHAIN)
+	}
+
+	var seed []byte
+	if cache != nil {
+		var err error
+		seed, err = hex.DecodeString(p.SEED)
+		if err != nil {
+			return err
+		}
+		defer Clear(&seed)
+		ok, err := cache.Use(cache.ID(seed, path), fn)
+		if ok {
+			return err
+		}
+	}
+
+	accKey, err := generateAccountKey(p)
+	if err != nil {
+		return err
+	}
+	accPubKey, err := accKey.Neuter()
+	Clear(&accKey)
+	if err != nil {
+		return err
+	}
+	pubKey := accPubKey
+	if chain {
+		pubKey, err = accPubKey.Derive(p.PATH.CHAIN)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = fn(pubKey)
+	if err != nil || cache == nil {
+		return err
+	}
+	if chain {
+		err = cache.Add(cache.ID(seed, accountPath), accPubKey)
+		if err != nil {
+			return err
+		}
+	}
+	return cache.Add(cache.ID(seed, path), pubKey)
+}
+
+// GenerateHDKey Generate a bitcoin mainnet HD private key given the seed and path, m/account'/chain/address
+func GenerateHDKey(p *BIP32PARAM) (*hdkeychain.ExtendedKey, error) {
+	accKey, err := generateAccountKey(p)
 	if err != nil {
 		return nil, err
 	}
@@ -232,16 +550,41 @@ func GenerateHDPublicKey(p *BIP32PARAM) (*hdkeychain.ExtendedKey, error){
 		return nil, err
 	}
 
-	clientHDPubKey, err := addressKey.Neuter()
+	return addressKey, nil
+}
+
+// generateAccountKey Generate the bitcoin mainnet HD private key of the account given the seed, m/account'
+func generateAccountKey(p *BIP32PARAM) (*hdkeychain.ExtendedKey, error) {
+	seed, err := hex.DecodeString(p.SEED)
 	if err != nil {
 		return nil, err
 	}
 
-	return clientHDPubKey, nil
+	clientMasterKey, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	Clear(&seed)
+	if err != nil {
+		return nil, err
+	}
+
+	accKey, err := clientMasterKey.Derive(hdkeychain.HardenedKeyStart + p.PATH.ACCOUNT)
+	Clear(&clientMasterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return accKey, nil
+}
+
+// MultiSigHandler the handler uses for passing this struct into the ServerHTTP function
+type MultiSigHandler struct {
+	auditLog *audit.Log
+	node     *bitcoind.Client
+	net      *chaincfg.Params
 }
 
-// HandleMultiSigP2SHAddress a handle function to genarate the n-out-of-m MultiSig P2SH bitcoin Address
-func GenMultiSigP2SHAddress(w http.ResponseWriter, r *http.Request)  {
+// ServeHTTP handle the V1/genMultiSigP2SHAddress API request to genarate the n-out-of-m MultiSig P2SH bitcoin Address
+// The request is plaintext json or encrypted by the SecureChannel middleware, the address is recorded in the audit log
+func (mh *MultiSigHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Println("Handle API /v1/genMultiSigP2SHAddress")
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -249,28 +592,28 @@ func GenMultiSigP2SHAddress(w http.ResponseWriter, r *http.Request)  {
 		return
 	}
 
-	var msgParam map[string]string
+	var msgParam MULTISIGPARAM
 	err = json.Unmarshal(body, &msgParam)
 	if err != nil {
 		ServerErrorHandle(w, err, "Json unmarshal error:")
 		return
 	}
 
-	n, err := strconv.ParseInt(msgParam["n"], 10, 32)
+	n, err := strconv.ParseInt(msgParam.N, 10, 32)
 	if err != nil {
 		ServerErrorHandle(w, err, "The argument n parsing error:")
 		return
 	}
-	m, err := strconv.ParseInt(msgParam["m"], 10, 32)
+	m, err := strconv.ParseInt(msgParam.M, 10, 32)
 	if err != nil {
 		ServerErrorHandle(w, err, "The argument m parsing error:")
 		return
 	}
-	publicKeys := msgParam["publicKeys"]
+	publicKeys := msgParam.PUBLICKEYS
 
 	// the client input requirement is n-of-m multisig. Therefore, the order of the param for calling the following function
 	// need to be careful
-	P2SHAddress, redeemScriptHex, err := cipher.OutputAddress(int(n), int(m), publicKeys)
+	P2SHAddress, redeemScriptHex, err := cipher.OutputAddress(int(n), int(m), publicKeys, mh.net)
 
 	resp := make(map[string]string)
 	if err != nil {
@@ -285,6 +628,23 @@ func GenMultiSigP2SHAddress(w http.ResponseWriter, r *http.Request)  {
 	resp["ps2hAddress"] = P2SHAddress
 	resp["redeemScriptHex"] = redeemScriptHex
 
+	err = mh.auditLog.Append(audit.Entry{
+		Endpoint:  "/v1/genMultiSigP2SHAddress",
+		Path:      msgParam.N + "-of-" + msgParam.M,
+		Address:   P2SHAddress,
+		PublicKey: publicKeys,
+		Requester: Requester(r),
+	})
+	if err != nil {
+		ServerErrorHandle(w, err, "Audit log error:")
+		return
+	}
+
+	// The node watches the P2SH address without the redeem script
+	if state := WatchAddress(mh.node, bitcoind.AddrDescriptor(P2SHAddress), ""); state != "" {
+		resp["watchOnly"] = state
+	}
+
 	marshalledData, err := json.Marshal(resp)
 	if err != nil {
 		log.Println("Json Marshal error:", err)
@@ -4,26 +4,34 @@ import (
 	"bytes"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/jayt106/bitcoinAddressGenerator/cipher"
+	"github.com/jayt106/bitcoinAddressGenerator/keycache"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
 	"testing"
+	"time"
 )
 
 var privKey, _ = btcec.NewPrivateKey(btcec.S256())
+var keyRing = cipher.NewKeyRing(privKey, time.Hour)
+var keyID = cipher.KeyID(privKey.PubKey())
+var identityKey, _ = btcec.NewPrivateKey(btcec.S256())
+var replayGuard = cipher.NewReplayGuard(time.Minute, 100)
 
 func GetServerPublicKey() (*btcec.PublicKey, error) {
-	pubkh := &PubKeyHandler{privKey.PubKey()}
+	pubkh := &PubKeyHandler{keyRing, identityKey, cipher.EnvelopeVersions(false)}
 	resp, err := http.NewRequest("GET", "v1/serverPublicKeys", nil)
 	if err != nil {
 		return nil, err
 	}
 
 	rr := httptest.NewRecorder()
-	http.Handle("/v1/serverPublicKeys", pubkh)
 	pubkh.ServeHTTP(rr, resp)
 
 	body, err := ioutil.ReadAll(rr.Body)
@@ -37,6 +45,29 @@ func GetServerPublicKey() (*btcec.PublicKey, error) {
 		return nil, err
 	}
 
+	if rsp["keyId"] != keyID {
+		return nil, errors.New("unmatched server key id")
+	}
+
+	if rsp["identityKey"] != hex.EncodeToString(identityKey.PubKey().SerializeCompressed()) {
+		return nil, errors.New("unmatched server identity key")
+	}
+
+	if rsp["envelopeVersions"] != "2" {
+		return nil, errors.New("unmatched envelope versions")
+	}
+
+	announcement := &cipher.ChannelKeyAnnouncement{
+		PublicKey:        rsp["publicKey"],
+		KeyID:            rsp["keyId"],
+		ExpiresAt:        rsp["expiresAt"],
+		EnvelopeVersions: rsp["envelopeVersions"],
+	}
+	err = cipher.VerifyChannelKey(identityKey.PubKey(), announcement, rsp["signature"])
+	if err != nil {
+		return nil, err
+	}
+
 	channelPubKeyServerString := rsp["publicKey"]
 	bs, err := hex.DecodeString(channelPubKeyServerString)
 	if err != nil {
@@ -52,7 +83,9 @@ func GetServerPublicKey() (*btcec.PublicKey, error) {
 	return pubKey, err
 }
 
-func TestHTTPServerGenPublicKeyAndSegWitAddress(t *testing.T) {
+// requestGenPublicKeyAndSegWitAddress Send the test seed to the handler accepting the envelope versions, encrypted by the version.
+// A fresh replay param is used if replayParam is nil.
+func requestGenPublicKeyAndSegWitAddress(t *testing.T, version int, envelopeVersions []int, replayParam *REPLAYPARAM) (map[string]string, int) {
 	serverPubECKey, err := GetServerPublicKey()
 	if err != nil {
 		t.Error(err)
@@ -70,6 +103,14 @@ func TestHTTPServerGenPublicKeyAndSegWitAddress(t *testing.T) {
 		t.Error(err)
 	}
 
+	if replayParam == nil {
+		replayParam, err = NewReplayParam()
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	keyParam.REPLAYPARAM = *replayParam
+
 	marshalledData, err := json.Marshal(keyParam)
 	if err != nil {
 		t.Error(err)
@@ -83,12 +124,14 @@ func TestHTTPServerGenPublicKeyAndSegWitAddress(t *testing.T) {
 	var slice []byte
 	slice = append(channelPrivKeyClient.PubKey().SerializeCompressed(), marshalledData...)
 
-	ciphertext, err := cipher.MessageEncrypt(serverPubECKey, &slice)
+	ciphertext, err := cipher.MessageEncryptVersion(version, serverPubECKey, &slice)
 	if err != nil {
 		t.Error(err)
 	}
 
 	data := make(map[string]string)
+	data["keyId"] = keyID
+	data["version"] = strconv.Itoa(version)
 	data["data"] = hex.EncodeToString(*ciphertext)
 	bytesData, err := json.Marshal(data)
 	if err != nil {
@@ -100,17 +143,19 @@ func TestHTTPServerGenPublicKeyAndSegWitAddress(t *testing.T) {
 		t.Error(err)
 	}
 
-	privkh := &PrivKeyHandler{privKey}
-	http.Handle("v1/genPublicKeyAndSegWitAddress", privkh)
+	channel := &SecureChannel{keyRing, envelopeVersions, replayGuard}
 	rr := httptest.NewRecorder()
-	privkh.ServeHTTP(rr, req)
+	channel.Handler(&HDKeyHandler{net: &chaincfg.MainNetParams}, false).ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		return nil, rr.Code
+	}
 
 	body, err := ioutil.ReadAll(rr.Body)
 	if err != nil {
 		t.Error(err)
 	}
 
-	plaintext, err := cipher.MessageDecrypt(channelPrivKeyClient, &body)
+	plaintext, err := cipher.MessageDecryptVersion(version, channelPrivKeyClient, &body)
 	if err != nil {
 		t.Error(err)
 	}
@@ -121,6 +166,15 @@ func TestHTTPServerGenPublicKeyAndSegWitAddress(t *testing.T) {
 		t.Error(err)
 	}
 
+	return rsp, rr.Code
+}
+
+func TestHTTPServerGenPublicKeyAndSegWitAddress(t *testing.T) {
+	rsp, code := requestGenPublicKeyAndSegWitAddress(t, cipher.EnvelopeV2, cipher.EnvelopeVersions(false), nil)
+	if code != 200 {
+		t.Fatal("Unexpected status:", code)
+	}
+
 	publicKey := rsp["publicKey"]
 	segwitAddress := rsp["segwitAddress"]
 
@@ -133,6 +187,72 @@ func TestHTTPServerGenPublicKeyAndSegWitAddress(t *testing.T) {
 	}
 }
 
+func TestHTTPServerLegacyEnvelope(t *testing.T) {
+	rsp, code := requestGenPublicKeyAndSegWitAddress(t, cipher.EnvelopeLegacy, cipher.EnvelopeVersions(true), nil)
+	if code != 200 {
+		t.Fatal("The legacy envelope should be accepted in the compatibility mode, status:", code)
+	}
+
+	if rsp["segwitAddress"] != "bc1q8c87x4v0m3dfrxksv724rtwpxy5ghpw8gwf8da" {
+		t.Error("Unmatched segwitAddress")
+	}
+
+	_, code = requestGenPublicKeyAndSegWitAddress(t, cipher.EnvelopeLegacy, cipher.EnvelopeVersions(false), nil)
+	if code != 500 {
+		t.Error("The legacy envelope should be rejected without the compatibility mode, status:", code)
+	}
+}
+
+func TestHTTPServerReplayedRequest(t *testing.T) {
+	replayParam, err := NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, code := requestGenPublicKeyAndSegWitAddress(t, cipher.EnvelopeV2, cipher.EnvelopeVersions(false), replayParam)
+	if code != 200 {
+		t.Fatal("Unexpected status:", code)
+	}
+
+	_, code = requestGenPublicKeyAndSegWitAddress(t, cipher.EnvelopeV2, cipher.EnvelopeVersions(false), replayParam)
+	if code != http.StatusConflict {
+		t.Error("The replayed request should be rejected, status:", code)
+	}
+
+	replayParam, err = NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayParam.TIMESTAMP -= 3600
+	_, code = requestGenPublicKeyAndSegWitAddress(t, cipher.EnvelopeV2, cipher.EnvelopeVersions(false), replayParam)
+	if code != http.StatusBadRequest {
+		t.Error("The stale request should be rejected, status:", code)
+	}
+}
+
+func TestHTTPServerUnknownKeyID(t *testing.T) {
+	data := make(map[string]string)
+	data["keyId"] = "00000000"
+	data["data"] = ""
+	bytesData, err := json.Marshal(data)
+	if err != nil {
+		t.Error(err)
+	}
+
+	req, err := http.NewRequest("POST", "v1/genPublicKeyAndSegWitAddress", bytes.NewReader(bytesData))
+	if err != nil {
+		t.Error(err)
+	}
+
+	channel := &SecureChannel{keyRing, cipher.EnvelopeVersions(false), replayGuard}
+	rr := httptest.NewRecorder()
+	channel.Handler(&HDKeyHandler{net: &chaincfg.MainNetParams}, false).ServeHTTP(rr, req)
+
+	if rr.Code != 500 {
+		t.Error("The request with an unknown key id should be rejected, status:", rr.Code)
+	}
+}
+
 func TestGenerateSegwitAddress(t *testing.T) {
 	publickey := "0279BE667EF9DCBBAC55A06295CE870B07029BFCDB2DCE28D959F2815B16F81798"
 
@@ -141,7 +261,7 @@ func TestGenerateSegwitAddress(t *testing.T) {
 		t.Error(err)
 	}
 
-	segwitAddress, err := GenerateSegwitAddress(&keyBytes)
+	segwitAddress, err := GenerateSegwitAddress(&keyBytes, &chaincfg.MainNetParams)
 	if err != nil {
 		t.Error(err)
 	}
@@ -149,6 +269,113 @@ func TestGenerateSegwitAddress(t *testing.T) {
 	if *segwitAddress != "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4" {
 		t.Error("Unmatched segwit address")
 	}
+
+	segwitAddress, err = GenerateSegwitAddress(&keyBytes, &chaincfg.TestNet3Params)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if *segwitAddress != "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx" {
+		t.Error("Unmatched testnet segwit address")
+	}
+}
+
+func TestGenerateHDPublicKeyCache(t *testing.T) {
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filePath = workingDir + "/../test/test.json"
+	keyParam, err := ReadSeedFromJsonFile(&filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The keys derived without the cache
+	var xpubs []string
+	for _, path := range []KEYPATH{{0, 0, 0}, {0, 0, 7}, {0, 1, 7}, {3, 0, 1}} {
+		keyParam.PATH = path
+		hdPubKey, err := GenerateHDPublicKey(keyParam, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		xpubs = append(xpubs, hdPubKey.String())
+	}
+	accPubKey, err := GenerateAccountPublicKey(keyParam, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyCache, err := keycache.New(10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for round := 0; round < 2; round++ {
+		for i, path := range []KEYPATH{{0, 0, 0}, {0, 0, 7}, {0, 1, 7}, {3, 0, 1}} {
+			keyParam.PATH = path
+			hdPubKey, err := GenerateHDPublicKey(keyParam, keyCache)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdPubKey.String() != xpubs[i] {
+				t.Error("The cached key derives a different key:", path)
+			}
+		}
+	}
+	cachedAccPubKey, err := GenerateAccountPublicKey(keyParam, keyCache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cachedAccPubKey.String() != accPubKey.String() {
+		t.Error("Unexpected cached account key")
+	}
+
+	// The chain keys m/0'/0, m/0'/1 and m/3'/0 and the account keys m/0' and m/3' are cached at the first round
+	if stats := keyCache.Stats(); stats.Entries != 5 || stats.Misses != 3 || stats.Hits != 6 {
+		t.Error("Unexpected key cache stats:", stats)
+	}
+
+	// The returned key isn't zeroed by the eviction
+	keyCache.Purge()
+	if cachedAccPubKey.String() != accPubKey.String() {
+		t.Error("The returned key should be a copy of the cached key")
+	}
+}
+
+// benchmarkGenerateHDPublicKey Derive the addresses of the chain with or without the key cache
+func benchmarkGenerateHDPublicKey(b *testing.B, cached bool) {
+	workingDir, err := os.Getwd()
+	if err != nil {
+		b.Fatal(err)
+	}
+	var filePath = workingDir + "/../test/test.json"
+	keyParam, err := ReadSeedFromJsonFile(&filePath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var keyCache *keycache.Cache
+	if cached {
+		keyCache, err = keycache.New(1000, time.Hour)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		keyParam.PATH.ADDRESS = uint32(i)
+		if _, err = GenerateHDPublicKey(keyParam, keyCache); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGenerateHDPublicKey(b *testing.B) {
+	benchmarkGenerateHDPublicKey(b, false)
+}
+
+func BenchmarkGenerateHDPublicKeyCached(b *testing.B) {
+	benchmarkGenerateHDPublicKey(b, true)
 }
 
 func TestHTTPServerGenMultiSigP2SHAddress(t *testing.T) {
@@ -167,8 +394,7 @@ func TestHTTPServerGenMultiSigP2SHAddress(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	http.HandleFunc("v1/genMultiSigP2SHAddress", GenMultiSigP2SHAddress)
-	GenMultiSigP2SHAddress(rr, req)
+	(&MultiSigHandler{net: &chaincfg.MainNetParams}).ServeHTTP(rr, req)
 
 	body, err := ioutil.ReadAll(rr.Body)
 	if err != nil {
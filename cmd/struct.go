@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/btcsuite/btcd/chaincfg"
@@ -8,6 +10,7 @@ import (
 	"github.com/btcsuite/btcutil/hdkeychain"
 	"io/ioutil"
 	"reflect"
+	"time"
 )
 
 type KEYPATH struct {
@@ -16,9 +19,244 @@ type KEYPATH struct {
 	ADDRESS uint32
 }
 
+// String Return the BIP032 notation of the path, m/account'/chain/address
+func (p KEYPATH) String() string {
+	return fmt.Sprintf("m/%d'/%d/%d", p.ACCOUNT, p.CHAIN, p.ADDRESS)
+}
+
+// REPLAYPARAM the timestamp(unix seconds) and the random nonce sent inside the encrypted request to prevent the replay attack
+type REPLAYPARAM struct {
+	TIMESTAMP int64
+	NONCE string
+}
+
+// BIP32PARAM the seed (hex) or the wallet id and the wallet token of the seed registered in the vault, and the path
 type BIP32PARAM struct {
 	SEED string
+	WALLETID string `json:",omitempty"`
+	WALLETTOKEN string `json:",omitempty"`
 	PATH KEYPATH
+	REPLAYPARAM
+}
+
+// SIGNMESSAGEPARAM the key to sign by, the address type (p2pkh, p2sh-p2wpkh, p2wpkh or p2tr), the optional signature
+// format (bip137 or bip322) and the message
+type SIGNMESSAGEPARAM struct {
+	BIP32PARAM
+	ADDRESSTYPE string
+	FORMAT string
+	MESSAGE string
+}
+
+// VERIFYMESSAGEPARAM the address, the message and the base64 signature to verify
+type VERIFYMESSAGEPARAM struct {
+	ADDRESS string
+	MESSAGE string
+	SIGNATURE string
+}
+
+// NEXTADDRESSPARAM the wallet token, the account and chain to allocate the next address from, the optional idempotency
+// key and label
+type NEXTADDRESSPARAM struct {
+	WALLETTOKEN string
+	ACCOUNT uint32
+	CHAIN uint32
+	IDEMPOTENCYKEY string
+	LABEL string
+	REPLAYPARAM
+}
+
+// WALLETPARAM the seed (hex) to register in the vault
+type WALLETPARAM struct {
+	SEED string
+	REPLAYPARAM
+}
+
+// MULTISIGPARAM the n-out-of-m multisig P2SH address request, n and m are decimal strings
+type MULTISIGPARAM struct {
+	N string `json:"n"`
+	M string `json:"m"`
+	PUBLICKEYS string `json:"publicKeys"`
+	REPLAYPARAM
+}
+
+// PSBTDERIVATIONPARAM the BIP032 derivation of a public key (hex), the master key fingerprint (the wallet id) and the path
+// like m/48'/0'/0'/2'/0/0. The empty public key is derived from the vault seed of the wallet id and the wallet token, the
+// empty fingerprint is the fingerprint of the vault seed
+type PSBTDERIVATIONPARAM struct {
+	PUBLICKEY string `json:",omitempty"`
+	FINGERPRINT string `json:",omitempty"`
+	WALLETID string `json:",omitempty"`
+	WALLETTOKEN string `json:",omitempty"`
+	PATH string
+}
+
+// PSBTUTXOPARAM the output to spend, the txid, the output index, the value in satoshi and the hex scripts. The address
+// is required by the single key outputs, the previous transaction (hex) is required by BIP174 for the non-segwit outputs
+type PSBTUTXOPARAM struct {
+	TXID string
+	VOUT uint32
+	VALUE int64
+	ADDRESS string `json:",omitempty"`
+	REDEEMSCRIPT string `json:",omitempty"`
+	WITNESSSCRIPT string `json:",omitempty"`
+	PREVTX string `json:",omitempty"`
+	DERIVATIONS []PSBTDERIVATIONPARAM `json:",omitempty"`
+}
+
+// PSBTOUTPUTPARAM the address and the value in satoshi of an output, the scripts and the derivations of the change output
+type PSBTOUTPUTPARAM struct {
+	ADDRESS string
+	VALUE int64
+	REDEEMSCRIPT string `json:",omitempty"`
+	WITNESSSCRIPT string `json:",omitempty"`
+	DERIVATIONS []PSBTDERIVATIONPARAM `json:",omitempty"`
+}
+
+// PSBTCREATEPARAM the utxos to spend, the outputs, the optional change output receiving the value left after the fee
+// and the fee rate in sat/vB
+type PSBTCREATEPARAM struct {
+	UTXOS []PSBTUTXOPARAM
+	OUTPUTS []PSBTOUTPUTPARAM
+	CHANGE *PSBTOUTPUTPARAM `json:",omitempty"`
+	FEERATE float64
+	REPLAYPARAM
+}
+
+// PSBTSIGNPARAM the seed (hex) or the wallet id and the wallet token of the seed registered in the vault to sign by,
+// and the base64 psbt. The fingerprint of the psbt derivations is public, it never selects the signing seed.
+type PSBTSIGNPARAM struct {
+	SEED string
+	WALLETID string `json:",omitempty"`
+	WALLETTOKEN string `json:",omitempty"`
+	PSBT string
+	REPLAYPARAM
+}
+
+// PSBTCOMBINEPARAM the base64 psbts of the same transaction signed by the cosigners
+type PSBTCOMBINEPARAM struct {
+	PSBTS []string
+	REPLAYPARAM
+}
+
+// PSBTFINALIZEPARAM the base64 psbt to finalize
+type PSBTFINALIZEPARAM struct {
+	PSBT string
+	REPLAYPARAM
+}
+
+// BUILDTXPARAM the utxos of the cold wallet, the outputs and the fee rate in sat/vB. The change is sent to the next
+// address of the internal chain of the wallet account, the optional idempotency key and label are given to the allocation
+type BUILDTXPARAM struct {
+	WALLETID string
+	WALLETTOKEN string
+	ACCOUNT uint32
+	UTXOS []PSBTUTXOPARAM
+	OUTPUTS []PSBTOUTPUTPARAM
+	FEERATE float64
+	IDEMPOTENCYKEY string `json:",omitempty"`
+	LABEL string `json:",omitempty"`
+	REPLAYPARAM
+}
+
+// SWEEPPARAM the WIF private keys to sweep, their utxos and the fee rate in sat/vB. The funds are sent to the next
+// address of the chain of the wallet account, the optional idempotency key and label are given to the allocation
+type SWEEPPARAM struct {
+	WIFS []string
+	UTXOS []PSBTUTXOPARAM
+	FEERATE float64
+	WALLETID string
+	WALLETTOKEN string
+	ACCOUNT uint32
+	CHAIN uint32
+	IDEMPOTENCYKEY string `json:",omitempty"`
+	LABEL string `json:",omitempty"`
+	REPLAYPARAM
+}
+
+// VANITYPARAM the seed (or the wallet id and the wallet token of the seed registered in the vault) and the chain of the account to search
+// from the start index, or RANDOM to search the fresh random keys. The address type is p2wpkh (default) or p2tr, either
+// the prefix or the regular expression is given. The workers and the time budget in seconds are capped by the server.
+type VANITYPARAM struct {
+	SEED string `json:",omitempty"`
+	WALLETID string `json:",omitempty"`
+	WALLETTOKEN string `json:",omitempty"`
+	ACCOUNT uint32
+	CHAIN uint32
+	START uint32 `json:",omitempty"`
+	RANDOM bool `json:",omitempty"`
+	ADDRESSTYPE string `json:",omitempty"`
+	PREFIX string `json:",omitempty"`
+	REGEX string `json:",omitempty"`
+	WORKERS int `json:",omitempty"`
+	TIMEBUDGET int64 `json:",omitempty"`
+	REPLAYPARAM
+}
+
+// DERIVERANGEPARAM the seed (or the wallet id and the wallet token of the seed registered in the vault), the chain of the account and the
+// range of the indices to derive, the address types are p2pkh, p2sh-p2wpkh, p2wpkh (default) or p2tr
+type DERIVERANGEPARAM struct {
+	SEED string `json:",omitempty"`
+	WALLETID string `json:",omitempty"`
+	WALLETTOKEN string `json:",omitempty"`
+	ACCOUNT uint32
+	CHAIN uint32
+	START uint32
+	COUNT uint32
+	ADDRESSTYPES []string `json:",omitempty"`
+	REPLAYPARAM
+}
+
+// ADDRESSSTATUSPARAM the address imported to the watch-only wallet of the node
+type ADDRESSSTATUSPARAM struct {
+	ADDRESS string
+	REPLAYPARAM
+}
+
+// DISCOVERPARAM the seed (or the wallet id and the wallet token of the seed registered in the vault) to recover, the history oracle
+// (addresses, snapshot or rpc, addresses by default) and the used addresses of the addresses oracle. The gap limit (20 by
+// default) and the checked address types (p2pkh, p2sh-p2wpkh, p2wpkh and p2tr by default) are optional.
+type DISCOVERPARAM struct {
+	SEED string
+	WALLETID string `json:",omitempty"`
+	WALLETTOKEN string `json:",omitempty"`
+	ORACLE string `json:",omitempty"`
+	USEDADDRESSES []string `json:",omitempty"`
+	GAPLIMIT uint32 `json:",omitempty"`
+	ADDRESSTYPES []string `json:",omitempty"`
+	REPLAYPARAM
+}
+
+// VERIFYSCRIPTPARAM the hex redeem script (or witness script) to check, the input type (p2sh, p2wsh or p2sh-p2wsh, p2sh
+// by default) and the test private keys (WIF or hex) signing the dummy spend
+type VERIFYSCRIPTPARAM struct {
+	REDEEMSCRIPT string
+	TYPE string
+	PRIVATEKEYS []string
+	REPLAYPARAM
+}
+
+// DECODEMATCHPARAM the optional account xpub (m/account') and the hex redeem scripts of this server to match the
+// inputs and outputs of the decoded transaction, the lookahead is the number of the addresses of each xpub chain
+type DECODEMATCHPARAM struct {
+	XPUB string `json:",omitempty"`
+	LOOKAHEAD uint32 `json:",omitempty"`
+	REDEEMSCRIPTS []string `json:",omitempty"`
+}
+
+// DECODETXPARAM the raw transaction (hex) to decode and the optional previous transactions (hex) of its inputs
+type DECODETXPARAM struct {
+	TX string
+	PREVTXS []string `json:",omitempty"`
+	DECODEMATCHPARAM
+	REPLAYPARAM
+}
+
+// DECODEPSBTPARAM the base64 psbt to decode
+type DECODEPSBTPARAM struct {
+	PSBT string
+	DECODEMATCHPARAM
+	REPLAYPARAM
 }
 
 // Clear clear the data of a instance especially the importance data like a seed, reduce the possibilities of the malware attack
@@ -27,10 +265,20 @@ func Clear(v interface{}) {
 	p.Set(reflect.Zero(p.Type()))
 }
 
-// GenerateSegwitAddress Generate segwit address using for the bitcoin mainnet by the public key
-func GenerateSegwitAddress(key *[]byte) (*string, error) {
+// NetworkParams Return the parameters of the network name, mainnet, testnet3, regtest or signet
+func NetworkParams(name string) (*chaincfg.Params, error) {
+	for _, net := range []*chaincfg.Params{&chaincfg.MainNetParams, &chaincfg.TestNet3Params, &chaincfg.RegressionNetParams, &chaincfg.SigNetParams} {
+		if net.Name == name {
+			return net, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown network %s", name)
+}
+
+// GenerateSegwitAddress Generate segwit address of the network by the public key
+func GenerateSegwitAddress(key *[]byte, net *chaincfg.Params) (*string, error) {
 	witnessProg := btcutil.Hash160(*key)
-	addressWitnessPubKeyHash, err := btcutil.NewAddressWitnessPubKeyHash(witnessProg, &chaincfg.MainNetParams)
+	addressWitnessPubKeyHash, err := btcutil.NewAddressWitnessPubKeyHash(witnessProg, net)
 	if err != nil {
 		return nil, err
 	}
@@ -50,6 +298,17 @@ func ConvertPublicKey(key *hdkeychain.ExtendedKey) (*[]byte, error) {
 	return &compressed, nil
 }
 
+// NewReplayParam Create the replay protection param of a request with the current time and a random nonce
+func NewReplayParam() (*REPLAYPARAM, error) {
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return &REPLAYPARAM{TIMESTAMP: time.Now().Unix(), NONCE: hex.EncodeToString(nonce)}, nil
+}
+
 // ReadSeedFromJsonFile a helper function to read the json file to a BIP32PARAM instance
 func ReadSeedFromJsonFile(file *string) (*BIP32PARAM, error)  {
 	data, err := ioutil.ReadFile(*file)
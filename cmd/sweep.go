@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+	"github.com/jayt106/bitcoinAddressGenerator/audit"
+	"github.com/jayt106/bitcoinAddressGenerator/keycache"
+	"github.com/jayt106/bitcoinAddressGenerator/psbt"
+	"github.com/jayt106/bitcoinAddressGenerator/sweep"
+	"github.com/jayt106/bitcoinAddressGenerator/vault"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// p2wpkhScriptSize the size of the P2WPKH output script, OP_0 and the 20 bytes key hash
+const p2wpkhScriptSize = 22
+
+// SweepHandler the handler uses for passing this struct into the ServerHTTP function
+type SweepHandler struct {
+	vault    *vault.Vault
+	auditLog *audit.Log
+	net      *chaincfg.Params
+	keyCache *keycache.Cache
+}
+
+// ServeHTTP handle the V1/sweep API request behind the SecureChannel middleware, the request has the WIF private keys
+// (paper wallets or the keys of the other wallets) and their utxos. All the funds are swept to the SegWit address of
+// the next index of the chain of the wallet account, the fee at the fee rate is taken from the swept value. Return the
+// signed raw transaction, nothing is broadcast.
+func (sh *SweepHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handle API /v1/sweep")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Read body error:")
+		return
+	}
+
+	var sweepParam SWEEPPARAM
+	err = json.Unmarshal(body, &sweepParam)
+	Clear(&body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json unmarshal error:")
+		return
+	}
+	defer Clear(&sweepParam)
+	if sweepParam.WALLETID == "" {
+		ServerErrorHandle(w, errors.New("the wallet id of the sweep address is missing"), "Sweep error:")
+		return
+	}
+
+	var keys []*btcutil.WIF
+	defer func() {
+		for _, key := range keys {
+			key.PrivKey.D.SetInt64(0)
+		}
+	}()
+	for i, s := range sweepParam.WIFS {
+		key, err := btcutil.DecodeWIF(s)
+		if err != nil {
+			ServerErrorHandle(w, fmt.Errorf("key %d: %v", i, err), "Parse WIF error:")
+			return
+		}
+		keys = append(keys, key)
+	}
+
+	vaultKeys := &psbtVault{sh.vault, sh.auditLog, r, "/v1/sweep"}
+	utxos := make([]*psbt.UTXO, len(sweepParam.UTXOS))
+	for i := range sweepParam.UTXOS {
+		utxos[i], err = psbtUTXO(vaultKeys, &sweepParam.UTXOS[i], sh.net)
+		if err != nil {
+			ServerErrorHandle(w, fmt.Errorf("utxo %d: %v", i, err), "PSBT utxo error:")
+			return
+		}
+	}
+
+	// The sweep is checked against a placeholder P2WPKH script of the same size first, so a sweep that can't be built
+	// doesn't allocate the index nor audit the address
+	_, err = sweep.Sweep(keys, utxos, make([]byte, p2wpkhScriptSize), sweepParam.FEERATE)
+	if err != nil {
+		ServerErrorHandle(w, err, "Sweep failed:")
+		return
+	}
+
+	label := sweepParam.LABEL
+	if label == "" {
+		label = "sweep"
+	}
+	allocation, _, err := sh.vault.NextIndex(sweepParam.WALLETID, sweepParam.WALLETTOKEN, sweepParam.ACCOUNT, sweepParam.CHAIN, sweepParam.IDEMPOTENCYKEY, label)
+	if err != nil {
+		ServerErrorHandle(w, err, "Allocate address error:")
+		return
+	}
+	output, address, path, err := segwitOutput(sh.vault, sh.auditLog, r, "/v1/sweep", sweepParam.WALLETID, sweepParam.WALLETTOKEN, allocation, sh.net, sh.keyCache)
+	if err != nil {
+		ServerErrorHandle(w, err, "Generate segwit address error:")
+		return
+	}
+
+	result, err := sweep.Sweep(keys, utxos, output.TxOut.PkScript, sweepParam.FEERATE)
+	if err != nil {
+		ServerErrorHandle(w, err, "Sweep failed:")
+		return
+	}
+
+	var buf bytes.Buffer
+	err = result.Tx.Serialize(&buf)
+	if err != nil {
+		ServerErrorHandle(w, err, "Serialize transaction failed:")
+		return
+	}
+
+	summary := result.Summary
+	resp := make(map[string]string)
+	resp["tx"] = hex.EncodeToString(buf.Bytes())
+	resp["txid"] = result.Tx.TxHash().String()
+	resp["value"] = strconv.FormatInt(summary.OutputValue, 10)
+	resp["fee"] = strconv.FormatInt(summary.Fee, 10)
+	resp["feeRate"] = strconv.FormatFloat(summary.FeeRate, 'f', 2, 64)
+	resp["vsize"] = strconv.FormatInt(summary.VSize, 10)
+	resp["address"] = address
+	resp["path"] = path
+	if len(summary.Warnings) != 0 {
+		resp["warning"] = strings.Join(summary.Warnings, "; ")
+	}
+
+	marshalledData, err := json.Marshal(resp)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json Marshal error:")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, err = w.Write(marshalledData)
+	if err != nil {
+		log.Println("ServeHTTP write error:", err)
+	}
+}
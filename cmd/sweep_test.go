@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/jayt106/bitcoinAddressGenerator/audit"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"github.com/jayt106/bitcoinAddressGenerator/vault"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestHTTPServerSweep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	walletVault, err := vault.Open(filepath.Join(dir, "vault.json"), []byte("test passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLog, err := audit.Open(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filePath = workingDir + "/../test/test.json"
+	keyParam, err := ReadSeedFromJsonFile(&filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, err := hex.DecodeString(keyParam.SEED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	walletID, walletToken, err := walletVault.Register(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The paper wallet keys, the uncompressed key has the P2PKH output and the compressed key the P2WPKH output
+	privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), append(make([]byte, 31), 1))
+	uncompressedKey, err := btcutil.NewWIF(privKey, &chaincfg.MainNetParams, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressedKey, err := btcutil.NewWIF(privKey, &chaincfg.MainNetParams, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uncompressedAddress, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(privKey.PubKey().SerializeUncompressed()), &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segwitAddress, err := message.Address(privKey.PubKey(), message.AddressP2WPKH, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := message.AddressScript(uncompressedAddress.EncodeAddress(), &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevTx := wire.NewMsgTx(wire.TxVersion)
+	prevTx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{1}}})
+	prevTx.AddTxOut(wire.NewTxOut(60000, pkScript))
+	var prevBuf bytes.Buffer
+	if err = prevTx.Serialize(&prevBuf); err != nil {
+		t.Fatal(err)
+	}
+
+	replayParam, err := NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sweepParam := SWEEPPARAM{
+		WIFS: []string{uncompressedKey.String(), compressedKey.String()},
+		UTXOS: []PSBTUTXOPARAM{
+			{TXID: prevTx.TxHash().String(), VOUT: 0, VALUE: 60000, ADDRESS: uncompressedAddress.EncodeAddress(),
+				PREVTX: hex.EncodeToString(prevBuf.Bytes())},
+			{TXID: "5e2383defe7efcbdc9fdd6dba55da148b206617bbb49e6bb93fce7bfbb459d44", VOUT: 1, VALUE: 40000, ADDRESS: segwitAddress},
+		},
+		FEERATE:     1,
+		WALLETID:    walletID,
+		WALLETTOKEN: walletToken,
+		REPLAYPARAM: *replayParam,
+	}
+	handler := &SweepHandler{walletVault, auditLog, &chaincfg.MainNetParams, nil}
+	rsp, code := requestSecureChannel(t, handler, sweepParam)
+	if code != 200 {
+		t.Fatal("Sweep failed, status:", code)
+	}
+	if rsp["address"] != "bc1q8c87x4v0m3dfrxksv724rtwpxy5ghpw8gwf8da" || rsp["path"] != "m/0'/0/0" || rsp["warning"] != "" {
+		t.Fatal("Unexpected sweep:", rsp)
+	}
+
+	tx, err := decodeRawTx(rsp["tx"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.TxHash().String() != rsp["txid"] || len(tx.TxOut) != 1 || strconv.FormatInt(tx.TxOut[0].Value, 10) != rsp["value"] ||
+		rsp["fee"] != strconv.FormatInt(100000-tx.TxOut[0].Value, 10) {
+		t.Error("Unexpected sweep transaction:", rsp)
+	}
+	segwitScript, err := message.AddressScript(segwitAddress, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigHashes := txscript.NewTxSigHashes(tx)
+	for i, prevOut := range []*wire.TxOut{prevTx.TxOut[0], wire.NewTxOut(40000, segwitScript)} {
+		vm, err := txscript.NewEngine(prevOut.PkScript, tx, i, txscript.StandardVerifyFlags, nil, sigHashes, prevOut.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = vm.Execute(); err != nil {
+			t.Errorf("Input %d isn't valid: %v", i, err)
+		}
+	}
+
+	entries, err := audit.Verify(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Endpoint != "/v1/sweep" || entries[0].Address != rsp["address"] {
+		t.Error("The sweep address should be audited:", entries)
+	}
+
+	// The WIF key of the uncompressed public key doesn't have the P2WPKH output
+	replayParam, err = NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sweepParam.WIFS = sweepParam.WIFS[:1]
+	sweepParam.UTXOS = sweepParam.UTXOS[1:]
+	sweepParam.REPLAYPARAM = *replayParam
+	if _, code = requestSecureChannel(t, handler, sweepParam); code != 500 {
+		t.Error("The utxo without the key should be rejected, status:", code)
+	}
+
+	// The rejected sweep neither allocates the next index nor audits its address
+	entries, err = audit.Verify(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Error("The rejected sweep shouldn't be audited:", entries)
+	}
+	allocation, _, err := walletVault.NextIndex(walletID, walletToken, 0, 0, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocation.Index != 1 {
+		t.Error("The rejected sweep shouldn't allocate the index:", allocation.Index)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/jayt106/bitcoinAddressGenerator/audit"
+	"github.com/jayt106/bitcoinAddressGenerator/keycache"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"github.com/jayt106/bitcoinAddressGenerator/psbt"
+	"github.com/jayt106/bitcoinAddressGenerator/txbuilder"
+	"github.com/jayt106/bitcoinAddressGenerator/vault"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// changeChain the internal chain of the change addresses
+const changeChain = 1
+
+// BuildTransactionHandler the handler uses for passing this struct into the ServerHTTP function
+type BuildTransactionHandler struct {
+	vault    *vault.Vault
+	auditLog *audit.Log
+	net      *chaincfg.Params
+	keyCache *keycache.Cache
+}
+
+// ServeHTTP handle the V1/buildTransaction API request behind the SecureChannel middleware, the offline transaction
+// builder of the cold wallet. The utxos to spend are selected by branch and bound with the knapsack fallback, the change
+// is sent to the SegWit address of the next index of the internal chain (CHAIN=1) of the wallet account. Return the
+// unsigned psbt with the fee and weight breakdown, nothing is fetched from the network.
+func (bh *BuildTransactionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handle API /v1/buildTransaction")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Read body error:")
+		return
+	}
+
+	var buildParam BUILDTXPARAM
+	err = json.Unmarshal(body, &buildParam)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json unmarshal error:")
+		return
+	}
+	if buildParam.WALLETID == "" {
+		ServerErrorHandle(w, errors.New("the wallet id of the change is missing"), "Build transaction error:")
+		return
+	}
+
+	vaultKeys := &psbtVault{bh.vault, bh.auditLog, r, "/v1/buildTransaction"}
+	utxos := make([]*psbt.UTXO, len(buildParam.UTXOS))
+	for i := range buildParam.UTXOS {
+		utxos[i], err = psbtUTXO(vaultKeys, &buildParam.UTXOS[i], bh.net)
+		if err != nil {
+			ServerErrorHandle(w, fmt.Errorf("utxo %d: %v", i, err), "PSBT utxo error:")
+			return
+		}
+	}
+
+	outputs := make([]*psbt.TxOutput, len(buildParam.OUTPUTS))
+	for i := range buildParam.OUTPUTS {
+		outputs[i], err = psbtOutput(vaultKeys, &buildParam.OUTPUTS[i], bh.net)
+		if err != nil {
+			ServerErrorHandle(w, fmt.Errorf("output %d: %v", i, err), "PSBT output error:")
+			return
+		}
+	}
+
+	// The change index is only allocated when the selected coins leave the change
+	var changeAddress, changePath string
+	change := func() (*psbt.TxOutput, error) {
+		output, address, path, err := bh.changeOutput(r, &buildParam)
+		changeAddress, changePath = address, path
+		return output, err
+	}
+	result, err := txbuilder.Build(utxos, outputs, buildParam.FEERATE, change)
+	if err != nil {
+		ServerErrorHandle(w, err, "Build transaction failed:")
+		return
+	}
+
+	encoded, err := result.Packet.B64Encode()
+	if err != nil {
+		ServerErrorHandle(w, err, "Serialize PSBT failed:")
+		return
+	}
+
+	summary := result.Summary
+	resp := make(map[string]string)
+	resp["psbt"] = encoded
+	resp["algorithm"] = result.Algorithm
+	resp["selectedInputs"] = joinInts(result.Selected)
+	resp["fee"] = strconv.FormatInt(summary.Fee, 10)
+	resp["feeRate"] = strconv.FormatFloat(summary.FeeRate, 'f', 2, 64)
+	resp["targetFee"] = strconv.FormatInt(result.Breakdown.TargetFee, 10)
+	resp["excess"] = strconv.FormatInt(result.Breakdown.Excess, 10)
+	resp["vsize"] = strconv.FormatInt(summary.VSize, 10)
+	resp["weight"] = strconv.FormatInt(summary.Weight, 10)
+	resp["overheadWeight"] = strconv.FormatInt(result.Breakdown.OverheadWeight, 10)
+	resp["inputWeights"] = joinInt64s(result.Breakdown.InputWeights)
+	resp["outputWeights"] = joinInt64s(result.Breakdown.OutputWeights)
+	resp["changeIndex"] = strconv.Itoa(summary.ChangeIndex)
+	if summary.ChangeIndex >= 0 {
+		resp["changeAddress"] = changeAddress
+		resp["changePath"] = changePath
+	}
+	if len(summary.Warnings) != 0 {
+		resp["warning"] = strings.Join(summary.Warnings, "; ")
+	}
+
+	marshalledData, err := json.Marshal(resp)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json Marshal error:")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, err = w.Write(marshalledData)
+	if err != nil {
+		log.Println("ServeHTTP write error:", err)
+	}
+}
+
+// changeOutput Allocate the next index of the internal chain of the wallet account, and return the P2WPKH change output
+// with its BIP032 derivation, the address and the path. The issued change address is recorded in the audit log.
+func (bh *BuildTransactionHandler) changeOutput(r *http.Request, p *BUILDTXPARAM) (*psbt.TxOutput, string, string, error) {
+	label := p.LABEL
+	if label == "" {
+		label = "change"
+	}
+	allocation, _, err := bh.vault.NextIndex(p.WALLETID, p.WALLETTOKEN, p.ACCOUNT, changeChain, p.IDEMPOTENCYKEY, label)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return segwitOutput(bh.vault, bh.auditLog, r, "/v1/buildTransaction", p.WALLETID, p.WALLETTOKEN, allocation, bh.net, bh.keyCache)
+}
+
+// segwitOutput Return the P2WPKH output of the allocated index of the vault wallet with its BIP032 derivation, the
+// address of the network and the path. The issued address is recorded in the audit log as issued by the endpoint.
+func segwitOutput(v *vault.Vault, auditLog *audit.Log, r *http.Request, endpoint string, walletID string, token string,
+	allocation *vault.Allocation, net *chaincfg.Params, cache *keycache.Cache) (*psbt.TxOutput, string, string, error) {
+	keyPath := KEYPATH{allocation.Account, allocation.Chain, allocation.Index}
+	keyParam := BIP32PARAM{WALLETID: walletID, WALLETTOKEN: token, PATH: keyPath}
+	err := ResolveSeed(v, &keyParam)
+	if err != nil {
+		return nil, "", "", err
+	}
+	walletFingerprint, err := WalletFingerprint(&keyParam)
+	if err != nil {
+		Clear(&keyParam)
+		return nil, "", "", err
+	}
+	hdPubKey, err := GenerateHDPublicKey(&keyParam, cache)
+	Clear(&keyParam)
+	if err != nil {
+		return nil, "", "", err
+	}
+	pubKey, err := ConvertPublicKey(hdPubKey)
+	if err != nil {
+		return nil, "", "", err
+	}
+	address, err := GenerateSegwitAddress(pubKey, net)
+	if err != nil {
+		return nil, "", "", err
+	}
+	pkScript, err := message.AddressScript(*address, net)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	fingerprint, err := hex.DecodeString(walletFingerprint)
+	if err != nil {
+		return nil, "", "", err
+	}
+	derivation := &psbt.Bip32Derivation{
+		PubKey: *pubKey,
+		Path:   []uint32{hdkeychain.HardenedKeyStart + keyPath.ACCOUNT, keyPath.CHAIN, keyPath.ADDRESS},
+	}
+	copy(derivation.Fingerprint[:], fingerprint)
+
+	err = auditLog.Append(audit.Entry{
+		Endpoint:  endpoint,
+		WalletID:  walletFingerprint,
+		Path:      keyPath.String(),
+		Address:   *address,
+		PublicKey: hex.EncodeToString(*pubKey),
+		Requester: Requester(r),
+	})
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	output := &psbt.TxOutput{TxOut: wire.NewTxOut(0, pkScript)}
+	output.Output.Bip32Derivation = []*psbt.Bip32Derivation{derivation}
+	return output, *address, keyPath.String(), nil
+}
+
+// joinInts Return the comma separated decimal integers
+func joinInts(values []int) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.Itoa(v)
+	}
+	return strings.Join(s, ",")
+}
+
+// joinInt64s Return the comma separated decimal integers
+func joinInt64s(values []int64) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.FormatInt(v, 10)
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/hex"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/jayt106/bitcoinAddressGenerator/audit"
+	"github.com/jayt106/bitcoinAddressGenerator/psbt"
+	"github.com/jayt106/bitcoinAddressGenerator/vault"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHTTPServerBuildTransaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	walletVault, err := vault.Open(filepath.Join(dir, "vault.json"), []byte("test passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLog, err := audit.Open(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filePath = workingDir + "/../test/test.json"
+	keyParam, err := ReadSeedFromJsonFile(&filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, err := hex.DecodeString(keyParam.SEED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	walletID, walletToken, err := walletVault.Register(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The utxo of the address m/0'/0/0 pays the output and the fee without change
+	utxo := PSBTUTXOPARAM{TXID: "5e2383defe7efcbdc9fdd6dba55da148b206617bbb49e6bb93fce7bfbb459d44", VOUT: 0, VALUE: 100000,
+		ADDRESS:     "bc1q8c87x4v0m3dfrxksv724rtwpxy5ghpw8gwf8da",
+		DERIVATIONS: []PSBTDERIVATIONPARAM{{WALLETID: walletID, WALLETTOKEN: walletToken, PATH: "m/0'/0/0"}}}
+	replayParam, err := NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buildParam := BUILDTXPARAM{
+		WALLETID:    walletID,
+		WALLETTOKEN: walletToken,
+		UTXOS:       []PSBTUTXOPARAM{utxo},
+		OUTPUTS:     []PSBTOUTPUTPARAM{{ADDRESS: "347N1Thc213QqfYCz3PZkjoJpNv5b14kBd", VALUE: 99838}},
+		FEERATE:     1,
+		REPLAYPARAM: *replayParam,
+	}
+	handler := &BuildTransactionHandler{walletVault, auditLog, &chaincfg.MainNetParams, nil}
+	rsp, code := requestSecureChannel(t, handler, buildParam)
+	if code != 200 {
+		t.Fatal("Build transaction failed, status:", code)
+	}
+	if rsp["algorithm"] != "bnb" || rsp["changeIndex"] != "-1" || rsp["changeAddress"] != "" || rsp["excess"] != "51" {
+		t.Error("Unexpected transaction without change:", rsp)
+	}
+
+	// The smaller output leaves the change to the first internal chain address, the index wasn't used by the first build
+	replayParam, err = NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buildParam.OUTPUTS[0].VALUE = 50000
+	buildParam.REPLAYPARAM = *replayParam
+	rsp, code = requestSecureChannel(t, handler, buildParam)
+	if code != 200 {
+		t.Fatal("Build transaction failed, status:", code)
+	}
+	if rsp["algorithm"] != "knapsack" || rsp["changeIndex"] != "1" || rsp["changePath"] != "m/0'/1/0" || rsp["selectedInputs"] != "0" {
+		t.Fatal("Unexpected transaction with change:", rsp)
+	}
+
+	packet, err := psbt.NewFromBase64(rsp["psbt"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	derivations := packet.Outputs[1].Bip32Derivation
+	if len(derivations) != 1 || psbt.FormatDerivationPath(derivations[0].Path) != "m/0'/1/0" {
+		t.Error("Unexpected change derivation:", derivations)
+	}
+	if len(packet.Inputs[0].Bip32Derivation) != 1 {
+		t.Error("The utxo derivation should be resolved from the vault")
+	}
+
+	entries, err := audit.Verify(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The utxo public key derived from the vault by each build and the change address
+	if len(entries) != 3 || entries[2].Address != rsp["changeAddress"] || entries[2].Path != "m/0'/1/0" {
+		t.Fatal("The change address should be audited:", entries)
+	}
+	for _, entry := range entries[:2] {
+		if entry.Endpoint != "/v1/buildTransaction" || entry.Path != "m/0'/0/0" || entry.PublicKey == "" || entry.Address != "" {
+			t.Error("The utxo public key should be audited:", entry)
+		}
+	}
+
+	replayParam, err = NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buildParam.OUTPUTS[0].VALUE = 200000
+	buildParam.REPLAYPARAM = *replayParam
+	if _, code = requestSecureChannel(t, handler, buildParam); code != 500 {
+		t.Error("The insufficient funds should be rejected, status:", code)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/jayt106/bitcoinAddressGenerator/utxoscan"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+func main() {
+
+	l := len(os.Args)
+
+	if l >= 2 && strings.ToLower(os.Args[1]) == "help" {
+		help()
+		return
+	} else if l >= 4 && l <= 6 && os.Args[1] == "scan" {
+		gapLimit := uint64(utxoscan.DefaultGapLimit)
+		format := "text"
+		if l >= 5 {
+			var err error
+			gapLimit, err = strconv.ParseUint(os.Args[4], 10, 32)
+			if err != nil {
+				fmt.Println("Invalid gap limit, please check your input")
+				help()
+				return
+			}
+		}
+		if l == 6 {
+			format = strings.ToLower(os.Args[5])
+		}
+		if format != "text" && format != "json" {
+			fmt.Println("Invalid output format, please check your input")
+			help()
+			return
+		}
+
+		network := os.Getenv("NETWORK")
+		if network == "" {
+			network = chaincfg.MainNetParams.Name
+		}
+		netParams, err := NetworkParams(network)
+		if err != nil {
+			fmt.Println(err)
+			help()
+			return
+		}
+
+		scanner, err := newScanner(os.Args[3], uint32(gapLimit), netParams)
+		if err != nil {
+			log.Fatalln("Load the key error:", err)
+			return
+		}
+		report, err := scanner.Scan(utxoscan.FileReader(os.Args[2], scanner.Net()))
+		scanner.Zero()
+		if err != nil {
+			log.Fatalln("Scan the utxo set error:", err)
+			return
+		}
+
+		if format == "json" {
+			err = json.NewEncoder(os.Stdout).Encode(report)
+		} else {
+			err = printReport(report)
+		}
+		if err != nil {
+			log.Fatalln(err)
+			return
+		}
+	} else {
+		fmt.Println("Invalid arguments, please check your input")
+		help()
+		return
+	}
+}
+
+// newScanner Create the scanner of the seed json file (the format of test/test.json) or the account xpub on the network
+func newScanner(key string, gapLimit uint32, netParams *chaincfg.Params) (*utxoscan.Scanner, error) {
+	if _, err := os.Stat(key); err != nil {
+		return utxoscan.NewXPubScanner(key, gapLimit, netParams)
+	}
+
+	keyParam, err := ReadSeedFromJsonFile(&key)
+	if err != nil {
+		return nil, err
+	}
+	defer Clear(keyParam)
+	seed, err := hex.DecodeString(keyParam.SEED)
+	if err != nil {
+		return nil, err
+	}
+	defer Clear(&seed)
+	return utxoscan.NewSeedScanner(seed, gapLimit, netParams)
+}
+
+// printReport Print the balances of the used addresses and the accounts
+func printReport(report *utxoscan.Report) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tTYPE\tADDRESS\tUTXOS\tVALUE")
+	for _, b := range report.Balances {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\n", b.Path, b.Type, b.Address, b.UTXOs, b.Value)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "ACCOUNT\tLAST USED (0/1)\tUTXOS\tVALUE")
+	for _, a := range report.Accounts {
+		fmt.Fprintf(w, "%d\t%d/%d\t%d\t%d\n", a.Account, a.LastUsed[0], a.LastUsed[1], a.UTXOs, a.Value)
+	}
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	fmt.Println("Total:", report.Total, "satoshi in", report.UTXOs, "utxos,", report.Derived, "keys derived in", report.Passes, "passes")
+	return nil
+}
+
+func help() {
+	fmt.Println("usage: ./utxoScan scan [utxo file] [seed json file|account xpub] [gap limit (optional, 20 by default)] [text|json (optional)]")
+	fmt.Println()
+	fmt.Println("Scan the Bitcoin Core dumptxoutset snapshot or the csv utxo export (.csv, the columns txid,vout,value,address or")
+	fmt.Println("txid,vout,value,scriptPubKey) for the addresses m/account'/chain/index of the seed, or chain/index of the xpub, and")
+	fmt.Println("print the balance of each used address. The accounts are scanned until the first unused account.")
+	fmt.Println("The addresses of the seed or the xpub are on the network of the NETWORK environment variable, mainnet (default),")
+	fmt.Println("testnet3, regtest or signet, the version of the xpub must match the network.")
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+	"github.com/jayt106/bitcoinAddressGenerator/audit"
+	"github.com/jayt106/bitcoinAddressGenerator/keycache"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"github.com/jayt106/bitcoinAddressGenerator/vanity"
+	"github.com/jayt106/bitcoinAddressGenerator/vault"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// vanityProgressInterval the interval of the progress logs of the running search
+const vanityProgressInterval = 10 * time.Second
+
+// VanityHandler the handler uses for passing this struct into the ServerHTTP function. The searches share the workers,
+// one search runs at a time and the others wait for it.
+type VanityHandler struct {
+	vault      *vault.Vault
+	auditLog   *audit.Log
+	workers    int
+	timeBudget time.Duration
+	busy       chan struct{}
+	net        *chaincfg.Params
+	keyCache   *keycache.Cache
+}
+
+// NewVanityHandler Create the handler of the searches of the network addresses on the workers (the number of CPUs if
+// zero) within the max time budget, the chain keys are taken from the key cache if any
+func NewVanityHandler(v *vault.Vault, auditLog *audit.Log, workers int, timeBudget time.Duration, net *chaincfg.Params,
+	keyCache *keycache.Cache) *VanityHandler {
+	return &VanityHandler{v, auditLog, workers, timeBudget, make(chan struct{}, 1), net, keyCache}
+}
+
+// ServeHTTP handle the V1/vanity API request behind the SecureChannel middleware to search the P2WPKH or P2TR address
+// matching the prefix or the regular expression. The indices of the chain of the seed (or the wallet id of the seed
+// registered in the vault) are tried from the start index, the matching address is recoverable from the seed by the
+// returned path and is recorded in the audit log. With RANDOM the fresh random keys are tried instead and the private
+// key of the match is returned as WIF. The search stops at the time budget of the request (the max time budget of the
+// server by default) or when the client disconnects. Return the address, the attempts, the difficulty, the probability of
+// a match within the attempts and the ETA of the 50% probability, the failed search at the time budget has them in the
+// error.
+func (vh *VanityHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handle API /v1/vanity")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Read body error:")
+		return
+	}
+
+	var vanityParam VANITYPARAM
+	err = json.Unmarshal(body, &vanityParam)
+	Clear(&body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json unmarshal error:")
+		return
+	}
+	keyParam := BIP32PARAM{SEED: vanityParam.SEED, WALLETID: vanityParam.WALLETID, WALLETTOKEN: vanityParam.WALLETTOKEN, PATH: KEYPATH{ACCOUNT: vanityParam.ACCOUNT, CHAIN: vanityParam.CHAIN}}
+	Clear(&vanityParam.SEED)
+	defer Clear(&keyParam)
+
+	addressType := vanityParam.ADDRESSTYPE
+	if addressType == "" {
+		addressType = message.AddressP2WPKH
+	}
+	pattern, err := vanity.NewPattern(addressType, vanityParam.PREFIX, vanityParam.REGEX, vh.net)
+	if err != nil {
+		ServerErrorHandle(w, err, "Vanity pattern error:")
+		return
+	}
+
+	var source vanity.Source = vanity.RandomSource{}
+	walletID := ""
+	if !vanityParam.RANDOM {
+		err = ResolveSeed(vh.vault, &keyParam)
+		if err != nil {
+			ServerErrorHandle(w, err, "Resolve wallet seed error:")
+			return
+		}
+		walletID, err = WalletFingerprint(&keyParam)
+		if err != nil {
+			ServerErrorHandle(w, err, "Wallet fingerprint error:")
+			return
+		}
+		chainKey, err := GenerateChainPublicKey(&keyParam, vh.keyCache)
+		if err != nil {
+			ServerErrorHandle(w, err, "Generate HD public key failed:")
+			return
+		}
+		source, err = vanity.NewHDSource(chainKey, vanityParam.START)
+		if err != nil {
+			ServerErrorHandle(w, err, "Vanity source error:")
+			return
+		}
+	}
+
+	timeBudget := time.Duration(vanityParam.TIMEBUDGET) * time.Second
+	if timeBudget <= 0 || vh.timeBudget > 0 && timeBudget > vh.timeBudget {
+		timeBudget = vh.timeBudget
+	}
+	workers := vanityParam.WORKERS
+	if workers <= 0 || vh.workers > 0 && workers > vh.workers {
+		workers = vh.workers
+	}
+
+	// Wait for the running search, or until the client gives up
+	select {
+	case vh.busy <- struct{}{}:
+		defer func() { <-vh.busy }()
+	case <-r.Context().Done():
+		ServerErrorHandle(w, r.Context().Err(), "Vanity search error:")
+		return
+	}
+
+	opts := vanity.Options{Workers: workers, TimeBudget: timeBudget, ProgressInterval: vanityProgressInterval}
+	opts.Progress = func(p vanity.Progress) {
+		log.Printf("Vanity search %s: %d attempts, %.0f/s, difficulty %.0f, probability %.2f, ETA %s", pattern.AddressType,
+			p.Attempts, p.Rate, p.Difficulty, p.Probability, p.ETA.Round(time.Second))
+	}
+	result, err := vanity.Search(r.Context(), pattern, source, opts)
+	if err == vanity.ErrTimeBudget {
+		err = fmt.Errorf("%v, %d attempts, probability %.4f, ETA %s", err, result.Attempts, result.Probability,
+			result.ETA.Round(time.Second))
+	}
+	if err != nil {
+		ServerErrorHandle(w, err, "Vanity search error:")
+		return
+	}
+
+	resp := make(map[string]string)
+	resp["address"] = result.Address
+	resp["addressType"] = pattern.AddressType
+	resp["publicKey"] = hex.EncodeToString(result.Candidate.PubKey.SerializeCompressed())
+	resp["attempts"] = strconv.FormatUint(result.Attempts, 10)
+	resp["difficulty"] = strconv.FormatFloat(result.Difficulty, 'f', 0, 64)
+	resp["elapsed"] = result.Elapsed.Round(time.Millisecond).String()
+	resp["probability"] = strconv.FormatFloat(result.Probability, 'f', 4, 64)
+	resp["eta"] = result.ETA.Round(time.Second).String()
+
+	if result.Candidate.PrivKey != nil {
+		// The WIF shares the private key, zero it after the encoding
+		defer result.Candidate.PrivKey.D.SetInt64(0)
+		wif, err := btcutil.NewWIF(result.Candidate.PrivKey, vh.net, true)
+		if err != nil {
+			ServerErrorHandle(w, err, "Encode WIF error:")
+			return
+		}
+		resp["wif"] = wif.String()
+	} else {
+		path := KEYPATH{keyParam.PATH.ACCOUNT, keyParam.PATH.CHAIN, result.Candidate.Index}.String()
+		resp["path"] = path
+		err = vh.auditLog.Append(audit.Entry{
+			Endpoint:  "/v1/vanity",
+			WalletID:  walletID,
+			Path:      path,
+			Address:   result.Address,
+			PublicKey: resp["publicKey"],
+			Requester: Requester(r),
+		})
+		if err != nil {
+			ServerErrorHandle(w, err, "Audit log error:")
+			return
+		}
+	}
+
+	marshalledData, err := json.Marshal(resp)
+	Clear(&resp)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json Marshal error:")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, err = w.Write(marshalledData)
+	if err != nil {
+		log.Println("ServeHTTP write error:", err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+	"github.com/jayt106/bitcoinAddressGenerator/vanity"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+
+	l := len(os.Args)
+
+	if l >= 2 && strings.ToLower(os.Args[1]) == "help" {
+		help()
+		return
+	} else if l >= 5 && l <= 7 && os.Args[1] == "search" {
+		opts := vanity.Options{}
+		if l >= 6 {
+			workers, err := strconv.Atoi(os.Args[5])
+			if err != nil || workers <= 0 {
+				fmt.Println("Invalid workers, please check your input")
+				help()
+				return
+			}
+			opts.Workers = workers
+		}
+		if l == 7 {
+			timeBudget, err := time.ParseDuration(os.Args[6])
+			if err != nil || timeBudget <= 0 {
+				fmt.Println("Invalid time budget, please check your input")
+				help()
+				return
+			}
+			opts.TimeBudget = timeBudget
+		}
+
+		// The regular expression is quoted by the slashes
+		prefix, expr := os.Args[4], ""
+		if len(prefix) >= 2 && strings.HasPrefix(prefix, "/") && strings.HasSuffix(prefix, "/") {
+			prefix, expr = "", prefix[1:len(prefix)-1]
+		}
+		pattern, err := vanity.NewPattern(strings.ToLower(os.Args[3]), prefix, expr, &chaincfg.MainNetParams)
+		if err != nil {
+			log.Fatalln("Invalid pattern:", err)
+			return
+		}
+
+		source, path, err := newSource(os.Args[2])
+		if err != nil {
+			log.Fatalln("Load the key error:", err)
+			return
+		}
+
+		// Ctrl + C cancels the search
+		ctx, cancel := context.WithCancel(context.Background())
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-c
+			cancel()
+		}()
+
+		if pattern.Difficulty != 0 {
+			fmt.Fprintf(os.Stderr, "Searching %s..., difficulty %.0f\n", pattern.Prefix, pattern.Difficulty)
+		}
+		opts.Progress = func(p vanity.Progress) {
+			eta := "unknown"
+			if p.ETA > 0 {
+				eta = p.ETA.Round(time.Second).String()
+			}
+			fmt.Fprintf(os.Stderr, "\r%d attempts, %.0f/s, probability %.1f%%, 50%% ETA %s    ", p.Attempts, p.Rate, p.Probability*100, eta)
+		}
+		result, err := vanity.Search(ctx, pattern, source, opts)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			log.Fatalln("The search stopped after", result.Attempts, "attempts in", result.Elapsed.Round(time.Second), "-", err)
+			return
+		}
+
+		fmt.Println("Address:", result.Address)
+		fmt.Println("Public key:", hex.EncodeToString(result.Candidate.PubKey.SerializeCompressed()))
+		if result.Candidate.PrivKey != nil {
+			wif, err := btcutil.NewWIF(result.Candidate.PrivKey, &chaincfg.MainNetParams, true)
+			if err != nil {
+				log.Fatalln(err)
+				return
+			}
+			fmt.Println("Private key (WIF):", wif.String())
+		} else {
+			path.ADDRESS = result.Candidate.Index
+			fmt.Println("Path:", path.String())
+		}
+		fmt.Println("Found after", result.Attempts, "attempts in", result.Elapsed.Round(time.Millisecond))
+	} else {
+		fmt.Println("Invalid arguments, please check your input")
+		help()
+		return
+	}
+}
+
+// newSource Return the source of the chain of the seed json file (the format of test/test.json) from the address index
+// of its path, or the fresh random keys
+func newSource(key string) (vanity.Source, KEYPATH, error) {
+	if key == "random" {
+		return vanity.RandomSource{}, KEYPATH{}, nil
+	}
+
+	keyParam, err := ReadSeedFromJsonFile(&key)
+	if err != nil {
+		return nil, KEYPATH{}, err
+	}
+	defer Clear(keyParam)
+	seed, err := hex.DecodeString(keyParam.SEED)
+	if err != nil {
+		return nil, KEYPATH{}, err
+	}
+	defer Clear(&seed)
+	chainKey, err := vanity.ChainKey(seed, keyParam.PATH.ACCOUNT, keyParam.PATH.CHAIN, &chaincfg.MainNetParams)
+	if err != nil {
+		return nil, KEYPATH{}, err
+	}
+	source, err := vanity.NewHDSource(chainKey, keyParam.PATH.ADDRESS)
+	return source, keyParam.PATH, err
+}
+
+func help() {
+	fmt.Println("usage: ./vanityAddress search [seed json file|random] [p2wpkh|p2tr] [prefix|/regex/] [workers (optional, the number of CPUs by default)] [time budget (optional, e.g. 10m)]")
+	fmt.Println()
+	fmt.Println("Search the address matching the prefix (after bc1q or bc1p) or the regular expression of the whole address. The")
+	fmt.Println("addresses m/account'/chain/index of the seed are tried from the path of the json file, the path of the match")
+	fmt.Println("recovers it from the seed. With random the fresh random keys are tried and the private key of the match is printed.")
+	fmt.Println("Ctrl + C stops the search.")
+}
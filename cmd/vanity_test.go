@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+	"github.com/jayt106/bitcoinAddressGenerator/audit"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"github.com/jayt106/bitcoinAddressGenerator/vault"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPServerVanity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	walletVault, err := vault.Open(filepath.Join(dir, "vault.json"), []byte("test passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLog, err := audit.Open(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filePath = workingDir + "/../test/test.json"
+	keyParam, err := ReadSeedFromJsonFile(&filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, err := hex.DecodeString(keyParam.SEED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	walletID, walletToken, err := walletVault.Register(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint, err := vault.Fingerprint(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayParam, err := NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewVanityHandler(walletVault, auditLog, 2, time.Minute, &chaincfg.MainNetParams, nil)
+	vanityParam := VANITYPARAM{WALLETID: walletID, WALLETTOKEN: walletToken, CHAIN: 1, START: 10, PREFIX: "bc1qz", REPLAYPARAM: *replayParam}
+	rsp, code := requestSecureChannel(t, handler, vanityParam)
+	if code != 200 {
+		t.Fatal("Vanity search failed, status:", code)
+	}
+	if !strings.HasPrefix(rsp["address"], "bc1qz") || !strings.HasPrefix(rsp["path"], "m/0'/1/") || rsp["difficulty"] != "32" || rsp["wif"] != "" {
+		t.Fatal("Unexpected vanity address:", rsp)
+	}
+	if probability, err := strconv.ParseFloat(rsp["probability"], 64); err != nil || probability <= 0 || probability > 1 || rsp["eta"] == "" {
+		t.Error("Unexpected vanity progress:", rsp["probability"], rsp["eta"])
+	}
+
+	// The address is recovered from the seed by the path
+	var index uint32
+	if _, err = fmt.Sscanf(rsp["path"], "m/0'/1/%d", &index); err != nil || index < 10 {
+		t.Fatal("Unexpected vanity path:", rsp["path"], err)
+	}
+	hdPubKey, err := GenerateHDPublicKey(&BIP32PARAM{SEED: keyParam.SEED, PATH: KEYPATH{0, 1, index}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := ConvertPublicKey(hdPubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := GenerateSegwitAddress(pubKey, &chaincfg.MainNetParams)
+	if err != nil || *address != rsp["address"] || hex.EncodeToString(*pubKey) != rsp["publicKey"] {
+		t.Error("The vanity address isn't the address of its path:", *address, rsp, err)
+	}
+
+	entries, err := audit.Verify(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Endpoint != "/v1/vanity" || entries[0].Path != rsp["path"] || entries[0].WalletID != fingerprint {
+		t.Error("The vanity address should be audited:", entries)
+	}
+
+	// The fresh random key of the P2TR address
+	replayParam, err = NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	randomParam := VANITYPARAM{RANDOM: true, ADDRESSTYPE: message.AddressP2TR, REGEX: "^bc1p[qp]", REPLAYPARAM: *replayParam}
+	rsp, code = requestSecureChannel(t, handler, randomParam)
+	if code != 200 {
+		t.Fatal("Random vanity search failed, status:", code)
+	}
+	wif, err := btcutil.DecodeWIF(rsp["wif"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	taprootAddress, err := message.Address(wif.PrivKey.PubKey(), message.AddressP2TR, &chaincfg.MainNetParams)
+	if err != nil || taprootAddress != rsp["address"] || rsp["path"] != "" || rsp["difficulty"] != "0" {
+		t.Error("The vanity address isn't the address of its private key:", taprootAddress, rsp, err)
+	}
+
+	// The impossible pattern within the time budget of the server
+	replayParam, err = NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	randomParam = VANITYPARAM{RANDOM: true, REGEX: "^x", TIMEBUDGET: 60, REPLAYPARAM: *replayParam}
+	start := time.Now()
+	if _, code = requestSecureChannel(t, NewVanityHandler(walletVault, auditLog, 1, 100*time.Millisecond, &chaincfg.MainNetParams, nil), randomParam); code != 500 ||
+		time.Since(start) > 10*time.Second {
+		t.Error("The search should stop at the time budget of the server, status:", code)
+	}
+
+	// The P2PKH address isn't searched
+	replayParam, err = NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vanityParam.ADDRESSTYPE = message.AddressP2PKH
+	vanityParam.REPLAYPARAM = *replayParam
+	if _, code = requestSecureChannel(t, handler, vanityParam); code != 500 {
+		t.Error("The p2pkh vanity address should be rejected, status:", code)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/jayt106/bitcoinAddressGenerator/audit"
+	"github.com/jayt106/bitcoinAddressGenerator/bitcoind"
+	"github.com/jayt106/bitcoinAddressGenerator/keycache"
+	"github.com/jayt106/bitcoinAddressGenerator/vault"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// RegisterWalletHandler the handler uses for passing this struct into the ServerHTTP function
+type RegisterWalletHandler struct {
+	vault *vault.Vault
+}
+
+// ServeHTTP handle the V1/wallets/register API request behind the SecureChannel middleware. The seed is stored in the
+// vault encrypted at rest, the random wallet id, the wallet token and the informational master key fingerprint are
+// returned. The later derivation requests refer the seed by the wallet id with the wallet token, the token is only
+// returned here and registering the seed again issues a new token.
+func (rh *RegisterWalletHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Println("Handle API /v1/wallets/register")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Read body error:")
+		return
+	}
+
+	var walletParam WALLETPARAM
+	err = json.Unmarshal(body, &walletParam)
+	Clear(&body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Unmarshal data error:")
+		return
+	}
+
+	seed, err := hex.DecodeString(walletParam.SEED)
+	Clear(&walletParam)
+	if err != nil {
+		ServerErrorHandle(w, err, "Hex decode string error:")
+		return
+	}
+
+	walletID, token, err := rh.vault.Register(seed)
+	if err != nil {
+		for i := range seed {
+			seed[i] = 0
+		}
+		ServerErrorHandle(w, err, "Register wallet error:")
+		return
+	}
+	fingerprint, err := vault.Fingerprint(seed)
+	for i := range seed {
+		seed[i] = 0
+	}
+	if err != nil {
+		ServerErrorHandle(w, err, "Wallet fingerprint error:")
+		return
+	}
+
+	resp := make(map[string]string)
+	resp["walletId"] = walletID
+	resp["walletToken"] = token
+	resp["fingerprint"] = fingerprint
+
+	marshalledData, err := json.Marshal(resp)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json Marshal error:")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, err = w.Write(marshalledData)
+	if err != nil {
+		log.Println("ServeHTTP write error:", err)
+	}
+}
+
+// WalletsHandler the handler uses for passing this struct into the ServerHTTP function
+type WalletsHandler struct {
+	vault    *vault.Vault
+	auditLog *audit.Log
+	node     *bitcoind.Client
+	net      *chaincfg.Params
+	keyCache *keycache.Cache
+}
+
+// ServeHTTP handle the V1/wallets/{id}/nextAddress API request behind the SecureChannel middleware. Allocate the next
+// unused address index of the account and chain of the registered wallet and return its public key and SegWit address.
+// The request with the same idempotency key returns the same address.
+func (wh *WalletsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/wallets/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "nextAddress" {
+		log.Println("Unknown wallets API:", r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	walletID := parts[0]
+
+	log.Println("Handle API /v1/wallets/" + walletID + "/nextAddress")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		ServerErrorHandle(w, err, "Read body error:")
+		return
+	}
+
+	var addressParam NEXTADDRESSPARAM
+	err = json.Unmarshal(body, &addressParam)
+	if err != nil {
+		ServerErrorHandle(w, err, "Unmarshal data error:")
+		return
+	}
+
+	allocation, existing, err := wh.vault.NextIndex(walletID, addressParam.WALLETTOKEN, addressParam.ACCOUNT, addressParam.CHAIN, addressParam.IDEMPOTENCYKEY, addressParam.LABEL)
+	if err != nil {
+		ServerErrorHandle(w, err, "Allocate address index error:")
+		return
+	}
+
+	keyParam := BIP32PARAM{WALLETID: walletID, WALLETTOKEN: addressParam.WALLETTOKEN, PATH: KEYPATH{allocation.Account, allocation.Chain, allocation.Index}}
+	err = ResolveSeed(wh.vault, &keyParam)
+	if err != nil {
+		ServerErrorHandle(w, err, "Resolve wallet seed error:")
+		return
+	}
+	fingerprint, err := WalletFingerprint(&keyParam)
+	if err != nil {
+		Clear(&keyParam)
+		ServerErrorHandle(w, err, "Wallet fingerprint error:")
+		return
+	}
+
+	clientHDPubKey, err := GenerateHDPublicKey(&keyParam, wh.keyCache)
+	Clear(&keyParam)
+	if err != nil {
+		ServerErrorHandle(w, err, "Generate HD public key failed:")
+		return
+	}
+
+	compressedPubKey, err := ConvertPublicKey(clientHDPubKey)
+	if err != nil {
+		ServerErrorHandle(w, err, "Convert HD public key failed:")
+		return
+	}
+
+	segwitAddress, err := GenerateSegwitAddress(compressedPubKey, wh.net)
+	if err != nil {
+		ServerErrorHandle(w, err, "Generate segwit address failed:")
+		return
+	}
+
+	err = wh.auditLog.Append(audit.Entry{
+		Endpoint:  "/v1/wallets/" + walletID + "/nextAddress",
+		WalletID:  fingerprint,
+		Path:      KEYPATH{allocation.Account, allocation.Chain, allocation.Index}.String(),
+		Address:   *segwitAddress,
+		PublicKey: hex.EncodeToString(*compressedPubKey),
+		Requester: Requester(r),
+	})
+	if err != nil {
+		ServerErrorHandle(w, err, "Audit log error:")
+		return
+	}
+
+	resp := make(map[string]string)
+	resp["walletId"] = walletID
+	resp["account"] = strconv.FormatUint(uint64(allocation.Account), 10)
+	resp["chain"] = strconv.FormatUint(uint64(allocation.Chain), 10)
+	resp["address"] = strconv.FormatUint(uint64(allocation.Index), 10)
+	resp["label"] = allocation.Label
+	resp["publicKey"] = hex.EncodeToString(*compressedPubKey)
+	resp["segwitAddress"] = *segwitAddress
+	resp["existing"] = strconv.FormatBool(existing)
+
+	keyPath := KEYPATH{allocation.Account, allocation.Chain, allocation.Index}.String()
+	if state := WatchAddress(wh.node, bitcoind.WPKHDescriptor(fingerprint, keyPath, *compressedPubKey), allocation.Label); state != "" {
+		resp["watchOnly"] = state
+	}
+
+	marshalledData, err := json.Marshal(resp)
+	if err != nil {
+		ServerErrorHandle(w, err, "Json Marshal error:")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, err = w.Write(marshalledData)
+	if err != nil {
+		log.Println("ServeHTTP write error:", err)
+	}
+}
+
+// ResolveSeed Load the seed of the wallet id from the vault if the request refers the seed by the wallet id, the wallet
+// token issued at the registration must be given. The wallet id isn't a secret and never authorizes the use of the seed.
+func ResolveSeed(v *vault.Vault, p *BIP32PARAM) error {
+	if p.WALLETID == "" {
+		return nil
+	}
+	if p.SEED != "" {
+		return errors.New("the request should carry either the seed or the wallet id")
+	}
+	if v == nil {
+		return errors.New("the vault is not enabled")
+	}
+
+	seed, err := v.Seed(p.WALLETID, p.WALLETTOKEN)
+	if err != nil {
+		return err
+	}
+
+	p.SEED = hex.EncodeToString(seed)
+	for i := range seed {
+		seed[i] = 0
+	}
+	return nil
+}
+
+// WalletFingerprint Return the master key fingerprint of the seed of the derivation request for the audit log and the
+// descriptors, the seed of the wallet id is resolved by ResolveSeed first
+func WalletFingerprint(p *BIP32PARAM) (string, error) {
+	seed, err := hex.DecodeString(p.SEED)
+	if err != nil {
+		return "", err
+	}
+
+	fingerprint, err := vault.Fingerprint(seed)
+	for i := range seed {
+		seed[i] = 0
+	}
+	return fingerprint, err
+}
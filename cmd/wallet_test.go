@@ -0,0 +1,144 @@
+package main
+
+import (
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/jayt106/bitcoinAddressGenerator/audit"
+	"github.com/jayt106/bitcoinAddressGenerator/vault"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHTTPServerWalletVault(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	walletVault, err := vault.Open(filepath.Join(dir, "vault.json"), []byte("test passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auditLogFile := filepath.Join(dir, "audit.log")
+	auditLog, err := audit.Open(auditLogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filePath = workingDir + "/../test/test.json"
+	keyParam, err := ReadSeedFromJsonFile(&filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayParam, err := NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp, code := requestSecureChannel(t, &RegisterWalletHandler{walletVault}, WALLETPARAM{keyParam.SEED, *replayParam})
+	if code != 200 {
+		t.Fatal("Register wallet failed, status:", code)
+	}
+	walletID, walletToken, fingerprint := rsp["walletId"], rsp["walletToken"], rsp["fingerprint"]
+	if len(walletID) != 32 || len(walletToken) != 64 || len(fingerprint) != 8 || walletID == fingerprint {
+		t.Fatal("Unexpected wallet:", rsp)
+	}
+
+	// The derivation request refers the seed by the wallet id with the wallet token
+	replayParam, err = NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	param := BIP32PARAM{WALLETID: walletID, WALLETTOKEN: walletToken, PATH: keyParam.PATH, REPLAYPARAM: *replayParam}
+	rsp, code = requestSecureChannel(t, &HDKeyHandler{walletVault, auditLog, nil, &chaincfg.MainNetParams, nil}, param)
+	if code != 200 {
+		t.Fatal("Derive by the wallet id failed, status:", code)
+	}
+
+	if rsp["segwitAddress"] != "bc1q8c87x4v0m3dfrxksv724rtwpxy5ghpw8gwf8da" {
+		t.Error("Unmatched segwitAddress", rsp["segwitAddress"])
+	}
+
+	// The next address allocation returns the same address for the repeated idempotency key
+	addresses := make(map[string]bool)
+	for _, key := range []string{"order-1", "order-1", "order-2"} {
+		replayParam, err = NewReplayParam()
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := NEXTADDRESSPARAM{WALLETTOKEN: walletToken, IDEMPOTENCYKEY: key, REPLAYPARAM: *replayParam}
+		rsp, code = requestSecureChannelPath(t, &WalletsHandler{walletVault, auditLog, nil, &chaincfg.MainNetParams, nil}, "/v1/wallets/"+walletID+"/nextAddress", req)
+		if code != 200 {
+			t.Fatal("Next address failed, status:", code)
+		}
+		addresses[rsp["segwitAddress"]] = true
+	}
+	if len(addresses) != 2 || !addresses["bc1q8c87x4v0m3dfrxksv724rtwpxy5ghpw8gwf8da"] {
+		t.Error("Unexpected next addresses:", addresses)
+	}
+
+	replayParam, err = NewReplayParam()
+	if err != nil {
+		t.Fatal(err)
+	}
+	param = BIP32PARAM{WALLETID: fingerprint, WALLETTOKEN: walletToken, PATH: keyParam.PATH, REPLAYPARAM: *replayParam}
+	_, code = requestSecureChannel(t, &HDKeyHandler{walletVault, auditLog, nil, &chaincfg.MainNetParams, nil}, param)
+	if code != 500 {
+		t.Error("The fingerprint isn't the wallet id, status:", code)
+	}
+
+	// The public wallet id isn't enough to use the seed
+	for _, token := range []string{"", strings.Repeat("0", 64)} {
+		replayParam, err = NewReplayParam()
+		if err != nil {
+			t.Fatal(err)
+		}
+		param = BIP32PARAM{WALLETID: walletID, WALLETTOKEN: token, PATH: keyParam.PATH, REPLAYPARAM: *replayParam}
+		_, code = requestSecureChannel(t, &HDKeyHandler{walletVault, auditLog, nil, &chaincfg.MainNetParams, nil}, param)
+		if code != 500 {
+			t.Error("The wallet token should be required, status:", code)
+		}
+		replayParam, err = NewReplayParam()
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := NEXTADDRESSPARAM{WALLETTOKEN: token, REPLAYPARAM: *replayParam}
+		_, code = requestSecureChannelPath(t, &WalletsHandler{walletVault, auditLog, nil, &chaincfg.MainNetParams, nil}, "/v1/wallets/"+walletID+"/nextAddress", req)
+		if code != 500 {
+			t.Error("The wallet token should be required to allocate the address, status:", code)
+		}
+	}
+
+	// Every issued address is recorded without the seed
+	entries, err := audit.Verify(auditLogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Fatal("Unexpected audit log entries:", len(entries))
+	}
+	if entries[0].Endpoint != "/v1/genPublicKeyAndSegWitAddress" || entries[0].WalletID != fingerprint ||
+		entries[0].Path != keyParam.PATH.String() || entries[0].Address != "bc1q8c87x4v0m3dfrxksv724rtwpxy5ghpw8gwf8da" {
+		t.Error("Unexpected audit log entry:", entries[0])
+	}
+	if entries[3].Requester != "192.0.2.1:1234" || entries[3].Endpoint != "/v1/wallets/"+walletID+"/nextAddress" {
+		t.Error("Unexpected audit log entry:", entries[3])
+	}
+
+	data, err := ioutil.ReadFile(auditLogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), keyParam.SEED) {
+		t.Error("The seed is recorded in the audit log")
+	}
+}
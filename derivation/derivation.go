@@ -0,0 +1,208 @@
+package derivation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"runtime"
+	"sync"
+)
+
+// DefaultBatchSize the number of the indices derived by a worker at a time
+const DefaultBatchSize = 64
+
+// maxPrealloc the max keys allocated ahead by Range, the large count isn't trusted
+const maxPrealloc = 1 << 16
+
+// Key the derived key of an index, with the addresses and the output scripts of the address types of the options
+type Key struct {
+	Index     uint32
+	PubKey    *btcec.PublicKey
+	Addresses []string
+	PkScripts [][]byte
+}
+
+// Options the workers, the address types and the progress reports of the derivation
+type Options struct {
+	// Workers the goroutine workers, the number of CPUs by default
+	Workers int
+	// BatchSize the indices of a task of the workers, DefaultBatchSize by default
+	BatchSize uint32
+	// AddressTypes the addresses and the output scripts of each key, the public key only if empty
+	AddressTypes []string
+	Net          *chaincfg.Params
+	// Progress is called with the number of the indices done after each batch
+	Progress func(done uint32, total uint32)
+}
+
+// task the batch of the indices, the result is sent once by the worker
+type task struct {
+	start  uint32
+	count  uint32
+	result chan taskResult
+}
+
+// taskResult the keys of the batch
+type taskResult struct {
+	keys []Key
+	err  error
+}
+
+// Stream Derive the child keys of the indices [start, start+count) of the neutered chain key by the bounded worker
+// pool and call fn with each key in the index order. The batches of the indices are derived concurrently, at most two
+// batches per worker are buffered ahead of fn. The invalid child keys are skipped by BIP032. The derivation stops at
+// the cancellation of the context or the first error of fn.
+func Stream(ctx context.Context, chainKey *hdkeychain.ExtendedKey, start uint32, count uint32, opts Options, fn func(*Key) error) error {
+	if chainKey.IsPrivate() {
+		return errors.New("the chain key must be neutered")
+	}
+	if uint64(start)+uint64(count) > hdkeychain.HardenedKeyStart {
+		return fmt.Errorf("the indices %d+%d are beyond the non-hardened keys", start, count)
+	}
+	net := opts.Net
+	if net == nil {
+		net = &chaincfg.MainNetParams
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	batchSize := opts.BatchSize
+	if batchSize == 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tasks := make(chan *task)
+	order := make(chan *task, 2*workers)
+	go func() {
+		defer close(tasks)
+		defer close(order)
+		for done := uint32(0); done < count; done += batchSize {
+			t := &task{start: start + done, count: batchSize, result: make(chan taskResult, 1)}
+			if count-done < batchSize {
+				t.count = count - done
+			}
+			select {
+			case order <- t:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case tasks <- t:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range tasks {
+				keys, err := deriveBatch(ctx, chainKey, t.start, t.count, opts.AddressTypes, net)
+				t.result <- taskResult{keys, err}
+			}
+		}()
+	}
+
+	done := uint32(0)
+	for t := range order {
+		var result taskResult
+		select {
+		case result = <-t.result:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if result.err != nil {
+			return result.err
+		}
+		for i := range result.keys {
+			err := fn(&result.keys[i])
+			if err != nil {
+				return err
+			}
+		}
+		done += t.count
+		if opts.Progress != nil {
+			opts.Progress(done, count)
+		}
+	}
+	return ctx.Err()
+}
+
+// Range Return the child keys of the indices [start, start+count) of the neutered chain key in the index order, the
+// keys are derived by the bounded worker pool of Stream
+func Range(ctx context.Context, chainKey *hdkeychain.ExtendedKey, start uint32, count uint32, opts Options) ([]Key, error) {
+	capacity := count
+	if capacity > maxPrealloc {
+		capacity = maxPrealloc
+	}
+	keys := make([]Key, 0, capacity)
+	err := Stream(ctx, chainKey, start, count, opts, func(key *Key) error {
+		keys = append(keys, *key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// deriveBatch Derive the keys of the batch, the invalid child keys are skipped
+func deriveBatch(ctx context.Context, chainKey *hdkeychain.ExtendedKey, start uint32, count uint32, addressTypes []string,
+	net *chaincfg.Params) ([]Key, error) {
+	keys := make([]Key, 0, count)
+	for index := start; index-start < count; index++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		key, err := DeriveKey(chainKey, index, addressTypes, net)
+		if err == hdkeychain.ErrInvalidChild {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, nil
+}
+
+// DeriveKey Derive the child key of the index of the chain key with the addresses and the output scripts of the
+// address types, the serial path of a single key
+func DeriveKey(chainKey *hdkeychain.ExtendedKey, index uint32, addressTypes []string, net *chaincfg.Params) (*Key, error) {
+	child, err := chainKey.Derive(index)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := child.ECPubKey()
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{Index: index, PubKey: pubKey}
+	for _, addressType := range addressTypes {
+		address, err := message.Address(pubKey, addressType, net)
+		if err != nil {
+			return nil, err
+		}
+		// The script of the address skips the second taproot tweak
+		pkScript, err := message.AddressScript(address, net)
+		if err != nil {
+			return nil, err
+		}
+		key.Addresses = append(key.Addresses, address)
+		key.PkScripts = append(key.PkScripts, pkScript)
+	}
+	return key, nil
+}
@@ -0,0 +1,171 @@
+package derivation
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"runtime"
+	"testing"
+)
+
+// testChainKey Return the neutered chain key m/0'/0 of the test seed
+func testChainKey(t testing.TB) *hdkeychain.ExtendedKey {
+	seed, _ := hex.DecodeString("a966eb6058f8ec9f47074a2faadd3dab42e2c60ed05bc34d39d6c0e1d32b8bdf")
+	master, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accKey, err := master.Derive(hdkeychain.HardenedKeyStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accPubKey, err := accKey.Neuter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainKey, err := accPubKey.Derive(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chainKey
+}
+
+func TestRange(t *testing.T) {
+	chainKey := testChainKey(t)
+	addressTypes := []string{message.AddressP2WPKH, message.AddressP2TR}
+
+	// The serial derivation of the same range
+	var serial []*Key
+	for index := uint32(5); index < 205; index++ {
+		key, err := DeriveKey(chainKey, index, addressTypes, &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serial = append(serial, key)
+	}
+
+	for _, opts := range []Options{
+		{AddressTypes: addressTypes},
+		{Workers: 3, BatchSize: 7, AddressTypes: addressTypes},
+		{Workers: 1, BatchSize: 1000, AddressTypes: addressTypes},
+	} {
+		var progress []uint32
+		opts.Progress = func(done uint32, total uint32) {
+			if total != 200 {
+				t.Error("Unexpected progress total:", total)
+			}
+			progress = append(progress, done)
+		}
+		keys, err := Range(context.Background(), chainKey, 5, 200, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != len(serial) {
+			t.Fatal("Unexpected keys:", len(keys))
+		}
+		for i, key := range keys {
+			if key.Index != serial[i].Index || !key.PubKey.IsEqual(serial[i].PubKey) || key.Addresses[1] != serial[i].Addresses[1] ||
+				hex.EncodeToString(key.PkScripts[0]) != hex.EncodeToString(serial[i].PkScripts[0]) {
+				t.Fatal("The keys aren't in the index order:", opts.Workers, opts.BatchSize, i, key.Index)
+			}
+		}
+		if len(progress) == 0 || progress[len(progress)-1] != 200 {
+			t.Error("Unexpected progress:", progress)
+		}
+		for i := 1; i < len(progress); i++ {
+			if progress[i] <= progress[i-1] {
+				t.Error("The progress should increase:", progress)
+			}
+		}
+	}
+
+	keys, err := Range(context.Background(), chainKey, 0, 3, Options{})
+	if err != nil || len(keys) != 3 || keys[2].Addresses != nil {
+		t.Error("The keys without the address types should only have the public keys:", keys, err)
+	}
+	if _, err = Range(context.Background(), chainKey, hdkeychain.HardenedKeyStart-2, 3, Options{}); err == nil {
+		t.Error("The hardened indices should be rejected")
+	}
+	seed, _ := hex.DecodeString("a966eb6058f8ec9f47074a2faadd3dab42e2c60ed05bc34d39d6c0e1d32b8bdf")
+	master, _ := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	if _, err = Range(context.Background(), master, 0, 3, Options{}); err == nil {
+		t.Error("The private key should be rejected")
+	}
+}
+
+func TestStreamStop(t *testing.T) {
+	chainKey := testChainKey(t)
+	goroutines := runtime.NumGoroutine()
+
+	// The context is cancelled by the consumer in the middle of the range
+	ctx, cancel := context.WithCancel(context.Background())
+	received := 0
+	err := Stream(ctx, chainKey, 0, 100000, Options{Workers: 4, BatchSize: 8}, func(key *Key) error {
+		received++
+		if received == 20 {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled || received >= 100000 {
+		t.Error("The derivation should stop at the cancellation:", err, received)
+	}
+
+	errStop := errors.New("stop")
+	received = 0
+	err = Stream(context.Background(), chainKey, 0, 100000, Options{Workers: 4, BatchSize: 8}, func(key *Key) error {
+		received++
+		if key.Index == 30 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop || received != 31 {
+		t.Error("The derivation should stop at the error of the consumer:", err, received)
+	}
+
+	// The workers and the producer are done after the return
+	for i := 0; i < 100 && runtime.NumGoroutine() > goroutines; i++ {
+		runtime.Gosched()
+	}
+	if runtime.NumGoroutine() > goroutines+1 {
+		t.Error("The goroutines of the derivation are leaked:", runtime.NumGoroutine(), goroutines)
+	}
+}
+
+// benchmarkRange Derive the P2WPKH addresses of 1000 indices by the workers
+func benchmarkRange(b *testing.B, workers int) {
+	chainKey := testChainKey(b)
+	opts := Options{Workers: workers, AddressTypes: []string{message.AddressP2WPKH}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Range(context.Background(), chainKey, 0, 1000, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkRangeSerial the serial path deriving the indices one by one
+func BenchmarkRangeSerial(b *testing.B) {
+	chainKey := testChainKey(b)
+	addressTypes := []string{message.AddressP2WPKH}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for index := uint32(0); index < 1000; index++ {
+			if _, err := DeriveKey(chainKey, index, addressTypes, &chaincfg.MainNetParams); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkRangeOneWorker(b *testing.B) {
+	benchmarkRange(b, 1)
+}
+
+func BenchmarkRangeParallel(b *testing.B) {
+	benchmarkRange(b, 0)
+}
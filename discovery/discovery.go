@@ -0,0 +1,109 @@
+package discovery
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/jayt106/bitcoinAddressGenerator/bitcoind"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"github.com/jayt106/bitcoinAddressGenerator/utxoscan"
+)
+
+// Account a used account, the last used index of the external (0) and internal (1) chains, -1 if the chain is unused
+type Account struct {
+	Account  uint32   `json:"account"`
+	XPub     string   `json:"xpub"`
+	LastUsed [2]int64 `json:"lastUsed"`
+}
+
+// Accounts Return the used accounts of the gap limit scan, the scan of the seed ends with the first unused account
+func Accounts(report *utxoscan.Report) []Account {
+	accounts := []Account{}
+	for _, a := range report.Accounts {
+		if a.LastUsed[0] >= 0 || a.LastUsed[1] >= 0 {
+			accounts = append(accounts, Account{a.Account, a.XPub, a.LastUsed})
+		}
+	}
+	return accounts
+}
+
+// ScriptSet the oracle of the known used output scripts
+type ScriptSet map[string]bool
+
+// NewAddressSet Return the oracle of the list of the used addresses of the network
+func NewAddressSet(addresses []string, net *chaincfg.Params) (ScriptSet, error) {
+	set := make(ScriptSet, len(addresses))
+	for _, address := range addresses {
+		pkScript, err := message.AddressScript(address, net)
+		if err != nil {
+			return nil, fmt.Errorf("address %s: %v", address, err)
+		}
+		set[string(pkScript)] = true
+	}
+	return set, nil
+}
+
+// Coins Call fn with a coin without value for each script of the windows in the set
+func (s ScriptSet) Coins(windows []utxoscan.Window, fn func(*utxoscan.Coin) error) error {
+	for _, window := range windows {
+		for _, pkScript := range window.PkScripts {
+			if !s[string(pkScript)] {
+				continue
+			}
+			err := fn(&utxoscan.Coin{PkScript: pkScript})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rangedDescriptors the scantxoutset descriptors of the address types, of the chain xpub and its child keys
+var rangedDescriptors = map[string]string{
+	message.AddressP2PKH:      "pkh(%s/*)",
+	message.AddressP2SHP2WPKH: "sh(wpkh(%s/*))",
+	message.AddressP2WPKH:     "wpkh(%s/*)",
+	message.AddressP2TR:       "tr(%s/*)",
+}
+
+// NodeOracle the oracle of the utxo set of the bitcoind node by scantxoutset, the address of which the outputs are all
+// spent is seen as unused
+type NodeOracle struct {
+	Node *bitcoind.Client
+}
+
+// Coins Call fn with a coin without value for each script of the windows with the unspent outputs in the utxo set of
+// the node. Each window is scanned by the ranged descriptors of the chain xpub, and all the windows of a pass by a
+// single scantxoutset, as each scan reads the whole utxo set.
+func (o *NodeOracle) Coins(windows []utxoscan.Window, fn func(*utxoscan.Coin) error) error {
+	var descs []bitcoind.ScanObject
+	for _, window := range windows {
+		for _, addressType := range window.AddressTypes {
+			template, ok := rangedDescriptors[addressType]
+			if !ok {
+				return fmt.Errorf("unknown address type %s", addressType)
+			}
+			descs = append(descs, bitcoind.ScanObject{
+				Desc:  fmt.Sprintf(template, window.ChainKey.String()),
+				Range: []uint32{window.Start, window.End - 1},
+			})
+		}
+	}
+
+	scripts, err := o.Node.ScanTxOutSet(descs)
+	if err != nil {
+		return err
+	}
+	for _, s := range scripts {
+		pkScript, err := hex.DecodeString(s)
+		if err != nil {
+			return err
+		}
+		err = fn(&utxoscan.Coin{PkScript: pkScript})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
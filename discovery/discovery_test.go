@@ -0,0 +1,165 @@
+package discovery
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/jayt106/bitcoinAddressGenerator/bitcoind"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"github.com/jayt106/bitcoinAddressGenerator/utxoscan"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var testSeed, _ = hex.DecodeString("a966eb6058f8ec9f47074a2faadd3dab42e2c60ed05bc34d39d6c0e1d32b8bdf")
+
+// testAddress Return the address of the address type of m/account'/chain/index of the test seed
+func testAddress(t *testing.T, account, chain, index uint32, addressType string) string {
+	key, err := hdkeychain.NewMaster(testSeed, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []uint32{hdkeychain.HardenedKeyStart + account, chain, index} {
+		if key, err = key.Derive(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pubKey, err := key.ECPubKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := message.Address(pubKey, addressType, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return address
+}
+
+// testDiscover Return the used accounts of the test seed found by the oracle and the scan report
+func testDiscover(t *testing.T, oracle utxoscan.Oracle, gapLimit uint32, addressTypes []string) ([]Account, *utxoscan.Report) {
+	scanner, err := utxoscan.NewSeedScanner(testSeed, gapLimit, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer scanner.Zero()
+	if err = scanner.SetAddressTypes(addressTypes); err != nil {
+		t.Fatal(err)
+	}
+	report, err := scanner.Scan(oracle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Accounts(report), report
+}
+
+func TestScriptSet(t *testing.T) {
+	addresses := []string{
+		testAddress(t, 0, 0, 10, message.AddressP2WPKH),
+		// Found after the index 10, the index 31 is within the gap limit of the index 25 and 60 is beyond it
+		testAddress(t, 0, 0, 25, message.AddressP2PKH),
+		testAddress(t, 0, 0, 31, message.AddressP2TR),
+		testAddress(t, 0, 0, 60, message.AddressP2WPKH),
+		testAddress(t, 0, 1, 3, message.AddressP2SHP2WPKH),
+		testAddress(t, 1, 0, 0, message.AddressP2WPKH),
+		// The account 2 has only the internal chain history, it's used like the utxoScan tool sees it
+		testAddress(t, 2, 1, 0, message.AddressP2WPKH),
+		testAddress(t, 3, 0, 0, message.AddressP2WPKH),
+	}
+	oracle, err := NewAddressSet(addresses, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accounts, report := testDiscover(t, oracle, utxoscan.DefaultGapLimit, utxoscan.DefaultAddressTypes)
+	if len(accounts) != 4 || accounts[0].LastUsed != [2]int64{31, 3} || accounts[1].LastUsed != [2]int64{0, -1} ||
+		accounts[2].LastUsed != [2]int64{-1, 0} || accounts[3].LastUsed != [2]int64{0, -1} {
+		t.Fatal("Unexpected accounts:", accounts)
+	}
+	if accounts[1].Account != 1 || accounts[1].XPub != report.Accounts[1].XPub || !strings.HasPrefix(accounts[1].XPub, "xpub") {
+		t.Error("Unexpected xpub of the account 1:", accounts[1])
+	}
+	// The chains of the accounts 0 to 3 and of the unused account 4
+	if report.Derived != (52+24)+(21+20)+(20+21)+(21+20)+(20+20) {
+		t.Error("Unexpected derived keys:", report.Derived)
+	}
+
+	// The P2WPKH addresses only
+	accounts, _ = testDiscover(t, oracle, utxoscan.DefaultGapLimit, []string{message.AddressP2WPKH})
+	if len(accounts) != 4 || accounts[0].LastUsed != [2]int64{10, -1} {
+		t.Error("Unexpected P2WPKH accounts:", accounts)
+	}
+
+	if accounts, _ = testDiscover(t, ScriptSet{}, utxoscan.DefaultGapLimit, utxoscan.DefaultAddressTypes); len(accounts) != 0 {
+		t.Error("The unused seed should have no account:", accounts)
+	}
+	if _, err = NewAddressSet([]string{"invalid"}, &chaincfg.MainNetParams); err == nil {
+		t.Error("The invalid address should be rejected")
+	}
+}
+
+func TestNodeOracle(t *testing.T) {
+	master, err := hdkeychain.NewMaster(testSeed, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainKey := master
+	for _, i := range []uint32{hdkeychain.HardenedKeyStart, 0} {
+		if chainKey, err = chainKey.Derive(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if chainKey, err = chainKey.Neuter(); err != nil {
+		t.Fatal(err)
+	}
+	usedScript, err := message.AddressScript(testAddress(t, 0, 0, 5, message.AddressP2WPKH), &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The node has the unspent output of m/0'/0/5, each scan is recorded
+	var scans [][]bitcoind.ScanObject
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     uint64            `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != "scantxoutset" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var descs []bitcoind.ScanObject
+		_ = json.Unmarshal(req.Params[1], &descs)
+		scans = append(scans, descs)
+		unspents := []map[string]string{}
+		for _, desc := range descs {
+			if desc.Desc == fmt.Sprintf("wpkh(%s/*)", chainKey.String()) && desc.Range[0] <= 5 && desc.Range[1] >= 5 {
+				unspents = append(unspents, map[string]string{"scriptPubKey": hex.EncodeToString(usedScript)})
+			}
+		}
+		result := map[string]interface{}{"success": true, "unspents": unspents}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": result, "error": nil, "id": req.ID})
+	}))
+	defer server.Close()
+	node, err := bitcoind.New(strings.Replace(server.URL, "http://", "http://user:password@", 1), "", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accounts, _ := testDiscover(t, &NodeOracle{node}, utxoscan.DefaultGapLimit, utxoscan.DefaultAddressTypes)
+	if len(accounts) != 1 || accounts[0].LastUsed != [2]int64{5, -1} {
+		t.Fatal("Unexpected node accounts:", accounts)
+	}
+
+	// A scan per pass: the chains of the account 0, then the gap after the index 5 and the chains of the account 1
+	if len(scans) != 2 || len(scans[0]) != 2*len(utxoscan.DefaultAddressTypes) || len(scans[1]) != 3*len(utxoscan.DefaultAddressTypes) {
+		t.Fatal("Unexpected scans:", scans)
+	}
+	if desc := scans[1][0]; desc.Desc != fmt.Sprintf("pkh(%s/*)", chainKey.String()) || desc.Range[0] != 20 || desc.Range[1] != 25 {
+		t.Error("Unexpected ranged descriptor:", desc)
+	}
+}
@@ -1,4 +1,5 @@
-# This example will call the server to retrivev the public key of the server
-# And then the client can use the key to encrypt their seed data to avoid the network attack (But middle man attack might happens)
+# This example will call the server to retrivev the public key of the server and its key id
+# And then the client can use the key to encrypt their seed data to avoid the network attack
+# The key is signed by the server identity key, verify the signature by the identity fingerprint to prevent the middle man attack
 
 curl  http://localhost:8080/v1/serverPublicKeys
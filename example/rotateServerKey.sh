@@ -0,0 +1,5 @@
+# This example will call the server to rotate the server channel key, the server needs to be launched with the ADMIN_TOKEN environment variable
+# The previous key is still accepted until the grace period (-keyGracePeriod) expires
+
+curl -v -X POST "http://localhost:8080/v1/admin/rotateServerKey" \
+  -H "Authorization: Bearer ${ADMIN_TOKEN}"
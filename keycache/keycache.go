@@ -0,0 +1,170 @@
+package keycache
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"sync"
+	"time"
+)
+
+// Stats the counters of the cache
+type Stats struct {
+	Entries   int    `json:"entries"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// entry the cached key, the key is used under the read lock and zeroed under the write lock
+type entry struct {
+	id     string
+	expiry time.Time
+	mu     sync.RWMutex
+	key    *hdkeychain.ExtendedKey
+}
+
+// zero Zero the key after the running users of the key are done
+func (e *entry) zero() {
+	e.mu.Lock()
+	e.key.Zero()
+	e.key = nil
+	e.mu.Unlock()
+}
+
+// Cache the in-memory cache of the neutered account and chain keys, bounded by the number of the keys and the time to
+// live of each key. The least recently used key is evicted when the cache is full. The evicted and expired keys are
+// zeroed, the neutered key isn't a secret but it reveals all the addresses of the chain, and the private key of the
+// chain with any of its child private keys.
+type Cache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	salt       []byte
+	entries    map[string]*list.Element
+	lru        *list.List
+	stats      Stats
+	now        func() time.Time
+}
+
+// New Create the cache of at most maxEntries keys, each key is kept for the ttl after it's added
+func New(maxEntries int, ttl time.Duration) (*Cache, error) {
+	if maxEntries <= 0 {
+		return nil, errors.New("the cache size must be positive")
+	}
+	if ttl <= 0 {
+		return nil, errors.New("the cache ttl must be positive")
+	}
+	salt := make([]byte, 32)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		salt:       salt,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		now:        time.Now,
+	}, nil
+}
+
+// ID Return the cache id of the key of the path prefix (m/account' or m/account'/chain) of the seed. The seed
+// fingerprint is the HMAC of the seed by the random salt of the cache, unlike the BIP032 master key fingerprint it
+// needs no master key derivation and can't be linked to the wallet outside the process.
+func (c *Cache) ID(seed []byte, path string) string {
+	mac := hmac.New(sha256.New, c.salt)
+	mac.Write(seed)
+	return hex.EncodeToString(mac.Sum(nil)) + "/" + path
+}
+
+// Use Run fn with the cached key of the id and return true, or return false if the key isn't cached or has expired.
+// The key is only valid within fn, it's zeroed at the eviction.
+func (c *Cache) Use(id string, fn func(*hdkeychain.ExtendedKey) error) (bool, error) {
+	c.mu.Lock()
+	elem, ok := c.entries[id]
+	if !ok {
+		c.stats.Misses++
+		c.mu.Unlock()
+		return false, nil
+	}
+	e := elem.Value.(*entry)
+	if !c.now().Before(e.expiry) {
+		c.remove(elem)
+		c.stats.Misses++
+		c.mu.Unlock()
+		return false, nil
+	}
+	c.lru.MoveToFront(elem)
+	c.stats.Hits++
+	e.mu.RLock()
+	c.mu.Unlock()
+
+	defer e.mu.RUnlock()
+	if e.key == nil {
+		// Evicted by Purge after the lookup
+		return false, nil
+	}
+	return true, fn(e.key)
+}
+
+// Add Cache the neutered key of the id, the cache owns the key after it's added and the caller mustn't use it anymore.
+// The expired keys are removed, and the least recently used keys are evicted if the cache is full.
+func (c *Cache) Add(id string, key *hdkeychain.ExtendedKey) error {
+	if key.IsPrivate() {
+		return errors.New("only the neutered key is cached")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[id]; ok {
+		// Added by a concurrent miss
+		key.Zero()
+		return nil
+	}
+
+	now := c.now()
+	for elem := c.lru.Back(); elem != nil; {
+		prev := elem.Prev()
+		if !now.Before(elem.Value.(*entry).expiry) {
+			c.remove(elem)
+		}
+		elem = prev
+	}
+	for c.lru.Len() >= c.maxEntries {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+	c.entries[id] = c.lru.PushFront(&entry{id: id, expiry: now.Add(c.ttl), key: key})
+	return nil
+}
+
+// Purge Zero and remove all the keys
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.lru.Len() != 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Stats Return the counters of the cache
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	return stats
+}
+
+// remove Remove the entry and zero its key, the cache lock is held
+func (c *Cache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*entry)
+	delete(c.entries, e.id)
+	e.zero()
+}
@@ -0,0 +1,174 @@
+package keycache
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testKey Return the neutered key m/account' of the test seed
+func testKey(t testing.TB, account uint32) *hdkeychain.ExtendedKey {
+	seed, _ := hex.DecodeString("a966eb6058f8ec9f47074a2faadd3dab42e2c60ed05bc34d39d6c0e1d32b8bdf")
+	master, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := master.Derive(hdkeychain.HardenedKeyStart + account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err = key.Neuter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestCache(t *testing.T) {
+	cache, err := New(2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	seed := []byte("seed")
+	if cache.ID(seed, "m/0'") == cache.ID([]byte("other"), "m/0'") || cache.ID(seed, "m/0'") == cache.ID(seed, "m/1'") {
+		t.Error("The ids of the different seeds or paths should be different")
+	}
+	if other, _ := New(2, time.Minute); other.ID(seed, "m/0'") == cache.ID(seed, "m/0'") {
+		t.Error("The seed fingerprint should depend on the cache salt")
+	}
+
+	var xpubs []string
+	var keys []*hdkeychain.ExtendedKey
+	for i := uint32(0); i < 3; i++ {
+		keys = append(keys, testKey(t, i))
+		xpubs = append(xpubs, keys[i].String())
+	}
+
+	use := func(id string) string {
+		xpub := ""
+		ok, err := cache.Use(id, func(key *hdkeychain.ExtendedKey) error {
+			xpub = key.String()
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return ""
+		}
+		return xpub
+	}
+	if use("m/0'") != "" {
+		t.Error("The empty cache should miss")
+	}
+	if err = cache.Add("m/0'", keys[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err = cache.Add("m/1'", keys[1]); err != nil {
+		t.Fatal(err)
+	}
+	if use("m/0'") != xpubs[0] {
+		t.Error("The cached key should hit")
+	}
+
+	// The least recently used key m/1' is evicted and zeroed
+	if err = cache.Add("m/2'", keys[2]); err != nil {
+		t.Fatal(err)
+	}
+	if use("m/1'") != "" || use("m/0'") != xpubs[0] || use("m/2'") != xpubs[2] {
+		t.Error("The least recently used key should be evicted")
+	}
+	if _, err = keys[1].ECPubKey(); err == nil {
+		t.Error("The evicted key should be zeroed")
+	}
+	if stats := cache.Stats(); stats.Entries != 2 || stats.Hits != 3 || stats.Misses != 2 || stats.Evictions != 1 {
+		t.Error("Unexpected stats:", stats)
+	}
+
+	// The keys expire after the ttl
+	now = now.Add(time.Minute)
+	if use("m/0'") != "" {
+		t.Error("The expired key should miss")
+	}
+	if _, err = keys[0].ECPubKey(); err == nil {
+		t.Error("The expired key should be zeroed")
+	}
+	key := testKey(t, 0)
+	if err = cache.Add("m/0'", key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = keys[2].ECPubKey(); err == nil || cache.Stats().Entries != 1 {
+		t.Error("The expired keys should be removed by the next add")
+	}
+
+	cache.Purge()
+	if _, err = key.ECPubKey(); err == nil || cache.Stats().Entries != 0 {
+		t.Error("The purged key should be zeroed")
+	}
+
+	seedKey, _ := hdkeychain.NewMaster(make([]byte, 32), &chaincfg.MainNetParams)
+	if err = cache.Add("m", seedKey); err == nil {
+		t.Error("The private key should be rejected")
+	}
+	if _, err = New(0, time.Minute); err == nil {
+		t.Error("The empty cache should be rejected")
+	}
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	cache, err := New(4, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xpubs := make([]string, 8)
+	for i := range xpubs {
+		xpubs[i] = testKey(t, uint32(i)).String()
+	}
+
+	// The keys are used and evicted concurrently, a used key is never zeroed
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				account := (w + i) % len(xpubs)
+				id := fmt.Sprintf("m/%d'", account)
+				var xpub string
+				ok, err := cache.Use(id, func(key *hdkeychain.ExtendedKey) error {
+					_, err := key.Derive(uint32(i))
+					xpub = key.String()
+					return err
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !ok {
+					key, err := hdkeychain.NewKeyFromString(xpubs[account])
+					if err == nil {
+						err = cache.Add(id, key)
+					}
+					if err != nil {
+						t.Error(err)
+					}
+					continue
+				}
+				if xpub != xpubs[account] {
+					t.Error("The used key is changed:", account)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if stats := cache.Stats(); stats.Entries != 4 || stats.Hits == 0 {
+		t.Error("Unexpected stats:", stats)
+	}
+}
@@ -0,0 +1,147 @@
+package message
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/jayt106/bitcoinAddressGenerator/taproot"
+	"math"
+)
+
+// maxWitnessItemSize the max size of the witness item of a BIP322 simple signature
+const maxWitnessItemSize = 10000
+
+// bip322Hash Return the BIP322 tagged hash of the message
+func bip322Hash(message string) []byte {
+	return taproot.TaggedHash("BIP0322-signed-message", []byte(message))
+}
+
+// toSpend Return the virtual transaction of which the output is spent by the BIP322 signature
+func toSpend(message string, pkScript []byte) *wire.MsgTx {
+	tx := wire.NewMsgTx(0)
+	scriptSig := append([]byte{txscript.OP_0, txscript.OP_DATA_32}, bip322Hash(message)...)
+	tx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{}, Index: math.MaxUint32},
+		SignatureScript:  scriptSig,
+		Sequence:         0,
+	})
+	tx.AddTxOut(wire.NewTxOut(0, pkScript))
+	return tx
+}
+
+// toSign Return the virtual transaction spending the output of the to_spend transaction, the witness is the signature
+func toSign(toSpend *wire.MsgTx) *wire.MsgTx {
+	tx := wire.NewMsgTx(0)
+	tx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: toSpend.TxHash(), Index: 0},
+		Sequence:         0,
+	})
+	tx.AddTxOut(wire.NewTxOut(0, []byte{txscript.OP_RETURN}))
+	return tx
+}
+
+// signBIP322 Return the BIP322 simple signature, the serialized witness of the to_sign transaction
+func signBIP322(privKey *btcec.PrivateKey, addressType string, message string, net *chaincfg.Params) ([]byte, error) {
+	pkScript, err := PkScript(privKey.PubKey(), addressType, net)
+	if err != nil {
+		return nil, err
+	}
+
+	spend := toSpend(message, pkScript)
+	tx := toSign(spend)
+
+	var witness wire.TxWitness
+	switch addressType {
+	case AddressP2WPKH:
+		witness, err = txscript.WitnessSignature(tx, txscript.NewTxSigHashes(tx), 0, 0, pkScript, txscript.SigHashAll, privKey, true)
+	case AddressP2TR:
+		var sig []byte
+		sig, err = taproot.SignKeyPath(tx, 0, spend.TxOut, taproot.SigHashDefault, privKey)
+		witness = wire.TxWitness{sig}
+	default:
+		err = fmt.Errorf("the BIP322 simple signature doesn't support the address type %s", addressType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = wire.WriteVarInt(&buf, 0, uint64(len(witness)))
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range witness {
+		err = wire.WriteVarBytes(&buf, 0, item)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// verifyBIP322 Verify the BIP322 simple signature by executing the to_sign transaction against the address script
+func verifyBIP322(address string, message string, sig []byte, net *chaincfg.Params) error {
+	pkScript, err := AddressScript(address, net)
+	if err != nil {
+		return err
+	}
+
+	r := bytes.NewReader(sig)
+	count, err := wire.ReadVarInt(r, 0)
+	if err != nil || count == 0 || count > uint64(len(sig)) {
+		return ErrInvalidSignature
+	}
+	witness := make(wire.TxWitness, count)
+	for i := range witness {
+		witness[i], err = wire.ReadVarBytes(r, 0, maxWitnessItemSize, "witness item")
+		if err != nil {
+			return ErrInvalidSignature
+		}
+	}
+	if r.Len() != 0 {
+		return ErrInvalidSignature
+	}
+
+	spend := toSpend(message, pkScript)
+	tx := toSign(spend)
+	tx.TxIn[0].Witness = witness
+
+	if taproot.IsPkScript(pkScript) {
+		if len(witness) != 1 || taproot.VerifyKeyPath(tx, 0, spend.TxOut, witness[0]) != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+
+	vm, err := txscript.NewEngine(pkScript, tx, 0, txscript.StandardVerifyFlags, nil, txscript.NewTxSigHashes(tx), 0)
+	if err != nil {
+		return err
+	}
+	if vm.Execute() != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// AddressScript Return the output script of the address, the P2TR address is decoded as bech32m
+func AddressScript(address string, net *chaincfg.Params) ([]byte, error) {
+	outputKey, err := taproot.DecodeAddress(address, net)
+	if err == nil {
+		return append([]byte{txscript.OP_1, txscript.OP_DATA_32}, outputKey...), nil
+	}
+
+	decoded, err := btcutil.DecodeAddress(address, net)
+	if err != nil {
+		return nil, err
+	}
+	if !decoded.IsForNet(net) {
+		return nil, errors.New("the address isn't for the network")
+	}
+	return txscript.PayToAddrScript(decoded)
+}
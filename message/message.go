@@ -0,0 +1,207 @@
+package message
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/jayt106/bitcoinAddressGenerator/taproot"
+)
+
+// The address types of the signing key
+const (
+	AddressP2PKH      = "p2pkh"
+	AddressP2SHP2WPKH = "p2sh-p2wpkh"
+	AddressP2WPKH     = "p2wpkh"
+	AddressP2TR       = "p2tr"
+)
+
+// The message signature formats
+const (
+	FormatBIP137 = "bip137"
+	FormatBIP322 = "bip322"
+)
+
+// messageMagic the prefix of the BIP137 signed message
+const messageMagic = "Bitcoin Signed Message:\n"
+
+// ErrInvalidSignature the signature doesn't prove the control of the address
+var ErrInvalidSignature = errors.New("invalid message signature")
+
+// Address Return the address of the public key in the address type
+func Address(pubKey *btcec.PublicKey, addressType string, net *chaincfg.Params) (string, error) {
+	if addressType == AddressP2TR {
+		outputKey, err := taproot.OutputKey(pubKey)
+		if err != nil {
+			return "", err
+		}
+		return taproot.Address(outputKey, net)
+	}
+
+	pkScript, err := PkScript(pubKey, addressType, net)
+	if err != nil {
+		return "", err
+	}
+
+	_, addresses, _, err := txscript.ExtractPkScriptAddrs(pkScript, net)
+	if err != nil {
+		return "", err
+	}
+	if len(addresses) != 1 {
+		return "", errors.New("unexpected address script")
+	}
+	return addresses[0].EncodeAddress(), nil
+}
+
+// PkScript Return the output script paying to the public key in the address type
+func PkScript(pubKey *btcec.PublicKey, addressType string, net *chaincfg.Params) ([]byte, error) {
+	pubKeyHash := btcutil.Hash160(pubKey.SerializeCompressed())
+
+	var address btcutil.Address
+	var err error
+	switch addressType {
+	case AddressP2PKH:
+		address, err = btcutil.NewAddressPubKeyHash(pubKeyHash, net)
+	case AddressP2SHP2WPKH:
+		redeemScript := append([]byte{txscript.OP_0, txscript.OP_DATA_20}, pubKeyHash...)
+		address, err = btcutil.NewAddressScriptHash(redeemScript, net)
+	case AddressP2WPKH:
+		address, err = btcutil.NewAddressWitnessPubKeyHash(pubKeyHash, net)
+	case AddressP2TR:
+		outputKey, err := taproot.OutputKey(pubKey)
+		if err != nil {
+			return nil, err
+		}
+		return taproot.PkScript(outputKey), nil
+	default:
+		return nil, fmt.Errorf("unsupported address type %s", addressType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return txscript.PayToAddrScript(address)
+}
+
+// Sign Sign the message by the private key of the address in the format, the default format is BIP137 for the P2PKH,
+// P2SH-P2WPKH and P2WPKH addresses and BIP322 for the P2TR addresses. Return the address and the base64 signature.
+func Sign(privKey *btcec.PrivateKey, addressType string, format string, message string, net *chaincfg.Params) (string, string, error) {
+	if format == "" {
+		format = FormatBIP137
+		if addressType == AddressP2TR {
+			format = FormatBIP322
+		}
+	}
+
+	address, err := Address(privKey.PubKey(), addressType, net)
+	if err != nil {
+		return "", "", err
+	}
+
+	var sig []byte
+	switch format {
+	case FormatBIP137:
+		sig, err = signBIP137(privKey, addressType, message)
+	case FormatBIP322:
+		sig, err = signBIP322(privKey, addressType, message, net)
+	default:
+		err = fmt.Errorf("unsupported signature format %s", format)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	return address, base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// Verify Verify the base64 message signature of the address, the format is detected from the signature. Return the
+// format of the valid signature, or ErrInvalidSignature.
+func Verify(address string, message string, signature string, net *chaincfg.Params) (string, error) {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sig) == 65 && sig[0] >= 27 && sig[0] <= 42 {
+		return FormatBIP137, verifyBIP137(address, message, sig, net)
+	}
+	return FormatBIP322, verifyBIP322(address, message, sig, net)
+}
+
+// bip137Hash the double sha256 of the message with the magic prefix, both serialized with the length prefix
+func bip137Hash(message string) []byte {
+	var buf bytes.Buffer
+	wire.WriteVarString(&buf, 0, messageMagic)
+	wire.WriteVarString(&buf, 0, message)
+	return chainhash.DoubleHashB(buf.Bytes())
+}
+
+// signBIP137 Return the compact signature, the header byte tells the address type of the compressed key:
+// 31-34 P2PKH, 35-38 P2SH-P2WPKH and 39-42 P2WPKH
+func signBIP137(privKey *btcec.PrivateKey, addressType string, message string) ([]byte, error) {
+	sig, err := btcec.SignCompact(btcec.S256(), privKey, bip137Hash(message), true)
+	if err != nil {
+		return nil, err
+	}
+
+	switch addressType {
+	case AddressP2PKH:
+	case AddressP2SHP2WPKH:
+		sig[0] += 4
+	case AddressP2WPKH:
+		sig[0] += 8
+	default:
+		return nil, fmt.Errorf("the BIP137 signature doesn't support the address type %s", addressType)
+	}
+	return sig, nil
+}
+
+// verifyBIP137 Recover the public key from the compact signature and compare its address with the given address.
+// The compressed key is matched against all the address types as some wallets sign the segwit addresses with the
+// P2PKH header.
+func verifyBIP137(address string, message string, sig []byte, net *chaincfg.Params) error {
+	header := sig[0]
+	recoverable := append([]byte{27 + (header-27)%4}, sig[1:]...)
+	if header >= 31 {
+		recoverable[0] += 4
+	}
+
+	pubKey, compressed, err := btcec.RecoverCompact(btcec.S256(), recoverable, bip137Hash(message))
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	decoded, err := btcutil.DecodeAddress(address, net)
+	if err != nil {
+		return err
+	}
+	address = decoded.EncodeAddress()
+
+	if !compressed {
+		p2pkh, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(pubKey.SerializeUncompressed()), net)
+		if err != nil {
+			return err
+		}
+		if address != p2pkh.EncodeAddress() {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+
+	for _, addressType := range []string{AddressP2PKH, AddressP2SHP2WPKH, AddressP2WPKH} {
+		candidate, err := Address(pubKey, addressType, net)
+		if err != nil {
+			return err
+		}
+		if candidate == address {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
@@ -0,0 +1,106 @@
+package message
+
+import (
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"testing"
+)
+
+// The BIP322 test vectors addresses
+const (
+	testP2WPKH  = "bc1q9vza2e8x573nczrlzms0wvx3gsqjx7vavgkx0l"
+	testP2TR    = "bc1ppv609nr0vr25u07u95waq5lucwfm6tde4nydujnu8npg4q75mr5sxq8lt3"
+	testMessage = "Hello World"
+)
+
+func TestBIP322(t *testing.T) {
+	if hex.EncodeToString(bip322Hash("")) != "c90c269c4f8fcbe6880f72a721ddfbf1914268a794cbb21cfafee13770ae19f1" ||
+		hex.EncodeToString(bip322Hash(testMessage)) != "f0eb03b1a75ac6d9847f55c624a99169b5dccba2a31f5b23bea77ba270de0a7a" {
+		t.Error("Unmatched BIP322 message hash")
+	}
+
+	net := &chaincfg.MainNetParams
+
+	expected := "AkcwRAIgZRfIY3p7/DoVTty6YZbWS71bc5Vct9p9Fia83eRmw2QCICK/ENGfwLtptFluMGs2KsqoNSk89pO7F29zJLUx9a/sASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI="
+	format, err := Verify(testP2WPKH, testMessage, expected, net)
+	if err != nil || format != FormatBIP322 {
+		t.Error("The P2WPKH signature should be valid:", format, err)
+	}
+	_, err = Verify(testP2WPKH, "", expected, net)
+	if err != ErrInvalidSignature {
+		t.Error("The signature of another message should be invalid:", err)
+	}
+
+	// The taproot test vector signature has the explicit SIGHASH_ALL
+	_, err = Verify(testP2TR, testMessage, "AUHd69PrJQEv+oKTfZ8l+WROBHuy9HKrbFCJu7U1iK2iiEy1vMU5EfMtjc+VSHM7aU0SDbak5IUZRVno2P5mjSafAQ==", net)
+	if err != nil {
+		t.Error("The taproot test vector should be valid:", err)
+	}
+
+	privKey := testPrivKey(t)
+	for _, addressType := range []string{AddressP2WPKH, AddressP2TR} {
+		address, sig, err := Sign(privKey, addressType, FormatBIP322, testMessage, net)
+		if err != nil {
+			t.Fatal("Sign error:", addressType, err)
+		}
+		format, err = Verify(address, testMessage, sig, net)
+		if err != nil || format != FormatBIP322 {
+			t.Error("The signature should be valid:", addressType, format, err)
+		}
+		_, err = Verify(testP2TR, testMessage, sig, net)
+		if err != ErrInvalidSignature {
+			t.Error("The signature of another address should be invalid:", addressType, err)
+		}
+	}
+}
+
+func TestBIP137(t *testing.T) {
+	privKey := testPrivKey(t)
+	net := &chaincfg.MainNetParams
+
+	var addresses []string
+	for _, addressType := range []string{AddressP2PKH, AddressP2SHP2WPKH, AddressP2WPKH} {
+		address, sig, err := Sign(privKey, addressType, "", testMessage, net)
+		if err != nil {
+			t.Fatal("Sign error:", addressType, err)
+		}
+		addresses = append(addresses, address)
+
+		format, err := Verify(address, testMessage, sig, net)
+		if err != nil || format != FormatBIP137 {
+			t.Error("The signature should be valid:", addressType, format, err)
+		}
+		_, err = Verify(address, testMessage+"!", sig, net)
+		if err != ErrInvalidSignature {
+			t.Error("The signature of another message should be invalid:", addressType, err)
+		}
+	}
+	if addresses[2] != "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4" {
+		t.Error("Unmatched P2WPKH address:", addresses[2])
+	}
+
+	_, sig, err := Sign(privKey, AddressP2PKH, "", testMessage, net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Verify("1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", testMessage, sig, net)
+	if err != ErrInvalidSignature {
+		t.Error("The signature of another address should be invalid:", err)
+	}
+
+	_, _, err = Sign(privKey, AddressP2TR, FormatBIP137, testMessage, net)
+	if err == nil {
+		t.Error("The BIP137 signature of the P2TR address should be rejected")
+	}
+}
+
+// testPrivKey the private key of the BIP173 test vector address bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4
+func testPrivKey(t *testing.T) *btcec.PrivateKey {
+	keyBytes, err := hex.DecodeString("0000000000000000000000000000000000000000000000000000000000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), keyBytes)
+	return privKey
+}
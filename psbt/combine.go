@@ -0,0 +1,173 @@
+package psbt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Combine Merge the psbts of the same unsigned transaction signed by the cosigners. The signatures, the derivations and
+// the unknown pairs are merged by the key, the other fields are taken from the first psbt having them. The psbts with
+// the different values of a field are rejected.
+func Combine(packets []*Packet) (*Packet, error) {
+	if len(packets) == 0 {
+		return nil, errors.New("no psbt to combine")
+	}
+
+	// The combined psbt is a copy of the first psbt
+	data, err := packets[0].Serialize()
+	if err != nil {
+		return nil, err
+	}
+	combined, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for n, p := range packets[1:] {
+		if p.UnsignedTx.TxHash() != combined.UnsignedTx.TxHash() {
+			return nil, fmt.Errorf("psbt %d: the unsigned transaction doesn't match", n+1)
+		}
+
+		for i := range p.Inputs {
+			err = combined.Inputs[i].merge(&p.Inputs[i])
+			if err != nil {
+				return nil, fmt.Errorf("psbt %d: input %d: %v", n+1, i, err)
+			}
+		}
+		for i := range p.Outputs {
+			err = combined.Outputs[i].merge(&p.Outputs[i])
+			if err != nil {
+				return nil, fmt.Errorf("psbt %d: output %d: %v", n+1, i, err)
+			}
+		}
+		combined.Unknowns = mergeUnknowns(combined.Unknowns, p.Unknowns)
+	}
+	return combined, nil
+}
+
+func (in *Input) merge(other *Input) error {
+	if in.NonWitnessUtxo == nil {
+		in.NonWitnessUtxo = other.NonWitnessUtxo
+	} else if other.NonWitnessUtxo != nil && in.NonWitnessUtxo.TxHash() != other.NonWitnessUtxo.TxHash() {
+		return errors.New("the non-witness utxo doesn't match")
+	}
+
+	if in.WitnessUtxo == nil {
+		in.WitnessUtxo = other.WitnessUtxo
+	} else if other.WitnessUtxo != nil && (in.WitnessUtxo.Value != other.WitnessUtxo.Value ||
+		!bytes.Equal(in.WitnessUtxo.PkScript, other.WitnessUtxo.PkScript)) {
+		return errors.New("the witness utxo doesn't match")
+	}
+
+	if in.SighashType == 0 {
+		in.SighashType = other.SighashType
+	} else if other.SighashType != 0 && in.SighashType != other.SighashType {
+		return errors.New("the sighash type doesn't match")
+	}
+
+	for _, field := range []struct {
+		name string
+		dst  *[]byte
+		src  []byte
+	}{
+		{"redeem script", &in.RedeemScript, other.RedeemScript},
+		{"witness script", &in.WitnessScript, other.WitnessScript},
+		{"final script sig", &in.FinalScriptSig, other.FinalScriptSig},
+		{"final script witness", &in.FinalScriptWitness, other.FinalScriptWitness},
+		{"taproot key spend signature", &in.TaprootKeySpendSig, other.TaprootKeySpendSig},
+		{"taproot internal key", &in.TaprootInternalKey, other.TaprootInternalKey},
+		{"taproot merkle root", &in.TaprootMerkleRoot, other.TaprootMerkleRoot},
+	} {
+		err := mergeField(field.dst, field.src)
+		if err != nil {
+			return fmt.Errorf("the %s doesn't match", field.name)
+		}
+	}
+
+	for _, sig := range other.PartialSigs {
+		if !hasPartialSig(in, sig.PubKey) {
+			in.PartialSigs = append(in.PartialSigs, sig)
+		}
+	}
+	in.Bip32Derivation = mergeDerivations(in.Bip32Derivation, other.Bip32Derivation)
+	in.TaprootBip32Derivation = mergeTaprootDerivations(in.TaprootBip32Derivation, other.TaprootBip32Derivation)
+	in.Unknowns = mergeUnknowns(in.Unknowns, other.Unknowns)
+	return nil
+}
+
+func (out *Output) merge(other *Output) error {
+	for _, field := range []struct {
+		name string
+		dst  *[]byte
+		src  []byte
+	}{
+		{"redeem script", &out.RedeemScript, other.RedeemScript},
+		{"witness script", &out.WitnessScript, other.WitnessScript},
+		{"taproot internal key", &out.TaprootInternalKey, other.TaprootInternalKey},
+	} {
+		err := mergeField(field.dst, field.src)
+		if err != nil {
+			return fmt.Errorf("the %s doesn't match", field.name)
+		}
+	}
+
+	out.Bip32Derivation = mergeDerivations(out.Bip32Derivation, other.Bip32Derivation)
+	out.TaprootBip32Derivation = mergeTaprootDerivations(out.TaprootBip32Derivation, other.TaprootBip32Derivation)
+	out.Unknowns = mergeUnknowns(out.Unknowns, other.Unknowns)
+	return nil
+}
+
+// mergeField Set the field if it's empty, the different value is an error
+func mergeField(dst *[]byte, src []byte) error {
+	if src == nil {
+		return nil
+	}
+	if *dst == nil {
+		*dst = src
+		return nil
+	}
+	if !bytes.Equal(*dst, src) {
+		return errors.New("the field doesn't match")
+	}
+	return nil
+}
+
+func mergeDerivations(dst []*Bip32Derivation, src []*Bip32Derivation) []*Bip32Derivation {
+	for _, d := range src {
+		found := false
+		for _, existing := range dst {
+			found = found || bytes.Equal(existing.PubKey, d.PubKey)
+		}
+		if !found {
+			dst = append(dst, d)
+		}
+	}
+	return dst
+}
+
+func mergeTaprootDerivations(dst []*TaprootBip32Derivation, src []*TaprootBip32Derivation) []*TaprootBip32Derivation {
+	for _, d := range src {
+		found := false
+		for _, existing := range dst {
+			found = found || bytes.Equal(existing.XOnlyPubKey, d.XOnlyPubKey)
+		}
+		if !found {
+			dst = append(dst, d)
+		}
+	}
+	return dst
+}
+
+func mergeUnknowns(dst []*Unknown, src []*Unknown) []*Unknown {
+	for _, u := range src {
+		found := false
+		for _, existing := range dst {
+			found = found || bytes.Equal(existing.Key, u.Key)
+		}
+		if !found {
+			dst = append(dst, u)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,182 @@
+package psbt
+
+import (
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/wire"
+	"math"
+)
+
+// DustLimit the min value of the change output, the smaller change is left to the fee
+const DustLimit = 546
+
+// DefaultSequence the input sequence of the created transaction, it signals the replace-by-fee (BIP125)
+const DefaultSequence = wire.MaxTxInSequenceNum - 2
+
+// UTXO an output to spend. The output script is derived from the redeem script and the witness script if it's not given.
+// The previous transaction is required by BIP174 for the non-segwit inputs.
+type UTXO struct {
+	OutPoint               wire.OutPoint
+	Value                  int64
+	PkScript               []byte
+	RedeemScript           []byte
+	WitnessScript          []byte
+	PrevTx                 *wire.MsgTx
+	Bip32Derivation        []*Bip32Derivation
+	TaprootInternalKey     []byte
+	TaprootBip32Derivation []*TaprootBip32Derivation
+}
+
+// TxOutput an output of the created transaction with the data of the change output
+type TxOutput struct {
+	TxOut  *wire.TxOut
+	Output Output
+}
+
+// Summary the fee of the created transaction and the warnings of the inputs the signers can't fully verify
+type Summary struct {
+	InputValue  int64
+	OutputValue int64
+	Fee         int64
+	Weight      int64
+	VSize       int64
+	FeeRate     float64
+	ChangeIndex int
+	Warnings    []string
+}
+
+// Create Create the unsigned psbt spending the utxos to the outputs with the fee of the fee rate (sat/vB). The change
+// output receives the value left after the fee, the change under the dust limit is dropped and left to the fee.
+// The change is optional, without it all the value left is the fee.
+func Create(utxos []*UTXO, outputs []*TxOutput, change *TxOutput, feeRate float64) (*Packet, *Summary, error) {
+	if len(utxos) == 0 {
+		return nil, nil, errors.New("no utxo to spend")
+	}
+	if feeRate <= 0 {
+		return nil, nil, errors.New("the fee rate must be positive")
+	}
+
+	summary := &Summary{ChangeIndex: -1}
+	tx := wire.NewMsgTx(2)
+	inputs := make([]InputWeight, len(utxos))
+	inputTypes := make([]string, len(utxos))
+	pkScripts := make([][]byte, len(utxos))
+	for i, utxo := range utxos {
+		if utxo.Value <= 0 {
+			return nil, nil, fmt.Errorf("utxo %d: the value must be positive", i)
+		}
+
+		pkScript, err := utxo.OutputScript()
+		if err != nil {
+			return nil, nil, fmt.Errorf("utxo %d: %v", i, err)
+		}
+		pkScripts[i] = pkScript
+
+		if utxo.PrevTx != nil {
+			if utxo.PrevTx.TxHash() != utxo.OutPoint.Hash {
+				return nil, nil, fmt.Errorf("utxo %d: the previous transaction doesn't match the txid", i)
+			}
+			if int(utxo.OutPoint.Index) >= len(utxo.PrevTx.TxOut) ||
+				utxo.PrevTx.TxOut[utxo.OutPoint.Index].Value != utxo.Value {
+				return nil, nil, fmt.Errorf("utxo %d: the previous transaction output doesn't match the value", i)
+			}
+		}
+
+		inputTypes[i], err = InputType(pkScript, utxo.RedeemScript, utxo.WitnessScript)
+		if err != nil {
+			return nil, nil, fmt.Errorf("utxo %d: %v", i, err)
+		}
+		weight, err := EstimateInputWeight(inputTypes[i], utxo.RedeemScript, utxo.WitnessScript)
+		if err != nil {
+			return nil, nil, fmt.Errorf("utxo %d: %v", i, err)
+		}
+		inputs[i] = InputWeight{inputTypes[i], weight}
+
+		summary.InputValue += utxo.Value
+		tx.AddTxIn(&wire.TxIn{PreviousOutPoint: utxo.OutPoint, Sequence: DefaultSequence})
+	}
+
+	txOuts := make([]*wire.TxOut, 0, len(outputs)+1)
+	for i, output := range outputs {
+		if output.TxOut.Value < DustLimit {
+			return nil, nil, fmt.Errorf("output %d: the value is under the dust limit %d", i, DustLimit)
+		}
+		summary.OutputValue += output.TxOut.Value
+		txOuts = append(txOuts, output.TxOut)
+	}
+
+	left := summary.InputValue - summary.OutputValue
+	fee := estimateFee(inputs, txOuts, feeRate)
+	if left < fee {
+		return nil, nil, fmt.Errorf("insufficient funds, the inputs %d can't pay the outputs %d and the fee %d",
+			summary.InputValue, summary.OutputValue, fee)
+	}
+
+	if change != nil {
+		withChange := append(txOuts, change.TxOut)
+		changeFee := estimateFee(inputs, withChange, feeRate)
+		if left-changeFee >= DustLimit {
+			change.TxOut.Value = left - changeFee
+			outputs = append(outputs, change)
+			txOuts = withChange
+			summary.ChangeIndex = len(txOuts) - 1
+			summary.OutputValue += change.TxOut.Value
+		}
+	}
+	for _, txOut := range txOuts {
+		tx.AddTxOut(txOut)
+	}
+
+	p, err := NewFromUnsignedTx(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, utxo := range utxos {
+		in := &p.Inputs[i]
+		in.RedeemScript = utxo.RedeemScript
+		in.WitnessScript = utxo.WitnessScript
+		in.Bip32Derivation = utxo.Bip32Derivation
+		in.TaprootInternalKey = utxo.TaprootInternalKey
+		in.TaprootBip32Derivation = utxo.TaprootBip32Derivation
+		in.NonWitnessUtxo = utxo.PrevTx
+
+		if IsWitness(inputTypes[i]) {
+			in.WitnessUtxo = wire.NewTxOut(utxo.Value, pkScripts[i])
+		} else if utxo.PrevTx == nil {
+			// The legacy sighash doesn't commit to the value, the value can't be verified without the previous transaction
+			in.WitnessUtxo = wire.NewTxOut(utxo.Value, pkScripts[i])
+			summary.Warnings = append(summary.Warnings,
+				fmt.Sprintf("input %d: the non-segwit input has no previous transaction, the signers can't verify its value", i))
+		}
+	}
+	for i, output := range outputs {
+		p.Outputs[i] = output.Output
+	}
+
+	summary.Weight = EstimateTxWeight(inputs, txOuts)
+	summary.VSize = VSize(summary.Weight)
+	summary.Fee = summary.InputValue - summary.OutputValue
+	summary.FeeRate = float64(summary.Fee) / float64(summary.VSize)
+	return p, summary, nil
+}
+
+// OutputScript Return the output script of the utxo, the given output script must match the scripts
+func (utxo *UTXO) OutputScript() ([]byte, error) {
+	if utxo.PkScript != nil {
+		return utxo.PkScript, nil
+	}
+
+	switch {
+	case utxo.RedeemScript != nil:
+		return P2SHScript(utxo.RedeemScript), nil
+	case utxo.WitnessScript != nil:
+		return P2WSHScript(utxo.WitnessScript), nil
+	}
+	return nil, errors.New("missing the output script or the redeem or witness script")
+}
+
+// estimateFee Return the fee of the estimated virtual size at the fee rate, rounded up
+func estimateFee(inputs []InputWeight, outputs []*wire.TxOut, feeRate float64) int64 {
+	return int64(math.Ceil(float64(VSize(EstimateTxWeight(inputs, outputs))) * feeRate))
+}
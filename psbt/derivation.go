@@ -0,0 +1,66 @@
+package psbt
+
+import (
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"strconv"
+	"strings"
+)
+
+// ParseDerivationPath Parse the BIP032 path notation like m/84'/0'/0'/0/1, the hardened index is marked by ' or h
+func ParseDerivationPath(s string) ([]uint32, error) {
+	parts := strings.Split(s, "/")
+	if parts[0] != "m" {
+		return nil, fmt.Errorf("the path %s should start with m", s)
+	}
+
+	path := make([]uint32, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h")
+		if hardened {
+			part = part[:len(part)-1]
+		}
+
+		index, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || index >= hdkeychain.HardenedKeyStart {
+			return nil, fmt.Errorf("invalid index %s of the path %s", part, s)
+		}
+		if hardened {
+			index += hdkeychain.HardenedKeyStart
+		}
+		path = append(path, uint32(index))
+	}
+	return path, nil
+}
+
+// FormatDerivationPath Return the BIP032 notation of the path
+func FormatDerivationPath(path []uint32) string {
+	var sb strings.Builder
+	sb.WriteString("m")
+	for _, index := range path {
+		if index >= hdkeychain.HardenedKeyStart {
+			fmt.Fprintf(&sb, "/%d'", index-hdkeychain.HardenedKeyStart)
+		} else {
+			fmt.Fprintf(&sb, "/%d", index)
+		}
+	}
+	return sb.String()
+}
+
+// DeriveKey Derive the child key of the master key by the path
+func DeriveKey(masterKey *hdkeychain.ExtendedKey, path []uint32) (*hdkeychain.ExtendedKey, error) {
+	if masterKey.Depth() != 0 {
+		return nil, errors.New("the key isn't a master key")
+	}
+
+	key := masterKey
+	for _, index := range path {
+		child, err := key.Derive(index)
+		if err != nil {
+			return nil, err
+		}
+		key = child
+	}
+	return key, nil
+}
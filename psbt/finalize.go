@@ -0,0 +1,309 @@
+package psbt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/jayt106/bitcoinAddressGenerator/taproot"
+	"strings"
+)
+
+// InputStatus the finalization result of an input. The reason explains why the input can't be finalized yet, the
+// signatures are the valid signatures found for the input script.
+type InputStatus struct {
+	Index      int    `json:"index"`
+	Type       string `json:"type,omitempty"`
+	Finalized  bool   `json:"finalized"`
+	Signatures int    `json:"signatures"`
+	Required   int    `json:"required"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// Finalize Build the final signature script and witness of each input having enough valid signatures, the partial
+// signatures and the signing data of the finalized input are removed. Return the status of every input, the psbt is
+// complete when all the inputs are finalized.
+func Finalize(p *Packet) []InputStatus {
+	statuses := make([]InputStatus, len(p.Inputs))
+	sigHashes := txscript.NewTxSigHashes(p.UnsignedTx)
+	for i := range p.Inputs {
+		statuses[i] = finalizeInput(p, i, sigHashes)
+	}
+	return statuses
+}
+
+// IsComplete Return true if all the inputs are finalized
+func IsComplete(statuses []InputStatus) bool {
+	for _, status := range statuses {
+		if !status.Finalized {
+			return false
+		}
+	}
+	return true
+}
+
+// Extract Return the signed transaction of the finalized psbt
+func Extract(p *Packet) (*wire.MsgTx, error) {
+	tx := p.UnsignedTx.Copy()
+	for i := range p.Inputs {
+		in := &p.Inputs[i]
+		if in.FinalScriptSig == nil && in.FinalScriptWitness == nil {
+			return nil, fmt.Errorf("input %d isn't finalized", i)
+		}
+
+		tx.TxIn[i].SignatureScript = in.FinalScriptSig
+		witness, err := in.FinalWitness()
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %v", i, err)
+		}
+		tx.TxIn[i].Witness = witness
+	}
+	return tx, nil
+}
+
+// FinalWitness Return the final witness of the input, nil if it has none
+func (in *Input) FinalWitness() (wire.TxWitness, error) {
+	if in.FinalScriptWitness == nil {
+		return nil, nil
+	}
+	return parseWitness(in.FinalScriptWitness)
+}
+
+func finalizeInput(p *Packet, idx int, sigHashes *txscript.TxSigHashes) InputStatus {
+	in := &p.Inputs[idx]
+	status := InputStatus{Index: idx, Required: 1}
+	if in.FinalScriptSig != nil || in.FinalScriptWitness != nil {
+		status.Finalized = true
+		return status
+	}
+
+	var script []byte
+	var prevOut *wire.TxOut
+	var err error
+	status.Type, script, prevOut, err = inputScript(p, idx)
+	if err != nil {
+		status.Reason = err.Error()
+		return status
+	}
+
+	if status.Type == InputP2TR {
+		finalizeTaproot(p, idx, &status)
+		return status
+	}
+
+	// The keys of the script in order, the single key input has the key hash
+	var pubKeys [][]byte
+	switch status.Type {
+	case InputP2PKH, InputP2WPKH, InputP2SHP2WPKH:
+		// The key hash is the last push, after the witness version of the witness program
+		pushes, _ := txscript.PushedData(script)
+		pubKeyHash := pushes[len(pushes)-1]
+		for _, sig := range in.PartialSigs {
+			if bytes.Equal(btcutil.Hash160(sig.PubKey), pubKeyHash) {
+				pubKeys = append(pubKeys, sig.PubKey)
+			}
+		}
+		if len(pubKeys) == 0 {
+			status.Reason = "missing the signature of the key hash " + hex.EncodeToString(pubKeyHash)
+			return status
+		}
+	default:
+		if txscript.GetScriptClass(script) != txscript.MultiSigTy {
+			status.Reason = "only the multisig script can be finalized"
+			return status
+		}
+		_, required, err := txscript.CalcMultiSigStats(script)
+		if err != nil {
+			status.Reason = err.Error()
+			return status
+		}
+		status.Required = required
+		pubKeys, _ = txscript.PushedData(script)
+	}
+
+	// The valid signatures in the order of the keys
+	var sigs [][]byte
+	var invalid []string
+	for _, pubKey := range pubKeys {
+		for _, partialSig := range in.PartialSigs {
+			if !bytes.Equal(partialSig.PubKey, pubKey) {
+				continue
+			}
+			err = verifyPartialSig(p, idx, status.Type, script, prevOut.Value, partialSig, sigHashes)
+			if err != nil {
+				invalid = append(invalid, fmt.Sprintf("%x: %v", pubKey, err))
+				continue
+			}
+			sigs = append(sigs, partialSig.Signature)
+		}
+	}
+	status.Signatures = len(sigs)
+
+	if len(sigs) < status.Required {
+		var missing []string
+		for _, pubKey := range pubKeys {
+			if !hasPartialSig(in, pubKey) {
+				missing = append(missing, hex.EncodeToString(pubKey))
+			}
+		}
+		status.Reason = fmt.Sprintf("%d of %d valid signatures", len(sigs), status.Required)
+		if len(invalid) != 0 {
+			status.Reason += ", invalid signatures of " + strings.Join(invalid, "; ")
+		}
+		if len(missing) != 0 {
+			status.Reason += ", missing the signatures of " + strings.Join(missing, ", ")
+		}
+		return status
+	}
+	sigs = sigs[:status.Required]
+
+	var scriptSig []byte
+	var witness wire.TxWitness
+	switch status.Type {
+	case InputP2PKH:
+		scriptSig, err = txscript.NewScriptBuilder().AddData(sigs[0]).AddData(pubKeys[0]).Script()
+	case InputP2WPKH:
+		witness = wire.TxWitness{sigs[0], pubKeys[0]}
+	case InputP2SHP2WPKH:
+		scriptSig, err = txscript.NewScriptBuilder().AddData(in.RedeemScript).Script()
+		witness = wire.TxWitness{sigs[0], pubKeys[0]}
+	case InputP2SH:
+		// The extra OP_0 is consumed by the OP_CHECKMULTISIG bug
+		builder := txscript.NewScriptBuilder().AddOp(txscript.OP_0)
+		for _, sig := range sigs {
+			builder.AddData(sig)
+		}
+		scriptSig, err = builder.AddData(in.RedeemScript).Script()
+	case InputP2WSH, InputP2SHP2WSH:
+		witness = append(wire.TxWitness{nil}, sigs...)
+		witness = append(witness, in.WitnessScript)
+		if status.Type == InputP2SHP2WSH {
+			scriptSig, err = txscript.NewScriptBuilder().AddData(in.RedeemScript).Script()
+		}
+	}
+	if err != nil {
+		status.Reason = err.Error()
+		return status
+	}
+
+	in.FinalScriptSig = scriptSig
+	if witness != nil {
+		in.FinalScriptWitness = serializeWitness(witness)
+	}
+	clearSigningData(in)
+	status.Finalized = true
+	return status
+}
+
+func finalizeTaproot(p *Packet, idx int, status *InputStatus) {
+	in := &p.Inputs[idx]
+	if in.TaprootKeySpendSig == nil {
+		status.Reason = "missing the taproot key path signature"
+		if in.TaprootInternalKey != nil {
+			status.Reason += " of the internal key " + hex.EncodeToString(in.TaprootInternalKey)
+		}
+		return
+	}
+
+	prevOuts, err := p.PrevOuts()
+	if err != nil {
+		status.Reason = "the taproot signature needs the utxos of all the inputs: " + err.Error()
+		return
+	}
+	err = taproot.VerifyKeyPath(p.UnsignedTx, idx, prevOuts, in.TaprootKeySpendSig)
+	if err != nil {
+		status.Reason = err.Error()
+		return
+	}
+
+	status.Signatures = 1
+	in.FinalScriptWitness = serializeWitness(wire.TxWitness{in.TaprootKeySpendSig})
+	clearSigningData(in)
+	status.Finalized = true
+}
+
+// verifyPartialSig Verify the ECDSA signature of the input against the script the signature commits to
+func verifyPartialSig(p *Packet, idx int, inputType string, script []byte, value int64, partialSig *PartialSig,
+	sigHashes *txscript.TxSigHashes) error {
+	if len(partialSig.Signature) == 0 {
+		return errors.New("empty signature")
+	}
+	sigBytes := partialSig.Signature[:len(partialSig.Signature)-1]
+	hashType := txscript.SigHashType(partialSig.Signature[len(partialSig.Signature)-1])
+	if p.Inputs[idx].SighashType != 0 && hashType != p.Inputs[idx].SighashType {
+		return errors.New("the sighash type doesn't match the input")
+	}
+
+	var hash []byte
+	var err error
+	if IsWitness(inputType) {
+		hash, err = txscript.CalcWitnessSigHash(script, sigHashes, hashType, p.UnsignedTx, idx, value)
+	} else {
+		hash, err = txscript.CalcSignatureHash(script, hashType, p.UnsignedTx, idx)
+	}
+	if err != nil {
+		return err
+	}
+
+	sig, err := btcec.ParseDERSignature(sigBytes, btcec.S256())
+	if err != nil {
+		return err
+	}
+	pubKey, err := btcec.ParsePubKey(partialSig.PubKey, btcec.S256())
+	if err != nil {
+		return err
+	}
+	if !sig.Verify(hash, pubKey) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// clearSigningData Remove the fields of the input the finalizer doesn't need anymore (BIP174), the utxos are kept
+func clearSigningData(in *Input) {
+	in.PartialSigs = nil
+	in.SighashType = 0
+	in.RedeemScript = nil
+	in.WitnessScript = nil
+	in.Bip32Derivation = nil
+	in.TaprootKeySpendSig = nil
+	in.TaprootBip32Derivation = nil
+	in.TaprootInternalKey = nil
+	in.TaprootMerkleRoot = nil
+}
+
+func serializeWitness(witness wire.TxWitness) []byte {
+	var buf bytes.Buffer
+	_ = wire.WriteVarInt(&buf, 0, uint64(len(witness)))
+	for _, item := range witness {
+		_ = wire.WriteVarBytes(&buf, 0, item)
+	}
+	return buf.Bytes()
+}
+
+func parseWitness(data []byte) (wire.TxWitness, error) {
+	r := bytes.NewReader(data)
+	count, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if count > uint64(len(data)) {
+		return nil, errors.New("invalid witness item count")
+	}
+
+	witness := make(wire.TxWitness, count)
+	for i := range witness {
+		witness[i], err = wire.ReadVarBytes(r, 0, wire.MaxBlockPayload, "witness item")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("trailing data of the witness")
+	}
+	return witness, nil
+}
@@ -0,0 +1,596 @@
+package psbt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"io"
+	"sort"
+)
+
+// magic the BIP174 magic bytes "psbt" 0xff
+var magic = []byte{0x70, 0x73, 0x62, 0x74, 0xff}
+
+// maxPsbtSize the max size of the serialized psbt accepted by the parser
+const maxPsbtSize = 4000000
+
+// The global key types
+const (
+	globalUnsignedTx = 0x00
+)
+
+// The input key types, including the BIP371 taproot fields
+const (
+	inNonWitnessUtxo         = 0x00
+	inWitnessUtxo            = 0x01
+	inPartialSig             = 0x02
+	inSighashType            = 0x03
+	inRedeemScript           = 0x04
+	inWitnessScript          = 0x05
+	inBip32Derivation        = 0x06
+	inFinalScriptSig         = 0x07
+	inFinalScriptWitness     = 0x08
+	inTaprootKeySpendSig     = 0x13
+	inTaprootBip32Derivation = 0x16
+	inTaprootInternalKey     = 0x17
+	inTaprootMerkleRoot      = 0x18
+)
+
+// The output key types
+const (
+	outRedeemScript           = 0x00
+	outWitnessScript          = 0x01
+	outBip32Derivation        = 0x02
+	outTaprootInternalKey     = 0x05
+	outTaprootBip32Derivation = 0x07
+)
+
+// ErrInvalidPsbt the data isn't a valid BIP174 psbt
+var ErrInvalidPsbt = errors.New("invalid psbt")
+
+// Packet the BIP174 partially signed bitcoin transaction (version 0)
+type Packet struct {
+	UnsignedTx *wire.MsgTx
+	Inputs     []Input
+	Outputs    []Output
+	Unknowns   []*Unknown
+}
+
+// Unknown a key value pair the package doesn't interpret, it's kept as is
+type Unknown struct {
+	Key   []byte
+	Value []byte
+}
+
+// PartialSig the signature of a public key of the input
+type PartialSig struct {
+	PubKey    []byte
+	Signature []byte
+}
+
+// Bip32Derivation the master key fingerprint and the BIP032 path of a public key
+type Bip32Derivation struct {
+	PubKey      []byte
+	Fingerprint [4]byte
+	Path        []uint32
+}
+
+// TaprootBip32Derivation the BIP032 derivation of a taproot x-only public key and the leaves it's used in
+type TaprootBip32Derivation struct {
+	XOnlyPubKey []byte
+	LeafHashes  [][]byte
+	Fingerprint [4]byte
+	Path        []uint32
+}
+
+// Input the signing data of a transaction input
+type Input struct {
+	NonWitnessUtxo         *wire.MsgTx
+	WitnessUtxo            *wire.TxOut
+	PartialSigs            []*PartialSig
+	SighashType            txscript.SigHashType
+	RedeemScript           []byte
+	WitnessScript          []byte
+	Bip32Derivation        []*Bip32Derivation
+	FinalScriptSig         []byte
+	FinalScriptWitness     []byte
+	TaprootKeySpendSig     []byte
+	TaprootBip32Derivation []*TaprootBip32Derivation
+	TaprootInternalKey     []byte
+	TaprootMerkleRoot      []byte
+	Unknowns               []*Unknown
+}
+
+// Output the data of a transaction output, the change output is identified by the derivation of its key
+type Output struct {
+	RedeemScript           []byte
+	WitnessScript          []byte
+	Bip32Derivation        []*Bip32Derivation
+	TaprootInternalKey     []byte
+	TaprootBip32Derivation []*TaprootBip32Derivation
+	Unknowns               []*Unknown
+}
+
+// NewFromUnsignedTx Create the psbt of the unsigned transaction, the inputs must not have the signature scripts or witnesses
+func NewFromUnsignedTx(tx *wire.MsgTx) (*Packet, error) {
+	for _, txIn := range tx.TxIn {
+		if len(txIn.SignatureScript) != 0 || len(txIn.Witness) != 0 {
+			return nil, errors.New("the unsigned transaction has the signature script or witness")
+		}
+	}
+
+	return &Packet{
+		UnsignedTx: tx,
+		Inputs:     make([]Input, len(tx.TxIn)),
+		Outputs:    make([]Output, len(tx.TxOut)),
+	}, nil
+}
+
+// NewFromBase64 Parse the base64 encoded psbt
+func NewFromBase64(s string) (*Packet, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse Parse the serialized psbt
+func Parse(data []byte) (*Packet, error) {
+	if len(data) > maxPsbtSize {
+		return nil, fmt.Errorf("%w: too large", ErrInvalidPsbt)
+	}
+	if !bytes.HasPrefix(data, magic) {
+		return nil, fmt.Errorf("%w: missing magic bytes", ErrInvalidPsbt)
+	}
+	r := bytes.NewReader(data[len(magic):])
+
+	p := &Packet{}
+	pairs, err := readMap(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range pairs {
+		switch pair.Key[0] {
+		case globalUnsignedTx:
+			if len(pair.Key) != 1 {
+				return nil, fmt.Errorf("%w: invalid unsigned tx key", ErrInvalidPsbt)
+			}
+			tx := wire.NewMsgTx(0)
+			err = tx.DeserializeNoWitness(bytes.NewReader(pair.Value))
+			if err != nil {
+				return nil, fmt.Errorf("%w: unsigned tx: %v", ErrInvalidPsbt, err)
+			}
+			p.UnsignedTx = tx
+		default:
+			p.Unknowns = append(p.Unknowns, &Unknown{pair.Key, pair.Value})
+		}
+	}
+	if p.UnsignedTx == nil {
+		return nil, fmt.Errorf("%w: missing unsigned tx", ErrInvalidPsbt)
+	}
+	for _, txIn := range p.UnsignedTx.TxIn {
+		if len(txIn.SignatureScript) != 0 {
+			return nil, fmt.Errorf("%w: the unsigned tx has the signature script", ErrInvalidPsbt)
+		}
+	}
+
+	p.Inputs = make([]Input, len(p.UnsignedTx.TxIn))
+	for i := range p.Inputs {
+		pairs, err = readMap(r)
+		if err != nil {
+			return nil, err
+		}
+		err = p.Inputs[i].parse(pairs)
+		if err != nil {
+			return nil, fmt.Errorf("%w: input %d: %v", ErrInvalidPsbt, i, err)
+		}
+		if p.Inputs[i].NonWitnessUtxo != nil && p.Inputs[i].NonWitnessUtxo.TxHash() != p.UnsignedTx.TxIn[i].PreviousOutPoint.Hash {
+			return nil, fmt.Errorf("%w: input %d: the non-witness utxo doesn't match the outpoint", ErrInvalidPsbt, i)
+		}
+	}
+
+	p.Outputs = make([]Output, len(p.UnsignedTx.TxOut))
+	for i := range p.Outputs {
+		pairs, err = readMap(r)
+		if err != nil {
+			return nil, err
+		}
+		err = p.Outputs[i].parse(pairs)
+		if err != nil {
+			return nil, fmt.Errorf("%w: output %d: %v", ErrInvalidPsbt, i, err)
+		}
+	}
+
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%w: trailing data", ErrInvalidPsbt)
+	}
+	return p, nil
+}
+
+// Serialize Return the serialized psbt
+func (p *Packet) Serialize() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(magic)
+
+	var tx bytes.Buffer
+	err := p.UnsignedTx.SerializeNoWitness(&tx)
+	if err != nil {
+		return nil, err
+	}
+	writePair(&buf, []byte{globalUnsignedTx}, tx.Bytes())
+	writeUnknowns(&buf, p.Unknowns)
+	buf.WriteByte(0x00)
+
+	for i := range p.Inputs {
+		err = p.Inputs[i].serialize(&buf)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte(0x00)
+	}
+
+	for i := range p.Outputs {
+		p.Outputs[i].serialize(&buf)
+		buf.WriteByte(0x00)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// B64Encode Return the base64 encoded psbt
+func (p *Packet) B64Encode() (string, error) {
+	data, err := p.Serialize()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// PrevOut Return the output spent by the input, from the witness utxo or the non-witness utxo
+func (p *Packet) PrevOut(idx int) (*wire.TxOut, error) {
+	in := &p.Inputs[idx]
+	if in.WitnessUtxo != nil {
+		return in.WitnessUtxo, nil
+	}
+	if in.NonWitnessUtxo != nil {
+		outPoint := p.UnsignedTx.TxIn[idx].PreviousOutPoint
+		if int(outPoint.Index) >= len(in.NonWitnessUtxo.TxOut) {
+			return nil, fmt.Errorf("input %d: the outpoint index is out of the previous tx outputs", idx)
+		}
+		return in.NonWitnessUtxo.TxOut[outPoint.Index], nil
+	}
+	return nil, fmt.Errorf("input %d: missing the utxo", idx)
+}
+
+// PrevOuts Return the outputs spent by all the inputs, the taproot signature commits to all of them
+func (p *Packet) PrevOuts() ([]*wire.TxOut, error) {
+	prevOuts := make([]*wire.TxOut, len(p.Inputs))
+	for i := range p.Inputs {
+		prevOut, err := p.PrevOut(i)
+		if err != nil {
+			return nil, err
+		}
+		prevOuts[i] = prevOut
+	}
+	return prevOuts, nil
+}
+
+func (in *Input) parse(pairs []*Unknown) error {
+	for _, pair := range pairs {
+		keyData := pair.Key[1:]
+		var err error
+		switch pair.Key[0] {
+		case inNonWitnessUtxo:
+			tx := wire.NewMsgTx(0)
+			err = tx.Deserialize(bytes.NewReader(pair.Value))
+			in.NonWitnessUtxo = tx
+		case inWitnessUtxo:
+			in.WitnessUtxo, err = parseTxOut(pair.Value)
+		case inPartialSig:
+			if len(keyData) != 33 && len(keyData) != 65 {
+				return errors.New("invalid partial signature public key")
+			}
+			in.PartialSigs = append(in.PartialSigs, &PartialSig{keyData, pair.Value})
+		case inSighashType:
+			if len(pair.Value) != 4 {
+				return errors.New("invalid sighash type")
+			}
+			in.SighashType = txscript.SigHashType(binary.LittleEndian.Uint32(pair.Value))
+		case inRedeemScript:
+			in.RedeemScript = pair.Value
+		case inWitnessScript:
+			in.WitnessScript = pair.Value
+		case inBip32Derivation:
+			var derivation *Bip32Derivation
+			derivation, err = parseBip32Derivation(keyData, pair.Value)
+			in.Bip32Derivation = append(in.Bip32Derivation, derivation)
+		case inFinalScriptSig:
+			in.FinalScriptSig = pair.Value
+		case inFinalScriptWitness:
+			in.FinalScriptWitness = pair.Value
+		case inTaprootKeySpendSig:
+			if len(pair.Value) != 64 && len(pair.Value) != 65 {
+				return errors.New("invalid taproot key spend signature")
+			}
+			in.TaprootKeySpendSig = pair.Value
+		case inTaprootBip32Derivation:
+			var derivation *TaprootBip32Derivation
+			derivation, err = parseTaprootBip32Derivation(keyData, pair.Value)
+			in.TaprootBip32Derivation = append(in.TaprootBip32Derivation, derivation)
+		case inTaprootInternalKey:
+			if len(pair.Value) != 32 {
+				return errors.New("invalid taproot internal key")
+			}
+			in.TaprootInternalKey = pair.Value
+		case inTaprootMerkleRoot:
+			if len(pair.Value) != 32 {
+				return errors.New("invalid taproot merkle root")
+			}
+			in.TaprootMerkleRoot = pair.Value
+		default:
+			in.Unknowns = append(in.Unknowns, pair)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if len(keyData) != 0 && !keyDataAllowed(pair.Key[0], inPartialSig, inBip32Derivation, inTaprootBip32Derivation) {
+			return fmt.Errorf("unexpected key data of the key type %#x", pair.Key[0])
+		}
+	}
+	return nil
+}
+
+func (in *Input) serialize(w *bytes.Buffer) error {
+	if in.NonWitnessUtxo != nil {
+		var tx bytes.Buffer
+		err := in.NonWitnessUtxo.Serialize(&tx)
+		if err != nil {
+			return err
+		}
+		writePair(w, []byte{inNonWitnessUtxo}, tx.Bytes())
+	}
+	if in.WitnessUtxo != nil {
+		var txOut bytes.Buffer
+		err := wire.WriteTxOut(&txOut, 0, 0, in.WitnessUtxo)
+		if err != nil {
+			return err
+		}
+		writePair(w, []byte{inWitnessUtxo}, txOut.Bytes())
+	}
+	for _, sig := range in.PartialSigs {
+		writePair(w, append([]byte{inPartialSig}, sig.PubKey...), sig.Signature)
+	}
+	if in.SighashType != 0 {
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], uint32(in.SighashType))
+		writePair(w, []byte{inSighashType}, b[:])
+	}
+	if in.RedeemScript != nil {
+		writePair(w, []byte{inRedeemScript}, in.RedeemScript)
+	}
+	if in.WitnessScript != nil {
+		writePair(w, []byte{inWitnessScript}, in.WitnessScript)
+	}
+	for _, derivation := range in.Bip32Derivation {
+		writePair(w, append([]byte{inBip32Derivation}, derivation.PubKey...), derivation.value())
+	}
+	if in.FinalScriptSig != nil {
+		writePair(w, []byte{inFinalScriptSig}, in.FinalScriptSig)
+	}
+	if in.FinalScriptWitness != nil {
+		writePair(w, []byte{inFinalScriptWitness}, in.FinalScriptWitness)
+	}
+	if in.TaprootKeySpendSig != nil {
+		writePair(w, []byte{inTaprootKeySpendSig}, in.TaprootKeySpendSig)
+	}
+	for _, derivation := range in.TaprootBip32Derivation {
+		writePair(w, append([]byte{inTaprootBip32Derivation}, derivation.XOnlyPubKey...), derivation.value())
+	}
+	if in.TaprootInternalKey != nil {
+		writePair(w, []byte{inTaprootInternalKey}, in.TaprootInternalKey)
+	}
+	if in.TaprootMerkleRoot != nil {
+		writePair(w, []byte{inTaprootMerkleRoot}, in.TaprootMerkleRoot)
+	}
+	writeUnknowns(w, in.Unknowns)
+	return nil
+}
+
+func (out *Output) parse(pairs []*Unknown) error {
+	for _, pair := range pairs {
+		keyData := pair.Key[1:]
+		var err error
+		switch pair.Key[0] {
+		case outRedeemScript:
+			out.RedeemScript = pair.Value
+		case outWitnessScript:
+			out.WitnessScript = pair.Value
+		case outBip32Derivation:
+			var derivation *Bip32Derivation
+			derivation, err = parseBip32Derivation(keyData, pair.Value)
+			out.Bip32Derivation = append(out.Bip32Derivation, derivation)
+		case outTaprootInternalKey:
+			if len(pair.Value) != 32 {
+				return errors.New("invalid taproot internal key")
+			}
+			out.TaprootInternalKey = pair.Value
+		case outTaprootBip32Derivation:
+			var derivation *TaprootBip32Derivation
+			derivation, err = parseTaprootBip32Derivation(keyData, pair.Value)
+			out.TaprootBip32Derivation = append(out.TaprootBip32Derivation, derivation)
+		default:
+			out.Unknowns = append(out.Unknowns, pair)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if len(keyData) != 0 && !keyDataAllowed(pair.Key[0], outBip32Derivation, outTaprootBip32Derivation) {
+			return fmt.Errorf("unexpected key data of the key type %#x", pair.Key[0])
+		}
+	}
+	return nil
+}
+
+func (out *Output) serialize(w *bytes.Buffer) {
+	if out.RedeemScript != nil {
+		writePair(w, []byte{outRedeemScript}, out.RedeemScript)
+	}
+	if out.WitnessScript != nil {
+		writePair(w, []byte{outWitnessScript}, out.WitnessScript)
+	}
+	for _, derivation := range out.Bip32Derivation {
+		writePair(w, append([]byte{outBip32Derivation}, derivation.PubKey...), derivation.value())
+	}
+	if out.TaprootInternalKey != nil {
+		writePair(w, []byte{outTaprootInternalKey}, out.TaprootInternalKey)
+	}
+	for _, derivation := range out.TaprootBip32Derivation {
+		writePair(w, append([]byte{outTaprootBip32Derivation}, derivation.XOnlyPubKey...), derivation.value())
+	}
+	writeUnknowns(w, out.Unknowns)
+}
+
+func parseTxOut(data []byte) (*wire.TxOut, error) {
+	if len(data) < 9 {
+		return nil, errors.New("invalid witness utxo")
+	}
+	r := bytes.NewReader(data[8:])
+	pkScript, err := wire.ReadVarBytes(r, 0, uint32(len(data)), "pkScript")
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("invalid witness utxo")
+	}
+	return wire.NewTxOut(int64(binary.LittleEndian.Uint64(data[:8])), pkScript), nil
+}
+
+func parseBip32Derivation(pubKey []byte, value []byte) (*Bip32Derivation, error) {
+	if len(pubKey) != 33 && len(pubKey) != 65 {
+		return nil, errors.New("invalid bip32 derivation public key")
+	}
+	if len(value) < 4 || len(value)%4 != 0 {
+		return nil, errors.New("invalid bip32 derivation path")
+	}
+
+	derivation := &Bip32Derivation{PubKey: pubKey}
+	copy(derivation.Fingerprint[:], value[:4])
+	derivation.Path = parsePath(value[4:])
+	return derivation, nil
+}
+
+func parseTaprootBip32Derivation(xOnlyPubKey []byte, value []byte) (*TaprootBip32Derivation, error) {
+	if len(xOnlyPubKey) != 32 {
+		return nil, errors.New("invalid taproot bip32 derivation public key")
+	}
+
+	r := bytes.NewReader(value)
+	count, err := wire.ReadVarInt(r, 0)
+	if err != nil || count > uint64(r.Len()/32) {
+		return nil, errors.New("invalid taproot bip32 derivation leaf hashes")
+	}
+
+	derivation := &TaprootBip32Derivation{XOnlyPubKey: xOnlyPubKey}
+	for i := uint64(0); i < count; i++ {
+		leafHash := make([]byte, 32)
+		_, err = io.ReadFull(r, leafHash)
+		if err != nil {
+			return nil, err
+		}
+		derivation.LeafHashes = append(derivation.LeafHashes, leafHash)
+	}
+
+	rest := value[len(value)-r.Len():]
+	if len(rest) < 4 || len(rest)%4 != 0 {
+		return nil, errors.New("invalid taproot bip32 derivation path")
+	}
+	copy(derivation.Fingerprint[:], rest[:4])
+	derivation.Path = parsePath(rest[4:])
+	return derivation, nil
+}
+
+func (d *Bip32Derivation) value() []byte {
+	return append(d.Fingerprint[:], serializePath(d.Path)...)
+}
+
+func (d *TaprootBip32Derivation) value() []byte {
+	var buf bytes.Buffer
+	wire.WriteVarInt(&buf, 0, uint64(len(d.LeafHashes)))
+	for _, leafHash := range d.LeafHashes {
+		buf.Write(leafHash)
+	}
+	buf.Write(d.Fingerprint[:])
+	buf.Write(serializePath(d.Path))
+	return buf.Bytes()
+}
+
+func parsePath(data []byte) []uint32 {
+	path := make([]uint32, len(data)/4)
+	for i := range path {
+		path[i] = binary.LittleEndian.Uint32(data[i*4:])
+	}
+	return path
+}
+
+func serializePath(path []uint32) []byte {
+	data := make([]byte, len(path)*4)
+	for i, index := range path {
+		binary.LittleEndian.PutUint32(data[i*4:], index)
+	}
+	return data
+}
+
+// readMap Read the key value pairs until the separator, the duplicated keys are rejected
+func readMap(r *bytes.Reader) ([]*Unknown, error) {
+	var pairs []*Unknown
+	seen := make(map[string]bool)
+	for {
+		key, err := wire.ReadVarBytes(r, 0, maxPsbtSize, "key")
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPsbt, err)
+		}
+		if len(key) == 0 {
+			return pairs, nil
+		}
+		if seen[string(key)] {
+			return nil, fmt.Errorf("%w: duplicated key %x", ErrInvalidPsbt, key)
+		}
+		seen[string(key)] = true
+
+		value, err := wire.ReadVarBytes(r, 0, maxPsbtSize, "value")
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPsbt, err)
+		}
+		pairs = append(pairs, &Unknown{key, value})
+	}
+}
+
+func writePair(w *bytes.Buffer, key []byte, value []byte) {
+	wire.WriteVarBytes(w, 0, key)
+	wire.WriteVarBytes(w, 0, value)
+}
+
+// writeUnknowns Write the unknown pairs sorted by the key, so the serialization is deterministic
+func writeUnknowns(w *bytes.Buffer, unknowns []*Unknown) {
+	sorted := append([]*Unknown(nil), unknowns...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].Key, sorted[j].Key) < 0 })
+	for _, pair := range sorted {
+		writePair(w, pair.Key, pair.Value)
+	}
+}
+
+func keyDataAllowed(keyType byte, allowed ...byte) bool {
+	for _, t := range allowed {
+		if keyType == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,562 @@
+package psbt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/jayt106/bitcoinAddressGenerator/taproot"
+	"strings"
+	"testing"
+)
+
+var testPubKeys = []string{
+	"04a882d414e478039cd5b52a92ffb13dd5e6bd4515497439dffd691a0f12af9575fa349b5694ed3155b136f09e63975a1700c9f4d4df849323dac06cf3bd6458cd",
+	"046ce31db9bdd543e72fe3039a1f1c047dab87037c36a669ff90e28da1848f640de68c2fe913d363a51154a0c62d7adea1b822d05035077418267b1a1379790187",
+	"0411ffd36c70776538d079fbae117dc38effafb33304af83ce4894589747aee1ef992f63280567f52f5ba870678b4ab4ff6c8ea600bd217870a8b4f1f09f3a8e83",
+}
+
+// testMultiSigScript Return the 2-of-3 redeem script of the test public keys, the script of 347N1Thc213QqfYCz3PZkjoJpNv5b14kBd
+func testMultiSigScript(t *testing.T) []byte {
+	var addrs []*btcutil.AddressPubKey
+	for _, key := range testPubKeys {
+		data, _ := hex.DecodeString(key)
+		addr, err := btcutil.NewAddressPubKey(data, &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	script, err := txscript.MultiSigScript(addrs, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+func TestParsePsbt(t *testing.T) {
+	// The first valid psbt of the BIP174 test vectors
+	b64 := "cHNidP8BAHUCAAAAASaBcTce3/KF6Tet7qSze3gADAVmy7OtZGQXE8pCFxv2AAAAAAD+////AtPf9QUAAAAAGXapFNDFmQPFusKGh2DpD9UhpGZap2UgiKwA4fUFAAAAABepFDVF5uM7gyxHBQ8k0+65PJwDlIvHh7MuEwAAAQD9pQEBAAAAAAECiaPHHqtNIOA3G7ukzGmPopXJRjr6Ljl/hTPMti+VZ+UBAAAAFxYAFL4Y0VKpsBIDna89p95PUzSe7LmF/////4b4qkOnHf8USIk6UwpyN+9rRgi7st0tAXHmOuxqSJC0AQAAABcWABT+Pp7xp0XpdNkCxDVZQ6vLNL1TU/////8CAMLrCwAAAAAZdqkUhc/xCX/Z4Ai7NK9wnGIZeziXikiIrHL++E4sAAAAF6kUM5cluiHv1irHU6m80GfWx6ajnQWHAkcwRAIgJxK+IuAnDzlPVoMR3HyppolwuAJf3TskAinwf4pfOiQCIAGLONfc0xTnNMkna9b7QPZzMlvEuqFEyADS8vAtsnZcASED0uFWdJQbrUqZY3LLh+GFbTZSYG2YVi/jnF6efkE/IQUCSDBFAiEA0SuFLYXc2WHS9fSrZgZU327tzHlMDDPOXMMJ/7X85Y0CIGczio4OFyXBl/saiK9Z9R5E5CVbIBZ8hoQDHAXR8lkqASECI7cr7vCWXRC+B3jv7NYfysb3mk6haTkzgHNEZPhPKrMAAAAAAAAA"
+	p, err := NewFromBase64(b64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Inputs) != 1 || len(p.Outputs) != 2 || p.Inputs[0].NonWitnessUtxo == nil {
+		t.Fatal("Unexpected psbt:", len(p.Inputs), len(p.Outputs))
+	}
+
+	encoded, err := p.B64Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded != b64 {
+		t.Error("The serialized psbt doesn't match:", encoded)
+	}
+
+	// The input without the unsigned transaction and the duplicated key are rejected
+	for _, invalid := range []string{"cHNidP8AAA==", "cHNidP8BAHUBAHU="} {
+		if _, err = NewFromBase64(invalid); err == nil {
+			t.Error("The invalid psbt should be rejected:", invalid)
+		}
+	}
+}
+
+func TestDerivationPath(t *testing.T) {
+	path, err := ParseDerivationPath("m/48'/0h/0'/2'/0/5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(path) != 6 || path[0] != 0x80000030 || path[5] != 5 {
+		t.Error("Unexpected path:", path)
+	}
+	if s := FormatDerivationPath(path); s != "m/48'/0'/0'/2'/0/5" {
+		t.Error("Unexpected path notation:", s)
+	}
+
+	for _, invalid := range []string{"", "0/1", "m/x", "m/2147483648"} {
+		if _, err = ParseDerivationPath(invalid); err == nil {
+			t.Error("The invalid path should be rejected:", invalid)
+		}
+	}
+}
+
+func TestCreate(t *testing.T) {
+	redeemScript := testMultiSigScript(t)
+	address, err := btcutil.NewAddressScriptHash(redeemScript, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address.EncodeAddress() != "347N1Thc213QqfYCz3PZkjoJpNv5b14kBd" {
+		t.Fatal("Unexpected multisig address:", address.EncodeAddress())
+	}
+
+	prevTx := wire.NewMsgTx(1)
+	prevTx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Index: 0}})
+	prevTx.AddTxOut(wire.NewTxOut(100000, P2SHScript(redeemScript)))
+
+	// The uncompressed keys are non-standard in the witness, still fine for the estimation
+	witnessScript := redeemScript
+	p2wshScript := P2WSHScript(witnessScript)
+
+	pubKey, _ := hex.DecodeString(testPubKeys[0])
+	derivation := &Bip32Derivation{PubKey: pubKey, Fingerprint: [4]byte{1, 2, 3, 4}, Path: []uint32{0x80000000, 0, 1}}
+	utxos := []*UTXO{
+		{OutPoint: wire.OutPoint{Hash: prevTx.TxHash(), Index: 0}, Value: 100000, RedeemScript: redeemScript, PrevTx: prevTx,
+			Bip32Derivation: []*Bip32Derivation{derivation}},
+		{OutPoint: wire.OutPoint{Hash: chainhash.Hash{1}, Index: 1}, Value: 50000, WitnessScript: witnessScript},
+	}
+	outputs := []*TxOutput{{TxOut: wire.NewTxOut(120000, p2wshScript)}}
+	change := &TxOutput{TxOut: wire.NewTxOut(0, P2SHScript(redeemScript)), Output: Output{RedeemScript: redeemScript}}
+
+	p, summary, err := Create(utxos, outputs, change, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.ChangeIndex != 1 || len(p.UnsignedTx.TxOut) != 2 || p.Outputs[1].RedeemScript == nil {
+		t.Fatal("The change output is expected:", summary.ChangeIndex)
+	}
+	if summary.Fee < summary.VSize*10 || summary.Fee > summary.VSize*10+10 {
+		t.Error("Unexpected fee:", summary.Fee, summary.VSize)
+	}
+	if summary.InputValue != summary.OutputValue+summary.Fee || len(summary.Warnings) != 0 {
+		t.Error("Unexpected summary:", summary)
+	}
+	if p.Inputs[0].NonWitnessUtxo == nil || p.Inputs[0].WitnessUtxo != nil || p.Inputs[1].WitnessUtxo == nil {
+		t.Error("Unexpected utxos of the inputs")
+	}
+	if p.UnsignedTx.TxIn[0].Sequence != DefaultSequence || p.UnsignedTx.Version != 2 {
+		t.Error("Unexpected unsigned transaction")
+	}
+
+	data, err := p.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reserialized, err := parsed.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, reserialized) {
+		t.Error("The parsed psbt doesn't match")
+	}
+	in := parsed.Inputs[0]
+	if !bytes.Equal(in.RedeemScript, redeemScript) || len(in.Bip32Derivation) != 1 ||
+		FormatDerivationPath(in.Bip32Derivation[0].Path) != "m/0'/0/1" || in.Bip32Derivation[0].Fingerprint != derivation.Fingerprint {
+		t.Error("Unexpected input fields of the parsed psbt")
+	}
+	if !bytes.Equal(parsed.Inputs[1].WitnessScript, witnessScript) {
+		t.Error("Unexpected witness script of the parsed psbt")
+	}
+
+	// The dust change is left to the fee
+	outputs[0].TxOut.Value = 150000 - summary.Fee
+	change.TxOut.Value = 0
+	p, summary, err = Create(utxos, outputs, change, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.ChangeIndex != -1 || len(p.UnsignedTx.TxOut) != 1 {
+		t.Error("The dust change should be dropped")
+	}
+
+	// The legacy input without the previous transaction is warned
+	utxos[0].PrevTx = nil
+	_, summary, err = Create(utxos[:1], []*TxOutput{{TxOut: wire.NewTxOut(90000, p2wshScript)}}, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Warnings) != 1 {
+		t.Error("The warning is expected:", summary.Warnings)
+	}
+
+	_, _, err = Create(utxos[:1], []*TxOutput{{TxOut: wire.NewTxOut(100000, p2wshScript)}}, nil, 1)
+	if err == nil || !strings.Contains(err.Error(), "insufficient funds") {
+		t.Error("The insufficient funds error is expected:", err)
+	}
+}
+
+// testDerivation Return the public key and the derivation of the path m/0'/0/index of the master key
+func testDerivation(t *testing.T, masterKey *hdkeychain.ExtendedKey, index uint32) (*btcec.PublicKey, *Bip32Derivation) {
+	fingerprint, err := Fingerprint(masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := []uint32{hdkeychain.HardenedKeyStart, 0, index}
+	key, err := DeriveKey(masterKey, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := key.ECPubKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pubKey, &Bip32Derivation{PubKey: pubKey.SerializeCompressed(), Fingerprint: fingerprint, Path: path}
+}
+
+func TestSign(t *testing.T) {
+	// The master key of the BIP032 test vector 1, the fingerprint is 3442193e
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	masterKey, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint, err := Fingerprint(masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(fingerprint[:]) != "3442193e" {
+		t.Fatal("Unexpected fingerprint:", hex.EncodeToString(fingerprint[:]))
+	}
+
+	pubKeys := make([]*btcec.PublicKey, 4)
+	derivations := make([]*Bip32Derivation, 4)
+	for i := range pubKeys {
+		pubKeys[i], derivations[i] = testDerivation(t, masterKey, uint32(i))
+	}
+
+	var addrs []*btcutil.AddressPubKey
+	for _, pubKey := range pubKeys[1:] {
+		addr, err := btcutil.NewAddressPubKey(pubKey.SerializeCompressed(), &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, addr)
+	}
+	multiSigScript, err := txscript.MultiSigScript(addrs, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The key of another wallet is not signed
+	otherDerivation := &Bip32Derivation{PubKey: derivations[1].PubKey, Fingerprint: [4]byte{1, 2, 3, 4}, Path: derivations[1].Path}
+
+	p2wpkhScript := append([]byte{txscript.OP_0, txscript.OP_DATA_20}, btcutil.Hash160(derivations[0].PubKey)...)
+	p2shP2wpkhScript := append([]byte{txscript.OP_0, txscript.OP_DATA_20}, btcutil.Hash160(derivations[1].PubKey)...)
+	outputKey, err := taproot.OutputKey(pubKeys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prevTx := wire.NewMsgTx(2)
+	prevTx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Index: 0}})
+	prevTx.AddTxOut(wire.NewTxOut(40000, P2SHScript(multiSigScript)))
+
+	utxos := []*UTXO{
+		{OutPoint: wire.OutPoint{Hash: chainhash.Hash{1}}, Value: 10000, PkScript: p2wpkhScript,
+			Bip32Derivation: []*Bip32Derivation{derivations[0]}},
+		{OutPoint: wire.OutPoint{Hash: chainhash.Hash{2}}, Value: 20000, RedeemScript: p2shP2wpkhScript,
+			Bip32Derivation: []*Bip32Derivation{derivations[1]}},
+		{OutPoint: wire.OutPoint{Hash: chainhash.Hash{3}}, Value: 30000, WitnessScript: multiSigScript,
+			Bip32Derivation: []*Bip32Derivation{derivations[2], derivations[3]}},
+		{OutPoint: wire.OutPoint{Hash: prevTx.TxHash()}, Value: 40000, RedeemScript: multiSigScript, PrevTx: prevTx,
+			Bip32Derivation: []*Bip32Derivation{otherDerivation, derivations[3]}},
+		{OutPoint: wire.OutPoint{Hash: chainhash.Hash{5}}, Value: 50000, PkScript: taproot.PkScript(outputKey),
+			TaprootInternalKey: taproot.XOnlyPubKey(pubKeys[0]),
+			TaprootBip32Derivation: []*TaprootBip32Derivation{{XOnlyPubKey: taproot.XOnlyPubKey(pubKeys[0]),
+				Fingerprint: fingerprint, Path: derivations[0].Path}}},
+	}
+	outputs := []*TxOutput{{TxOut: wire.NewTxOut(100000, p2wpkhScript)}}
+
+	p, _, err := Create(utxos, outputs, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := Sign(p, masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signed) != 5 {
+		t.Fatal("Unexpected signed inputs:", signed)
+	}
+
+	expectedSigs := []int{1, 1, 2, 1, 0}
+	sigHashes := txscript.NewTxSigHashes(p.UnsignedTx)
+	scripts := [][]byte{p2wpkhScript, p2shP2wpkhScript, multiSigScript, multiSigScript}
+	for i, in := range p.Inputs[:4] {
+		if len(in.PartialSigs) != expectedSigs[i] {
+			t.Fatal("Unexpected partial signatures of the input", i, len(in.PartialSigs))
+		}
+
+		for _, partialSig := range in.PartialSigs {
+			var hash []byte
+			if i == 3 {
+				hash, err = txscript.CalcSignatureHash(scripts[i], txscript.SigHashAll, p.UnsignedTx, i)
+			} else {
+				hash, err = txscript.CalcWitnessSigHash(scripts[i], sigHashes, txscript.SigHashAll, p.UnsignedTx, i, utxos[i].Value)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sig, err := btcec.ParseDERSignature(partialSig.Signature[:len(partialSig.Signature)-1], btcec.S256())
+			if err != nil {
+				t.Fatal(err)
+			}
+			pubKey, err := btcec.ParsePubKey(partialSig.PubKey, btcec.S256())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sig.Verify(hash, pubKey) {
+				t.Error("Invalid partial signature of the input", i)
+			}
+		}
+	}
+
+	prevOuts, err := p.PrevOuts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = taproot.VerifyKeyPath(p.UnsignedTx, 4, prevOuts, p.Inputs[4].TaprootKeySpendSig)
+	if err != nil {
+		t.Error("Invalid taproot signature:", err)
+	}
+
+	// The signed keys are not signed again
+	signed, err = Sign(p, masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signed) != 0 {
+		t.Error("The inputs should not be signed again:", signed)
+	}
+}
+
+// testCopy Return a copy of the psbt
+func testCopy(t *testing.T, p *Packet) *Packet {
+	data, err := p.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return copied
+}
+
+func TestCombineFinalize(t *testing.T) {
+	var masterKeys []*hdkeychain.ExtendedKey
+	for _, seedHex := range []string{"000102030405060708090a0b0c0d0e0f", "fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542"} {
+		seed, _ := hex.DecodeString(seedHex)
+		masterKey, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		masterKeys = append(masterKeys, masterKey)
+	}
+
+	// The 2-of-3 multisig of the keys of the cosigners A and B and a key without the derivation
+	pubKeyA, derivationA := testDerivation(t, masterKeys[0], 0)
+	pubKeyB, derivationB := testDerivation(t, masterKeys[1], 0)
+	pubKeyC, _ := hex.DecodeString("02f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9")
+	var addrs []*btcutil.AddressPubKey
+	for _, pubKey := range [][]byte{pubKeyA.SerializeCompressed(), pubKeyB.SerializeCompressed(), pubKeyC} {
+		addr, err := btcutil.NewAddressPubKey(pubKey, &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, addr)
+	}
+	multiSigScript, err := txscript.MultiSigScript(addrs, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prevTx := wire.NewMsgTx(2)
+	prevTx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Index: 0}})
+	prevTx.AddTxOut(wire.NewTxOut(40000, P2SHScript(multiSigScript)))
+
+	p2wpkhScript := append([]byte{txscript.OP_0, txscript.OP_DATA_20}, btcutil.Hash160(derivationA.PubKey)...)
+	outputKey, err := taproot.OutputKey(pubKeyA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derivations := []*Bip32Derivation{derivationA, derivationB}
+	utxos := []*UTXO{
+		{OutPoint: wire.OutPoint{Hash: chainhash.Hash{1}}, Value: 30000, WitnessScript: multiSigScript, Bip32Derivation: derivations},
+		{OutPoint: wire.OutPoint{Hash: prevTx.TxHash()}, Value: 40000, RedeemScript: multiSigScript, PrevTx: prevTx,
+			Bip32Derivation: derivations},
+		{OutPoint: wire.OutPoint{Hash: chainhash.Hash{3}}, Value: 10000, PkScript: p2wpkhScript,
+			Bip32Derivation: []*Bip32Derivation{derivationA}},
+		{OutPoint: wire.OutPoint{Hash: chainhash.Hash{4}}, Value: 20000, PkScript: taproot.PkScript(outputKey),
+			TaprootInternalKey: taproot.XOnlyPubKey(pubKeyA),
+			TaprootBip32Derivation: []*TaprootBip32Derivation{{XOnlyPubKey: taproot.XOnlyPubKey(pubKeyA),
+				Fingerprint: derivationA.Fingerprint, Path: derivationA.Path}}},
+	}
+	p, _, err := Create(utxos, []*TxOutput{{TxOut: wire.NewTxOut(90000, p2wpkhScript)}}, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signedA, signedB := testCopy(t, p), testCopy(t, p)
+	if _, err = Sign(signedA, masterKeys[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Sign(signedB, masterKeys[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	// A single cosigner can't finalize the multisig inputs
+	partial := testCopy(t, signedA)
+	statuses := Finalize(partial)
+	if IsComplete(statuses) || statuses[0].Finalized || statuses[0].Signatures != 1 || statuses[0].Required != 2 ||
+		!strings.Contains(statuses[0].Reason, hex.EncodeToString(derivationB.PubKey)) {
+		t.Error("Unexpected status of the multisig input:", statuses[0])
+	}
+	if !statuses[2].Finalized || !statuses[3].Finalized {
+		t.Error("The single key inputs should be finalized:", statuses[2], statuses[3])
+	}
+	if _, err = Extract(partial); err == nil {
+		t.Error("The incomplete psbt should not be extracted")
+	}
+
+	// The invalid signature is reported
+	corrupted := testCopy(t, signedB)
+	corrupted.Inputs[1].PartialSigs[0].Signature[10] ^= 0xff
+	combined, err := Combine([]*Packet{signedA, corrupted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses = Finalize(combined)
+	if statuses[1].Finalized || !strings.Contains(statuses[1].Reason, "invalid signatures") {
+		t.Error("The invalid signature should be reported:", statuses[1])
+	}
+
+	combined, err = Combine([]*Packet{signedA, signedB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses = Finalize(combined)
+	if !IsComplete(statuses) {
+		t.Fatal("The combined psbt should be complete:", statuses)
+	}
+
+	tx, err := Extract(combined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevOuts, err := combined.PrevOuts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigHashes := txscript.NewTxSigHashes(tx)
+	for i := range tx.TxIn[:3] {
+		engine, err := txscript.NewEngine(prevOuts[i].PkScript, tx, i, txscript.StandardVerifyFlags, nil, sigHashes, prevOuts[i].Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = engine.Execute(); err != nil {
+			t.Error("The script of the input fails:", i, err)
+		}
+	}
+	if err = taproot.VerifyKeyPath(tx, 3, prevOuts, tx.TxIn[3].Witness[0]); err != nil {
+		t.Error("Invalid taproot witness:", err)
+	}
+
+	other := testCopy(t, p)
+	other.UnsignedTx.LockTime = 1
+	if _, err = Combine([]*Packet{p, other}); err == nil {
+		t.Error("The psbts of the different transactions should not be combined")
+	}
+}
+
+func TestCheckScript(t *testing.T) {
+	var privKeys []*btcec.PrivateKey
+	var compressed, uncompressed []*btcutil.AddressPubKey
+	for i := byte(1); i <= 5; i++ {
+		privKey, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), bytes.Repeat([]byte{i}, 32))
+		privKeys = append(privKeys, privKey)
+		addr, err := btcutil.NewAddressPubKey(pubKey.SerializeCompressed(), &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressed = append(compressed, addr)
+		addr, err = btcutil.NewAddressPubKey(pubKey.SerializeUncompressed(), &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uncompressed = append(uncompressed, addr)
+	}
+	compressedScript, err := txscript.MultiSigScript(compressed[:3], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uncompressedScript, err := txscript.MultiSigScript(uncompressed[:3], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, inputType := range []string{InputP2SH, InputP2WSH, InputP2SHP2WSH} {
+		check, err := CheckScript(compressedScript, inputType, privKeys[1:3])
+		if err != nil {
+			t.Fatal(inputType, err)
+		}
+		if !check.Passed || check.Signatures != 2 || len(check.Trace) == 0 {
+			t.Error(inputType, "should pass:", check.Error)
+		}
+		if check.Weight > check.EstimatedWeight || len(check.Warnings) != 0 {
+			t.Error(inputType, "unexpected weight:", check.Weight, check.EstimatedWeight, check.Warnings)
+		}
+		if (check.WitnessSize != 0) != IsWitness(inputType) || (check.ScriptSigSize != 0) == (inputType == InputP2WSH) {
+			t.Error(inputType, "unexpected sizes:", check.ScriptSigSize, check.WitnessSize)
+		}
+	}
+
+	// The legacy script accepts the uncompressed keys, the witness script doesn't
+	check, err := CheckScript(uncompressedScript, InputP2SH, privKeys[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !check.Passed || check.MultiSigSize != 344 || check.MultiSigBound != 496 || len(check.Warnings) != 0 {
+		t.Error("The uncompressed P2SH script should pass:", check.Error, check.MultiSigSize, check.Warnings)
+	}
+	check, err = CheckScript(uncompressedScript, InputP2WSH, privKeys[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Passed || check.Error == "" || len(check.Trace) == 0 {
+		t.Error("The uncompressed P2WSH script should fail:", check.Passed, check.Trace)
+	}
+
+	// The m*73+n*66 estimate of the 3-of-5 multisig exceeds the bound, the signature script of the compressed keys doesn't
+	bigScript, err := txscript.MultiSigScript(compressed, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check, err = CheckScript(bigScript, InputP2SH, privKeys[:3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !check.Passed || check.MultiSigSize != 549 || check.ScriptSigSize > check.MultiSigBound || len(check.Warnings) != 1 ||
+		!strings.Contains(check.Warnings[0], "disagree on the bound 496") {
+		t.Error("Unexpected check of the 3-of-5 multisig:", check.ScriptSigSize, check.MultiSigSize, check.Warnings)
+	}
+
+	// A single signature and a key of another script
+	otherKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), bytes.Repeat([]byte{6}, 32))
+	check, err = CheckScript(compressedScript, InputP2WSH, []*btcec.PrivateKey{privKeys[0], otherKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Passed || !strings.HasPrefix(check.Error, "1 of 2 valid signatures") || len(check.Warnings) != 1 {
+		t.Error("Unexpected check of a single signature:", check.Error, check.Warnings)
+	}
+
+	if _, err = CheckScript(compressedScript, InputP2TR, privKeys); err == nil {
+		t.Error("The taproot input type should be rejected")
+	}
+	if _, err = CheckScript(compressedScript, InputP2SH, nil); err == nil {
+		t.Error("The check without the private keys should be rejected")
+	}
+}
@@ -0,0 +1,204 @@
+package psbt
+
+import (
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/jayt106/bitcoinAddressGenerator/cipher"
+)
+
+// The standardness limits of the spending scripts (Bitcoin Core policy)
+const (
+	MaxStandardScriptSigSize     = 1650
+	MaxStandardP2WSHScriptSize   = 3600
+	MaxStandardP2WSHStackItems   = 100
+	MaxStandardP2WSHStackItemLen = 80
+)
+
+// errNoKeys the script check needs the private keys to sign
+var errNoKeys = errors.New("no private key to sign the script")
+
+// checkValue the value of the dummy output spent by the script check
+const checkValue = 100000
+
+// ScriptCheck the result of the dummy spend of a script, the executed opcodes, and the actual sizes of the signature
+// script and the witness compared with the estimate used for the fee. The P2SH multisig also has the m*73+n*66 size
+// estimate of the multisig address generator next to its bound, to compare with the actual signature script size.
+type ScriptCheck struct {
+	Type            string   `json:"type"`
+	Passed          bool     `json:"passed"`
+	Error           string   `json:"error,omitempty"`
+	Trace           []string `json:"trace"`
+	Signatures      int      `json:"signatures"`
+	Required        int      `json:"required"`
+	ScriptSigSize   int      `json:"scriptSigSize"`
+	WitnessSize     int      `json:"witnessSize"`
+	Weight          int64    `json:"weight"`
+	EstimatedWeight int64    `json:"estimatedWeight"`
+	MultiSigSize    int      `json:"multiSigSize,omitempty"`
+	MultiSigBound   int      `json:"multiSigBound,omitempty"`
+	Warnings        []string `json:"warnings,omitempty"`
+}
+
+// CheckScript Prove the multisig script is spendable before funding it. Spend a dummy output of the script with the
+// input type (p2sh, p2wsh or p2sh-p2wsh), sign by the private keys, finalize and execute the input by the script engine
+// with the standard verify flags. The failed check has the error, the other errors are the invalid arguments.
+func CheckScript(script []byte, inputType string, privKeys []*btcec.PrivateKey) (*ScriptCheck, error) {
+	if len(script) == 0 {
+		return nil, errors.New("empty script")
+	}
+	if len(privKeys) == 0 {
+		return nil, errNoKeys
+	}
+
+	utxo := &UTXO{Value: checkValue}
+	switch inputType {
+	case InputP2SH:
+		utxo.RedeemScript = script
+	case InputP2WSH:
+		utxo.WitnessScript = script
+	case InputP2SHP2WSH:
+		utxo.WitnessScript = script
+		utxo.RedeemScript = P2WSHScript(script)
+	default:
+		return nil, fmt.Errorf("unsupported input type %s", inputType)
+	}
+	pkScript, err := utxo.OutputScript()
+	if err != nil {
+		return nil, err
+	}
+
+	// The funding transaction of the dummy output
+	prevTx := wire.NewMsgTx(wire.TxVersion)
+	prevTx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{}, Index: wire.MaxPrevOutIndex}})
+	prevTx.AddTxOut(wire.NewTxOut(checkValue, pkScript))
+	utxo.OutPoint = wire.OutPoint{Hash: prevTx.TxHash(), Index: 0}
+	utxo.PrevTx = prevTx
+
+	p, _, err := Create([]*UTXO{utxo}, []*TxOutput{{TxOut: wire.NewTxOut(checkValue/2, pkScript)}}, nil, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	check := &ScriptCheck{Type: inputType}
+	check.EstimatedWeight, err = EstimateInputWeight(inputType, p.Inputs[0].RedeemScript, p.Inputs[0].WitnessScript)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, privKey := range privKeys {
+		signed, err := SignWithKey(p, 0, privKey)
+		if err != nil {
+			return nil, err
+		}
+		if !signed {
+			check.Warnings = append(check.Warnings, fmt.Sprintf("the private key %d isn't in the script", i))
+		}
+	}
+
+	status := Finalize(p)[0]
+	check.Signatures, check.Required = status.Signatures, status.Required
+	if !status.Finalized {
+		check.Error = status.Reason
+		return check, nil
+	}
+
+	tx, err := Extract(p)
+	if err != nil {
+		return nil, err
+	}
+	txIn := tx.TxIn[0]
+	check.ScriptSigSize = len(txIn.SignatureScript)
+	if IsWitness(inputType) {
+		check.WitnessSize = txIn.Witness.SerializeSize()
+	}
+	base := 32 + 4 + 4 + wire.VarIntSerializeSize(uint64(check.ScriptSigSize)) + check.ScriptSigSize
+	check.Weight = int64(base)*WitnessScaleFactor + int64(check.WitnessSize)
+	if check.Weight > check.EstimatedWeight {
+		check.Warnings = append(check.Warnings, fmt.Sprintf("the weight %d exceeds the estimate %d", check.Weight, check.EstimatedWeight))
+	}
+	if inputType == InputP2SH {
+		check.Warnings = append(check.Warnings, multiSigSizeWarnings(check, script)...)
+	}
+	check.Warnings = append(check.Warnings, standardnessWarnings(inputType, txIn)...)
+
+	check.Trace, err = executeTrace(pkScript, tx, 0, checkValue)
+	if err != nil {
+		check.Error = err.Error()
+		return check, nil
+	}
+	check.Passed = true
+	return check, nil
+}
+
+// executeTrace Execute the input by the script engine, return the disassembled opcodes executed until the failure
+func executeTrace(pkScript []byte, tx *wire.MsgTx, idx int, value int64) ([]string, error) {
+	engine, err := txscript.NewEngine(pkScript, tx, idx, txscript.StandardVerifyFlags, nil, txscript.NewTxSigHashes(tx), value)
+	if err != nil {
+		return nil, err
+	}
+
+	var trace []string
+	for done := false; !done; {
+		dis, err := engine.DisasmPC()
+		if err == nil {
+			trace = append(trace, dis)
+		}
+
+		done, err = engine.Step()
+		if err != nil {
+			return trace, err
+		}
+	}
+	return trace, engine.CheckErrorCondition(true)
+}
+
+// multiSigSizeWarnings Set the m*73+n*66 size estimate of the P2SH multisig script and its bound, and warn when the
+// estimate and the actual signature script size aren't on the same side of the bound
+func multiSigSizeWarnings(check *ScriptCheck, script []byte) []string {
+	if txscript.GetScriptClass(script) != txscript.MultiSigTy {
+		return nil
+	}
+	n, m, err := txscript.CalcMultiSigStats(script)
+	if err != nil {
+		return nil
+	}
+
+	check.MultiSigSize, check.MultiSigBound = cipher.MultiSigSizeEstimate(m, n), cipher.MultiSigSizeBound
+	if (check.MultiSigSize > check.MultiSigBound) == (check.ScriptSigSize > check.MultiSigBound) {
+		return nil
+	}
+	return []string{fmt.Sprintf("the m*73+n*66 estimate %d of the %d-of-%d multisig and the signature script size %d "+
+		"disagree on the bound %d", check.MultiSigSize, m, n, check.ScriptSigSize, check.MultiSigBound)}
+}
+
+// standardnessWarnings Return the standardness violations of the spending input, the transaction isn't relayed
+func standardnessWarnings(inputType string, txIn *wire.TxIn) []string {
+	var warnings []string
+	if len(txIn.SignatureScript) > MaxStandardScriptSigSize {
+		warnings = append(warnings, fmt.Sprintf("the signature script size %d exceeds the standard limit %d",
+			len(txIn.SignatureScript), MaxStandardScriptSigSize))
+	}
+	if inputType == InputP2WSH || inputType == InputP2SHP2WSH {
+		witnessScript := txIn.Witness[len(txIn.Witness)-1]
+		if len(witnessScript) > MaxStandardP2WSHScriptSize {
+			warnings = append(warnings, fmt.Sprintf("the witness script size %d exceeds the standard limit %d",
+				len(witnessScript), MaxStandardP2WSHScriptSize))
+		}
+		items := txIn.Witness[:len(txIn.Witness)-1]
+		if len(items) > MaxStandardP2WSHStackItems {
+			warnings = append(warnings, fmt.Sprintf("the witness stack items %d exceed the standard limit %d",
+				len(items), MaxStandardP2WSHStackItems))
+		}
+		for _, item := range items {
+			if len(item) > MaxStandardP2WSHStackItemLen {
+				warnings = append(warnings, fmt.Sprintf("the witness stack item size %d exceeds the standard limit %d",
+					len(item), MaxStandardP2WSHStackItemLen))
+			}
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,266 @@
+package psbt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/jayt106/bitcoinAddressGenerator/taproot"
+)
+
+// Fingerprint Return the BIP032 fingerprint of the master key, the first 4 bytes of the hash160 of the public key
+func Fingerprint(masterKey *hdkeychain.ExtendedKey) ([4]byte, error) {
+	var fingerprint [4]byte
+	pubKey, err := masterKey.ECPubKey()
+	if err != nil {
+		return fingerprint, err
+	}
+	copy(fingerprint[:], btcutil.Hash160(pubKey.SerializeCompressed()))
+	return fingerprint, nil
+}
+
+// Sign Sign the inputs of which the BIP032 derivation fingerprint matches the master key, the P2PKH, P2WPKH, P2SH-P2WPKH,
+// P2SH/P2WSH multisig inputs get the partial signatures and the P2TR inputs get the key path signature. The finalized
+// inputs and the keys not in the input scripts are skipped. Return the indexes of the signed inputs.
+func Sign(p *Packet, masterKey *hdkeychain.ExtendedKey) ([]int, error) {
+	fingerprint, err := Fingerprint(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var signed []int
+	var sigHashes *txscript.TxSigHashes
+	for i := range p.Inputs {
+		in := &p.Inputs[i]
+		if in.FinalScriptSig != nil || in.FinalScriptWitness != nil {
+			continue
+		}
+
+		var n int
+		if IsTaprootInput(in) {
+			n, err = signTaproot(p, i, masterKey, fingerprint)
+		} else {
+			if sigHashes == nil {
+				sigHashes = txscript.NewTxSigHashes(p.UnsignedTx)
+			}
+			n, err = signECDSA(p, i, masterKey, fingerprint, sigHashes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %v", i, err)
+		}
+		if n != 0 {
+			signed = append(signed, i)
+		}
+	}
+	return signed, nil
+}
+
+// IsTaprootInput Return true if the input spends a taproot output
+func IsTaprootInput(in *Input) bool {
+	return in.TaprootInternalKey != nil || len(in.TaprootBip32Derivation) != 0 ||
+		(in.WitnessUtxo != nil && taproot.IsPkScript(in.WitnessUtxo.PkScript))
+}
+
+// signECDSA Add the partial signatures of the keys derived from the master key, return the number of the signatures
+func signECDSA(p *Packet, idx int, masterKey *hdkeychain.ExtendedKey, fingerprint [4]byte, sigHashes *txscript.TxSigHashes) (int, error) {
+	in := &p.Inputs[idx]
+	var derivations []*Bip32Derivation
+	for _, d := range in.Bip32Derivation {
+		if d.Fingerprint == fingerprint && !hasPartialSig(in, d.PubKey) {
+			derivations = append(derivations, d)
+		}
+	}
+	if len(derivations) == 0 {
+		return 0, nil
+	}
+
+	inputType, script, prevOut, err := inputScript(p, idx)
+	if err != nil {
+		return 0, err
+	}
+	if !IsWitness(inputType) && in.NonWitnessUtxo == nil {
+		return 0, errors.New("the non-segwit input has no previous transaction")
+	}
+
+	n := 0
+	for _, d := range derivations {
+		key, err := DeriveKey(masterKey, d.Path)
+		if err != nil {
+			return n, err
+		}
+		privKey, err := key.ECPrivKey()
+		key.Zero()
+		if err != nil {
+			return n, err
+		}
+
+		pubKey := privKey.PubKey().SerializeCompressed()
+		if len(d.PubKey) == btcec.PubKeyBytesLenUncompressed {
+			pubKey = privKey.PubKey().SerializeUncompressed()
+		}
+		if !bytes.Equal(pubKey, d.PubKey) {
+			privKey.D.SetInt64(0)
+			return n, fmt.Errorf("the key of the path %s doesn't match the public key", FormatDerivationPath(d.Path))
+		}
+		if !scriptHasKey(script, pubKey) {
+			privKey.D.SetInt64(0)
+			continue
+		}
+
+		err = addPartialSig(p, idx, inputType, script, prevOut.Value, privKey, pubKey, sigHashes)
+		privKey.D.SetInt64(0)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// SignWithKey Add the partial signature of the private key to the ECDSA input, the key isn't derived by a BIP032 path
+// like the test keys. Return false if neither the compressed nor the uncompressed key is in the input script.
+func SignWithKey(p *Packet, idx int, privKey *btcec.PrivateKey) (bool, error) {
+	inputType, script, prevOut, err := inputScript(p, idx)
+	if err != nil {
+		return false, err
+	}
+	if inputType == InputP2TR {
+		return false, errors.New("the taproot input isn't signed by the ECDSA key")
+	}
+
+	for _, pubKey := range [][]byte{privKey.PubKey().SerializeCompressed(), privKey.PubKey().SerializeUncompressed()} {
+		if !scriptHasKey(script, pubKey) || hasPartialSig(&p.Inputs[idx], pubKey) {
+			continue
+		}
+		err = addPartialSig(p, idx, inputType, script, prevOut.Value, privKey, pubKey, txscript.NewTxSigHashes(p.UnsignedTx))
+		return err == nil, err
+	}
+	return false, nil
+}
+
+// inputScript Return the type of the input, the script the signature commits to and the output spent by the input. The
+// script has the keys or the key hash, it's the output script, the redeem script or the witness script.
+func inputScript(p *Packet, idx int) (string, []byte, *wire.TxOut, error) {
+	in := &p.Inputs[idx]
+	prevOut, err := p.PrevOut(idx)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	inputType, err := InputType(prevOut.PkScript, in.RedeemScript, in.WitnessScript)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	switch inputType {
+	case InputP2PKH, InputP2WPKH:
+		return inputType, prevOut.PkScript, prevOut, nil
+	case InputP2SHP2WPKH, InputP2SH:
+		return inputType, in.RedeemScript, prevOut, nil
+	case InputP2WSH, InputP2SHP2WSH:
+		return inputType, in.WitnessScript, prevOut, nil
+	}
+	return inputType, nil, prevOut, nil
+}
+
+// addPartialSig Sign the input by the key with the sighash type of the input and add the partial signature
+func addPartialSig(p *Packet, idx int, inputType string, script []byte, value int64, privKey *btcec.PrivateKey, pubKey []byte,
+	sigHashes *txscript.TxSigHashes) error {
+	in := &p.Inputs[idx]
+	hashType := in.SighashType
+	if hashType == 0 {
+		hashType = txscript.SigHashAll
+	}
+
+	var sig []byte
+	var err error
+	if IsWitness(inputType) {
+		sig, err = txscript.RawTxInWitnessSignature(p.UnsignedTx, sigHashes, idx, value, script, hashType, privKey)
+	} else {
+		sig, err = txscript.RawTxInSignature(p.UnsignedTx, idx, script, hashType, privKey)
+	}
+	if err != nil {
+		return err
+	}
+
+	in.PartialSigs = append(in.PartialSigs, &PartialSig{PubKey: pubKey, Signature: sig})
+	return nil
+}
+
+// signTaproot Add the key path signature of the internal key derived from the master key, the script path isn't supported
+func signTaproot(p *Packet, idx int, masterKey *hdkeychain.ExtendedKey, fingerprint [4]byte) (int, error) {
+	in := &p.Inputs[idx]
+	if in.TaprootKeySpendSig != nil {
+		return 0, nil
+	}
+
+	var derivation *TaprootBip32Derivation
+	for _, d := range in.TaprootBip32Derivation {
+		if d.Fingerprint == fingerprint && len(d.LeafHashes) == 0 && bytes.Equal(d.XOnlyPubKey, in.TaprootInternalKey) {
+			derivation = d
+		}
+	}
+	if derivation == nil {
+		return 0, nil
+	}
+	if in.TaprootMerkleRoot != nil {
+		return 0, errors.New("the key path of the output with the script tree isn't supported")
+	}
+
+	prevOuts, err := p.PrevOuts()
+	if err != nil {
+		return 0, err
+	}
+
+	key, err := DeriveKey(masterKey, derivation.Path)
+	if err != nil {
+		return 0, err
+	}
+	privKey, err := key.ECPrivKey()
+	key.Zero()
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(taproot.XOnlyPubKey(privKey.PubKey()), derivation.XOnlyPubKey) {
+		privKey.D.SetInt64(0)
+		return 0, fmt.Errorf("the key of the path %s doesn't match the internal key", FormatDerivationPath(derivation.Path))
+	}
+
+	sig, err := taproot.SignKeyPath(p.UnsignedTx, idx, prevOuts, in.SighashType, privKey)
+	privKey.D.SetInt64(0)
+	if err != nil {
+		return 0, err
+	}
+
+	in.TaprootKeySpendSig = sig
+	return 1, nil
+}
+
+// hasPartialSig Return true if the input has the signature of the public key
+func hasPartialSig(in *Input, pubKey []byte) bool {
+	for _, sig := range in.PartialSigs {
+		if bytes.Equal(sig.PubKey, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// scriptHasKey Return true if the script pushes the public key or its hash
+func scriptHasKey(script []byte, pubKey []byte) bool {
+	pushes, err := txscript.PushedData(script)
+	if err != nil {
+		return false
+	}
+
+	hash := btcutil.Hash160(pubKey)
+	for _, data := range pushes {
+		if bytes.Equal(data, pubKey) || bytes.Equal(data, hash) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package psbt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/jayt106/bitcoinAddressGenerator/taproot"
+)
+
+// The input types the package can estimate, sign and finalize
+const (
+	InputP2PKH      = "p2pkh"
+	InputP2WPKH     = "p2wpkh"
+	InputP2SHP2WPKH = "p2sh-p2wpkh"
+	InputP2SH       = "p2sh"
+	InputP2WSH      = "p2wsh"
+	InputP2SHP2WSH  = "p2sh-p2wsh"
+	InputP2TR       = "p2tr"
+)
+
+// The worst case sizes of the signatures and the public key in the scripts
+const (
+	ecdsaSigLen   = 73
+	pubKeyLen     = 33
+	schnorrSigLen = 64
+)
+
+// WitnessScaleFactor the weight of a non-witness byte
+const WitnessScaleFactor = 4
+
+// InputType Return the type of the input spending the output script with the redeem script and witness script
+func InputType(pkScript []byte, redeemScript []byte, witnessScript []byte) (string, error) {
+	if taproot.IsPkScript(pkScript) {
+		return InputP2TR, nil
+	}
+
+	switch txscript.GetScriptClass(pkScript) {
+	case txscript.PubKeyHashTy:
+		return InputP2PKH, nil
+	case txscript.WitnessV0PubKeyHashTy:
+		return InputP2WPKH, nil
+	case txscript.WitnessV0ScriptHashTy:
+		if witnessScript == nil {
+			return "", fmt.Errorf("missing the witness script of the p2wsh output")
+		}
+		if !bytes.Equal(pkScript, P2WSHScript(witnessScript)) {
+			return "", fmt.Errorf("the witness script doesn't match the p2wsh output")
+		}
+		return InputP2WSH, nil
+	case txscript.ScriptHashTy:
+		if redeemScript == nil {
+			return "", fmt.Errorf("missing the redeem script of the p2sh output")
+		}
+		if !bytes.Equal(pkScript, P2SHScript(redeemScript)) {
+			return "", fmt.Errorf("the redeem script doesn't match the p2sh output")
+		}
+		switch {
+		case txscript.IsPayToWitnessPubKeyHash(redeemScript):
+			return InputP2SHP2WPKH, nil
+		case txscript.IsPayToWitnessScriptHash(redeemScript):
+			if witnessScript == nil {
+				return "", fmt.Errorf("missing the witness script of the p2sh-p2wsh output")
+			}
+			if !bytes.Equal(redeemScript, P2WSHScript(witnessScript)) {
+				return "", fmt.Errorf("the witness script doesn't match the p2sh-p2wsh redeem script")
+			}
+			return InputP2SHP2WSH, nil
+		}
+		return InputP2SH, nil
+	}
+	return "", fmt.Errorf("unsupported output script %x", pkScript)
+}
+
+// IsWitness Return true if the input type is spent by the witness
+func IsWitness(inputType string) bool {
+	return inputType != InputP2PKH && inputType != InputP2SH
+}
+
+// P2SHScript Return the P2SH output script of the redeem script
+func P2SHScript(redeemScript []byte) []byte {
+	script := []byte{txscript.OP_HASH160, txscript.OP_DATA_20}
+	script = append(script, btcutil.Hash160(redeemScript)...)
+	return append(script, txscript.OP_EQUAL)
+}
+
+// P2WSHScript Return the P2WSH output script of the witness script
+func P2WSHScript(witnessScript []byte) []byte {
+	hash := sha256.Sum256(witnessScript)
+	return append([]byte{txscript.OP_0, txscript.OP_DATA_32}, hash[:]...)
+}
+
+// EstimateInputWeight Return the worst case weight of the signed input, the outpoint, the sequence, the signature
+// script and the witness. The script inputs must be multisig scripts.
+func EstimateInputWeight(inputType string, redeemScript []byte, witnessScript []byte) (int64, error) {
+	var scriptSigLen, witnessLen int64
+	switch inputType {
+	case InputP2PKH:
+		scriptSigLen = 1 + ecdsaSigLen + 1 + pubKeyLen
+	case InputP2WPKH:
+		witnessLen = 1 + 1 + ecdsaSigLen + 1 + pubKeyLen
+	case InputP2SHP2WPKH:
+		scriptSigLen = 1 + 22
+		witnessLen = 1 + 1 + ecdsaSigLen + 1 + pubKeyLen
+	case InputP2SH:
+		sigs, err := multiSigSigs(redeemScript)
+		if err != nil {
+			return 0, err
+		}
+		scriptSigLen = 1 + sigs*(1+ecdsaSigLen) + pushDataLen(len(redeemScript)) + int64(len(redeemScript))
+	case InputP2WSH, InputP2SHP2WSH:
+		sigs, err := multiSigSigs(witnessScript)
+		if err != nil {
+			return 0, err
+		}
+		witnessLen = varIntLen(sigs+2) + 1 + sigs*(1+ecdsaSigLen) + varIntLen(int64(len(witnessScript))) + int64(len(witnessScript))
+		if inputType == InputP2SHP2WSH {
+			scriptSigLen = 1 + 34
+		}
+	case InputP2TR:
+		witnessLen = 1 + 1 + schnorrSigLen
+	default:
+		return 0, fmt.Errorf("unsupported input type %s", inputType)
+	}
+
+	base := int64(32+4+4) + varIntLen(scriptSigLen) + scriptSigLen
+	return base*WitnessScaleFactor + witnessLen, nil
+}
+
+// OutputWeight Return the weight of the transaction output
+func OutputWeight(txOut *wire.TxOut) int64 {
+	return int64(txOut.SerializeSize()) * WitnessScaleFactor
+}
+
+// InputWeight the type and the estimated weight of a transaction input
+type InputWeight struct {
+	Type   string
+	Weight int64
+}
+
+// EstimateTxWeight Return the worst case weight of the signed transaction of the inputs and the outputs, including
+// the version, the locktime, the counts and the segwit marker and flag if any input has the witness
+func EstimateTxWeight(inputs []InputWeight, outputs []*wire.TxOut) int64 {
+	weight := (4 + 4 + varIntLen(int64(len(inputs))) + varIntLen(int64(len(outputs)))) * WitnessScaleFactor
+
+	witness := false
+	for _, in := range inputs {
+		weight += in.Weight
+		witness = witness || IsWitness(in.Type)
+	}
+	if witness {
+		// The marker and flag, and the empty witness count of each non-witness input
+		weight += 2
+		for _, in := range inputs {
+			if !IsWitness(in.Type) {
+				weight++
+			}
+		}
+	}
+
+	for _, txOut := range outputs {
+		weight += OutputWeight(txOut)
+	}
+	return weight
+}
+
+// VSize Return the virtual size of the weight
+func VSize(weight int64) int64 {
+	return (weight + WitnessScaleFactor - 1) / WitnessScaleFactor
+}
+
+// multiSigSigs Return the number of the signatures required by the multisig script
+func multiSigSigs(script []byte) (int64, error) {
+	if txscript.GetScriptClass(script) != txscript.MultiSigTy {
+		return 0, fmt.Errorf("only the multisig script can be estimated")
+	}
+	_, sigs, err := txscript.CalcMultiSigStats(script)
+	if err != nil {
+		return 0, err
+	}
+	return int64(sigs), nil
+}
+
+func pushDataLen(n int) int64 {
+	switch {
+	case n <= 75:
+		return 1
+	case n <= 0xff:
+		return 2
+	default:
+		return 3
+	}
+}
+
+func varIntLen(n int64) int64 {
+	return int64(wire.VarIntSerializeSize(uint64(n)))
+}
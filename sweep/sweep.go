@@ -0,0 +1,133 @@
+package sweep
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"github.com/jayt106/bitcoinAddressGenerator/psbt"
+	"math"
+)
+
+// uncompressedExtraSize the size the uncompressed public key adds to the P2PKH signature script, the input weight of
+// the psbt package is estimated for the compressed key
+const uncompressedExtraSize = 32
+
+// Result the signed sweep transaction and its summary, the summary has the warnings of the P2PKH inputs without the
+// previous transaction
+type Result struct {
+	Tx      *wire.MsgTx
+	Summary *psbt.Summary
+}
+
+// Sweep Build and sign the transaction spending all the utxos of the WIF keys to the output script, the fee at the fee
+// rate (sat/vB) is taken from the swept value. The utxos are the P2PKH outputs of the compressed or uncompressed keys,
+// and the P2SH-P2WPKH and P2WPKH outputs of the compressed keys. The redeem script of the P2SH-P2WPKH utxo is filled by
+// the key. The previous transaction of the P2PKH utxo is optional, without it the value can't be verified.
+func Sweep(keys []*btcutil.WIF, utxos []*psbt.UTXO, pkScript []byte, feeRate float64) (*Result, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no key to sweep")
+	}
+
+	owners := make([]*btcutil.WIF, len(utxos))
+	uncompressedInputs := 0
+	for i, utxo := range utxos {
+		if utxo.PkScript == nil {
+			return nil, fmt.Errorf("utxo %d: missing the output script", i)
+		}
+		for _, key := range keys {
+			redeemScript, uncompressed, ok, err := keyScript(key, utxo.PkScript)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				owners[i] = key
+				utxo.RedeemScript = redeemScript
+				if uncompressed {
+					uncompressedInputs++
+				}
+				break
+			}
+		}
+		if owners[i] == nil {
+			return nil, fmt.Errorf("utxo %d: no key of the output script %x", i, utxo.PkScript)
+		}
+	}
+
+	// All the value left after the fee goes to the sweep output
+	sweepOutput := &psbt.TxOutput{TxOut: wire.NewTxOut(0, pkScript)}
+	p, summary, err := psbt.Create(utxos, nil, sweepOutput, feeRate)
+	if err != nil {
+		return nil, err
+	}
+	extraFee := int64(math.Ceil(float64(uncompressedInputs*uncompressedExtraSize) * feeRate))
+	if summary.ChangeIndex < 0 || summary.OutputValue-extraFee < psbt.DustLimit {
+		return nil, fmt.Errorf("the swept value %d can't pay the fee and the dust limit %d", summary.InputValue, psbt.DustLimit)
+	}
+	if extraFee != 0 {
+		p.UnsignedTx.TxOut[summary.ChangeIndex].Value -= extraFee
+		summary.OutputValue -= extraFee
+		summary.Weight += int64(uncompressedInputs * uncompressedExtraSize * psbt.WitnessScaleFactor)
+		summary.VSize = psbt.VSize(summary.Weight)
+		summary.Fee += extraFee
+		summary.FeeRate = float64(summary.Fee) / float64(summary.VSize)
+	}
+
+	for i, key := range owners {
+		signed, err := psbt.SignWithKey(p, i, key.PrivKey)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %v", i, err)
+		}
+		if !signed {
+			return nil, fmt.Errorf("input %d: the key isn't in the output script", i)
+		}
+	}
+
+	for _, status := range psbt.Finalize(p) {
+		if !status.Finalized {
+			return nil, fmt.Errorf("input %d: %s", status.Index, status.Reason)
+		}
+	}
+	tx, err := psbt.Extract(p)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Tx: tx, Summary: summary}, nil
+}
+
+// keyScript Return true if the output script pays to the key, the redeem script of the P2SH-P2WPKH output, and true if
+// the output is the P2PKH output of the uncompressed public key. The WIF key of the uncompressed public key only has the
+// P2PKH output, the segwit outputs only pay to the compressed public key.
+func keyScript(key *btcutil.WIF, pkScript []byte) ([]byte, bool, bool, error) {
+	if !key.CompressPubKey {
+		address, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(key.SerializePubKey()), &chaincfg.MainNetParams)
+		if err != nil {
+			return nil, false, false, err
+		}
+		script, err := txscript.PayToAddrScript(address)
+		if err != nil {
+			return nil, false, false, err
+		}
+		return nil, true, bytes.Equal(script, pkScript), nil
+	}
+
+	for _, addressType := range []string{message.AddressP2PKH, message.AddressP2WPKH, message.AddressP2SHP2WPKH} {
+		// The output script doesn't depend on the network
+		script, err := message.PkScript(key.PrivKey.PubKey(), addressType, &chaincfg.MainNetParams)
+		if err != nil {
+			return nil, false, false, err
+		}
+		if !bytes.Equal(script, pkScript) {
+			continue
+		}
+		if addressType == message.AddressP2SHP2WPKH {
+			return append([]byte{txscript.OP_0, txscript.OP_DATA_20}, btcutil.Hash160(key.SerializePubKey())...), false, true, nil
+		}
+		return nil, false, true, nil
+	}
+	return nil, false, false, nil
+}
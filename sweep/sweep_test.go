@@ -0,0 +1,100 @@
+package sweep
+
+import (
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"github.com/jayt106/bitcoinAddressGenerator/psbt"
+	"testing"
+)
+
+func testWIF(t *testing.T, i byte, compressed bool) *btcutil.WIF {
+	privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), append(make([]byte, 31), i))
+	wif, err := btcutil.NewWIF(privKey, &chaincfg.MainNetParams, compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wif
+}
+
+func TestSweep(t *testing.T) {
+	compressedKey := testWIF(t, 1, true)
+	uncompressedKey := testWIF(t, 2, false)
+
+	uncompressedAddress, err := btcutil.NewAddressPubKeyHash(
+		btcutil.Hash160(uncompressedKey.PrivKey.PubKey().SerializeUncompressed()), &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uncompressedScript, err := txscript.PayToAddrScript(uncompressedAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScripts := [][]byte{uncompressedScript}
+	for _, addressType := range []string{message.AddressP2PKH, message.AddressP2WPKH, message.AddressP2SHP2WPKH} {
+		pkScript, err := message.PkScript(compressedKey.PrivKey.PubKey(), addressType, &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkScripts = append(pkScripts, pkScript)
+	}
+
+	// The previous transaction of the P2PKH outputs
+	prevTx := wire.NewMsgTx(wire.TxVersion)
+	prevTx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{1}}})
+	prevTx.AddTxOut(wire.NewTxOut(20000, pkScripts[0]))
+	prevTx.AddTxOut(wire.NewTxOut(30000, pkScripts[1]))
+
+	utxos := []*psbt.UTXO{
+		{OutPoint: wire.OutPoint{Hash: prevTx.TxHash(), Index: 0}, Value: 20000, PkScript: pkScripts[0], PrevTx: prevTx},
+		{OutPoint: wire.OutPoint{Hash: prevTx.TxHash(), Index: 1}, Value: 30000, PkScript: pkScripts[1], PrevTx: prevTx},
+		{OutPoint: wire.OutPoint{Hash: chainhash.Hash{2}}, Value: 40000, PkScript: pkScripts[2]},
+		{OutPoint: wire.OutPoint{Hash: chainhash.Hash{3}}, Value: 50000, PkScript: pkScripts[3]},
+	}
+	destination, err := message.PkScript(testWIF(t, 3, true).PrivKey.PubKey(), message.AddressP2WPKH, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Sweep([]*btcutil.WIF{compressedKey, uncompressedKey}, utxos, destination, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := result.Tx
+	if len(tx.TxOut) != 1 || tx.TxOut[0].Value != 140000-result.Summary.Fee || len(result.Summary.Warnings) != 0 {
+		t.Fatal("Unexpected sweep transaction:", tx.TxOut, result.Summary)
+	}
+
+	// The fee rate of the actual size isn't under the requested fee rate
+	weight := int64(tx.SerializeSizeStripped()*(psbt.WitnessScaleFactor-1) + tx.SerializeSize())
+	if weight > result.Summary.Weight || float64(result.Summary.Fee)/float64(psbt.VSize(weight)) < 2 {
+		t.Error("The estimated weight is under the actual weight:", result.Summary.Weight, weight)
+	}
+
+	sigHashes := txscript.NewTxSigHashes(tx)
+	for i, utxo := range utxos {
+		vm, err := txscript.NewEngine(utxo.PkScript, tx, i, txscript.StandardVerifyFlags, nil, sigHashes, utxo.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = vm.Execute(); err != nil {
+			t.Errorf("Input %d isn't valid: %v", i, err)
+		}
+	}
+
+	// The WIF key of the uncompressed public key doesn't have the segwit outputs
+	if _, err = Sweep([]*btcutil.WIF{testWIF(t, 1, false)}, utxos[2:3], destination, 2); err == nil {
+		t.Error("The P2WPKH output of the uncompressed key should be rejected")
+	}
+
+	if _, err = Sweep([]*btcutil.WIF{compressedKey}, utxos[2:3], destination, 400); err == nil {
+		t.Error("The value under the fee and the dust limit should be rejected")
+	}
+	if _, err = Sweep(nil, utxos, destination, 2); err == nil {
+		t.Error("The sweep without the key should be rejected")
+	}
+}
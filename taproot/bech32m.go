@@ -0,0 +1,113 @@
+package taproot
+
+import (
+	"errors"
+	"github.com/btcsuite/btcutil/bech32"
+	"strings"
+)
+
+// bech32mConst the BIP350 checksum constant of the witness version 1+ addresses
+const bech32mConst = 0x2bc830a3
+
+const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var generator = []int{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+// encodeBech32m Encode the witness program as a BIP350 bech32m segwit address
+func encodeBech32m(hrp string, witnessVersion byte, program []byte) (string, error) {
+	converted, err := bech32.ConvertBits(program, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+
+	data := append([]byte{witnessVersion}, converted...)
+	values := append(hrpExpand(hrp), toInts(data)...)
+	polymod := polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ bech32mConst
+
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, b := range data {
+		sb.WriteByte(charset[b])
+	}
+	for i := 0; i < 6; i++ {
+		sb.WriteByte(charset[(polymod>>uint(5*(5-i)))&31])
+	}
+	return sb.String(), nil
+}
+
+// decodeBech32m Decode the BIP350 bech32m segwit address, return the witness version and the witness program
+func decodeBech32m(hrp string, address string) (byte, []byte, error) {
+	if len(address) > 90 {
+		return 0, nil, errors.New("invalid bech32m address length")
+	}
+	if strings.ToLower(address) != address && strings.ToUpper(address) != address {
+		return 0, nil, errors.New("mixed case bech32m address")
+	}
+	address = strings.ToLower(address)
+
+	sep := strings.LastIndexByte(address, '1')
+	if sep < 1 || sep+7 > len(address) {
+		return 0, nil, errors.New("invalid bech32m separator position")
+	}
+	if address[:sep] != hrp {
+		return 0, nil, errors.New("unexpected bech32m address prefix " + address[:sep])
+	}
+
+	data := make([]byte, 0, len(address)-sep-1)
+	for _, c := range address[sep+1:] {
+		i := strings.IndexRune(charset, c)
+		if i < 0 {
+			return 0, nil, errors.New("invalid bech32m character")
+		}
+		data = append(data, byte(i))
+	}
+
+	if polymod(append(hrpExpand(hrp), toInts(data)...)) != bech32mConst {
+		return 0, nil, errors.New("invalid bech32m checksum")
+	}
+
+	data = data[:len(data)-6]
+	if len(data) < 1 {
+		return 0, nil, errors.New("missing witness version")
+	}
+	program, err := bech32.ConvertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	return data[0], program, nil
+}
+
+func polymod(values []int) int {
+	chk := 1
+	for _, v := range values {
+		b := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ v
+		for i := 0; i < 5; i++ {
+			if (b>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func hrpExpand(hrp string) []int {
+	values := make([]int, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, int(hrp[i]>>5))
+	}
+	values = append(values, 0)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, int(hrp[i]&31))
+	}
+	return values
+}
+
+func toInts(data []byte) []int {
+	values := make([]int, len(data))
+	for i, b := range data {
+		values[i] = int(b)
+	}
+	return values
+}
@@ -0,0 +1,143 @@
+package taproot
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"github.com/btcsuite/btcd/btcec"
+	"math/big"
+)
+
+// The sizes of the BIP340 x-only public key and signature
+const (
+	XOnlyPubKeyLen = 32
+	SignatureLen   = 64
+)
+
+// TaggedHash Return the BIP340 tagged hash sha256(sha256(tag) || sha256(tag) || msgs...)
+func TaggedHash(tag string, msgs ...[]byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, msg := range msgs {
+		h.Write(msg)
+	}
+	return h.Sum(nil)
+}
+
+// XOnlyPubKey Return the 32 bytes x coordinate of the public key
+func XOnlyPubKey(pubKey *btcec.PublicKey) []byte {
+	return pubKey.SerializeCompressed()[1:]
+}
+
+// ParseXOnlyPubKey Return the public key of the x coordinate with the even y coordinate (lift_x of BIP340)
+func ParseXOnlyPubKey(xOnly []byte) (*btcec.PublicKey, error) {
+	if len(xOnly) != XOnlyPubKeyLen {
+		return nil, errors.New("invalid x-only public key length")
+	}
+	return btcec.ParsePubKey(append([]byte{0x02}, xOnly...), btcec.S256())
+}
+
+// SchnorrSign Sign the 32 bytes hash by the BIP340 schnorr signature with the fresh auxiliary randomness
+func SchnorrSign(privKey *btcec.PrivateKey, hash []byte) ([]byte, error) {
+	auxRand := make([]byte, 32)
+	_, err := rand.Read(auxRand)
+	if err != nil {
+		return nil, err
+	}
+	return schnorrSign(privKey, hash, auxRand)
+}
+
+// schnorrSign the BIP340 default signing algorithm with the given auxiliary randomness
+func schnorrSign(privKey *btcec.PrivateKey, hash []byte, auxRand []byte) ([]byte, error) {
+	if len(hash) != 32 {
+		return nil, errors.New("invalid schnorr message hash length")
+	}
+
+	curve := btcec.S256()
+	n := curve.Params().N
+	if privKey.D.Sign() == 0 || privKey.D.Cmp(n) >= 0 {
+		return nil, errors.New("invalid schnorr private key")
+	}
+
+	d := new(big.Int).Set(privKey.D)
+	px, py := curve.ScalarBaseMult(scalarBytes(d))
+	if py.Bit(0) == 1 {
+		d.Sub(n, d)
+	}
+	pubKey := scalarBytes(px)
+
+	t := scalarBytes(d)
+	aux := TaggedHash("BIP0340/aux", auxRand)
+	for i := range t {
+		t[i] ^= aux[i]
+	}
+
+	k := new(big.Int).SetBytes(TaggedHash("BIP0340/nonce", t, pubKey, hash))
+	k.Mod(k, n)
+	if k.Sign() == 0 {
+		return nil, errors.New("schnorr nonce is zero")
+	}
+
+	rx, ry := curve.ScalarBaseMult(scalarBytes(k))
+	if ry.Bit(0) == 1 {
+		k.Sub(n, k)
+	}
+	r := scalarBytes(rx)
+
+	e := new(big.Int).SetBytes(TaggedHash("BIP0340/challenge", r, pubKey, hash))
+	e.Mod(e, n)
+
+	s := new(big.Int).Mul(e, d)
+	s.Add(s, k)
+	s.Mod(s, n)
+
+	sig := append(r, scalarBytes(s)...)
+	if !SchnorrVerify(pubKey, hash, sig) {
+		return nil, errors.New("schnorr signature verification failed")
+	}
+	return sig, nil
+}
+
+// SchnorrVerify Verify the BIP340 schnorr signature of the 32 bytes hash by the x-only public key
+func SchnorrVerify(xOnlyPubKey []byte, hash []byte, sig []byte) bool {
+	if len(hash) != 32 || len(sig) != SignatureLen {
+		return false
+	}
+
+	pubKey, err := ParseXOnlyPubKey(xOnlyPubKey)
+	if err != nil {
+		return false
+	}
+
+	curve := btcec.S256()
+	n := curve.Params().N
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if r.Cmp(curve.Params().P) >= 0 || s.Cmp(n) >= 0 {
+		return false
+	}
+
+	e := new(big.Int).SetBytes(TaggedHash("BIP0340/challenge", sig[:32], xOnlyPubKey, hash))
+	e.Mod(e, n)
+	e.Sub(n, e)
+
+	// R = s*G - e*P
+	sx, sy := curve.ScalarBaseMult(scalarBytes(s))
+	ex, ey := curve.ScalarMult(pubKey.X, pubKey.Y, scalarBytes(e))
+	rx, ry := curve.Add(sx, sy, ex, ey)
+	if rx.Sign() == 0 && ry.Sign() == 0 {
+		return false
+	}
+
+	return ry.Bit(0) == 0 && rx.Cmp(r) == 0
+}
+
+// scalarBytes Return the 32 bytes big endian encoding of the scalar or the coordinate
+func scalarBytes(v *big.Int) []byte {
+	b := make([]byte, 32)
+	vb := v.Bytes()
+	copy(b[32-len(vb):], vb)
+	return b
+}
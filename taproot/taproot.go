@@ -0,0 +1,248 @@
+package taproot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"io"
+	"math/big"
+)
+
+// SigHashDefault the BIP341 default hash type, it commits to the same data as SigHashAll and is omitted from the signature
+const SigHashDefault txscript.SigHashType = 0x00
+
+// witnessVersion the segwit version of the taproot outputs
+const witnessVersion = 1
+
+// tweak Return the BIP341 tweak of the internal key without the script tree (BIP086)
+func tweak(internalKey *btcec.PublicKey) (*big.Int, error) {
+	t := new(big.Int).SetBytes(TaggedHash("TapTweak", XOnlyPubKey(internalKey)))
+	if t.Cmp(btcec.S256().Params().N) >= 0 {
+		return nil, errors.New("taproot tweak is out of range")
+	}
+	return t, nil
+}
+
+// OutputKey Return the taproot output key of the internal key committing to no script path, Q = lift_x(P) + t*G
+func OutputKey(internalKey *btcec.PublicKey) (*btcec.PublicKey, error) {
+	t, err := tweak(internalKey)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := ParseXOnlyPubKey(XOnlyPubKey(internalKey))
+	if err != nil {
+		return nil, err
+	}
+
+	curve := btcec.S256()
+	tx, ty := curve.ScalarBaseMult(scalarBytes(t))
+	qx, qy := curve.Add(p.X, p.Y, tx, ty)
+	if qx.Sign() == 0 && qy.Sign() == 0 {
+		return nil, errors.New("taproot output key is infinity")
+	}
+	return &btcec.PublicKey{Curve: curve, X: qx, Y: qy}, nil
+}
+
+// TweakPrivKey Return the private key of the taproot output key for the key path spending
+func TweakPrivKey(privKey *btcec.PrivateKey) (*btcec.PrivateKey, error) {
+	t, err := tweak(privKey.PubKey())
+	if err != nil {
+		return nil, err
+	}
+
+	n := btcec.S256().Params().N
+	d := new(big.Int).Set(privKey.D)
+	if privKey.PubKey().Y.Bit(0) == 1 {
+		d.Sub(n, d)
+	}
+	d.Add(d, t)
+	d.Mod(d, n)
+	if d.Sign() == 0 {
+		return nil, errors.New("taproot tweaked private key is zero")
+	}
+
+	tweaked, _ := btcec.PrivKeyFromBytes(btcec.S256(), scalarBytes(d))
+	return tweaked, nil
+}
+
+// PkScript Return the output script of the taproot output key, OP_1 <x-only output key>
+func PkScript(outputKey *btcec.PublicKey) []byte {
+	return append([]byte{txscript.OP_1, txscript.OP_DATA_32}, XOnlyPubKey(outputKey)...)
+}
+
+// IsPkScript Return true if the output script is a taproot output
+func IsPkScript(pkScript []byte) bool {
+	return len(pkScript) == 34 && pkScript[0] == txscript.OP_1 && pkScript[1] == txscript.OP_DATA_32
+}
+
+// Address Return the bech32m P2TR address of the taproot output key
+func Address(outputKey *btcec.PublicKey, net *chaincfg.Params) (string, error) {
+	return encodeBech32m(net.Bech32HRPSegwit, witnessVersion, XOnlyPubKey(outputKey))
+}
+
+// DecodeAddress Return the x-only output key of the P2TR address
+func DecodeAddress(address string, net *chaincfg.Params) ([]byte, error) {
+	version, program, err := decodeBech32m(net.Bech32HRPSegwit, address)
+	if err != nil {
+		return nil, err
+	}
+	if version != witnessVersion || len(program) != XOnlyPubKeyLen {
+		return nil, fmt.Errorf("unsupported witness version %d program length %d", version, len(program))
+	}
+	return program, nil
+}
+
+// SigHash Return the BIP341 signature hash of the key path spending of the input. The previous outputs of all the
+// inputs are committed to by the signature.
+func SigHash(tx *wire.MsgTx, idx int, prevOuts []*wire.TxOut, hashType txscript.SigHashType) ([]byte, error) {
+	if idx < 0 || idx >= len(tx.TxIn) || len(prevOuts) != len(tx.TxIn) {
+		return nil, errors.New("the previous outputs don't match the transaction inputs")
+	}
+
+	outputType := hashType & 0x03
+	anyoneCanPay := hashType&txscript.SigHashAnyOneCanPay != 0
+	if hashType != SigHashDefault && (outputType == 0 || hashType&^(txscript.SigHashAnyOneCanPay|0x03) != 0) {
+		return nil, fmt.Errorf("invalid taproot hash type %#x", hashType)
+	}
+
+	var msg bytes.Buffer
+	msg.WriteByte(0x00) // the sighash epoch
+	msg.WriteByte(byte(hashType))
+	writeUint32(&msg, uint32(tx.Version))
+	writeUint32(&msg, tx.LockTime)
+
+	if !anyoneCanPay {
+		prevouts, amounts, scriptPubKeys, sequences := sha256.New(), sha256.New(), sha256.New(), sha256.New()
+		for i, txIn := range tx.TxIn {
+			writeOutPoint(prevouts, &txIn.PreviousOutPoint)
+			writeUint64(amounts, uint64(prevOuts[i].Value))
+			err := wire.WriteVarBytes(scriptPubKeys, 0, prevOuts[i].PkScript)
+			if err != nil {
+				return nil, err
+			}
+			writeUint32(sequences, txIn.Sequence)
+		}
+		msg.Write(prevouts.Sum(nil))
+		msg.Write(amounts.Sum(nil))
+		msg.Write(scriptPubKeys.Sum(nil))
+		msg.Write(sequences.Sum(nil))
+	}
+
+	if outputType != txscript.SigHashNone && outputType != txscript.SigHashSingle {
+		outputs := sha256.New()
+		for _, txOut := range tx.TxOut {
+			err := wire.WriteTxOut(outputs, 0, 0, txOut)
+			if err != nil {
+				return nil, err
+			}
+		}
+		msg.Write(outputs.Sum(nil))
+	}
+
+	msg.WriteByte(0x00) // the key path spending without the annex
+
+	if anyoneCanPay {
+		txIn := tx.TxIn[idx]
+		writeOutPoint(&msg, &txIn.PreviousOutPoint)
+		writeUint64(&msg, uint64(prevOuts[idx].Value))
+		err := wire.WriteVarBytes(&msg, 0, prevOuts[idx].PkScript)
+		if err != nil {
+			return nil, err
+		}
+		writeUint32(&msg, txIn.Sequence)
+	} else {
+		writeUint32(&msg, uint32(idx))
+	}
+
+	if outputType == txscript.SigHashSingle {
+		if idx >= len(tx.TxOut) {
+			return nil, errors.New("no output of the SIGHASH_SINGLE input")
+		}
+		output := sha256.New()
+		err := wire.WriteTxOut(output, 0, 0, tx.TxOut[idx])
+		if err != nil {
+			return nil, err
+		}
+		msg.Write(output.Sum(nil))
+	}
+
+	return TaggedHash("TapSighash", msg.Bytes()), nil
+}
+
+// SignKeyPath Return the key path spending witness signature of the input, the hash type is appended unless it's the default
+func SignKeyPath(tx *wire.MsgTx, idx int, prevOuts []*wire.TxOut, hashType txscript.SigHashType, privKey *btcec.PrivateKey) ([]byte, error) {
+	tweaked, err := TweakPrivKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(prevOuts[idx].PkScript, PkScript(tweaked.PubKey())) {
+		return nil, errors.New("the private key doesn't match the taproot output")
+	}
+
+	hash, err := SigHash(tx, idx, prevOuts, hashType)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := SchnorrSign(tweaked, hash)
+	if err != nil {
+		return nil, err
+	}
+	if hashType != SigHashDefault {
+		sig = append(sig, byte(hashType))
+	}
+	return sig, nil
+}
+
+// VerifyKeyPath Verify the key path spending witness signature of the input against the taproot output
+func VerifyKeyPath(tx *wire.MsgTx, idx int, prevOuts []*wire.TxOut, sig []byte) error {
+	if idx < 0 || idx >= len(prevOuts) || !IsPkScript(prevOuts[idx].PkScript) {
+		return errors.New("the previous output isn't a taproot output")
+	}
+
+	hashType := SigHashDefault
+	if len(sig) == SignatureLen+1 {
+		hashType = txscript.SigHashType(sig[SignatureLen])
+		if hashType == SigHashDefault {
+			return errors.New("the default hash type must be omitted")
+		}
+		sig = sig[:SignatureLen]
+	} else if len(sig) != SignatureLen {
+		return errors.New("invalid taproot signature length")
+	}
+
+	hash, err := SigHash(tx, idx, prevOuts, hashType)
+	if err != nil {
+		return err
+	}
+
+	if !SchnorrVerify(prevOuts[idx].PkScript[2:], hash, sig) {
+		return errors.New("invalid taproot signature")
+	}
+	return nil
+}
+
+func writeOutPoint(w io.Writer, op *wire.OutPoint) {
+	w.Write(op.Hash[:])
+	writeUint32(w, op.Index)
+}
+
+func writeUint32(w io.Writer, v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	w.Write(b[:])
+}
+
+func writeUint64(w io.Writer, v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	w.Write(b[:])
+}
@@ -0,0 +1,101 @@
+package taproot
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"strings"
+	"testing"
+)
+
+func TestSchnorrSign(t *testing.T) {
+	// BIP340 test vectors 0 and 1
+	vectors := []struct {
+		privKey, pubKey, auxRand, msg, sig string
+	}{
+		{
+			"0000000000000000000000000000000000000000000000000000000000000003",
+			"F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA821525F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0",
+		},
+		{
+			"B7E151628AED2A6ABF7158809CF4F3C762E7160F38B4DA56A784D9045190CFEF",
+			"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+			"0000000000000000000000000000000000000000000000000000000000000001",
+			"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+			"6896BD60EEAE296DB48A229FF71DFE071BDE413E6D43F917DC8DCF8C78DE33418906D11AC976ABCCB20B091292BFF4EA897EFCB639EA871CFA95F6DE339E4B0A",
+		},
+	}
+
+	for i, v := range vectors {
+		privKeyBytes, _ := hex.DecodeString(v.privKey)
+		pubKey, _ := hex.DecodeString(v.pubKey)
+		auxRand, _ := hex.DecodeString(v.auxRand)
+		msg, _ := hex.DecodeString(v.msg)
+		expected, _ := hex.DecodeString(v.sig)
+
+		privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), privKeyBytes)
+		if !bytes.Equal(XOnlyPubKey(privKey.PubKey()), pubKey) {
+			t.Error("Unmatched x-only public key of vector", i)
+		}
+
+		sig, err := schnorrSign(privKey, msg, auxRand)
+		if err != nil {
+			t.Fatal("Sign error of vector", i, err)
+		}
+		if !bytes.Equal(sig, expected) {
+			t.Error("Unmatched signature of vector", i, strings.ToUpper(hex.EncodeToString(sig)))
+		}
+
+		if !SchnorrVerify(pubKey, msg, expected) {
+			t.Error("The signature of vector", i, "should be valid")
+		}
+		msg[0] ^= 1
+		if SchnorrVerify(pubKey, msg, expected) {
+			t.Error("The signature of the modified message of vector", i, "should be invalid")
+		}
+	}
+}
+
+func TestAddress(t *testing.T) {
+	// BIP086 test vector, the first receiving address of m/86'/0'/0'
+	internalKeyBytes, _ := hex.DecodeString("02cc8a4bc64d897bddc5fbc2f670f7a8ba0b386779106cf1223c6fc5d7cd6fc115")
+	internalKey, err := btcec.ParsePubKey(internalKeyBytes, btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputKey, err := OutputKey(internalKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(XOnlyPubKey(outputKey)) != "a60869f0dbcf1dc659c9cecbaf8050135ea9e8cdc487053f1dc6880949dc684c" {
+		t.Error("Unmatched output key", hex.EncodeToString(XOnlyPubKey(outputKey)))
+	}
+
+	address, err := Address(outputKey, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != "bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr" {
+		t.Error("Unmatched address", address)
+	}
+
+	program, err := DecodeAddress(strings.ToUpper(address), &chaincfg.MainNetParams)
+	if err != nil || !bytes.Equal(program, XOnlyPubKey(outputKey)) {
+		t.Error("Decode address error:", err)
+	}
+
+	// The bech32 checksum of the segwit v0 address isn't a valid bech32m checksum
+	_, err = DecodeAddress("bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", &chaincfg.MainNetParams)
+	if err == nil {
+		t.Error("The segwit v0 address should be rejected")
+	}
+	_, err = DecodeAddress(address[:len(address)-1]+"q", &chaincfg.MainNetParams)
+	if err == nil {
+		t.Error("The invalid checksum should be rejected")
+	}
+}
@@ -0,0 +1,183 @@
+package txbuilder
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// The coin selection algorithms
+const (
+	AlgorithmBnB      = "bnb"
+	AlgorithmKnapsack = "knapsack"
+)
+
+// bnbMaxTries the max number of the branch and bound search steps (Bitcoin Core TOTAL_TRIES)
+const bnbMaxTries = 100000
+
+// knapsackIterations the number of the random subsets tried by the knapsack (Bitcoin Core ApproximateBestSubset)
+const knapsackIterations = 1000
+
+// MinChange the change the knapsack tries to leave when there is no exact match (Bitcoin Core MIN_CHANGE)
+const MinChange = 1000000
+
+// coin a utxo of the selection, the effective value is the value minus the fee of spending it
+type coin struct {
+	index     int
+	effective int64
+}
+
+// selectBnB Search the subset of the coins of which the effective value is in [target, target+costOfChange], so the
+// transaction needs no change output and the excess is left to the fee. The subset with the least excess is returned,
+// nil if there is none. The coins are sorted in place by the effective value, largest first.
+func selectBnB(coins []coin, target int64, costOfChange int64) []coin {
+	sort.SliceStable(coins, func(i, j int) bool {
+		return coins[i].effective > coins[j].effective
+	})
+
+	var available int64
+	for _, c := range coins {
+		available += c.effective
+	}
+	if available < target {
+		return nil
+	}
+
+	var value int64
+	var selection, best []int
+	bestExcess := int64(-1)
+	for try, idx := 0, 0; try < bnbMaxTries; try, idx = try+1, idx+1 {
+		backtrack := false
+		if value+available < target || value > target+costOfChange {
+			backtrack = true
+		} else if value >= target {
+			if bestExcess < 0 || value-target < bestExcess {
+				bestExcess = value - target
+				best = append(best[:0], selection...)
+				if bestExcess == 0 {
+					break
+				}
+			}
+			backtrack = true
+		}
+
+		if backtrack {
+			if len(selection) == 0 {
+				break
+			}
+			// Put the omitted coins back and try the omission branch of the last included coin
+			for idx--; idx > selection[len(selection)-1]; idx-- {
+				available += coins[idx].effective
+			}
+			value -= coins[idx].effective
+			selection = selection[:len(selection)-1]
+			continue
+		}
+
+		available -= coins[idx].effective
+		// The coin of the same value as the previous omitted coin leads to the same subsets
+		if len(selection) != 0 && selection[len(selection)-1] != idx-1 && coins[idx].effective == coins[idx-1].effective {
+			continue
+		}
+		selection = append(selection, idx)
+		value += coins[idx].effective
+	}
+
+	if best == nil {
+		return nil
+	}
+	selected := make([]coin, len(best))
+	for i, idx := range best {
+		selected[i] = coins[idx]
+	}
+	return selected
+}
+
+// selectKnapsack Select the coins reaching the target by the Bitcoin Core knapsack solver: the exact match, all the
+// smaller coins, the random subsets close to the target plus the min change, or the smallest coin larger than the target.
+// Return nil if the coins can't reach the target.
+func selectKnapsack(coins []coin, target int64, minChange int64, rng *rand.Rand) []coin {
+	var lower []coin
+	var lowestLarger *coin
+	var totalLower int64
+	for i := range coins {
+		c := coins[i]
+		switch {
+		case c.effective == target:
+			return []coin{c}
+		case c.effective < target+minChange:
+			lower = append(lower, c)
+			totalLower += c.effective
+		case lowestLarger == nil || c.effective < lowestLarger.effective:
+			lowestLarger = &coins[i]
+		}
+	}
+
+	if totalLower == target {
+		return lower
+	}
+	if totalLower < target {
+		if lowestLarger == nil {
+			return nil
+		}
+		return []coin{*lowestLarger}
+	}
+
+	sort.SliceStable(lower, func(i, j int) bool {
+		return lower[i].effective > lower[j].effective
+	})
+	best, bestValue := approximateBestSubset(lower, totalLower, target, rng)
+	if bestValue != target && totalLower >= target+minChange {
+		best, bestValue = approximateBestSubset(lower, totalLower, target+minChange, rng)
+	}
+
+	// The larger coin is better if the subset isn't exact and leaves less than the min change, or it's even smaller
+	if lowestLarger != nil &&
+		((bestValue != target && bestValue < target+minChange) || lowestLarger.effective <= bestValue) {
+		return []coin{*lowestLarger}
+	}
+
+	var selected []coin
+	for i, included := range best {
+		if included {
+			selected = append(selected, lower[i])
+		}
+	}
+	return selected
+}
+
+// approximateBestSubset Search the random subsets of the coins for the smallest value not less than the target
+func approximateBestSubset(coins []coin, total int64, target int64, rng *rand.Rand) ([]bool, int64) {
+	best := make([]bool, len(coins))
+	for i := range best {
+		best[i] = true
+	}
+	bestValue := total
+
+	included := make([]bool, len(coins))
+	for rep := 0; rep < knapsackIterations && bestValue != target; rep++ {
+		for i := range included {
+			included[i] = false
+		}
+		var value int64
+		reached := false
+		// The first pass includes the coins randomly, the second pass includes the coins left out by the first pass
+		for pass := 0; pass < 2 && !reached; pass++ {
+			for i := range coins {
+				if pass == 0 && rng.Intn(2) == 0 || pass == 1 && !included[i] {
+					value += coins[i].effective
+					included[i] = true
+					if value >= target {
+						reached = true
+						if value < bestValue {
+							bestValue = value
+							copy(best, included)
+						}
+						value -= coins[i].effective
+						included[i] = false
+					}
+				}
+			}
+		}
+	}
+	return best, bestValue
+}
@@ -0,0 +1,180 @@
+package txbuilder
+
+import (
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/jayt106/bitcoinAddressGenerator/psbt"
+	"math"
+	"math/rand"
+)
+
+// changeScriptLen the size of the P2WPKH change output script, used to estimate the fee before the change is derived
+const changeScriptLen = 22
+
+// ChangeFunc Return the change output, it's only called when the selected coins leave the change
+type ChangeFunc func() (*psbt.TxOutput, error)
+
+// Breakdown the estimated weight of the parts of the transaction, the target fee of the fee rate and the excess left to
+// the fee by the selection without change
+type Breakdown struct {
+	OverheadWeight int64
+	InputWeights   []int64
+	OutputWeights  []int64
+	TargetFee      int64
+	Excess         int64
+}
+
+// Result the unsigned psbt, the indexes of the spent utxos and the selection algorithm
+type Result struct {
+	Packet    *psbt.Packet
+	Summary   *psbt.Summary
+	Algorithm string
+	Selected  []int
+	Breakdown Breakdown
+}
+
+// Build Build the unsigned psbt paying the outputs at the fee rate (sat/vB) from a subset of the utxos, without any
+// network access. The coins are selected by branch and bound, the subset needing no change, and by the knapsack when
+// there is no such subset. The change output is requested from the change function only if the selected coins leave
+// more than the dust limit after the fee of the change output, its script is expected to be P2WPKH.
+func Build(utxos []*psbt.UTXO, outputs []*psbt.TxOutput, feeRate float64, change ChangeFunc) (*Result, error) {
+	if len(outputs) == 0 {
+		return nil, errors.New("no output to pay")
+	}
+	if feeRate <= 0 {
+		return nil, errors.New("the fee rate must be positive")
+	}
+
+	txOuts := make([]*wire.TxOut, len(outputs))
+	var outputValue int64
+	for i, output := range outputs {
+		txOuts[i] = output.TxOut
+		outputValue += output.TxOut.Value
+	}
+
+	// The effective value of a coin is its value minus the fee of its input, the coins not paying for themselves are
+	// never selected
+	inputs := make([]psbt.InputWeight, len(utxos))
+	var coins []coin
+	for i, utxo := range utxos {
+		weight, err := utxoWeight(utxo)
+		if err != nil {
+			return nil, fmt.Errorf("utxo %d: %v", i, err)
+		}
+		inputs[i] = weight
+
+		// The non-witness input has the empty witness count in the segwit transaction
+		feeWeight := weight.Weight
+		if !psbt.IsWitness(weight.Type) {
+			feeWeight++
+		}
+		effective := utxo.Value - weightFee(feeWeight, feeRate)
+		if effective > 0 {
+			coins = append(coins, coin{i, effective})
+		}
+	}
+
+	// The target covers the outputs and the fee of the transaction without the inputs, with the segwit marker and the
+	// largest input count
+	overhead := psbt.EstimateTxWeight(nil, txOuts) + 2 + (int64(wire.VarIntSerializeSize(uint64(len(utxos))))-1)*psbt.WitnessScaleFactor
+	target := outputValue + weightFee(overhead, feeRate)
+
+	changeTxOut := wire.NewTxOut(0, make([]byte, changeScriptLen))
+	changeOutputFee := weightFee(psbt.OutputWeight(changeTxOut), feeRate)
+	changeInputWeight, err := psbt.EstimateInputWeight(psbt.InputP2WPKH, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	costOfChange := changeOutputFee + weightFee(changeInputWeight, feeRate)
+
+	algorithm := AlgorithmBnB
+	selected := selectBnB(coins, target, costOfChange)
+	if selected == nil {
+		// The randomness is seeded so the same request builds the same transaction
+		algorithm = AlgorithmKnapsack
+		selected = selectKnapsack(coins, target+changeOutputFee, MinChange, rand.New(rand.NewSource(1)))
+	}
+	if selected == nil {
+		var total int64
+		for _, c := range coins {
+			total += c.effective
+		}
+		return nil, fmt.Errorf("insufficient funds, the effective value %d of the utxos can't pay the outputs %d and the fee %d",
+			total, outputValue, target-outputValue+changeOutputFee)
+	}
+
+	result := &Result{Algorithm: algorithm}
+	spent := make([]*psbt.UTXO, len(selected))
+	spentInputs := make([]psbt.InputWeight, len(selected))
+	var inputValue int64
+	for i, c := range selected {
+		result.Selected = append(result.Selected, c.index)
+		spent[i] = utxos[c.index]
+		spentInputs[i] = inputs[c.index]
+		inputValue += utxos[c.index].Value
+	}
+
+	// The branch and bound selection leaves the excess to the fee, the knapsack keeps the change above the dust limit
+	var changeOutput *psbt.TxOutput
+	if algorithm == AlgorithmKnapsack {
+		left := inputValue - outputValue
+		changeFee := txFee(spentInputs, append(txOuts, changeTxOut), feeRate)
+		if left-changeFee >= psbt.DustLimit {
+			changeOutput, err = change()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	result.Packet, result.Summary, err = psbt.Create(spent, outputs, changeOutput, feeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	finalTxOuts := result.Packet.UnsignedTx.TxOut
+	result.Breakdown.OverheadWeight = psbt.EstimateTxWeight(spentInputs, finalTxOuts)
+	for _, in := range spentInputs {
+		result.Breakdown.InputWeights = append(result.Breakdown.InputWeights, in.Weight)
+		result.Breakdown.OverheadWeight -= in.Weight
+	}
+	for _, txOut := range finalTxOuts {
+		result.Breakdown.OutputWeights = append(result.Breakdown.OutputWeights, psbt.OutputWeight(txOut))
+		result.Breakdown.OverheadWeight -= psbt.OutputWeight(txOut)
+	}
+	result.Breakdown.TargetFee = txFee(spentInputs, finalTxOuts, feeRate)
+	result.Breakdown.Excess = result.Summary.Fee - result.Breakdown.TargetFee
+	return result, nil
+}
+
+// utxoWeight Return the type and the estimated weight of the input spending the utxo
+func utxoWeight(utxo *psbt.UTXO) (psbt.InputWeight, error) {
+	if utxo.Value <= 0 {
+		return psbt.InputWeight{}, errors.New("the value must be positive")
+	}
+	pkScript, err := utxo.OutputScript()
+	if err != nil {
+		return psbt.InputWeight{}, err
+	}
+
+	inputType, err := psbt.InputType(pkScript, utxo.RedeemScript, utxo.WitnessScript)
+	if err != nil {
+		return psbt.InputWeight{}, err
+	}
+	weight, err := psbt.EstimateInputWeight(inputType, utxo.RedeemScript, utxo.WitnessScript)
+	if err != nil {
+		return psbt.InputWeight{}, err
+	}
+	return psbt.InputWeight{Type: inputType, Weight: weight}, nil
+}
+
+// weightFee Return the fee of the weight at the fee rate, rounded up
+func weightFee(weight int64, feeRate float64) int64 {
+	return int64(math.Ceil(float64(psbt.VSize(weight)) * feeRate))
+}
+
+// txFee Return the fee of the transaction as psbt.Create estimates it
+func txFee(inputs []psbt.InputWeight, outputs []*wire.TxOut, feeRate float64) int64 {
+	return weightFee(psbt.EstimateTxWeight(inputs, outputs), feeRate)
+}
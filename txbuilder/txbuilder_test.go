@@ -0,0 +1,140 @@
+package txbuilder
+
+import (
+	"bytes"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/jayt106/bitcoinAddressGenerator/psbt"
+	"math/rand"
+	"testing"
+)
+
+// testP2WPKHScript Return the P2WPKH output script of a dummy key hash
+func testP2WPKHScript(b byte) []byte {
+	return append([]byte{0x00, 0x14}, bytes.Repeat([]byte{b}, 20)...)
+}
+
+func testUTXOs(values ...int64) []*psbt.UTXO {
+	utxos := make([]*psbt.UTXO, len(values))
+	for i, value := range values {
+		utxos[i] = &psbt.UTXO{
+			OutPoint: wire.OutPoint{Hash: chainhash.Hash{byte(i + 1)}, Index: uint32(i)},
+			Value:    value,
+			PkScript: testP2WPKHScript(byte(i + 1)),
+		}
+	}
+	return utxos
+}
+
+func testOutputs(values ...int64) []*psbt.TxOutput {
+	outputs := make([]*psbt.TxOutput, len(values))
+	for i, value := range values {
+		outputs[i] = &psbt.TxOutput{TxOut: wire.NewTxOut(value, testP2WPKHScript(0xa0+byte(i)))}
+	}
+	return outputs
+}
+
+func TestSelectBnB(t *testing.T) {
+	coins := []coin{{0, 1000}, {1, 2000}, {2, 3000}, {3, 4000}, {4, 5000}}
+	selected := selectBnB(coins, 6000, 0)
+	var sum int64
+	for _, c := range selected {
+		sum += c.effective
+	}
+	if sum != 6000 {
+		t.Error("Unexpected exact match:", selected)
+	}
+
+	// The excess within the cost of change is accepted, the smallest excess wins
+	selected = selectBnB(coins, 10500, 600)
+	sum = 0
+	for _, c := range selected {
+		sum += c.effective
+	}
+	if sum != 11000 {
+		t.Error("Unexpected match with the excess:", selected)
+	}
+
+	if selectBnB(coins, 20000, 100) != nil || selectBnB([]coin{{0, 5000}}, 3000, 100) != nil {
+		t.Error("The unreachable target should have no match")
+	}
+}
+
+func TestSelectKnapsack(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	coins := []coin{{0, 1000}, {1, 7000}, {2, 2000000}}
+	if selected := selectKnapsack(coins, 7000, MinChange, rng); len(selected) != 1 || selected[0].index != 1 {
+		t.Error("Unexpected exact coin:", selected)
+	}
+	if selected := selectKnapsack(coins, 8000, MinChange, rng); len(selected) != 2 {
+		t.Error("Unexpected smaller coins:", selected)
+	}
+
+	// The smaller coins can't reach the target, the smallest larger coin is used
+	if selected := selectKnapsack(coins, 9000, MinChange, rng); len(selected) != 1 || selected[0].index != 2 {
+		t.Error("Unexpected larger coin:", selected)
+	}
+	if selectKnapsack(coins[:2], 9000, MinChange, rng) != nil {
+		t.Error("The unreachable target should have no selection")
+	}
+}
+
+func TestBuild(t *testing.T) {
+	noChange := func() (*psbt.TxOutput, error) {
+		t.Fatal("The change output shouldn't be requested")
+		return nil, nil
+	}
+
+	// At 1 sat/vB the P2WPKH input costs 69 satoshi and the target of the output is 100042. The first two utxos match
+	// the target plus less than the cost of change, the dust utxo doesn't pay for itself
+	utxos := testUTXOs(40000, 60249, 200000, 50)
+	result, err := Build(utxos, testOutputs(100000), 1, noChange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Algorithm != AlgorithmBnB || len(result.Selected) != 2 || result.Summary.ChangeIndex != -1 {
+		t.Fatal("Unexpected selection:", result.Algorithm, result.Selected, result.Summary.ChangeIndex)
+	}
+	if result.Selected[0]+result.Selected[1] != 1 {
+		t.Error("Unexpected selected utxos:", result.Selected)
+	}
+	if result.Breakdown.Excess < 0 || result.Summary.Fee != result.Breakdown.TargetFee+result.Breakdown.Excess {
+		t.Error("Unexpected fee breakdown:", result.Summary.Fee, result.Breakdown)
+	}
+
+	weight := result.Breakdown.OverheadWeight
+	for _, w := range append(result.Breakdown.InputWeights, result.Breakdown.OutputWeights...) {
+		weight += w
+	}
+	if weight != result.Summary.Weight {
+		t.Error("The breakdown doesn't add up to the weight:", weight, result.Summary.Weight)
+	}
+
+	// No subset matches, the knapsack spends the larger utxo with the change
+	changeCalls := 0
+	change := func() (*psbt.TxOutput, error) {
+		changeCalls++
+		return &psbt.TxOutput{TxOut: wire.NewTxOut(0, testP2WPKHScript(0xcc))}, nil
+	}
+	result, err = Build(testUTXOs(200000, 5000), testOutputs(100000), 2, change)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Algorithm != AlgorithmKnapsack || len(result.Selected) != 1 || result.Selected[0] != 0 || changeCalls != 1 {
+		t.Fatal("Unexpected selection:", result.Algorithm, result.Selected, changeCalls)
+	}
+	changeOut := result.Packet.UnsignedTx.TxOut[result.Summary.ChangeIndex]
+	if !bytes.Equal(changeOut.PkScript, testP2WPKHScript(0xcc)) || changeOut.Value != 200000-100000-result.Summary.Fee {
+		t.Error("Unexpected change output:", changeOut.Value, result.Summary.Fee)
+	}
+	if result.Breakdown.Excess != 0 || result.Summary.Fee != result.Breakdown.TargetFee {
+		t.Error("The change should take the excess:", result.Breakdown)
+	}
+
+	if _, err = Build(testUTXOs(200000, 5000), testOutputs(300000), 1, change); err == nil {
+		t.Error("The insufficient funds should be rejected")
+	}
+	if _, err = Build(testUTXOs(200000), nil, 1, change); err == nil {
+		t.Error("The transaction without output should be rejected")
+	}
+}
@@ -0,0 +1,319 @@
+package txdecode
+
+import (
+	"encoding/hex"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/jayt106/bitcoinAddressGenerator/psbt"
+	"github.com/jayt106/bitcoinAddressGenerator/taproot"
+)
+
+// The output types besides the input types of the psbt package
+const (
+	OutputMultiSig    = "multisig"
+	OutputPubKey      = "pubkey"
+	OutputNullData    = "nulldata"
+	OutputNonStandard = "nonstandard"
+)
+
+// emptyInputWeight the weight of the input without the signature script
+const emptyInputWeight = (32 + 4 + 4 + 1) * psbt.WitnessScaleFactor
+
+// Input a decoded transaction input. The value, the address and the type are known with the spent output, the match
+// is the label of the xpub key or the redeem script of this server the input spends.
+type Input struct {
+	Index       int      `json:"index"`
+	TxID        string   `json:"txid"`
+	Vout        uint32   `json:"vout"`
+	Sequence    uint32   `json:"sequence"`
+	ScriptSig   string   `json:"scriptSig,omitempty"`
+	Witness     []string `json:"witness,omitempty"`
+	Value       int64    `json:"value,omitempty"`
+	Address     string   `json:"address,omitempty"`
+	Type        string   `json:"type,omitempty"`
+	Match       string   `json:"match,omitempty"`
+	Finalized   bool     `json:"finalized,omitempty"`
+	PartialSigs int      `json:"partialSigs,omitempty"`
+}
+
+// Output a decoded transaction output, the address is empty for the output script without the address
+type Output struct {
+	Index        int    `json:"index"`
+	Value        int64  `json:"value"`
+	ScriptPubKey string `json:"scriptPubKey"`
+	Type         string `json:"type"`
+	Address      string `json:"address,omitempty"`
+	Match        string `json:"match,omitempty"`
+}
+
+// Transaction a decoded transaction. The fee is known when the values of all the inputs are known. The weight of the
+// incomplete psbt is estimated by the worst case signature sizes, it's 0 if an input type is unknown.
+type Transaction struct {
+	TxID            string   `json:"txid"`
+	Hash            string   `json:"hash"`
+	Version         int32    `json:"version"`
+	LockTime        uint32   `json:"locktime"`
+	Size            int      `json:"size"`
+	Weight          int64    `json:"weight,omitempty"`
+	VSize           int64    `json:"vsize,omitempty"`
+	EstimatedWeight bool     `json:"estimatedWeight,omitempty"`
+	Inputs          []Input  `json:"inputs"`
+	Outputs         []Output `json:"outputs"`
+	InputValue      int64    `json:"inputValue,omitempty"`
+	OutputValue     int64    `json:"outputValue"`
+	Fee             int64    `json:"fee,omitempty"`
+	FeeRate         float64  `json:"feeRate,omitempty"`
+}
+
+// PSBT a decoded psbt, the transaction is complete when all the inputs are finalized
+type PSBT struct {
+	Transaction
+	Complete bool `json:"complete"`
+}
+
+// DecodeTx Decode the raw transaction for the network. The previous transactions are optional, they give the values
+// and the addresses of the spent outputs. The matcher is optional.
+func DecodeTx(tx *wire.MsgTx, prevTxs []*wire.MsgTx, net *chaincfg.Params, matcher *Matcher) *Transaction {
+	prevTxMap := make(map[chainhash.Hash]*wire.MsgTx, len(prevTxs))
+	for _, prevTx := range prevTxs {
+		prevTxMap[prevTx.TxHash()] = prevTx
+	}
+
+	result := decodeTx(tx, net, matcher)
+	result.Weight = int64(tx.SerializeSizeStripped()*(psbt.WitnessScaleFactor-1) + tx.SerializeSize())
+	result.VSize = psbt.VSize(result.Weight)
+
+	for i, txIn := range tx.TxIn {
+		in := &result.Inputs[i]
+		items := append([][]byte(nil), txIn.Witness...)
+		if pushes, err := txscript.PushedData(txIn.SignatureScript); err == nil {
+			items = append(items, pushes...)
+		}
+
+		var pkScript []byte
+		if prevTx, ok := prevTxMap[txIn.PreviousOutPoint.Hash]; ok && int(txIn.PreviousOutPoint.Index) < len(prevTx.TxOut) {
+			prevOut := prevTx.TxOut[txIn.PreviousOutPoint.Index]
+			pkScript = prevOut.PkScript
+			in.Value = prevOut.Value
+			in.Address = scriptAddress(pkScript, net)
+			in.Type = spendType(pkScript, txIn.SignatureScript, txIn.Witness)
+		}
+		in.Match = matcher.matchInput(pkScript, items)
+	}
+	result.setFee()
+	return result
+}
+
+// DecodePSBT Decode the psbt for the network, the values and the types of the inputs are known with the utxos.
+// The matcher is optional.
+func DecodePSBT(p *psbt.Packet, net *chaincfg.Params, matcher *Matcher) *PSBT {
+	result := &PSBT{Transaction: *decodeTx(p.UnsignedTx, net, matcher), Complete: true}
+
+	// The weight of the signed transaction, the actual size of the finalized inputs and the estimate of the others
+	tx := p.UnsignedTx.Copy()
+	var estimates []psbt.InputWeight
+	known := true
+	for i := range p.Inputs {
+		pin := &p.Inputs[i]
+		in := &result.Inputs[i]
+		in.Finalized = pin.FinalScriptSig != nil || pin.FinalScriptWitness != nil
+		in.PartialSigs = len(pin.PartialSigs)
+		result.Complete = result.Complete && in.Finalized
+
+		items := [][]byte{pin.RedeemScript, pin.WitnessScript}
+		for _, sig := range pin.PartialSigs {
+			items = append(items, sig.PubKey)
+		}
+		for _, derivation := range pin.Bip32Derivation {
+			items = append(items, derivation.PubKey)
+		}
+		if pushes, err := txscript.PushedData(pin.FinalScriptSig); err == nil {
+			items = append(items, pushes...)
+		}
+		tx.TxIn[i].SignatureScript = pin.FinalScriptSig
+
+		witness, err := pin.FinalWitness()
+		if err != nil {
+			known = false
+		}
+		tx.TxIn[i].Witness = witness
+		items = append(items, witness...)
+
+		prevOut, err := p.PrevOut(i)
+		if err != nil {
+			in.Match = matcher.matchInput(nil, items)
+			known = known && in.Finalized
+			continue
+		}
+		in.Value = prevOut.Value
+		in.Address = scriptAddress(prevOut.PkScript, net)
+		in.Match = matcher.matchInput(prevOut.PkScript, items)
+		if in.Finalized {
+			in.Type = spendType(prevOut.PkScript, pin.FinalScriptSig, witness)
+			continue
+		}
+
+		inputType, err := psbt.InputType(prevOut.PkScript, pin.RedeemScript, pin.WitnessScript)
+		if err != nil {
+			known = false
+			continue
+		}
+		in.Type = inputType
+		weight, err := psbt.EstimateInputWeight(inputType, pin.RedeemScript, pin.WitnessScript)
+		if err != nil {
+			known = false
+			continue
+		}
+		estimates = append(estimates, psbt.InputWeight{Type: inputType, Weight: weight})
+	}
+
+	if known {
+		result.Weight = int64(tx.SerializeSizeStripped()*(psbt.WitnessScaleFactor-1) + tx.SerializeSize())
+		result.EstimatedWeight = len(estimates) != 0
+
+		// The estimated inputs replace their empty inputs of the transaction, the estimate of the witness input has its
+		// own witness count
+		hasWitness := tx.HasWitness()
+		witnessInputs := 0
+		for _, estimate := range estimates {
+			result.Weight += estimate.Weight - emptyInputWeight
+			if psbt.IsWitness(estimate.Type) {
+				witnessInputs++
+				if hasWitness {
+					result.Weight--
+				}
+			}
+		}
+		if !hasWitness && witnessInputs != 0 {
+			// The marker and flag, and the empty witness count of each non-witness input
+			result.Weight += 2 + int64(len(tx.TxIn)-witnessInputs)
+		}
+		result.VSize = psbt.VSize(result.Weight)
+	}
+	result.setFee()
+	return result
+}
+
+// decodeTx Decode the inputs and the outputs of the transaction
+func decodeTx(tx *wire.MsgTx, net *chaincfg.Params, matcher *Matcher) *Transaction {
+	result := &Transaction{
+		TxID:     tx.TxHash().String(),
+		Hash:     tx.WitnessHash().String(),
+		Version:  tx.Version,
+		LockTime: tx.LockTime,
+		Size:     tx.SerializeSize(),
+		Inputs:   make([]Input, len(tx.TxIn)),
+		Outputs:  make([]Output, len(tx.TxOut)),
+	}
+
+	for i, txIn := range tx.TxIn {
+		in := &result.Inputs[i]
+		in.Index = i
+		in.TxID = txIn.PreviousOutPoint.Hash.String()
+		in.Vout = txIn.PreviousOutPoint.Index
+		in.Sequence = txIn.Sequence
+		in.ScriptSig = hex.EncodeToString(txIn.SignatureScript)
+		for _, item := range txIn.Witness {
+			in.Witness = append(in.Witness, hex.EncodeToString(item))
+		}
+	}
+
+	for i, txOut := range tx.TxOut {
+		result.Outputs[i] = Output{
+			Index:        i,
+			Value:        txOut.Value,
+			ScriptPubKey: hex.EncodeToString(txOut.PkScript),
+			Type:         outputType(txOut.PkScript),
+			Address:      scriptAddress(txOut.PkScript, net),
+			Match:        matcher.MatchScript(txOut.PkScript),
+		}
+		result.OutputValue += txOut.Value
+	}
+	return result
+}
+
+// setFee Set the input value and the fee if the values of all the inputs are known
+func (t *Transaction) setFee() {
+	for _, in := range t.Inputs {
+		if in.Value == 0 {
+			return
+		}
+		t.InputValue += in.Value
+	}
+	if len(t.Inputs) == 0 {
+		return
+	}
+	t.Fee = t.InputValue - t.OutputValue
+	if t.VSize != 0 {
+		t.FeeRate = float64(t.Fee) / float64(t.VSize)
+	}
+}
+
+// outputType Return the type of the output script
+func outputType(pkScript []byte) string {
+	if taproot.IsPkScript(pkScript) {
+		return psbt.InputP2TR
+	}
+	switch txscript.GetScriptClass(pkScript) {
+	case txscript.PubKeyHashTy:
+		return psbt.InputP2PKH
+	case txscript.ScriptHashTy:
+		return psbt.InputP2SH
+	case txscript.WitnessV0PubKeyHashTy:
+		return psbt.InputP2WPKH
+	case txscript.WitnessV0ScriptHashTy:
+		return psbt.InputP2WSH
+	case txscript.MultiSigTy:
+		return OutputMultiSig
+	case txscript.PubKeyTy:
+		return OutputPubKey
+	case txscript.NullDataTy:
+		return OutputNullData
+	}
+	return OutputNonStandard
+}
+
+// spendType Return the type of the input spending the output script by the signature script and the witness, the
+// nested segwit type is told by the redeem script of the signature script
+func spendType(pkScript []byte, scriptSig []byte, witness wire.TxWitness) string {
+	inputType := outputType(pkScript)
+	if inputType != psbt.InputP2SH || len(witness) == 0 {
+		return inputType
+	}
+
+	pushes, err := txscript.PushedData(scriptSig)
+	if err != nil || len(pushes) == 0 {
+		return inputType
+	}
+	redeemScript := pushes[len(pushes)-1]
+	switch {
+	case txscript.IsPayToWitnessPubKeyHash(redeemScript):
+		return psbt.InputP2SHP2WPKH
+	case txscript.IsPayToWitnessScriptHash(redeemScript):
+		return psbt.InputP2SHP2WSH
+	}
+	return inputType
+}
+
+// scriptAddress Return the address of the output script for the network, the empty string if it has no address
+func scriptAddress(pkScript []byte, net *chaincfg.Params) string {
+	if taproot.IsPkScript(pkScript) {
+		outputKey, err := taproot.ParseXOnlyPubKey(pkScript[2:])
+		if err != nil {
+			return ""
+		}
+		address, err := taproot.Address(outputKey, net)
+		if err != nil {
+			return ""
+		}
+		return address
+	}
+
+	class, addresses, _, err := txscript.ExtractPkScriptAddrs(pkScript, net)
+	if err != nil || len(addresses) != 1 || class == txscript.MultiSigTy || class == txscript.PubKeyTy {
+		return ""
+	}
+	return addresses[0].EncodeAddress()
+}
@@ -0,0 +1,129 @@
+package txdecode
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"github.com/jayt106/bitcoinAddressGenerator/psbt"
+)
+
+// DefaultLookahead the number of the addresses of each chain of the xpub matched by default
+const DefaultLookahead = 100
+
+// MaxLookahead the max number of the addresses of each chain of the xpub, it bounds the derivations of a request
+const MaxLookahead = 1000
+
+// Matcher the output scripts and the public keys of this server to find in the decoded transaction, each one has the
+// label reported by the matching input or output
+type Matcher struct {
+	scripts map[string]string
+	pubKeys map[string]string
+}
+
+// NewMatcher Create the empty matcher
+func NewMatcher() *Matcher {
+	return &Matcher{scripts: make(map[string]string), pubKeys: make(map[string]string)}
+}
+
+// AddXPub Add the keys of the external and internal chains (0 and 1) of the account xpub (m/account'), the addresses
+// 0 to lookahead-1 of the P2PKH, P2SH-P2WPKH, P2WPKH and P2TR types. The label is the relative path like "xpub 0/5".
+func (m *Matcher) AddXPub(xpub string, lookahead uint32, net *chaincfg.Params) error {
+	if lookahead == 0 || lookahead > MaxLookahead {
+		return fmt.Errorf("the lookahead must be in 1 to %d", MaxLookahead)
+	}
+	key, err := hdkeychain.NewKeyFromString(xpub)
+	if err != nil {
+		return err
+	}
+	if key.IsPrivate() {
+		return errors.New("the private extended key isn't accepted, use the xpub")
+	}
+	if !key.IsForNet(net) {
+		return fmt.Errorf("the xpub isn't for the %s network", net.Name)
+	}
+
+	for chain := uint32(0); chain <= 1; chain++ {
+		chainKey, err := key.Derive(chain)
+		if err != nil {
+			return err
+		}
+		for i := uint32(0); i < lookahead; i++ {
+			addressKey, err := chainKey.Derive(i)
+			if err != nil {
+				// The invalid child key is skipped (BIP032)
+				continue
+			}
+			pubKey, err := addressKey.ECPubKey()
+			if err != nil {
+				return err
+			}
+
+			label := fmt.Sprintf("xpub %d/%d", chain, i)
+			m.pubKeys[hex.EncodeToString(pubKey.SerializeCompressed())] = label
+			m.pubKeys[hex.EncodeToString(pubKey.SerializeUncompressed())] = label
+			for _, addressType := range []string{message.AddressP2PKH, message.AddressP2SHP2WPKH, message.AddressP2WPKH, message.AddressP2TR} {
+				pkScript, err := message.PkScript(pubKey, addressType, net)
+				if err != nil {
+					return err
+				}
+				m.scripts[hex.EncodeToString(pkScript)] = label
+			}
+		}
+	}
+	return nil
+}
+
+// AddRedeemScript Add the P2SH, P2WSH and P2SH-P2WSH outputs of the redeem script, like the multisig script of the
+// V1/genMultiSigP2SHAddress address. The label is the given label and the output type.
+func (m *Matcher) AddRedeemScript(script []byte, label string) {
+	m.scripts[hex.EncodeToString(psbt.P2SHScript(script))] = label + " " + psbt.InputP2SH
+	m.scripts[hex.EncodeToString(psbt.P2WSHScript(script))] = label + " " + psbt.InputP2WSH
+	m.scripts[hex.EncodeToString(psbt.P2SHScript(psbt.P2WSHScript(script)))] = label + " " + psbt.InputP2SHP2WSH
+}
+
+// MatchScript Return the label of the output script, the empty string if it doesn't match
+func (m *Matcher) MatchScript(pkScript []byte) string {
+	if m == nil {
+		return ""
+	}
+	return m.scripts[hex.EncodeToString(pkScript)]
+}
+
+// matchInput Return the label of the input spending the output script (nil if unknown) by the data items of the
+// signature script, the witness and the psbt, like the public keys and the redeem scripts
+func (m *Matcher) matchInput(pkScript []byte, items [][]byte) string {
+	if m == nil {
+		return ""
+	}
+	if label := m.MatchScript(pkScript); label != "" {
+		return label
+	}
+
+	for _, item := range items {
+		if label := m.pubKeys[hex.EncodeToString(item)]; label != "" {
+			return label
+		}
+		if label := m.MatchScript(psbt.P2SHScript(item)); label != "" {
+			return label
+		}
+		if label := m.MatchScript(psbt.P2WSHScript(item)); label != "" {
+			return label
+		}
+
+		// The public keys of the multisig script
+		pushes, err := txscript.PushedData(item)
+		if err != nil {
+			continue
+		}
+		for _, push := range pushes {
+			if label := m.pubKeys[hex.EncodeToString(push)]; label != "" {
+				return label
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,154 @@
+package txdecode
+
+import (
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"github.com/jayt106/bitcoinAddressGenerator/psbt"
+	"strings"
+	"testing"
+)
+
+// testAccountKey Return the account key m/0' of the BIP032 test vector 1 and the private key of m/0'/0/3
+func testAccountKey(t *testing.T) (*hdkeychain.ExtendedKey, *btcec.PrivateKey) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	masterKey, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accountKey, err := masterKey.Derive(hdkeychain.HardenedKeyStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainKey, err := accountKey.Derive(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addressKey, err := chainKey.Derive(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privKey, err := addressKey.ECPrivKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return accountKey, privKey
+}
+
+func testMultiSigScript(t *testing.T) []byte {
+	var addrs []*btcutil.AddressPubKey
+	for i := byte(1); i <= 3; i++ {
+		_, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), append(make([]byte, 31), i))
+		addr, err := btcutil.NewAddressPubKey(pubKey.SerializeCompressed(), &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, addr)
+	}
+	script, err := txscript.MultiSigScript(addrs, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+func TestDecode(t *testing.T) {
+	accountKey, privKey := testAccountKey(t)
+	xpub, err := accountKey.Neuter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	redeemScript := testMultiSigScript(t)
+
+	matcher := NewMatcher()
+	if err = matcher.AddXPub(xpub.String(), DefaultLookahead, &chaincfg.MainNetParams); err != nil {
+		t.Fatal(err)
+	}
+	matcher.AddRedeemScript(redeemScript, "redeem script 0")
+	if err = matcher.AddXPub(accountKey.String(), DefaultLookahead, &chaincfg.MainNetParams); err == nil {
+		t.Error("The xprv should be rejected")
+	}
+	if err = matcher.AddXPub(xpub.String(), DefaultLookahead, &chaincfg.TestNet3Params); err == nil {
+		t.Error("The mainnet xpub should be rejected on testnet")
+	}
+
+	// The previous transaction pays the P2WPKH address of m/0'/0/3
+	pkScript, err := message.PkScript(privKey.PubKey(), message.AddressP2WPKH, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevTx := wire.NewMsgTx(wire.TxVersion)
+	prevTx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{1}}})
+	prevTx.AddTxOut(wire.NewTxOut(100000, pkScript))
+
+	utxo := &psbt.UTXO{OutPoint: wire.OutPoint{Hash: prevTx.TxHash()}, Value: 100000, PkScript: pkScript}
+	outputs := []*psbt.TxOutput{
+		{TxOut: wire.NewTxOut(60000, psbt.P2WSHScript(redeemScript))},
+		{TxOut: wire.NewTxOut(30000, append([]byte{txscript.OP_1, txscript.OP_DATA_32}, make([]byte, 32)...))},
+	}
+	outputs[1].TxOut.PkScript[33] = 1
+	p, summary, err := psbt.Create([]*psbt.UTXO{utxo}, outputs, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := DecodePSBT(p, &chaincfg.MainNetParams, matcher)
+	if decoded.Complete || !decoded.EstimatedWeight || decoded.Weight != summary.Weight || decoded.Fee != summary.Fee {
+		t.Error("Unexpected unsigned psbt:", decoded.Weight, summary.Weight, decoded.Fee, summary.Fee)
+	}
+	in := decoded.Inputs[0]
+	if in.Type != psbt.InputP2WPKH || in.Match != "xpub 0/3" || !strings.HasPrefix(in.Address, "bc1q") {
+		t.Error("Unexpected psbt input:", in)
+	}
+	if decoded.Outputs[0].Match != "redeem script 0 p2wsh" || decoded.Outputs[0].Type != psbt.InputP2WSH {
+		t.Error("Unexpected multisig output:", decoded.Outputs[0])
+	}
+	if decoded.Outputs[1].Type != psbt.InputP2TR || !strings.HasPrefix(decoded.Outputs[1].Address, "bc1p") {
+		t.Error("Unexpected taproot output:", decoded.Outputs[1])
+	}
+
+	signed, err := psbt.SignWithKey(p, 0, privKey)
+	if err != nil || !signed {
+		t.Fatal("Sign the psbt failed:", err)
+	}
+	if !psbt.IsComplete(psbt.Finalize(p)) {
+		t.Fatal("The psbt should be finalized")
+	}
+	tx, err := psbt.Extract(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weight := int64(tx.SerializeSizeStripped()*3 + tx.SerializeSize())
+
+	decoded = DecodePSBT(p, &chaincfg.MainNetParams, matcher)
+	if !decoded.Complete || decoded.EstimatedWeight || decoded.Weight != weight || decoded.Inputs[0].Match != "xpub 0/3" {
+		t.Error("Unexpected finalized psbt:", decoded.Weight, weight, decoded.Inputs[0])
+	}
+
+	// The raw transaction, the value of the input is only known with the previous transaction
+	decodedTx := DecodeTx(tx, []*wire.MsgTx{prevTx}, &chaincfg.MainNetParams, matcher)
+	if decodedTx.TxID != tx.TxHash().String() || decodedTx.Weight != weight || decodedTx.Fee != 10000 {
+		t.Error("Unexpected transaction:", decodedTx.TxID, decodedTx.Weight, decodedTx.Fee)
+	}
+	if decodedTx.Inputs[0].Value != 100000 || decodedTx.Inputs[0].Type != psbt.InputP2WPKH || len(decodedTx.Inputs[0].Witness) != 2 {
+		t.Error("Unexpected transaction input:", decodedTx.Inputs[0])
+	}
+
+	decodedTx = DecodeTx(tx, nil, &chaincfg.TestNet3Params, matcher)
+	if decodedTx.Fee != 0 || decodedTx.Inputs[0].Value != 0 || decodedTx.Inputs[0].Match != "xpub 0/3" {
+		t.Error("Unexpected transaction without the previous transaction:", decodedTx.Fee, decodedTx.Inputs[0])
+	}
+	if !strings.HasPrefix(decodedTx.Outputs[0].Address, "tb1q") || !strings.HasPrefix(decodedTx.Outputs[1].Address, "tb1p") {
+		t.Error("The addresses should be decoded for the testnet:", decodedTx.Outputs)
+	}
+
+	if DecodeTx(tx, nil, &chaincfg.MainNetParams, nil).Inputs[0].Match != "" {
+		t.Error("The transaction without the matcher should match nothing")
+	}
+}
@@ -0,0 +1,344 @@
+package utxoscan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/jayt106/bitcoinAddressGenerator/derivation"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"sort"
+)
+
+// DefaultGapLimit the BIP44 gap limit, the number of the consecutive unused addresses ending the scan of a chain
+const DefaultGapLimit = 20
+
+// DefaultAddressTypes the address types of each derived key, in the order of the report
+var DefaultAddressTypes = []string{message.AddressP2PKH, message.AddressP2SHP2WPKH, message.AddressP2WPKH, message.AddressP2TR}
+
+// Balance the unspent outputs of a derived address found in the utxo set, the value is in satoshi. The path of the
+// xpub scan is relative to the account key.
+type Balance struct {
+	Path    string `json:"path"`
+	Account uint32 `json:"account"`
+	Chain   uint32 `json:"chain"`
+	Index   uint32 `json:"index"`
+	Type    string `json:"type"`
+	Address string `json:"address"`
+	Value   int64  `json:"value"`
+	UTXOs   int    `json:"utxos"`
+}
+
+// AccountBalance the total of an account and the last used index of its chains, -1 if the chain is unused
+type AccountBalance struct {
+	Account  uint32   `json:"account"`
+	XPub     string   `json:"xpub"`
+	Value    int64    `json:"value"`
+	UTXOs    int      `json:"utxos"`
+	LastUsed [2]int64 `json:"lastUsed"`
+}
+
+// Report the result of the scan, the balances of the used addresses and the accounts, and the number of the passes
+// over the utxo set and the derived keys
+type Report struct {
+	Balances []Balance        `json:"balances"`
+	Accounts []AccountBalance `json:"accounts"`
+	Total    int64            `json:"total"`
+	UTXOs    int              `json:"utxos"`
+	Passes   int              `json:"passes"`
+	Derived  int              `json:"derived"`
+}
+
+// Reader read the utxo set and call fn with each coin, the reader is called for each pass of the scan
+type Reader func(fn func(*Coin) error) error
+
+// Window the addresses of a chain derived by a pass of the scan, the indices from Start to End (exclusive) of the
+// neutered chain key m/account'/chain in the address types. PkScripts has the output scripts of the addresses.
+type Window struct {
+	Account      uint32
+	Chain        uint32
+	ChainKey     *hdkeychain.ExtendedKey
+	Start        uint32
+	End          uint32
+	AddressTypes []string
+	PkScripts    [][]byte
+}
+
+// Oracle find the history of the derived addresses, Coins is called once per pass of the scan with the windows derived
+// by the pass and calls fn with the coins of their output scripts. The coins of the other scripts are ignored.
+type Oracle interface {
+	Coins(windows []Window, fn func(*Coin) error) error
+}
+
+// Coins Read the whole utxo set and call fn with the coins of the output scripts of the windows
+func (read Reader) Coins(windows []Window, fn func(*Coin) error) error {
+	scripts := make(map[string]bool)
+	for _, window := range windows {
+		for _, pkScript := range window.PkScripts {
+			scripts[string(pkScript)] = true
+		}
+	}
+	return read(func(coin *Coin) error {
+		if !scripts[string(coin.PkScript)] {
+			return nil
+		}
+		return fn(coin)
+	})
+}
+
+// Scanner the gap limit scanner of the addresses of a seed or an account xpub. The seed accounts are m/account' like
+// the server, the addresses are m/account'/chain/index of the external (0) and internal (1) chains.
+type Scanner struct {
+	master       *hdkeychain.ExtendedKey
+	xpub         *hdkeychain.ExtendedKey
+	gapLimit     uint32
+	net          *chaincfg.Params
+	addressTypes []string
+}
+
+// accountState the derived keys of an account
+type accountState struct {
+	number   uint32
+	xpub     string
+	chains   [2]*hdkeychain.ExtendedKey
+	derived  [2]uint32
+	lastUsed [2]int64
+}
+
+// target the derived address of an output script
+type target struct {
+	account *accountState
+	chain   uint32
+	index   uint32
+	typ     string
+	address string
+}
+
+// NewSeedScanner Create the scanner of the seed, the accounts are scanned until the first account without the
+// unspent outputs like the BIP44 account discovery
+func NewSeedScanner(seed []byte, gapLimit uint32, net *chaincfg.Params) (*Scanner, error) {
+	if gapLimit == 0 {
+		return nil, errors.New("the gap limit must be positive")
+	}
+	master, err := hdkeychain.NewMaster(seed, net)
+	if err != nil {
+		return nil, err
+	}
+	return &Scanner{master: master, gapLimit: gapLimit, net: net, addressTypes: DefaultAddressTypes}, nil
+}
+
+// NewXPubScanner Create the scanner of the account xpub (m/account') on the network, the version of the xpub must be the
+// version of the network. The testnet3, regtest and signet xpubs share the same version, so the network isn't guessed.
+func NewXPubScanner(xpub string, gapLimit uint32, net *chaincfg.Params) (*Scanner, error) {
+	if gapLimit == 0 {
+		return nil, errors.New("the gap limit must be positive")
+	}
+	key, err := hdkeychain.NewKeyFromString(xpub)
+	if err != nil {
+		return nil, err
+	}
+	if key.IsPrivate() {
+		return nil, errors.New("the key is private, please give the xpub")
+	}
+	if !key.IsForNet(net) {
+		return nil, fmt.Errorf("the xpub isn't of the network %s", net.Name)
+	}
+	return &Scanner{xpub: key, gapLimit: gapLimit, net: net, addressTypes: DefaultAddressTypes}, nil
+}
+
+// Net Return the network of the addresses
+func (s *Scanner) Net() *chaincfg.Params {
+	return s.net
+}
+
+// SetAddressTypes Set the address types checked for each derived key, DefaultAddressTypes by default
+func (s *Scanner) SetAddressTypes(addressTypes []string) error {
+	if len(addressTypes) == 0 {
+		return errors.New("no address type to check")
+	}
+	s.addressTypes = addressTypes
+	return nil
+}
+
+// Zero Clear the master key of the seed
+func (s *Scanner) Zero() {
+	if s.master != nil {
+		s.master.Zero()
+	}
+}
+
+// Scan Scan the history of the derived addresses by the oracle, like the utxo set Reader. Each pass checks the addresses
+// derived up to the gap limit after the last used index of each chain, and the pass is repeated with the new addresses
+// until the gap limit is reached. The seed accounts are scanned until the first account without the history on either
+// chain. The utxo set only has the unspent outputs, an address of which the outputs are all spent is seen as unused.
+func (s *Scanner) Scan(oracle Oracle) (*Report, error) {
+	scripts := make(map[string]*target)
+	balances := make(map[*target]*Balance)
+	var accounts []*accountState
+	report := &Report{}
+
+	for {
+		// The next seed account is scanned after a used account
+		if len(accounts) == 0 || (s.master != nil && accounts[len(accounts)-1].used()) {
+			account, err := s.newAccount(uint32(len(accounts)))
+			if err != nil {
+				return nil, err
+			}
+			accounts = append(accounts, account)
+		}
+
+		windows, derived, err := s.derive(accounts, scripts)
+		if err != nil {
+			return nil, err
+		}
+		if derived == 0 {
+			break
+		}
+		report.Derived += derived
+
+		err = oracle.Coins(windows, func(coin *Coin) error {
+			t, ok := scripts[string(coin.PkScript)]
+			if !ok {
+				return nil
+			}
+			balance, ok := balances[t]
+			if !ok {
+				balance = &Balance{Account: t.account.number, Chain: t.chain, Index: t.index, Type: t.typ, Address: t.address}
+				balances[t] = balance
+			}
+			balance.Value += coin.Value
+			balance.UTXOs++
+			if int64(t.index) > t.account.lastUsed[t.chain] {
+				t.account.lastUsed[t.chain] = int64(t.index)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		report.Passes++
+	}
+
+	for t, balance := range balances {
+		balance.Path = s.path(t)
+		report.Balances = append(report.Balances, *balance)
+	}
+	sort.Slice(report.Balances, func(i, j int) bool {
+		a, b := &report.Balances[i], &report.Balances[j]
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		}
+		if a.Chain != b.Chain {
+			return a.Chain < b.Chain
+		}
+		if a.Index != b.Index {
+			return a.Index < b.Index
+		}
+		return s.typeOrder(a.Type) < s.typeOrder(b.Type)
+	})
+
+	for _, account := range accounts {
+		report.Accounts = append(report.Accounts, AccountBalance{Account: account.number, XPub: account.xpub, LastUsed: account.lastUsed})
+	}
+	for _, balance := range report.Balances {
+		accountBalance := &report.Accounts[balance.Account]
+		accountBalance.Value += balance.Value
+		accountBalance.UTXOs += balance.UTXOs
+		report.Total += balance.Value
+		report.UTXOs += balance.UTXOs
+	}
+	return report, nil
+}
+
+// newAccount Derive the chain keys of the account, the xpub is the only account of the xpub scan
+func (s *Scanner) newAccount(number uint32) (*accountState, error) {
+	accountKey := s.xpub
+	if s.master != nil {
+		if number >= hdkeychain.HardenedKeyStart {
+			return nil, errors.New("no account left to scan")
+		}
+		var err error
+		accountKey, err = s.master.Derive(hdkeychain.HardenedKeyStart + number)
+		if err != nil {
+			return nil, err
+		}
+		defer accountKey.Zero()
+	}
+
+	accountPubKey, err := accountKey.Neuter()
+	if err != nil {
+		return nil, err
+	}
+	account := &accountState{number: number, xpub: accountPubKey.String(), lastUsed: [2]int64{-1, -1}}
+	for chain := range account.chains {
+		chainKey, err := accountKey.Derive(uint32(chain))
+		if err != nil {
+			return nil, err
+		}
+		account.chains[chain], err = chainKey.Neuter()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return account, nil
+}
+
+// derive Derive the addresses of the chains up to the gap limit after the last used index by the derivation workers,
+// and return the windows of the derived addresses and the number of the derived keys
+func (s *Scanner) derive(accounts []*accountState, scripts map[string]*target) ([]Window, int, error) {
+	var windows []Window
+	derived := 0
+	opts := derivation.Options{AddressTypes: s.addressTypes, Net: s.net}
+	for _, account := range accounts {
+		for chain, chainKey := range account.chains {
+			end := uint64(account.lastUsed[chain]+1) + uint64(s.gapLimit)
+			if end > hdkeychain.HardenedKeyStart {
+				end = hdkeychain.HardenedKeyStart
+			}
+			start := account.derived[chain]
+			if uint64(start) >= end {
+				continue
+			}
+
+			window := Window{account.number, uint32(chain), chainKey, start, uint32(end), s.addressTypes, nil}
+			err := derivation.Stream(context.Background(), chainKey, start, uint32(end-uint64(start)), opts, func(key *derivation.Key) error {
+				for i, addressType := range s.addressTypes {
+					scripts[string(key.PkScripts[i])] = &target{account, uint32(chain), key.Index, addressType, key.Addresses[i]}
+					window.PkScripts = append(window.PkScripts, key.PkScripts[i])
+				}
+				derived++
+				return nil
+			})
+			if err != nil {
+				return nil, 0, err
+			}
+			account.derived[chain] = uint32(end)
+			windows = append(windows, window)
+		}
+	}
+	return windows, derived, nil
+}
+
+// path Return the path of the derived address, relative to the account key for the xpub
+func (s *Scanner) path(t *target) string {
+	if s.master == nil {
+		return fmt.Sprintf("%d/%d", t.chain, t.index)
+	}
+	return fmt.Sprintf("m/%d'/%d/%d", t.account.number, t.chain, t.index)
+}
+
+// used Return true if the account has an unspent output
+func (a *accountState) used() bool {
+	return a.lastUsed[0] >= 0 || a.lastUsed[1] >= 0
+}
+
+// typeOrder Return the order of the address type in the report
+func (s *Scanner) typeOrder(addressType string) int {
+	for i, t := range s.addressTypes {
+		if t == addressType {
+			return i
+		}
+	}
+	return len(s.addressTypes)
+}
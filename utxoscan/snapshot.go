@@ -0,0 +1,335 @@
+package utxoscan
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// snapshotMagic the magic bytes of the dumptxoutset snapshot since Bitcoin Core 28.0, the older snapshot starts with
+// the base block hash
+var snapshotMagic = []byte{'u', 't', 'x', 'o', 0xff}
+
+// The script compression of Bitcoin Core, the first 6 sizes are the special scripts and the larger script is replaced
+// by OP_RETURN
+const (
+	specialScripts = 6
+	maxScriptSize  = 10000
+)
+
+// Coin an unspent output of the snapshot, the output script is nil for the uncompressed P2PK output of an invalid key
+type Coin struct {
+	OutPoint wire.OutPoint
+	Value    int64
+	PkScript []byte
+	Height   uint32
+	Coinbase bool
+}
+
+// SnapshotInfo the metadata of the dumptxoutset snapshot
+type SnapshotInfo struct {
+	Version       uint16
+	NetworkMagic  uint32
+	BaseBlockHash chainhash.Hash
+	CoinsCount    uint64
+}
+
+// ReadSnapshot Read the coins of the Bitcoin Core dumptxoutset snapshot and call fn with each coin, the reading stops
+// at the first error of fn. The legacy format (before 28.0) and the format of version 2 grouping the coins by the
+// txid are supported. The coin is reused by the next call.
+func ReadSnapshot(r io.Reader, fn func(*Coin) error) (*SnapshotInfo, error) {
+	br := bufio.NewReaderSize(r, 1<<20)
+	info := &SnapshotInfo{}
+
+	head, err := br.Peek(len(snapshotMagic))
+	if err != nil {
+		return nil, err
+	}
+	legacy := !bytes.Equal(head, snapshotMagic)
+	if !legacy {
+		if _, err = br.Discard(len(snapshotMagic)); err != nil {
+			return nil, err
+		}
+		if err = binary.Read(br, binary.LittleEndian, &info.Version); err != nil {
+			return nil, err
+		}
+		if info.Version != 2 {
+			return nil, fmt.Errorf("unsupported snapshot version %d", info.Version)
+		}
+		if err = binary.Read(br, binary.LittleEndian, &info.NetworkMagic); err != nil {
+			return nil, err
+		}
+	}
+	if _, err = io.ReadFull(br, info.BaseBlockHash[:]); err != nil {
+		return nil, err
+	}
+	if err = binary.Read(br, binary.LittleEndian, &info.CoinsCount); err != nil {
+		return nil, err
+	}
+
+	coin := &Coin{}
+	for read := uint64(0); read < info.CoinsCount; {
+		if _, err = io.ReadFull(br, coin.OutPoint.Hash[:]); err != nil {
+			return nil, fmt.Errorf("coin %d: %v", read, err)
+		}
+
+		// The legacy snapshot has the output index of each coin, the grouped coins share the txid
+		count := uint64(1)
+		if !legacy {
+			if count, err = wire.ReadVarInt(br, 0); err != nil {
+				return nil, fmt.Errorf("coin %d: %v", read, err)
+			}
+			if count == 0 || count > info.CoinsCount-read {
+				return nil, fmt.Errorf("coin %d: invalid coins count %d of the txid", read, count)
+			}
+		}
+		for i := uint64(0); i < count; i++ {
+			if legacy {
+				err = binary.Read(br, binary.LittleEndian, &coin.OutPoint.Index)
+			} else {
+				var index uint64
+				index, err = wire.ReadVarInt(br, 0)
+				coin.OutPoint.Index = uint32(index)
+			}
+			if err == nil {
+				err = readCoin(br, coin)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("coin %d: %v", read, err)
+			}
+			read++
+			if err = fn(coin); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return info, nil
+}
+
+// readCoin Read the height, the amount and the output script of the serialized coin
+func readCoin(r io.ByteReader, coin *Coin) error {
+	code, err := readVarInt(r)
+	if err != nil {
+		return err
+	}
+	coin.Height = uint32(code >> 1)
+	coin.Coinbase = code&1 == 1
+
+	amount, err := readVarInt(r)
+	if err != nil {
+		return err
+	}
+	coin.Value = int64(decompressAmount(amount))
+
+	coin.PkScript, err = readScript(r)
+	return err
+}
+
+// readVarInt Read the VARINT of Bitcoin Core, the MSB base-128 integer without the redundant encodings
+func readVarInt(r io.ByteReader) (uint64, error) {
+	var n uint64
+	for {
+		if n > math.MaxUint64>>7 {
+			return 0, errors.New("varint is too large")
+		}
+		ch, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		n = n<<7 | uint64(ch&0x7f)
+		if ch&0x80 == 0 {
+			return n, nil
+		}
+		n++
+	}
+}
+
+// decompressAmount Reverse the amount compression of Bitcoin Core
+func decompressAmount(x uint64) uint64 {
+	if x == 0 {
+		return 0
+	}
+	x--
+	e := x % 10
+	x /= 10
+	var n uint64
+	if e < 9 {
+		d := x%9 + 1
+		x /= 9
+		n = x*10 + d
+	} else {
+		n = x + 1
+	}
+	for ; e > 0; e-- {
+		n *= 10
+	}
+	return n
+}
+
+// readScript Read the compressed output script of Bitcoin Core
+func readScript(r io.ByteReader) ([]byte, error) {
+	size, err := readVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	readBytes := func(n uint64) ([]byte, error) {
+		data := make([]byte, n)
+		for i := range data {
+			if data[i], err = r.ReadByte(); err != nil {
+				return nil, err
+			}
+		}
+		return data, nil
+	}
+
+	switch size {
+	case 0:
+		hash, err := readBytes(20)
+		if err != nil {
+			return nil, err
+		}
+		return append(append([]byte{txscript.OP_DUP, txscript.OP_HASH160, txscript.OP_DATA_20}, hash...),
+			txscript.OP_EQUALVERIFY, txscript.OP_CHECKSIG), nil
+	case 1:
+		hash, err := readBytes(20)
+		if err != nil {
+			return nil, err
+		}
+		return append(append([]byte{txscript.OP_HASH160, txscript.OP_DATA_20}, hash...), txscript.OP_EQUAL), nil
+	case 2, 3, 4, 5:
+		x, err := readBytes(32)
+		if err != nil {
+			return nil, err
+		}
+		pubKey := append([]byte{byte(size)}, x...)
+		if size >= 4 {
+			// The uncompressed key is stored compressed with the parity of size - 2
+			pubKey[0] -= 2
+			key, err := btcec.ParsePubKey(pubKey, btcec.S256())
+			if err != nil {
+				return nil, nil
+			}
+			pubKey = key.SerializeUncompressed()
+		}
+		return append(append([]byte{byte(len(pubKey))}, pubKey...), txscript.OP_CHECKSIG), nil
+	}
+
+	size -= specialScripts
+	if size > maxScriptSize {
+		// The oversized script is unspendable, it's stored as OP_RETURN without the data
+		for i := uint64(0); i < size; i++ {
+			if _, err = r.ReadByte(); err != nil {
+				return nil, err
+			}
+		}
+		return []byte{txscript.OP_RETURN}, nil
+	}
+	return readBytes(size)
+}
+
+// FileReader Return the reader of the csv utxo export (.csv) or the dumptxoutset snapshot file, the file is opened
+// again by each pass of the scan
+func FileReader(file string, net *chaincfg.Params) Reader {
+	return func(fn func(*Coin) error) error {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if strings.HasSuffix(strings.ToLower(file), ".csv") {
+			return ReadCSV(f, net, fn)
+		}
+		_, err = ReadSnapshot(f, fn)
+		return err
+	}
+}
+
+// ReadCSV Read the coins of the csv utxo export and call fn with each coin. The header names the columns: txid, vout,
+// value (satoshi) and the hex scriptPubKey or the address of the network, the other columns are ignored.
+func ReadCSV(r io.Reader, net *chaincfg.Params, fn func(*Coin) error) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"txid", "vout", "value"} {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("missing the %s column", name)
+		}
+	}
+	scriptColumn, hasScript := columns["scriptpubkey"]
+	addressColumn, hasAddress := columns["address"]
+	if !hasScript && !hasAddress {
+		return errors.New("missing the scriptPubKey or address column")
+	}
+
+	coin := &Coin{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		field := func(column int) string {
+			if column >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[column])
+		}
+
+		hash, err := chainhash.NewHashFromStr(field(columns["txid"]))
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		index, err := strconv.ParseUint(field(columns["vout"]), 10, 32)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		coin.Value, err = strconv.ParseInt(field(columns["value"]), 10, 64)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		coin.OutPoint = wire.OutPoint{Hash: *hash, Index: uint32(index)}
+
+		if hasScript && field(scriptColumn) != "" {
+			coin.PkScript, err = hex.DecodeString(field(scriptColumn))
+		} else if hasAddress {
+			coin.PkScript, err = message.AddressScript(field(addressColumn), net)
+		} else {
+			err = errors.New("missing the output script")
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+
+		if err = fn(coin); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package utxoscan
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"strings"
+	"testing"
+)
+
+var testSeed, _ = hex.DecodeString("a966eb6058f8ec9f47074a2faadd3dab42e2c60ed05bc34d39d6c0e1d32b8bdf")
+
+// writeVarInt Write the VARINT of Bitcoin Core
+func writeVarInt(buf *bytes.Buffer, n uint64) {
+	var tmp []byte
+	for {
+		b := byte(n & 0x7f)
+		if len(tmp) != 0 {
+			b |= 0x80
+		}
+		tmp = append(tmp, b)
+		if n <= 0x7f {
+			break
+		}
+		n = n>>7 - 1
+	}
+	for i := len(tmp) - 1; i >= 0; i-- {
+		buf.WriteByte(tmp[i])
+	}
+}
+
+// compressAmount The amount compression of Bitcoin Core
+func compressAmount(n uint64) uint64 {
+	if n == 0 {
+		return 0
+	}
+	e := uint64(0)
+	for n%10 == 0 && e < 9 {
+		n /= 10
+		e++
+	}
+	if e < 9 {
+		d := n % 10
+		n /= 10
+		return 1 + (n*9+d-1)*10 + e
+	}
+	return 1 + (n-1)*10 + 9
+}
+
+// writeCoin Write the coin, the P2PKH script is written as the special script and the others as the raw script
+func writeCoin(buf *bytes.Buffer, coin *Coin) {
+	writeVarInt(buf, uint64(coin.Height)*2)
+	writeVarInt(buf, compressAmount(uint64(coin.Value)))
+	if txscript.GetScriptClass(coin.PkScript) == txscript.PubKeyHashTy {
+		writeVarInt(buf, 0)
+		buf.Write(coin.PkScript[3:23])
+		return
+	}
+	writeVarInt(buf, uint64(len(coin.PkScript)+specialScripts))
+	buf.Write(coin.PkScript)
+}
+
+// testSnapshot Return the snapshot of the coins in the legacy format or the version 2 format
+func testSnapshot(coins []*Coin, legacy bool) []byte {
+	var buf bytes.Buffer
+	if !legacy {
+		buf.Write(snapshotMagic)
+		_ = binary.Write(&buf, binary.LittleEndian, uint16(2))
+		_ = binary.Write(&buf, binary.LittleEndian, uint32(wire.MainNet))
+	}
+	buf.Write(make([]byte, 32))
+	_ = binary.Write(&buf, binary.LittleEndian, uint64(len(coins)))
+	for _, coin := range coins {
+		buf.Write(coin.OutPoint.Hash[:])
+		if legacy {
+			_ = binary.Write(&buf, binary.LittleEndian, coin.OutPoint.Index)
+		} else {
+			_ = wire.WriteVarInt(&buf, 0, 1)
+			_ = wire.WriteVarInt(&buf, 0, uint64(coin.OutPoint.Index))
+		}
+		writeCoin(&buf, coin)
+	}
+	return buf.Bytes()
+}
+
+// testScript Return the output script of the address type of the seed path m/account'/chain/index
+func testScript(t *testing.T, account, chain, index uint32, addressType string) []byte {
+	master, err := hdkeychain.NewMaster(testSeed, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := master
+	for _, i := range []uint32{hdkeychain.HardenedKeyStart + account, chain, index} {
+		if key, err = key.Derive(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pubKey, err := key.ECPubKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := message.PkScript(pubKey, addressType, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkScript
+}
+
+func TestDecompressAmount(t *testing.T) {
+	// The test vectors of Bitcoin Core
+	for _, v := range [][2]uint64{{0, 0}, {1, 1}, {1000000, 0x7}, {100000000, 0x9}, {5000000000, 0x32}, {2100000000000000, 0x1406f40}} {
+		if compressAmount(v[0]) != v[1] || decompressAmount(v[1]) != v[0] {
+			t.Error("Unexpected amount compression:", v[0], compressAmount(v[0]), decompressAmount(v[1]))
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	_, uncompressed := btcec.PrivKeyFromBytes(btcec.S256(), append(make([]byte, 31), 1))
+	coins := []*Coin{
+		{OutPoint: wire.OutPoint{Hash: chainhash.Hash{1}}, Value: 100000, PkScript: testScript(t, 0, 0, 0, message.AddressP2WPKH)},
+		{OutPoint: wire.OutPoint{Hash: chainhash.Hash{2}, Index: 3}, Value: 20000, PkScript: testScript(t, 0, 0, 15, message.AddressP2SHP2WPKH)},
+		{OutPoint: wire.OutPoint{Hash: chainhash.Hash{3}}, Value: 30000, PkScript: testScript(t, 0, 0, 30, message.AddressP2TR)},
+		{OutPoint: wire.OutPoint{Hash: chainhash.Hash{4}}, Value: 40000, PkScript: testScript(t, 0, 0, 30, message.AddressP2WPKH)},
+		{OutPoint: wire.OutPoint{Hash: chainhash.Hash{5}}, Value: 50000, PkScript: testScript(t, 1, 1, 0, message.AddressP2PKH)},
+		{OutPoint: wire.OutPoint{Hash: chainhash.Hash{6}}, Value: 60000, PkScript: testScript(t, 1, 1, 0, message.AddressP2PKH)},
+		// Beyond the gap limit, and the accounts after the unused account 2
+		{OutPoint: wire.OutPoint{Hash: chainhash.Hash{7}}, Value: 70000, PkScript: testScript(t, 0, 0, 60, message.AddressP2WPKH)},
+		{OutPoint: wire.OutPoint{Hash: chainhash.Hash{8}}, Value: 80000, PkScript: testScript(t, 3, 0, 0, message.AddressP2WPKH)},
+		// The output scripts of the others
+		{OutPoint: wire.OutPoint{Hash: chainhash.Hash{9}}, Value: 90000, PkScript: append(append([]byte{65}, uncompressed.SerializeUncompressed()...), txscript.OP_CHECKSIG)},
+	}
+
+	for _, legacy := range []bool{true, false} {
+		snapshot := testSnapshot(coins, legacy)
+
+		// The snapshot is read back with the same coins
+		var read []Coin
+		info, err := ReadSnapshot(bytes.NewReader(snapshot), func(coin *Coin) error {
+			read = append(read, *coin)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.CoinsCount != uint64(len(coins)) || len(read) != len(coins) || (info.Version == 2) == legacy {
+			t.Fatal("Unexpected snapshot:", info, len(read))
+		}
+		for i, coin := range coins {
+			if read[i].OutPoint != coin.OutPoint || read[i].Value != coin.Value || !bytes.Equal(read[i].PkScript, coin.PkScript) {
+				t.Error("Unexpected coin", i, read[i], coin)
+			}
+		}
+
+		scanner, err := NewSeedScanner(testSeed, DefaultGapLimit, &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err := scanner.Scan(Reader(func(fn func(*Coin) error) error {
+			_, err := ReadSnapshot(bytes.NewReader(snapshot), fn)
+			return err
+		}))
+		scanner.Zero()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The index 30 is found by the second pass after the index 15, the third pass finds nothing new
+		if report.Total != 300000 || report.UTXOs != 6 || report.Passes != 3 || len(report.Accounts) != 3 || len(report.Balances) != 5 {
+			t.Fatal("Unexpected report:", report)
+		}
+		if b := report.Balances[0]; b.Path != "m/0'/0/0" || b.Type != message.AddressP2WPKH || b.Address != "bc1q8c87x4v0m3dfrxksv724rtwpxy5ghpw8gwf8da" {
+			t.Error("Unexpected balance:", b)
+		}
+		if b := report.Balances[2]; b.Path != "m/0'/0/30" || b.Type != message.AddressP2WPKH || !strings.HasPrefix(report.Balances[3].Address, "bc1p") {
+			t.Error("Unexpected balances of the index 30:", report.Balances[2:4])
+		}
+		if b := report.Balances[4]; b.Path != "m/1'/1/0" || b.Value != 110000 || b.UTXOs != 2 {
+			t.Error("Unexpected balance of the account 1:", b)
+		}
+		if a := report.Accounts[0]; a.Value != 190000 || a.LastUsed != [2]int64{30, -1} || !strings.HasPrefix(a.XPub, "xpub") {
+			t.Error("Unexpected account 0:", a)
+		}
+		if a := report.Accounts[2]; a.Value != 0 || a.LastUsed != [2]int64{-1, -1} {
+			t.Error("Unexpected account 2:", a)
+		}
+	}
+
+	if _, err := ReadSnapshot(bytes.NewReader(testSnapshot(coins, false)[:200]), func(*Coin) error { return nil }); err == nil {
+		t.Error("The truncated snapshot should be rejected")
+	}
+}
+
+func TestScanCSV(t *testing.T) {
+	master, err := hdkeychain.NewMaster(testSeed, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accountKey, err := master.Derive(hdkeychain.HardenedKeyStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xpub, err := accountKey.Neuter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csvFile := "txid,vout,value,address,scriptPubKey\n" +
+		chainhash.Hash{1}.String() + ",0,100000,bc1q8c87x4v0m3dfrxksv724rtwpxy5ghpw8gwf8da,\n" +
+		chainhash.Hash{2}.String() + ",1,25000,," + hex.EncodeToString(testScript(t, 0, 1, 4, message.AddressP2PKH)) + "\n"
+
+	scanner, err := NewXPubScanner(xpub.String(), 5, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := scanner.Scan(Reader(func(fn func(*Coin) error) error {
+		return ReadCSV(strings.NewReader(csvFile), &chaincfg.MainNetParams, fn)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 125000 || len(report.Balances) != 2 || report.Balances[0].Path != "0/0" || report.Balances[1].Path != "1/4" ||
+		report.Balances[1].Type != message.AddressP2PKH {
+		t.Error("Unexpected xpub report:", report)
+	}
+
+	if _, err = NewXPubScanner(accountKey.String(), 5, &chaincfg.MainNetParams); err == nil {
+		t.Error("The xprv should be rejected")
+	}
+	if _, err = NewXPubScanner(xpub.String(), 5, &chaincfg.TestNet3Params); err == nil {
+		t.Error("The xpub of another network should be rejected")
+	}
+	if err = ReadCSV(strings.NewReader("txid,value,address\n"), &chaincfg.MainNetParams, func(*Coin) error { return nil }); err == nil {
+		t.Error("The csv without the vout column should be rejected")
+	}
+	if err = ReadCSV(strings.NewReader("txid,vout,value,address\nxx,0,1,bc1q\n"), &chaincfg.MainNetParams, func(*Coin) error { return nil }); err == nil {
+		t.Error("The invalid csv line should be rejected")
+	}
+}
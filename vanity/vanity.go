@@ -0,0 +1,334 @@
+package vanity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"math"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultProgressInterval the interval of the progress reports
+const DefaultProgressInterval = time.Second
+
+// bech32Charset the characters of the bech32 and bech32m data part
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// ErrTimeBudget the search didn't find a match within the time budget
+var ErrTimeBudget = errors.New("no matching address within the time budget")
+
+// ErrExhausted the source has no candidate key left
+var ErrExhausted = errors.New("no candidate key left")
+
+// Pattern the prefix or the regular expression the address must match
+type Pattern struct {
+	AddressType string
+	Prefix      string
+	Regex       *regexp.Regexp
+	// Difficulty the expected attempts of a match, zero if it can't be estimated (the regular expression)
+	Difficulty float64
+	net        *chaincfg.Params
+}
+
+// NewPattern Return the pattern of the P2WPKH or P2TR address. The prefix is the bech32 data part after the head of the
+// address (bc1q of P2WPKH and bc1p of P2TR on the mainnet), the head itself may be included. The regular expression is
+// matched against the whole address. Exactly one of the prefix and the regular expression is given.
+func NewPattern(addressType string, prefix string, expr string, net *chaincfg.Params) (*Pattern, error) {
+	version := ""
+	switch addressType {
+	case message.AddressP2WPKH:
+		version = "q"
+	case message.AddressP2TR:
+		version = "p"
+	default:
+		return nil, fmt.Errorf("the address type %s isn't supported, p2wpkh or p2tr", addressType)
+	}
+	if (prefix == "") == (expr == "") {
+		return nil, errors.New("either the prefix or the regular expression is required")
+	}
+
+	p := &Pattern{AddressType: addressType, net: net}
+	if expr != "" {
+		regex, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		p.Regex = regex
+		return p, nil
+	}
+
+	// The bech32 address is lowercase, the first data character of P2WPKH is the witness version
+	head := net.Bech32HRPSegwit + "1" + version
+	prefix = strings.TrimPrefix(strings.ToLower(prefix), head)
+	if prefix == "" {
+		return nil, errors.New("the prefix has nothing after the address head " + head)
+	}
+	for _, c := range prefix {
+		if !strings.ContainsRune(bech32Charset, c) {
+			return nil, fmt.Errorf("the prefix character %q isn't in the bech32 charset %s", c, bech32Charset)
+		}
+	}
+	p.Prefix = head + prefix
+	p.Difficulty = math.Pow(32, float64(len(prefix)))
+	return p, nil
+}
+
+// Match Return true if the address matches the pattern
+func (p *Pattern) Match(address string) bool {
+	if p.Regex != nil {
+		return p.Regex.MatchString(address)
+	}
+	return strings.HasPrefix(address, p.Prefix)
+}
+
+// Candidate the key of an attempt, the private key is only known by the random source
+type Candidate struct {
+	PubKey  *btcec.PublicKey
+	PrivKey *btcec.PrivateKey
+	Index   uint32
+}
+
+// zero Zero the private key of the candidate which isn't returned, the random key is dropped by the search
+func (c *Candidate) zero() {
+	if c.PrivKey != nil {
+		c.PrivKey.D.SetInt64(0)
+	}
+}
+
+// Source the candidate keys of the search, Key is called by the workers concurrently with the distinct attempt numbers.
+// A nil candidate skips the attempt.
+type Source interface {
+	Key(n uint64) (*Candidate, error)
+}
+
+// HDSource the non-hardened child keys of the chain key from the start index, the match is recoverable from the seed
+// by its path
+type HDSource struct {
+	chainKey *hdkeychain.ExtendedKey
+	start    uint32
+}
+
+// NewHDSource Return the source of the child keys of the neutered chain key (m/account'/chain) from the start index
+func NewHDSource(chainKey *hdkeychain.ExtendedKey, start uint32) (*HDSource, error) {
+	if chainKey.IsPrivate() {
+		return nil, errors.New("the chain key must be neutered")
+	}
+	if start >= hdkeychain.HardenedKeyStart {
+		return nil, errors.New("the start index must be non-hardened")
+	}
+	return &HDSource{chainKey, start}, nil
+}
+
+// Key Return the child key of the index start+n
+func (s *HDSource) Key(n uint64) (*Candidate, error) {
+	if n >= uint64(hdkeychain.HardenedKeyStart-s.start) {
+		return nil, ErrExhausted
+	}
+	index := s.start + uint32(n)
+	child, err := s.chainKey.Derive(index)
+	if err == hdkeychain.ErrInvalidChild {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := child.ECPubKey()
+	if err != nil {
+		return nil, err
+	}
+	return &Candidate{PubKey: pubKey, Index: index}, nil
+}
+
+// RandomSource the fresh random keys, the match isn't recoverable from any seed and its private key must be kept
+type RandomSource struct{}
+
+// Key Return a fresh random key
+func (RandomSource) Key(uint64) (*Candidate, error) {
+	privKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		return nil, err
+	}
+	return &Candidate{PubKey: privKey.PubKey(), PrivKey: privKey}, nil
+}
+
+// Progress the state of the running search
+type Progress struct {
+	Attempts uint64        `json:"attempts"`
+	Elapsed  time.Duration `json:"elapsed"`
+	// Rate the attempts per second
+	Rate       float64 `json:"rate"`
+	Difficulty float64 `json:"difficulty"`
+	// Probability the probability of a match within the attempts so far
+	Probability float64 `json:"probability"`
+	// ETA the expected time until the probability of a match reaches 50%, zero if it's reached or unknown
+	ETA time.Duration `json:"eta"`
+}
+
+// Options the workers, the time budget and the progress reports of the search
+type Options struct {
+	// Workers the goroutine workers, the number of CPUs by default
+	Workers int
+	// TimeBudget the max duration of the search, unlimited if zero
+	TimeBudget time.Duration
+	// Progress is called at every progress interval
+	Progress         func(Progress)
+	ProgressInterval time.Duration
+}
+
+// Result the matching address, or the attempts of the failed search
+type Result struct {
+	Address   string
+	Candidate *Candidate
+	Progress
+}
+
+// Search Try the candidate keys of the source by the workers until the address matches the pattern. The search stops
+// at the cancellation of the context, the end of the time budget (ErrTimeBudget) or the end of the source
+// (ErrExhausted). The result has the attempts and the elapsed time even if the search fails.
+func Search(ctx context.Context, p *Pattern, source Source, opts Options) (*Result, error) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	interval := opts.ProgressInterval
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+	if opts.TimeBudget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.TimeBudget)
+		defer cancel()
+	}
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	start := time.Now()
+	var next, attempts uint64
+	var once sync.Once
+	var found *Result
+	var searchErr error
+	finish := func(r *Result, err error) bool {
+		finished := false
+		once.Do(func() {
+			found, searchErr = r, err
+			finished = true
+			stop()
+		})
+		return finished
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				candidate, err := source.Key(atomic.AddUint64(&next, 1) - 1)
+				if err != nil {
+					finish(nil, err)
+					return
+				}
+				if candidate == nil {
+					continue
+				}
+				address, err := message.Address(candidate.PubKey, p.AddressType, p.net)
+				if err != nil {
+					candidate.zero()
+					finish(nil, err)
+					return
+				}
+				atomic.AddUint64(&attempts, 1)
+				if p.Match(address) {
+					// The match found after the search is finished is dropped like the other candidates
+					if !finish(&Result{Address: address, Candidate: candidate}, nil) {
+						candidate.zero()
+					}
+					return
+				}
+				candidate.zero()
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		case <-ticker.C:
+			if opts.Progress != nil {
+				opts.Progress(progress(p, atomic.LoadUint64(&attempts), time.Since(start)))
+			}
+		}
+	}
+
+	result := found
+	if result == nil {
+		result = &Result{}
+	}
+	result.Progress = progress(p, atomic.LoadUint64(&attempts), time.Since(start))
+	if found != nil || searchErr != nil {
+		return result, searchErr
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return result, ErrTimeBudget
+	}
+	return result, ctx.Err()
+}
+
+// progress Return the progress of the attempts, the probability of a match after n attempts of the difficulty d is
+// 1-(1-1/d)^n
+func progress(p *Pattern, attempts uint64, elapsed time.Duration) Progress {
+	pr := Progress{Attempts: attempts, Elapsed: elapsed, Difficulty: p.Difficulty}
+	if elapsed > 0 {
+		pr.Rate = float64(attempts) / elapsed.Seconds()
+	}
+	if p.Difficulty == 0 {
+		return pr
+	}
+	miss := math.Log1p(-1 / p.Difficulty)
+	pr.Probability = -math.Expm1(float64(attempts) * miss)
+	if half := math.Log(0.5) / miss; pr.Rate > 0 && float64(attempts) < half {
+		// The ETA of the long prefix may be beyond the max duration
+		pr.ETA = time.Duration(math.MaxInt64)
+		if eta := (half - float64(attempts)) / pr.Rate * float64(time.Second); eta < math.MaxInt64 {
+			pr.ETA = time.Duration(eta)
+		}
+	}
+	return pr
+}
+
+// ChainKey Return the neutered chain key m/account'/chain of the seed
+func ChainKey(seed []byte, account uint32, chain uint32, net *chaincfg.Params) (*hdkeychain.ExtendedKey, error) {
+	master, err := hdkeychain.NewMaster(seed, net)
+	if err != nil {
+		return nil, err
+	}
+	accKey, err := master.Derive(hdkeychain.HardenedKeyStart + account)
+	master.Zero()
+	if err != nil {
+		return nil, err
+	}
+	// The neutered key shares the chain code of the account key, zero it after the chain key is derived
+	defer accKey.Zero()
+	accPubKey, err := accKey.Neuter()
+	if err != nil {
+		return nil, err
+	}
+	return accPubKey.Derive(chain)
+}
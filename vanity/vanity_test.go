@@ -0,0 +1,187 @@
+package vanity
+
+import (
+	"context"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/jayt106/bitcoinAddressGenerator/message"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testChainKey Return the chain key m/0'/0 of the test seed
+func testChainKey(t *testing.T) *hdkeychain.ExtendedKey {
+	seed, _ := hex.DecodeString("a966eb6058f8ec9f47074a2faadd3dab42e2c60ed05bc34d39d6c0e1d32b8bdf")
+	chainKey, err := ChainKey(seed, 0, 0, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chainKey
+}
+
+func TestPattern(t *testing.T) {
+	p, err := NewPattern(message.AddressP2WPKH, "BC1QCafe", "", &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Prefix != "bc1qcafe" || p.Difficulty != 32*32*32*32 || !p.Match("bc1qcafe0") || p.Match("bc1pcafe0") {
+		t.Error("Unexpected prefix pattern:", p.Prefix, p.Difficulty)
+	}
+	if p, err = NewPattern(message.AddressP2TR, "cafe", "", &chaincfg.TestNet3Params); err != nil || p.Prefix != "tb1pcafe" {
+		t.Error("Unexpected testnet taproot pattern:", p, err)
+	}
+	if p, err = NewPattern(message.AddressP2WPKH, "", "^bc1q.*z$", &chaincfg.MainNetParams); err != nil || p.Difficulty != 0 || !p.Match("bc1qxyz") {
+		t.Error("Unexpected regex pattern:", p, err)
+	}
+
+	for _, invalid := range [][3]string{
+		{message.AddressP2PKH, "cafe", ""},
+		{message.AddressP2WPKH, "", ""},
+		{message.AddressP2WPKH, "cafe", "^bc1q"},
+		{message.AddressP2WPKH, "bc1q", ""},
+		{message.AddressP2WPKH, "coffee", ""},
+		{message.AddressP2WPKH, "", "("},
+	} {
+		if _, err = NewPattern(invalid[0], invalid[1], invalid[2], &chaincfg.MainNetParams); err == nil {
+			t.Error("The invalid pattern should be rejected:", invalid)
+		}
+	}
+}
+
+func TestSearchHD(t *testing.T) {
+	chainKey := testChainKey(t)
+	source, err := NewHDSource(chainKey, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPattern(message.AddressP2WPKH, "q", "", &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reports := 0
+	result, err := Search(context.Background(), p, source, Options{Workers: 4, Progress: func(Progress) { reports++ }, ProgressInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(result.Address, "bc1qq") || result.Candidate.Index < 100 || result.Candidate.PrivKey != nil || result.Attempts == 0 {
+		t.Fatal("Unexpected result:", result.Address, result.Candidate, result.Attempts)
+	}
+
+	// The match is recovered from the seed by its index
+	child, err := chainKey.Derive(result.Candidate.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := child.ECPubKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := message.Address(pubKey, message.AddressP2WPKH, &chaincfg.MainNetParams)
+	if err != nil || address != result.Address {
+		t.Error("The match isn't the address of its index:", address, result.Address, err)
+	}
+
+	if _, err = NewHDSource(chainKey, hdkeychain.HardenedKeyStart); err == nil {
+		t.Error("The hardened start index should be rejected")
+	}
+	seed, _ := hex.DecodeString("a966eb6058f8ec9f47074a2faadd3dab42e2c60ed05bc34d39d6c0e1d32b8bdf")
+	master, _ := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	if _, err = NewHDSource(master, 0); err == nil {
+		t.Error("The private chain key should be rejected")
+	}
+
+	// Only the last 3 indices are left
+	source, _ = NewHDSource(chainKey, hdkeychain.HardenedKeyStart-3)
+	p, _ = NewPattern(message.AddressP2WPKH, "", "^x", &chaincfg.MainNetParams)
+	if result, err = Search(context.Background(), p, source, Options{Workers: 2}); err != ErrExhausted || result.Attempts != 3 {
+		t.Error("The search should exhaust the indices:", err, result.Attempts)
+	}
+}
+
+func TestSearchRandom(t *testing.T) {
+	p, err := NewPattern(message.AddressP2TR, "", "^bc1p[ac]", &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := Search(context.Background(), p, RandomSource{}, Options{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := message.Address(result.Candidate.PrivKey.PubKey(), message.AddressP2TR, &chaincfg.MainNetParams)
+	if err != nil || address != result.Address || !p.Match(address) {
+		t.Error("The match isn't the address of its private key:", address, result.Address, err)
+	}
+}
+
+// recordingSource the random source keeping the candidates it returns
+type recordingSource struct {
+	mu         sync.Mutex
+	candidates []*Candidate
+}
+
+func (s *recordingSource) Key(n uint64) (*Candidate, error) {
+	candidate, err := RandomSource{}.Key(n)
+	if err == nil {
+		s.mu.Lock()
+		s.candidates = append(s.candidates, candidate)
+		s.mu.Unlock()
+	}
+	return candidate, err
+}
+
+func TestSearchRandomZero(t *testing.T) {
+	p, err := NewPattern(message.AddressP2TR, "", "^bc1p[ac]", &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &recordingSource{}
+	result, err := Search(context.Background(), p, source, Options{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Candidate.PrivKey.D.Sign() == 0 {
+		t.Error("The private key of the match should be kept")
+	}
+	for _, candidate := range source.candidates {
+		if candidate != result.Candidate && candidate.PrivKey.D.Sign() != 0 {
+			t.Error("The private key of the dropped candidate should be zeroed")
+		}
+	}
+}
+
+func TestSearchStop(t *testing.T) {
+	p, err := NewPattern(message.AddressP2WPKH, "", "^x", &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := Search(context.Background(), p, RandomSource{}, Options{Workers: 2, TimeBudget: 50 * time.Millisecond})
+	if err != ErrTimeBudget || result.Attempts == 0 || result.Elapsed < 50*time.Millisecond || result.Address != "" {
+		t.Error("The search should stop at the time budget:", err, result.Attempts, result.Elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err = Search(ctx, p, RandomSource{}, Options{}); err != context.Canceled {
+		t.Error("The search should stop at the cancellation:", err)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	p := &Pattern{Difficulty: 32}
+	// About 69% of the difficulty reaches the 50% probability
+	pr := progress(p, 11, time.Second)
+	if pr.Rate != 11 || pr.ETA < 980*time.Millisecond || pr.ETA > 990*time.Millisecond || math.Abs(pr.Probability-0.295) > 0.001 {
+		t.Error("Unexpected progress:", pr)
+	}
+	if pr = progress(p, 64, time.Second); pr.ETA != 0 || math.Abs(pr.Probability-0.869) > 0.001 {
+		t.Error("Unexpected progress after the half probability:", pr)
+	}
+	if pr = progress(&Pattern{}, 64, time.Second); pr.ETA != 0 || pr.Probability != 0 {
+		t.Error("The regex progress should have no estimate:", pr)
+	}
+}
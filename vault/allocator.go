@@ -0,0 +1,216 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+// IdempotencyTTL how long the allocation of an idempotency key is returned again, the same key allocates a new index
+// after it. The expired keys are dropped, so the memory of the allocator is bounded by the allocation rate.
+const IdempotencyTTL = 24 * time.Hour
+
+// Allocation an address index handed out by NextIndex
+type Allocation struct {
+	Account        uint32    `json:"account"`
+	Chain          uint32    `json:"chain"`
+	Index          uint32    `json:"index"`
+	Label          string    `json:"label,omitempty"`
+	IdempotencyKey string    `json:"idempotencyKey,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// journalRecord a line of the allocation journal
+type journalRecord struct {
+	WalletID string `json:"walletId"`
+	Allocation
+}
+
+// allocator the address indices of the wallets. The allocations are appended to the journal file next to the vault
+// file instead of rewriting the vault with all its seeds, the state is rebuilt from the journal when the vault is opened.
+type allocator struct {
+	journal *os.File
+	size    int64
+	wallets map[string]*walletIndices
+	now     func() time.Time
+}
+
+// walletIndices the next index of each "account/chain" of a wallet and its unexpired idempotency keys, order has the
+// idempotency allocations by creation time for the expiry
+type walletIndices struct {
+	next        map[string]uint32
+	idempotency map[string]Allocation
+	order       []Allocation
+}
+
+// journalFile Return the allocation journal file of the vault file
+func journalFile(file string) string {
+	return file + ".alloc"
+}
+
+// openAllocator Rebuild the indices from the allocations of the vault file written before the journal and from the
+// journal, and open the journal for appending. A record cut by a crash at the end of the journal is dropped.
+func openAllocator(file string, wallets map[string]WalletEntry) (*allocator, error) {
+	a := &allocator{wallets: make(map[string]*walletIndices), now: time.Now}
+	for walletID, entry := range wallets {
+		for chainKey, index := range entry.NextIndex {
+			a.wallet(walletID).next[chainKey] = index
+		}
+		for _, allocation := range entry.Allocations {
+			a.add(walletID, allocation)
+		}
+	}
+	for _, w := range a.wallets {
+		sort.Slice(w.order, func(i, j int) bool { return w.order[i].CreatedAt.Before(w.order[j].CreatedAt) })
+	}
+
+	data, err := ioutil.ReadFile(journalFile(file))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			break
+		}
+		var record journalRecord
+		err = json.Unmarshal(data[:end], &record)
+		if err != nil {
+			return nil, fmt.Errorf("allocation journal record %d: %v", a.size, err)
+		}
+		a.add(record.WalletID, record.Allocation)
+		a.size += int64(end + 1)
+		data = data[end+1:]
+	}
+
+	a.journal, err = os.OpenFile(journalFile(file), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = a.journal.Truncate(a.size)
+	if err == nil {
+		_, err = a.journal.Seek(a.size, 0)
+	}
+	if err != nil {
+		a.journal.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// wallet Return the indices of the wallet, created if the wallet has no allocation yet
+func (a *allocator) wallet(walletID string) *walletIndices {
+	w, ok := a.wallets[walletID]
+	if !ok {
+		w = &walletIndices{next: make(map[string]uint32), idempotency: make(map[string]Allocation)}
+		a.wallets[walletID] = w
+	}
+	return w
+}
+
+// add Apply the allocation to the indices of the wallet
+func (a *allocator) add(walletID string, allocation Allocation) {
+	w := a.wallet(walletID)
+	chainKey := fmt.Sprintf("%d/%d", allocation.Account, allocation.Chain)
+	if next, ok := w.next[chainKey]; !ok || allocation.Index >= next {
+		w.next[chainKey] = allocation.Index + 1
+	}
+	if allocation.IdempotencyKey != "" {
+		w.idempotency[allocation.IdempotencyKey] = allocation
+		w.order = append(w.order, allocation)
+	}
+}
+
+// expire Drop the idempotency keys older than IdempotencyTTL
+func (w *walletIndices) expire(now time.Time) {
+	i := 0
+	for i < len(w.order) && now.Sub(w.order[i].CreatedAt) > IdempotencyTTL {
+		key := w.order[i].IdempotencyKey
+		if w.idempotency[key].CreatedAt.Equal(w.order[i].CreatedAt) {
+			delete(w.idempotency, key)
+		}
+		i++
+	}
+	if i > 0 {
+		w.order = append(w.order[:0:0], w.order[i:]...)
+	}
+}
+
+// append Write the allocation to the journal and sync it, the journal is cut back if the record isn't fully written
+func (a *allocator) append(walletID string, allocation Allocation) error {
+	line, err := json.Marshal(journalRecord{walletID, allocation})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	n, err := a.journal.Write(line)
+	if err != nil {
+		if n > 0 {
+			a.journal.Truncate(a.size)
+			a.journal.Seek(a.size, 0)
+		}
+		return err
+	}
+
+	// The written record is in the journal even if the sync fails, the index must not be handed out again
+	a.size += int64(n)
+	a.add(walletID, allocation)
+	return a.journal.Sync()
+}
+
+// NextIndex Allocate the next unused address index of the account and chain of the wallet and persist it before
+// returning, so the concurrent or restarted callers never get the same index. The allocation with the same idempotency
+// key within IdempotencyTTL returns the first allocation and true. The wallet token must be valid like Seed.
+func (v *Vault) NextIndex(walletID string, token string, account uint32, chain uint32, idempotencyKey string, label string) (*Allocation, bool, error) {
+	if account >= hdkeychain.HardenedKeyStart || chain >= hdkeychain.HardenedKeyStart {
+		return nil, false, errors.New("account and chain must be less than 2^31")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	_, err := v.authorize(walletID, token)
+	if err != nil {
+		return nil, false, err
+	}
+
+	now := v.alloc.now().UTC()
+	w := v.alloc.wallet(walletID)
+	w.expire(now)
+	if idempotencyKey != "" {
+		if allocation, ok := w.idempotency[idempotencyKey]; ok {
+			if allocation.Account != account || allocation.Chain != chain {
+				return nil, false, fmt.Errorf("idempotency key %s is used by account %d chain %d", idempotencyKey, allocation.Account, allocation.Chain)
+			}
+			return &allocation, true, nil
+		}
+	}
+
+	chainKey := fmt.Sprintf("%d/%d", account, chain)
+	index := w.next[chainKey]
+	if index >= hdkeychain.HardenedKeyStart {
+		return nil, false, fmt.Errorf("the non-hardened indexes of account %d chain %d are exhausted", account, chain)
+	}
+
+	allocation := Allocation{
+		Account:        account,
+		Chain:          chain,
+		Index:          index,
+		Label:          label,
+		IdempotencyKey: idempotencyKey,
+		CreatedAt:      now,
+	}
+	err = v.alloc.append(walletID, allocation)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &allocation, false, nil
+}
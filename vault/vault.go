@@ -0,0 +1,304 @@
+package vault
+
+import (
+	stdcipher "crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/jayt106/bitcoinAddressGenerator/cipher"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	vaultVersion = 1
+	saltLen      = 32
+	walletIDLen  = 16
+	tokenLen     = 32
+)
+
+// ErrUnknownWallet the wallet id isn't registered in the vault
+var ErrUnknownWallet = errors.New("unknown wallet id")
+
+// ErrInvalidToken the wallet token doesn't match the token issued at the registration
+var ErrInvalidToken = errors.New("invalid wallet token")
+
+// File the json file format of the vault, the seeds are encrypted by the master key derived from the passphrase
+type File struct {
+	Version int                    `json:"version"`
+	KDF     string                 `json:"kdf"`
+	Scrypt  cipher.ScryptParams    `json:"scrypt"`
+	Salt    string                 `json:"salt"`
+	Wallets map[string]WalletEntry `json:"wallets"`
+}
+
+// WalletEntry an encrypted seed in the vault, the wallet id is authenticated as the additional data. The seed is only
+// used with the wallet token of TokenHash (the SHA256 of the token), the fingerprint is informational.
+// NextIndex and Allocations are the address indices of the vault files written before the allocation journal, they are
+// only read when the vault is opened.
+type WalletEntry struct {
+	CreatedAt   time.Time             `json:"createdAt"`
+	Fingerprint string                `json:"fingerprint"`
+	TokenHash   string                `json:"tokenHash,omitempty"`
+	Nonce       string                `json:"nonce"`
+	CipherText  string                `json:"cipherText"`
+	NextIndex   map[string]uint32     `json:"nextIndex,omitempty"`
+	Allocations map[string]Allocation `json:"allocations,omitempty"`
+}
+
+// Vault stores the client seeds encrypted at rest, the client registers the seed once and refers it by the wallet id.
+// The allocated address indices are kept in the allocation journal (the vault file with the .alloc suffix).
+type Vault struct {
+	mu    sync.RWMutex
+	file  string
+	aead  stdcipher.AEAD
+	data  File
+	alloc *allocator
+}
+
+// Fingerprint Return the BIP032 master key fingerprint of the seed. It's public, every psbt of the wallet has it, so it
+// only identifies the wallet for the audit log and the descriptors and never authorizes the use of the seed.
+func Fingerprint(seed []byte) (string, error) {
+	masterKey, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	if err != nil {
+		return "", err
+	}
+
+	pubKey, err := masterKey.ECPubKey()
+	if err != nil {
+		return "", err
+	}
+
+	return cipher.KeyID(pubKey), nil
+}
+
+// Open Load the vault file and derive the master key by the passphrase, the empty vault is created if the file doesn't exist
+func Open(file string, passphrase []byte) (*Vault, error) {
+	v := &Vault{file: file}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		salt := make([]byte, saltLen)
+		_, err = rand.Read(salt)
+		if err != nil {
+			return nil, err
+		}
+
+		v.data = File{
+			Version: vaultVersion,
+			KDF:     "scrypt",
+			Scrypt:  cipher.DefaultScryptParams,
+			Salt:    hex.EncodeToString(salt),
+			Wallets: make(map[string]WalletEntry),
+		}
+		v.aead, err = cipher.NewPassphraseAEAD(passphrase, salt, v.data.Scrypt)
+		if err != nil {
+			return nil, err
+		}
+
+		err = v.save()
+		if err != nil {
+			return nil, err
+		}
+		v.alloc, err = openAllocator(file, v.data.Wallets)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &v.data)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.data.Version != vaultVersion || v.data.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported vault version %d kdf %s", v.data.Version, v.data.KDF)
+	}
+	if v.data.Wallets == nil {
+		v.data.Wallets = make(map[string]WalletEntry)
+	}
+	// The wallet id of the seed registered before the wallet tokens is the fingerprint, the seed is registered again
+	// for a token
+	for walletID, entry := range v.data.Wallets {
+		if entry.Fingerprint == "" {
+			entry.Fingerprint = walletID
+			v.data.Wallets[walletID] = entry
+		}
+	}
+
+	salt, err := hex.DecodeString(v.data.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	v.aead, err = cipher.NewPassphraseAEAD(passphrase, salt, v.data.Scrypt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify the passphrase by decrypting a seed, the wrong master key must not be used to store the new seeds
+	for walletID := range v.data.Wallets {
+		seed, err := v.decrypt(walletID)
+		if err != nil {
+			return nil, errors.New("vault decryption failed, wrong passphrase or corrupted file")
+		}
+		zero(seed)
+		break
+	}
+
+	v.alloc, err = openAllocator(file, v.data.Wallets)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Register Store the seed and return its random wallet id and the wallet token, the token is returned only once and
+// the vault only keeps its hash. Registering the same seed again returns the same wallet id with a new token, the
+// previous token is revoked. The seeds of the same fingerprint are different wallets.
+func (v *Vault) Register(seed []byte) (string, string, error) {
+	fingerprint, err := Fingerprint(seed)
+	if err != nil {
+		return "", "", err
+	}
+
+	token := make([]byte, tokenLen)
+	_, err = rand.Read(token)
+	if err != nil {
+		return "", "", err
+	}
+	tokenHex := hex.EncodeToString(token)
+	tokenHash := sha256.Sum256([]byte(tokenHex))
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for walletID, entry := range v.data.Wallets {
+		if entry.Fingerprint != fingerprint {
+			continue
+		}
+		stored, err := v.decrypt(walletID)
+		if err != nil {
+			return "", "", err
+		}
+		same := subtle.ConstantTimeCompare(stored, seed) == 1
+		zero(stored)
+		if !same {
+			continue
+		}
+
+		entry.TokenHash = hex.EncodeToString(tokenHash[:])
+		v.data.Wallets[walletID] = entry
+		err = v.save()
+		if err != nil {
+			return "", "", err
+		}
+		return walletID, tokenHex, nil
+	}
+
+	id := make([]byte, walletIDLen)
+	_, err = rand.Read(id)
+	if err != nil {
+		return "", "", err
+	}
+	walletID := hex.EncodeToString(id)
+
+	nonce := make([]byte, v.aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", "", err
+	}
+
+	cipherText := v.aead.Seal(nil, nonce, seed, []byte(walletID))
+	v.data.Wallets[walletID] = WalletEntry{
+		CreatedAt:   time.Now().UTC(),
+		Fingerprint: fingerprint,
+		TokenHash:   hex.EncodeToString(tokenHash[:]),
+		Nonce:       hex.EncodeToString(nonce),
+		CipherText:  hex.EncodeToString(cipherText),
+	}
+
+	err = v.save()
+	if err != nil {
+		delete(v.data.Wallets, walletID)
+		return "", "", err
+	}
+
+	return walletID, tokenHex, nil
+}
+
+// Seed Return the decrypted seed of the wallet id if the wallet token is valid, the caller should clear it after use
+func (v *Vault) Seed(walletID string, token string) ([]byte, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	_, err := v.authorize(walletID, token)
+	if err != nil {
+		return nil, err
+	}
+	return v.decrypt(walletID)
+}
+
+// authorize Return the wallet entry if the token matches the token hash, the hashes are compared in constant time. The
+// wallet without a token hash is registered before the wallet tokens and can't be used until it's registered again.
+func (v *Vault) authorize(walletID string, token string) (WalletEntry, error) {
+	entry, ok := v.data.Wallets[walletID]
+	if !ok {
+		return entry, ErrUnknownWallet
+	}
+	expected, err := hex.DecodeString(entry.TokenHash)
+	if err != nil || len(expected) != sha256.Size {
+		return entry, ErrInvalidToken
+	}
+	tokenHash := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare(tokenHash[:], expected) != 1 {
+		return entry, ErrInvalidToken
+	}
+	return entry, nil
+}
+
+func (v *Vault) decrypt(walletID string) ([]byte, error) {
+	entry, ok := v.data.Wallets[walletID]
+	if !ok {
+		return nil, ErrUnknownWallet
+	}
+
+	nonce, err := hex.DecodeString(entry.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	cipherText, err := hex.DecodeString(entry.CipherText)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.aead.Open(nil, nonce, cipherText, []byte(walletID))
+}
+
+// save Write the vault to a synced temporary file and rename it, a crash during the writing doesn't lose the seeds
+// nor roll back the allocated indices
+func (v *Vault) save() error {
+	data, err := json.MarshalIndent(v.data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return cipher.WriteFileAtomic(v.file, data, 0600)
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
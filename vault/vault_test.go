@@ -0,0 +1,207 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestVaultRegister(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "vault.json")
+	passphrase := []byte("test passphrase")
+	seed, err := hex.DecodeString("a966eb6058f8ec9f47074a2faadd3dab42e2c60ed05bc34d39d6c0e1d32b8bdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := Open(file, passphrase)
+	if err != nil {
+		t.Fatal("Open vault error:", err)
+	}
+
+	walletID, token, err := v.Register(seed)
+	if err != nil {
+		t.Fatal("Register error:", err)
+	}
+
+	// The wallet id is random, the public fingerprint is informational
+	fingerprint, err := Fingerprint(seed)
+	if err != nil || len(walletID) != 2*walletIDLen || walletID == fingerprint || len(token) != 2*tokenLen {
+		t.Error("Unexpected wallet id or token:", walletID, fingerprint, len(token))
+	}
+	if v.data.Wallets[walletID].Fingerprint != fingerprint || v.data.Wallets[walletID].TokenHash == token {
+		t.Error("Unexpected wallet entry:", v.data.Wallets[walletID])
+	}
+
+	// Registering the same seed again returns the same wallet id and revokes the previous token
+	again, newToken, err := v.Register(seed)
+	if err != nil || again != walletID || newToken == token {
+		t.Error("Registering the same seed should return the same wallet id and a new token:", again, err)
+	}
+	if _, err = v.Seed(walletID, token); err != ErrInvalidToken {
+		t.Error("The previous token should be revoked:", err)
+	}
+	token = newToken
+
+	// The seed of the same fingerprint is another wallet, the fingerprint can't be taken by the collision
+	other := append([]byte{}, seed...)
+	other[0] ^= 1
+	otherID, _, err := v.Register(other)
+	if err != nil || otherID == walletID {
+		t.Error("Another seed should be another wallet:", otherID, err)
+	}
+
+	// The seed survives the restart and can't be read by a wrong passphrase
+	v, err = Open(file, passphrase)
+	if err != nil {
+		t.Fatal("Reopen vault error:", err)
+	}
+
+	stored, err := v.Seed(walletID, token)
+	if err != nil {
+		t.Fatal("Seed error:", err)
+	}
+	if !reflect.DeepEqual(stored, seed) {
+		t.Error("The stored seed is different from the registered seed")
+	}
+
+	_, err = v.Seed("00000000", token)
+	if err != ErrUnknownWallet {
+		t.Error("The unknown wallet id should be rejected:", err)
+	}
+	for _, wrong := range []string{"", token[:len(token)-1], fingerprint} {
+		if _, err = v.Seed(walletID, wrong); err != ErrInvalidToken {
+			t.Error("The wrong token should be rejected:", wrong, err)
+		}
+	}
+
+	_, err = Open(file, []byte("wrong passphrase"))
+	if err == nil {
+		t.Error("The vault should not be opened by a wrong passphrase")
+	}
+}
+
+func TestVaultNextIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "vault.json")
+	passphrase := []byte("test passphrase")
+	seed, err := hex.DecodeString("a966eb6058f8ec9f47074a2faadd3dab42e2c60ed05bc34d39d6c0e1d32b8bdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := Open(file, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	walletID, token, err := v.Register(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The concurrent allocations never share an index
+	const n = 20
+	indexes := make(chan uint32, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			allocation, _, err := v.NextIndex(walletID, token, 0, 0, "", "")
+			if err != nil {
+				t.Error(err)
+				indexes <- 0
+				return
+			}
+			indexes <- allocation.Index
+		}()
+	}
+	seen := make(map[uint32]bool)
+	for i := 0; i < n; i++ {
+		seen[<-indexes] = true
+	}
+	if len(seen) != n {
+		t.Error("The concurrent allocations should be unique, got", len(seen))
+	}
+
+	// The allocations are appended to the journal, the vault file with the seeds isn't rewritten
+	vaultData, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The index is persisted and the idempotency key returns the same allocation after the restart
+	first, existing, err := v.NextIndex(walletID, token, 0, 0, "invoice-1", "invoice 1")
+	if err != nil || existing || first.Index != n {
+		t.Fatal("Unexpected allocation:", first, existing, err)
+	}
+
+	v, err = Open(file, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, existing, err := v.NextIndex(walletID, token, 0, 0, "invoice-1", "")
+	if err != nil || !existing || again.Index != first.Index || again.Label != "invoice 1" {
+		t.Error("The idempotency key should return the first allocation:", again, existing, err)
+	}
+
+	change, _, err := v.NextIndex(walletID, token, 0, 1, "", "")
+	if err != nil || change.Index != 0 {
+		t.Error("Each chain should have its own index:", change, err)
+	}
+
+	_, _, err = v.NextIndex("00000000", token, 0, 0, "", "")
+	if err != ErrUnknownWallet {
+		t.Error("The unknown wallet id should be rejected:", err)
+	}
+	_, _, err = v.NextIndex(walletID, "", 0, 0, "", "")
+	if err != ErrInvalidToken {
+		t.Error("The index shouldn't be allocated without the wallet token:", err)
+	}
+
+	if data, err := ioutil.ReadFile(file); err != nil || !bytes.Equal(data, vaultData) {
+		t.Error("The allocations shouldn't rewrite the vault file:", err)
+	}
+
+	// The record cut by a crash at the end of the journal is dropped
+	journal, err := os.OpenFile(file+".alloc", os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = journal.WriteString(`{"walletId":"` + walletID + `","account":0,"chain":0,"ind`)
+	journal.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err = Open(file, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, _, err := v.NextIndex(walletID, token, 0, 0, "", "")
+	if err != nil || next.Index != n+1 {
+		t.Error("The allocation after the cut record should continue the index:", next, err)
+	}
+
+	// The idempotency key allocates a new index after IdempotencyTTL
+	v.alloc.now = func() time.Time { return time.Now().Add(IdempotencyTTL + time.Minute) }
+	expired, existing, err := v.NextIndex(walletID, token, 0, 0, "invoice-1", "")
+	if err != nil || existing || expired.Index != n+2 {
+		t.Error("The expired idempotency key should allocate a new index:", expired, existing, err)
+	}
+	if len(v.alloc.wallet(walletID).order) != 1 {
+		t.Error("The expired idempotency keys should be dropped")
+	}
+}